@@ -49,7 +49,7 @@ func makeSplitterRunner() (pipeline.SplitterRunner, error) {
 
 func main() {
 	flagMatch := flag.String("match", "TRUE", "message_matcher filter expression")
-	flagFormat := flag.String("format", "txt", "output format [txt|json|heka|count]")
+	flagFormat := flag.String("format", "txt", "output format [txt|json|heka|count|explain]")
 	flagOutput := flag.String("output", "", "output filename, defaults to stdout")
 	flagTail := flag.Bool("tail", false, "don't exit on EOF")
 	flagOffset := flag.Int64("offset", 0, "starting offset for the input file in bytes")
@@ -133,6 +133,17 @@ func main() {
 					continue
 				}
 
+				if "explain" == *flagFormat {
+					trace := match.Explain(msg)
+					if trace.Result == message.TRACE_TRUE {
+						matched += 1
+					}
+					fmt.Fprintf(out, "Offset: %d UUID: %s\n%s\n", offset,
+						msg.GetUuidString(), trace)
+					offset += int64(n)
+					continue
+				}
+
 				if !match.Match(msg) {
 					continue
 				}
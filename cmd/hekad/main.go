@@ -36,21 +36,29 @@ import (
 	"github.com/mozilla-services/heka/pipeline"
 	_ "github.com/mozilla-services/heka/plugins"
 	_ "github.com/mozilla-services/heka/plugins/amqp"
+	_ "github.com/mozilla-services/heka/plugins/avro"
 	_ "github.com/mozilla-services/heka/plugins/dasher"
 	_ "github.com/mozilla-services/heka/plugins/elasticsearch"
 	_ "github.com/mozilla-services/heka/plugins/file"
+	_ "github.com/mozilla-services/heka/plugins/gelf"
+	_ "github.com/mozilla-services/heka/plugins/geoip"
 	_ "github.com/mozilla-services/heka/plugins/graphite"
 	_ "github.com/mozilla-services/heka/plugins/http"
 	_ "github.com/mozilla-services/heka/plugins/irc"
 	_ "github.com/mozilla-services/heka/plugins/kafka"
 	_ "github.com/mozilla-services/heka/plugins/logstreamer"
+	_ "github.com/mozilla-services/heka/plugins/lookup"
+	_ "github.com/mozilla-services/heka/plugins/msgpack"
 	_ "github.com/mozilla-services/heka/plugins/nagios"
 	_ "github.com/mozilla-services/heka/plugins/payload"
 	_ "github.com/mozilla-services/heka/plugins/process"
+	_ "github.com/mozilla-services/heka/plugins/schema"
 	_ "github.com/mozilla-services/heka/plugins/smtp"
 	_ "github.com/mozilla-services/heka/plugins/statsd"
+	_ "github.com/mozilla-services/heka/plugins/syslog"
 	_ "github.com/mozilla-services/heka/plugins/tcp"
 	_ "github.com/mozilla-services/heka/plugins/udp"
+	_ "github.com/mozilla-services/heka/plugins/useragent"
 )
 
 const (
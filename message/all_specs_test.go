@@ -20,6 +20,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/pborman/uuid"
 	"github.com/rafrombrc/gospec/src/gospec"
 	gs "github.com/rafrombrc/gospec/src/gospec"
@@ -29,10 +30,40 @@ func TestAllSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 	r.AddSpec(MessageFieldsSpec)
 	r.AddSpec(MessageEqualsSpec)
+	r.AddSpec(UnmarshalLazySpec)
 	r.AddSpec(MatcherSpecificationSpec)
 	gospec.MainGoTest(r, t)
 }
 
+// taggedField and taggedObject mirror the generated Field and Object types
+// w/o their Marshal and Unmarshal methods, the proto package encodes them
+// from the struct tags alone.
+type taggedField struct {
+	Name             *string          `protobuf:"bytes,1,req,name=name"`
+	ValueType        *Field_ValueType `protobuf:"varint,2,opt,name=value_type,enum=message.Field_ValueType,def=0"`
+	Representation   *string          `protobuf:"bytes,3,opt,name=representation"`
+	ValueString      []string         `protobuf:"bytes,4,rep,name=value_string"`
+	ValueBytes       [][]byte         `protobuf:"bytes,5,rep,name=value_bytes"`
+	ValueInteger     []int64          `protobuf:"varint,6,rep,packed,name=value_integer"`
+	ValueDouble      []float64        `protobuf:"fixed64,7,rep,packed,name=value_double"`
+	ValueBool        []bool           `protobuf:"varint,8,rep,packed,name=value_bool"`
+	ValueObject      []*taggedObject  `protobuf:"bytes,9,rep,name=value_object"`
+	XXX_unrecognized []byte
+}
+
+func (m *taggedField) Reset()         { *m = taggedField{} }
+func (m *taggedField) String() string { return proto.CompactTextString(m) }
+func (*taggedField) ProtoMessage()    {}
+
+type taggedObject struct {
+	Fields           []*taggedField `protobuf:"bytes,1,rep,name=fields"`
+	XXX_unrecognized []byte
+}
+
+func (m *taggedObject) Reset()         { *m = taggedObject{} }
+func (m *taggedObject) String() string { return proto.CompactTextString(m) }
+func (*taggedObject) ProtoMessage()    {}
+
 func getTestMessage() *Message {
 	hostname, _ := os.Hostname()
 	field, _ := NewField("foo", "bar", "")
@@ -146,6 +177,127 @@ func MessageFieldsSpec(c gospec.Context) {
 		c.Expect(v, gs.IsTrue)
 	})
 
+	c.Specify("Add Object Field", func() {
+		msg := &Message{}
+		f, err := NewField("http", map[string]interface{}{
+			"request": map[string]interface{}{
+				"method": "GET",
+				"bytes":  int64(512),
+			},
+			"status": 200,
+			"tags":   []interface{}{"a", "b"},
+		}, "")
+		c.Assume(err, gs.IsNil)
+		c.Expect(f.GetValueType(), gs.Equals, Field_OBJECT)
+		msg.AddField(f)
+
+		v, ok := msg.GetFieldValue("http")
+		c.Expect(ok, gs.IsTrue)
+		obj := v.(*Object)
+		c.Expect(len(obj.Fields), gs.Equals, 3)
+		// members are sorted by name
+		c.Expect(obj.Fields[0].GetName(), gs.Equals, "request")
+		c.Expect(obj.FindFirstField("status").ValueInteger[0], gs.Equals, int64(200))
+		c.Expect(obj.FindFirstField("tags").ValueString, gs.Equals, []string{"a", "b"})
+
+		method := msg.FindFieldPath("http.request.method")
+		c.Assume(method, gs.Not(gs.IsNil))
+		c.Expect(method.ValueString[0], gs.Equals, "GET")
+		c.Expect(msg.FindFieldPath("http.request.missing"), gs.IsNil)
+		c.Expect(msg.FindFieldPath("http.status.value"), gs.IsNil)
+
+		// an exact name match takes precedence over the path
+		flat, _ := NewField("http.request.method", "POST", "")
+		msg.AddField(flat)
+		c.Expect(msg.FindFieldPath("http.request.method").ValueString[0], gs.Equals, "POST")
+
+		c.Specify("survives a protobuf round trip", func() {
+			b, err := proto.Marshal(msg)
+			c.Assume(err, gs.IsNil)
+			msg1 := &Message{}
+			err = proto.Unmarshal(b, msg1)
+			c.Assume(err, gs.IsNil)
+			c.Expect(msg1, gs.Equals, msg)
+		})
+
+		c.Specify("matches the protobuf encoding of the message.proto tags", func() {
+			b, err := proto.Marshal(f)
+			c.Assume(err, gs.IsNil)
+			tagged := new(taggedField)
+			err = proto.Unmarshal(b, tagged)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(tagged.ValueObject), gs.Equals, 1)
+			c.Expect(len(tagged.ValueObject[0].Fields), gs.Equals, 3)
+			c.Expect(len(tagged.XXX_unrecognized), gs.Equals, 0)
+
+			b1, err := proto.Marshal(tagged)
+			c.Assume(err, gs.IsNil)
+			c.Expect(bytes.Equal(b1, b), gs.IsTrue)
+			f1 := new(Field)
+			err = proto.Unmarshal(b1, f1)
+			c.Assume(err, gs.IsNil)
+			c.Expect(f1, gs.Equals, f)
+		})
+
+		c.Specify("is deep copied", func() {
+			msg1 := CopyMessage(msg)
+			c.Expect(msg1, gs.Equals, msg)
+			*msg1.FindFieldPath("http.request.bytes").Name = "size"
+			c.Expect(msg1, gs.Not(gs.Equals), msg)
+		})
+
+		c.Specify("accepts Object values", func() {
+			obj1, err := NewObject(map[string]interface{}{"method": "PUT"})
+			c.Assume(err, gs.IsNil)
+			req := msg.FindFieldPath("http.request")
+			err = req.AddValue(obj1)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(req.ValueObject), gs.Equals, 2)
+			err = req.AddValue("PUT")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("converts back to a map", func() {
+			members := obj.Map()
+			c.Expect(members["status"], gs.Equals, int64(200))
+			c.Expect(len(members["tags"].([]interface{})), gs.Equals, 2)
+			request := members["request"].(map[string]interface{})
+			c.Expect(request["method"], gs.Equals, "GET")
+			c.Expect(request["bytes"], gs.Equals, int64(512))
+		})
+	})
+
+	c.Specify("Reject unsupported Object members", func() {
+		_, err := NewObject(map[string]interface{}{"mixed": []interface{}{"a", 1}})
+		c.Expect(err, gs.Not(gs.IsNil))
+		_, err = NewObject(map[string]interface{}{"nested": []interface{}{[]interface{}{1}}})
+		c.Expect(err, gs.Not(gs.IsNil))
+		_, err = NewObject(map[string]interface{}{"null": nil})
+		c.Expect(err, gs.Not(gs.IsNil))
+		_, err = NewField("badkey", map[int]string{1: "one"}, "")
+		c.Expect(err, gs.Not(gs.IsNil))
+	})
+
+	c.Specify("Multi-value field", func() {
+		f := NewMultiValueField("tags", []interface{}{"a", "b"})
+		c.Expect(f.GetValueType(), gs.Equals, Field_STRING)
+		c.Expect(len(f.ValueString), gs.Equals, 2)
+		f = NewMultiValueField("sizes", []interface{}{int64(1), 2.5})
+		c.Expect(f.GetValueType(), gs.Equals, Field_DOUBLE)
+		c.Expect(f.ValueDouble[0], gs.Equals, 1.0)
+		c.Expect(NewMultiValueField("mixed", []interface{}{int64(1), "x"}), gs.IsNil)
+		c.Expect(NewMultiValueField("nested", []interface{}{[]interface{}{}}), gs.IsNil)
+		c.Expect(NewMultiValueField("empty", nil), gs.IsNil)
+	})
+
+	c.Specify("Field values", func() {
+		f := NewMultiValueField("sizes", []interface{}{int64(1), int64(2)})
+		values := f.GetValues()
+		c.Expect(len(values), gs.Equals, 2)
+		c.Expect(values[1], gs.Equals, int64(2))
+		c.Expect(len(NewFieldInit("empty", Field_STRING, "").GetValues()), gs.Equals, 0)
+	})
+
 	c.Specify("Copy with nil field attributes", func() {
 		msg := &Message{}
 		field, _ := NewField("foo", "bar", "")
@@ -227,9 +379,113 @@ func MessageEqualsSpec(c gospec.Context) {
 	})
 }
 
+func UnmarshalLazySpec(c gospec.Context) {
+	msg := getTestMessage()
+	unknown := []byte{0x58, 0x01} // field 11 varint
+	data, err := proto.Marshal(msg)
+	c.Assume(err, gs.IsNil)
+	data = append(data, unknown...)
+
+	c.Specify("Header is decoded and Fields deferred", func() {
+		lazy := &Message{}
+		fields, err := UnmarshalLazy(data, lazy, nil)
+		c.Expect(err, gs.IsNil)
+		c.Expect(lazy.GetPayload(), gs.Equals, msg.GetPayload())
+		c.Expect(lazy.GetPid(), gs.Equals, msg.GetPid())
+		c.Expect(len(lazy.Fields), gs.Equals, 0)
+		c.Expect(bytes.Equal(lazy.XXX_unrecognized, unknown), gs.IsTrue)
+		c.Expect(len(fields) > 0, gs.IsTrue)
+
+		c.Specify("and decoded later", func() {
+			err := DecodeLazyFields(fields, lazy)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(lazy.Fields), gs.Equals, 2)
+			c.Expect(lazy, gs.Equals, msg)
+			encoded, err := proto.Marshal(lazy)
+			c.Expect(err, gs.IsNil)
+			c.Expect(bytes.Equal(encoded, data), gs.IsTrue)
+		})
+
+		c.Specify("ahead of fields added since", func() {
+			f, _ := NewField("added", "value", "")
+			lazy.AddField(f)
+			err := DecodeLazyFields(fields, lazy)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(lazy.Fields), gs.Equals, 3)
+			c.Expect(lazy.Fields[2].GetName(), gs.Equals, "added")
+		})
+
+		c.Specify("w/o references to the data", func() {
+			for i := range data {
+				data[i] = 0
+			}
+			c.Expect(lazy.GetUuidString(), gs.Equals, msg.GetUuidString())
+			c.Expect(DecodeLazyFields(fields, lazy), gs.IsNil)
+			c.Expect(lazy, gs.Equals, msg)
+		})
+
+		c.Specify("appended to the given slice", func() {
+			buf := make([]byte, 0, len(data))
+			fields1, err := UnmarshalLazy(data, &Message{}, buf)
+			c.Expect(err, gs.IsNil)
+			c.Expect(bytes.Equal(fields1, fields), gs.IsTrue)
+			c.Expect(&fields1[0], gs.Equals, &buf[:1][0])
+		})
+	})
+
+	c.Specify("Fields not at the end of the message", func() {
+		f, _ := NewField("foo", "bar", "")
+		fdata, err := proto.Marshal(&Message{Fields: []*Field{f}})
+		c.Assume(err, gs.IsNil)
+		hdr, err := proto.Marshal(&Message{Payload: proto.String("after")})
+		c.Assume(err, gs.IsNil)
+		lazy := &Message{}
+		fields, err := UnmarshalLazy(append(fdata, hdr...), lazy, nil)
+		c.Expect(err, gs.IsNil)
+		c.Expect(lazy.GetPayload(), gs.Equals, "after")
+		c.Expect(DecodeLazyFields(fields, lazy), gs.IsNil)
+		c.Expect(lazy.FindFirstField("foo"), gs.Not(gs.IsNil))
+	})
+
+	c.Specify("Truncated message fails", func() {
+		lazy := &Message{}
+		_, err := UnmarshalLazy(data[:len(data)-3], lazy, nil)
+		c.Expect(err, gs.Not(gs.IsNil))
+	})
+
+	c.Specify("Corrupt Fields fail to decode", func() {
+		lazy := &Message{}
+		fields, err := UnmarshalLazy(data, lazy, nil)
+		c.Assume(err, gs.IsNil)
+		f, _ := NewField("added", "value", "")
+		lazy.AddField(f)
+
+		// a Field w/ a truncated value_type varint
+		corrupt := append(append([]byte(nil), fields...), 0x52, 0x02, 0x10, 0x80)
+		err = DecodeLazyFields(corrupt, lazy)
+		c.Expect(err, gs.Not(gs.IsNil))
+		c.Expect(len(lazy.Fields), gs.Equals, 1)
+
+		err = DecodeLazyFields([]byte{0x58, 0x01}, lazy)
+		c.Expect(err, gs.Not(gs.IsNil))
+		err = DecodeLazyFields(fields[:len(fields)-1], lazy)
+		c.Expect(err, gs.Not(gs.IsNil))
+		c.Expect(len(lazy.Fields), gs.Equals, 1)
+	})
+}
+
 func BenchmarkMessageCreation(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		msg := getTestMessage()
 		msg.SetPid(999)
 	}
 }
+
+func BenchmarkUnmarshalLazy(b *testing.B) {
+	data, _ := proto.Marshal(getTestMessage())
+	msg := &Message{}
+	var fields []byte
+	for i := 0; i < b.N; i++ {
+		fields, _ = UnmarshalLazy(data, msg, fields[:0])
+	}
+}
@@ -52,54 +52,7 @@ func ForgivingTimeParse(timeLayout, inputTime string, loc *time.Location) (time.
 	)
 
 	if strings.HasPrefix(timeLayout, "Epoch") {
-		var (
-			parsedInt  uint64
-			multiplier int
-		)
-
-		switch timeLayout {
-		case "Epoch":
-			multiplier = 9
-		case "EpochMilli":
-			multiplier = 6
-		case "EpochMicro":
-			multiplier = 3
-		case "EpochNano":
-			multiplier = 0
-		default:
-			err := fmt.Errorf("Unrecognized `Epoch` time format: %s", timeLayout)
-			return parsedTime, err
-		}
-
-		i := strings.Index(inputTime, ".")
-		if i == -1 {
-			// Integer values are easy, we append the right number of 0s and
-			// we're done.
-			zeroes := strings.Repeat("0", multiplier)
-			inputTime = inputTime + zeroes
-			parsedInt, err = strconv.ParseUint(inputTime, 10, 64)
-		} else {
-			// Noninteger need more care, we can't use floats or we'll lose
-			// timestamp precision. First calculate the number of decimal
-			// digits.
-			decDigits := len(inputTime) - i - 1
-			if decDigits < multiplier {
-				// Pad out zeroes to nanosecond resolution.
-				zeroes := strings.Repeat("0", multiplier-decDigits)
-				inputTime = inputTime + zeroes
-			} else if decDigits > multiplier {
-				// Truncate to nanosecond resolution.
-				inputTime = inputTime[:len(inputTime)-(decDigits-multiplier)]
-			}
-			// Finally remove the decimal and parse the value as an integer.
-			intStr := fmt.Sprintf("%s%s", inputTime[:i], inputTime[i+1:])
-			parsedInt, err = strconv.ParseUint(intStr, 10, 64)
-		}
-		if err != nil {
-			err = fmt.Errorf("Error parsing %s time: %s", timeLayout, err.Error())
-			return parsedTime, err
-		}
-		return time.Unix(0, int64(parsedInt)), nil
+		return parseEpoch(timeLayout, inputTime)
 	}
 
 	if parsedTime, err = time.ParseInLocation(timeLayout, inputTime, loc); err == nil {
@@ -113,3 +66,58 @@ func ForgivingTimeParse(timeLayout, inputTime string, loc *time.Location) (time.
 	}
 	return parsedTime, err
 }
+
+// parseEpoch parses a (possibly fractional) number of seconds, milliseconds,
+// microseconds or nanoseconds since the UNIX epoch.
+func parseEpoch(timeLayout, inputTime string) (time.Time, error) {
+	var (
+		parsedTime time.Time
+		err        error
+		parsedInt  uint64
+		multiplier int
+	)
+
+	switch timeLayout {
+	case "Epoch":
+		multiplier = 9
+	case "EpochMilli":
+		multiplier = 6
+	case "EpochMicro":
+		multiplier = 3
+	case "EpochNano":
+		multiplier = 0
+	default:
+		err = fmt.Errorf("Unrecognized `Epoch` time format: %s", timeLayout)
+		return parsedTime, err
+	}
+
+	i := strings.Index(inputTime, ".")
+	if i == -1 {
+		// Integer values are easy, we append the right number of 0s and
+		// we're done.
+		zeroes := strings.Repeat("0", multiplier)
+		inputTime = inputTime + zeroes
+		parsedInt, err = strconv.ParseUint(inputTime, 10, 64)
+	} else {
+		// Noninteger need more care, we can't use floats or we'll lose
+		// timestamp precision. First calculate the number of decimal
+		// digits.
+		decDigits := len(inputTime) - i - 1
+		if decDigits < multiplier {
+			// Pad out zeroes to nanosecond resolution.
+			zeroes := strings.Repeat("0", multiplier-decDigits)
+			inputTime = inputTime + zeroes
+		} else if decDigits > multiplier {
+			// Truncate to nanosecond resolution.
+			inputTime = inputTime[:len(inputTime)-(decDigits-multiplier)]
+		}
+		// Finally remove the decimal and parse the value as an integer.
+		intStr := fmt.Sprintf("%s%s", inputTime[:i], inputTime[i+1:])
+		parsedInt, err = strconv.ParseUint(intStr, 10, 64)
+	}
+	if err != nil {
+		err = fmt.Errorf("Error parsing %s time: %s", timeLayout, err.Error())
+		return parsedTime, err
+	}
+	return time.Unix(0, int64(parsedInt)), nil
+}
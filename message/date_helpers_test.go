@@ -16,6 +16,7 @@ package message
 
 import (
 	"testing"
+	"time"
 )
 
 func TestEpochInt(t *testing.T) {
@@ -107,3 +108,107 @@ func TestEpochNanoFloat(t *testing.T) {
 		t.Errorf("Wrong EpochNano time w/ float: %d", ts.UnixNano())
 	}
 }
+
+func TestStrftimeToLayout(t *testing.T) {
+	layout, err := StrftimeToLayout("%Y-%m-%dT%H:%M:%S %z %%")
+	if err != nil {
+		t.Fatalf("Error converting strftime pattern: %s", err)
+	}
+	if layout != "2006-01-02T15:04:05 -0700 %" {
+		t.Errorf("Wrong layout: %s", layout)
+	}
+	if _, err = StrftimeToLayout("%Y %Q"); err == nil {
+		t.Error("Expected an error for an unsupported conversion")
+	}
+}
+
+func TestTimeParserLayoutsInOrder(t *testing.T) {
+	tp, err := NewTimeParser([]string{"%d/%m/%Y %H:%M", "02/01/2006"}, nil)
+	if err != nil {
+		t.Fatalf("Error creating TimeParser: %s", err)
+	}
+	ts, err := tp.Parse("03/02/2015 10:20")
+	if err != nil {
+		t.Fatalf("Error parsing time: %s", err)
+	}
+	if !ts.Equal(time.Date(2015, 2, 3, 10, 20, 0, 0, time.UTC)) {
+		t.Errorf("Wrong time: %s", ts)
+	}
+	if ts, err = tp.Parse("03/02/2015"); err != nil || ts.Month() != 2 {
+		t.Errorf("Wrong time for second layout: %s %v", ts, err)
+	}
+}
+
+func TestTimeParserNamedAndEpoch(t *testing.T) {
+	tp, _ := NewTimeParser([]string{"RFC1123", "EpochMilli"}, nil)
+	ts, err := tp.Parse("1414448234638")
+	if err != nil || ts.UnixNano() != 1414448234638000000 {
+		t.Errorf("Wrong EpochMilli time: %d %v", ts.UnixNano(), err)
+	}
+	ts, err = tp.Parse("Mon, 27 Oct 2014 22:17:14 UTC")
+	if err != nil || ts.Unix() != 1414448234 {
+		t.Errorf("Wrong RFC1123 time: %d %v", ts.Unix(), err)
+	}
+}
+
+func TestTimeParserDetection(t *testing.T) {
+	tp, _ := NewTimeParser(nil, nil)
+	tp.now = func() time.Time {
+		return time.Date(2015, 1, 2, 0, 0, 0, 0, time.UTC)
+	}
+	expected := map[string]time.Time{
+		"2015-02-03T04:05:06.5Z":     time.Date(2015, 2, 3, 4, 5, 6, 5e8, time.UTC),
+		"2015-02-03 04:05:06":        time.Date(2015, 2, 3, 4, 5, 6, 0, time.UTC),
+		"03/Feb/2015:04:05:06 +0000": time.Date(2015, 2, 3, 4, 5, 6, 0, time.UTC),
+		// syslog w/o year, the closest year to now is used
+		"Dec 31 23:59:59": time.Date(2014, 12, 31, 23, 59, 59, 0, time.UTC),
+		"Jan  1 00:00:01": time.Date(2015, 1, 1, 0, 0, 1, 0, time.UTC),
+		"Feb  3 04:05:06": time.Date(2015, 2, 3, 4, 5, 6, 0, time.UTC),
+		// ISO week dates
+		"2015-W01-1":           time.Date(2014, 12, 29, 0, 0, 0, 0, time.UTC),
+		"2015W053":             time.Date(2015, 1, 28, 0, 0, 0, 0, time.UTC),
+		"2015-W05-3T10:30:00Z": time.Date(2015, 1, 28, 10, 30, 0, 0, time.UTC),
+		// time only, the current date is used
+		"4:05PM": time.Date(2015, 1, 2, 16, 5, 0, 0, time.UTC),
+	}
+	for value, want := range expected {
+		ts, err := tp.Parse(value)
+		if err != nil {
+			t.Errorf("Error parsing '%s': %s", value, err)
+			continue
+		}
+		if !ts.Equal(want) {
+			t.Errorf("Wrong time for '%s': %s", value, ts)
+		}
+	}
+	if _, err := tp.Parse("not a time"); err == nil {
+		t.Error("Expected an error for an unrecognized format")
+	}
+}
+
+func TestTimeParserWithoutDetection(t *testing.T) {
+	tp, _ := NewTimeParser([]string{"%Y-%m-%d"}, nil)
+	tp.Detect = false
+	ts, err := tp.Parse("2015-02-03")
+	if err != nil || !ts.Equal(time.Date(2015, 2, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Wrong strftime time: %s %v", ts, err)
+	}
+	// the Go time layout constants are still tried
+	ts, err = tp.Parse("Mon, 27 Oct 2014 22:17:14 UTC")
+	if err != nil || ts.Unix() != 1414448234 {
+		t.Errorf("Wrong RFC1123 time: %d %v", ts.Unix(), err)
+	}
+	if _, err = tp.Parse("03/Feb/2015:04:05:06 +0000"); err == nil {
+		t.Error("Expected an error for a format that is only detected")
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	ts := time.Unix(1414448234, 638504391).UTC()
+	if s := FormatTime("RFC3339", ts); s != "2014-10-27T22:17:14Z" {
+		t.Errorf("Wrong RFC3339 format: %s", s)
+	}
+	if s := FormatTime("EpochMilli", ts); s != "1414448234638" {
+		t.Errorf("Wrong EpochMilli format: %s", s)
+	}
+}
@@ -26,6 +26,8 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/gogo/protobuf/proto"
 )
@@ -250,8 +252,22 @@ func CopyMessage(src *Message) *Message {
 	return dst
 }
 
+var objectPtrType = reflect.TypeOf((*Object)(nil))
+
 func getValueType(v reflect.Value) (t Field_ValueType, err error) {
 	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			t = Field_OBJECT
+		} else {
+			err = fmt.Errorf("unsupported map key type: %v", v.Type().Key())
+		}
+	case reflect.Ptr:
+		if v.Type() == objectPtrType {
+			t = Field_OBJECT
+		} else {
+			err = fmt.Errorf("unsupported value kind: %v type: %v", v.Kind(), v.Type())
+		}
 	case reflect.String:
 		t = Field_STRING
 	case reflect.Array, reflect.Slice:
@@ -311,6 +327,50 @@ func NewField(name string, value interface{}, representation string) (f *Field,
 	return
 }
 
+// NewMultiValueField returns a field holding all of the values, nil if they
+// aren't strings, byte slices, bools, int64s or float64s of the same type. A
+// mix of integers and floating point numbers is stored as doubles.
+func NewMultiValueField(name string, values []interface{}) *Field {
+	var valueType Field_ValueType
+	if len(values) == 0 {
+		return nil
+	}
+	for i, value := range values {
+		var vt Field_ValueType
+		switch value.(type) {
+		case string:
+			vt = Field_STRING
+		case []byte:
+			vt = Field_BYTES
+		case bool:
+			vt = Field_BOOL
+		case int64:
+			vt = Field_INTEGER
+		case float64:
+			vt = Field_DOUBLE
+		default:
+			return nil
+		}
+		if i > 0 && vt != valueType {
+			numeric := (vt == Field_INTEGER || vt == Field_DOUBLE) &&
+				(valueType == Field_INTEGER || valueType == Field_DOUBLE)
+			if !numeric {
+				return nil
+			}
+			vt = Field_DOUBLE
+		}
+		valueType = vt
+	}
+	f := NewFieldInit(name, valueType, "")
+	for _, value := range values {
+		if i, ok := value.(int64); ok && valueType == Field_DOUBLE {
+			value = float64(i)
+		}
+		f.AddValue(value)
+	}
+	return f
+}
+
 // Field initializer sets up the key, value type, and format but does not actually add a value
 func NewFieldInit(name string, valueType Field_ValueType, representation string) *Field {
 	f := &Field{}
@@ -399,6 +459,16 @@ func (f *Field) AddValue(value interface{}) error {
 			f.ValueBool = f.ValueBool[0 : l+1]
 		}
 		f.ValueBool[l] = v.Bool()
+	case Field_OBJECT:
+		var obj *Object
+		if v.Kind() == reflect.Map {
+			if obj, err = newObjectFromMap(v); err != nil {
+				return err
+			}
+		} else if obj = CopyObject(value.(*Object)); obj == nil {
+			return fmt.Errorf("nil Object value")
+		}
+		f.ValueObject = append(f.ValueObject, obj)
 	}
 	return nil
 }
@@ -426,10 +496,57 @@ func (f *Field) GetValue() (value interface{}) {
 		if len(f.ValueBool) > 0 {
 			value = f.ValueBool[0]
 		}
+	case Field_OBJECT:
+		if len(f.ValueObject) > 0 {
+			value = f.ValueObject[0]
+		}
 	}
 	return
 }
 
+// Helper function that returns all of the field's values.
+func (f *Field) GetValues() (values []interface{}) {
+	switch f.GetValueType() {
+	case Field_STRING:
+		for _, v := range f.ValueString {
+			values = append(values, v)
+		}
+	case Field_BYTES:
+		for _, v := range f.ValueBytes {
+			values = append(values, v)
+		}
+	case Field_INTEGER:
+		for _, v := range f.ValueInteger {
+			values = append(values, v)
+		}
+	case Field_DOUBLE:
+		for _, v := range f.ValueDouble {
+			values = append(values, v)
+		}
+	case Field_BOOL:
+		for _, v := range f.ValueBool {
+			values = append(values, v)
+		}
+	case Field_OBJECT:
+		for _, v := range f.ValueObject {
+			values = append(values, v)
+		}
+	}
+	return
+}
+
+// Helper function that returns all of the field's values, w/ OBJECT values
+// converted into maps by Object.Map.
+func (f *Field) GetNativeValues() []interface{} {
+	values := f.GetValues()
+	for i, v := range values {
+		if obj, ok := v.(*Object); ok {
+			values[i] = obj.Map()
+		}
+	}
+	return values
+}
+
 // Field copy constructor
 func CopyField(src *Field) *Field {
 	if src == nil {
@@ -470,9 +587,137 @@ func CopyField(src *Field) *Field {
 		dst.ValueBool = make([]bool, len(src.ValueBool))
 		copy(dst.ValueBool, src.ValueBool)
 	}
+	if src.ValueObject != nil {
+		dst.ValueObject = make([]*Object, len(src.ValueObject))
+		for i, v := range src.ValueObject {
+			dst.ValueObject[i] = CopyObject(v)
+		}
+	}
 	return dst
 }
 
+// Object copy constructor
+func CopyObject(src *Object) *Object {
+	if src == nil {
+		return nil
+	}
+	dst := &Object{Fields: make([]*Field, len(src.Fields))}
+	for i, v := range src.Fields {
+		dst.Fields[i] = CopyField(v)
+	}
+	return dst
+}
+
+// NewObject creates a structured value from a map, each entry becomes a
+// member field. Nested maps become OBJECT fields and slices become multi-value
+// fields, so all elements of a slice must have the same type.
+func NewObject(members map[string]interface{}) (*Object, error) {
+	return newObjectFromMap(reflect.ValueOf(members))
+}
+
+// Map returns the members of a structured value as a map, the reverse of
+// NewObject. Members w/ a single value map onto that value, those w/ several
+// values onto a slice and OBJECT values become nested maps.
+func (o *Object) Map() map[string]interface{} {
+	members := make(map[string]interface{}, len(o.GetFields()))
+	for _, f := range o.GetFields() {
+		if f == nil {
+			continue
+		}
+		values := f.GetNativeValues()
+		switch len(values) {
+		case 0:
+		case 1:
+			members[f.GetName()] = values[0]
+		default:
+			members[f.GetName()] = values
+		}
+	}
+	return members
+}
+
+func newObjectFromMap(v reflect.Value) (obj *Object, err error) {
+	keys := make(map[string]reflect.Value, v.Len())
+	names := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys[k.String()] = k
+		names = append(names, k.String())
+	}
+	sort.Strings(names) // keep the encoding deterministic
+
+	obj = &Object{Fields: make([]*Field, 0, len(names))}
+	for _, name := range names {
+		var f *Field
+		if f, err = newMemberField(name, v.MapIndex(keys[name])); err != nil {
+			return nil, err
+		}
+		obj.Fields = append(obj.Fields, f)
+	}
+	return
+}
+
+func newMemberField(name string, v reflect.Value) (f *Field, err error) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, fmt.Errorf("member '%s' has no value", name)
+	}
+	if k := v.Kind(); (k == reflect.Slice || k == reflect.Array) &&
+		v.Type().Elem().Kind() != reflect.Uint8 {
+		if v.Len() == 0 {
+			return nil, fmt.Errorf("member '%s' is an empty list", name)
+		}
+		for i := 0; i < v.Len(); i++ {
+			item := v.Index(i)
+			if item.Kind() == reflect.Interface {
+				item = item.Elem()
+			}
+			if !item.IsValid() {
+				return nil, fmt.Errorf("member '%s' has a null list item", name)
+			}
+			if k := item.Kind(); (k == reflect.Slice || k == reflect.Array) &&
+				item.Type().Elem().Kind() != reflect.Uint8 {
+				return nil, fmt.Errorf("member '%s' contains a nested list", name)
+			}
+			if f == nil {
+				if f, err = NewField(name, item.Interface(), ""); err != nil {
+					return nil, fmt.Errorf("member '%s': %s", name, err)
+				}
+			} else if err = f.AddValue(item.Interface()); err != nil {
+				return nil, fmt.Errorf("member '%s': %s", name, err)
+			}
+		}
+		return
+	}
+	if f, err = NewField(name, v.Interface(), ""); err != nil {
+		return nil, fmt.Errorf("member '%s': %s", name, err)
+	}
+	return
+}
+
+// FindFirstField finds and returns the first member field with the specified
+// name if not found nil is returned
+func (o *Object) FindFirstField(name string) *Field {
+	if o == nil {
+		return nil
+	}
+	for _, v := range o.Fields {
+		if v != nil && v.GetName() == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// Adds a member Field to the object
+func (o *Object) AddField(f *Field) {
+	if o == nil {
+		return
+	}
+	o.Fields = append(o.Fields, f)
+}
+
 // FindFirstField finds and returns the first field with the specified name
 // if not found nil is returned
 func (m *Message) FindFirstField(name string) *Field {
@@ -487,6 +732,50 @@ func (m *Message) FindFirstField(name string) *Field {
 	return nil
 }
 
+// FindFieldPath finds and returns the field identified by a dot separated
+// path e.g. "http.request.method", each leading path segment names an OBJECT
+// field whose first value is searched for the next segment. A field whose
+// name exactly matches the whole path takes precedence. If not found nil is
+// returned.
+func (m *Message) FindFieldPath(path string) *Field {
+	if f := m.FindFirstField(path); f != nil || m == nil {
+		return f
+	}
+	if !strings.Contains(path, ".") {
+		return nil
+	}
+	return findFieldPath(m.Fields, strings.Split(path, "."), 0)
+}
+
+// findFieldPath returns the fi'th field matching the last path segment.
+func findFieldPath(fields []*Field, path []string, fi int) *Field {
+	last := len(path) - 1
+	for _, name := range path[:last] {
+		var next []*Field
+		for _, v := range fields {
+			if v != nil && v.GetName() == name {
+				if v.GetValueType() == Field_OBJECT && len(v.ValueObject) > 0 {
+					next = v.ValueObject[0].Fields
+				}
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		fields = next
+	}
+	for _, v := range fields {
+		if v != nil && v.GetName() == path[last] {
+			if fi == 0 {
+				return v
+			}
+			fi--
+		}
+	}
+	return nil
+}
+
 // GetFieldValue helper function to simplify extracting single value fields
 func (m *Message) GetFieldValue(name string) (value interface{}, ok bool) {
 	if m == nil {
@@ -11,6 +11,7 @@
 	It has these top-level messages:
 		Header
 		Field
+		Object
 		Message
 */
 package message
@@ -72,6 +73,7 @@ const (
 	Field_INTEGER Field_ValueType = 2
 	Field_DOUBLE  Field_ValueType = 3
 	Field_BOOL    Field_ValueType = 4
+	Field_OBJECT  Field_ValueType = 5
 )
 
 var Field_ValueType_name = map[int32]string{
@@ -80,6 +82,7 @@ var Field_ValueType_name = map[int32]string{
 	2: "INTEGER",
 	3: "DOUBLE",
 	4: "BOOL",
+	5: "OBJECT",
 }
 var Field_ValueType_value = map[string]int32{
 	"STRING":  0,
@@ -87,6 +90,7 @@ var Field_ValueType_value = map[string]int32{
 	"INTEGER": 2,
 	"DOUBLE":  3,
 	"BOOL":    4,
+	"OBJECT":  5,
 }
 
 func (x Field_ValueType) Enum() *Field_ValueType {
@@ -165,6 +169,7 @@ type Field struct {
 	ValueInteger     []int64          `protobuf:"varint,6,rep,packed,name=value_integer" json:"value_integer,omitempty"`
 	ValueDouble      []float64        `protobuf:"fixed64,7,rep,packed,name=value_double" json:"value_double,omitempty"`
 	ValueBool        []bool           `protobuf:"varint,8,rep,packed,name=value_bool" json:"value_bool,omitempty"`
+	ValueObject      []*Object        `protobuf:"bytes,9,rep,name=value_object" json:"value_object,omitempty"`
 	XXX_unrecognized []byte           `json:"-"`
 }
 
@@ -230,6 +235,29 @@ func (m *Field) GetValueBool() []bool {
 	return nil
 }
 
+func (m *Field) GetValueObject() []*Object {
+	if m != nil {
+		return m.ValueObject
+	}
+	return nil
+}
+
+type Object struct {
+	Fields           []*Field `protobuf:"bytes,1,rep,name=fields" json:"fields,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *Object) Reset()         { *m = Object{} }
+func (m *Object) String() string { return proto.CompactTextString(m) }
+func (*Object) ProtoMessage()    {}
+
+func (m *Object) GetFields() []*Field {
+	if m != nil {
+		return m.Fields
+	}
+	return nil
+}
+
 type Message struct {
 	Uuid             []byte   `protobuf:"bytes,1,req,name=uuid" json:"uuid,omitempty"`
 	Timestamp        *int64   `protobuf:"varint,2,req,name=timestamp" json:"timestamp,omitempty"`
@@ -745,6 +773,94 @@ func (m *Field) Unmarshal(data []byte) error {
 			} else {
 				return fmt.Errorf("proto: wrong wireType = %d for field ValueBool", wireType)
 			}
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ValueObject", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ValueObject = append(m.ValueObject, &Object{})
+			m.ValueObject[len(m.ValueObject)-1].Unmarshal(data[index:postIndex])
+			index = postIndex
+		default:
+			var sizeOfWire int
+			for {
+				sizeOfWire++
+				wire >>= 7
+				if wire == 0 {
+					break
+				}
+			}
+			index -= sizeOfWire
+			skippy, err := github_com_gogo_protobuf_proto.Skip(data[index:])
+			if err != nil {
+				return err
+			}
+			if (index + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, data[index:index+skippy]...)
+			index += skippy
+		}
+	}
+	return nil
+}
+func (m *Object) Unmarshal(data []byte) error {
+	l := len(data)
+	index := 0
+	for index < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if index >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := data[index]
+			index++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Fields", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			postIndex := index + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Fields = append(m.Fields, &Field{})
+			m.Fields[len(m.Fields)-1].Unmarshal(data[index:postIndex])
+			index = postIndex
 		default:
 			var sizeOfWire int
 			for {
@@ -1086,6 +1202,27 @@ func (m *Field) Size() (n int) {
 	if len(m.ValueBool) > 0 {
 		n += 1 + sovMessage(uint64(len(m.ValueBool))) + len(m.ValueBool)*1
 	}
+	if len(m.ValueObject) > 0 {
+		for _, e := range m.ValueObject {
+			l = e.Size()
+			n += 1 + l + sovMessage(uint64(l))
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *Object) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Fields) > 0 {
+		for _, e := range m.Fields {
+			l = e.Size()
+			n += 1 + l + sovMessage(uint64(l))
+		}
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1311,6 +1448,51 @@ func (m *Field) MarshalTo(data []byte) (n int, err error) {
 			i++
 		}
 	}
+	if len(m.ValueObject) > 0 {
+		for _, msg := range m.ValueObject {
+			data[i] = 0x4a
+			i++
+			i = encodeVarintMessage(data, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(data[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *Object) Marshal() (data []byte, err error) {
+	size := m.Size()
+	data = make([]byte, size)
+	n, err := m.MarshalTo(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (m *Object) MarshalTo(data []byte) (n int, err error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Fields) > 0 {
+		for _, msg := range m.Fields {
+			data[i] = 0xa
+			i++
+			i = encodeVarintMessage(data, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
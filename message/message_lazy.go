@@ -0,0 +1,118 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package message
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+const fieldsKey = 10<<3 | 2 // Message.Fields tag, length delimited
+
+// UnmarshalLazy decodes the message header variables from data and appends
+// the encoded Fields to fields, the resulting slice is returned. The Fields
+// can be decoded later using DecodeLazyFields. Unlike proto.Unmarshal neither
+// the message nor the returned Fields retain any references to data.
+func UnmarshalLazy(data []byte, m *Message, fields []byte) ([]byte, error) {
+	var hdr []byte
+	aliased := true // hdr is a prefix of data
+	start := len(fields)
+	for i := 0; i < len(data); {
+		key, n := proto.DecodeVarint(data[i:])
+		if n == 0 {
+			// let the full decoder report it
+			return fields[:start], proto.Unmarshal(data, m)
+		}
+		end := skipValue(data, i+n, int(key&0x7))
+		if end < 0 {
+			return fields[:start], proto.Unmarshal(data, m)
+		}
+		switch {
+		case key == fieldsKey:
+			fields = append(fields, data[i:end]...)
+		case aliased && i == len(hdr):
+			hdr = data[:end]
+		default:
+			if aliased {
+				hdr = append([]byte(nil), hdr...)
+				aliased = false
+			}
+			hdr = append(hdr, data[i:end]...)
+		}
+		i = end
+	}
+	return fields, proto.Unmarshal(hdr, m)
+}
+
+// skipValue returns the offset just past the value of the given wire type
+// starting at offset i, -1 is returned if the value is truncated or the wire
+// type is not used by a Message.
+func skipValue(data []byte, i, wireType int) int {
+	switch wireType {
+	case 0: // varint
+		for ; i < len(data); i++ {
+			if data[i] < 0x80 {
+				return i + 1
+			}
+		}
+		return -1
+	case 1: // 64 bit
+		i += 8
+	case 2: // length delimited
+		l, n := proto.DecodeVarint(data[i:])
+		if n == 0 || l > uint64(len(data)-i-n) {
+			return -1
+		}
+		i += n + int(l)
+	case 5: // 32 bit
+		i += 4
+	default:
+		return -1
+	}
+	if i > len(data) {
+		return -1
+	}
+	return i
+}
+
+var errLazyFields = errors.New("not a list of encoded Fields")
+
+// DecodeLazyFields decodes the Fields returned by UnmarshalLazy and inserts
+// them ahead of any Fields added to the message since. If a Field fails to
+// decode the message is left unchanged and the error is returned.
+func DecodeLazyFields(fields []byte, m *Message) error {
+	var decoded []*Field
+	for i := 0; i < len(fields); {
+		key, n := proto.DecodeVarint(fields[i:])
+		if n == 0 || key != fieldsKey {
+			return errLazyFields
+		}
+		end := skipValue(fields, i+n, 2)
+		if end < 0 {
+			return errLazyFields
+		}
+		_, ln := proto.DecodeVarint(fields[i+n:])
+		f := new(Field)
+		if err := f.Unmarshal(fields[i+n+ln : end]); err != nil {
+			return fmt.Errorf("field %d: %s", len(decoded), err)
+		}
+		decoded = append(decoded, f)
+		i = end
+	}
+	m.Fields = append(decoded, m.Fields...)
+	return nil
+}
@@ -14,16 +14,21 @@
 
 package message
 
-import "strings"
+import (
+	"net"
+	"strings"
+)
 
 // MatcherSpecification used by the message router to distribute messages
 type MatcherSpecification struct {
-	vm   *tree
-	spec string
+	vm     *tree
+	match  matchFunc
+	spec   string
+	fields bool
 }
 
-// CreateMatcherSpecification compiles the spec string into a simple
-// virtual machine for execution
+// CreateMatcherSpecification parses the spec string and compiles it into a
+// tree of specialized closures for execution
 func CreateMatcherSpecification(spec string) (*MatcherSpecification, error) {
 	ms := new(MatcherSpecification)
 	ms.spec = spec
@@ -31,13 +36,15 @@ func CreateMatcherSpecification(spec string) (*MatcherSpecification, error) {
 	if err != nil {
 		return nil, err
 	}
+	ms.match = compileMatcherSpecification(ms.vm)
+	ms.fields = usesFields(ms.vm)
 	return ms, nil
 }
 
 // Match compares the message against the matcher spec and return the match
 // result
 func (m *MatcherSpecification) Match(message *Message) bool {
-	return evalMatcherSpecification(m.vm, message)
+	return m.match(message)
 }
 
 // String outputs the spec as text
@@ -45,6 +52,23 @@ func (m *MatcherSpecification) String() string {
 	return m.spec
 }
 
+// UsesFields returns true if the spec tests any message Fields
+func (m *MatcherSpecification) UsesFields() bool {
+	return m.fields
+}
+
+func usesFields(t *tree) bool {
+	if t == nil {
+		return false
+	}
+	if t.left == nil {
+		return t.stmt.field.tokenId == VAR_FIELDS
+	}
+	return usesFields(t.left) || usesFields(t.right)
+}
+
+// evalMatcherSpecification interprets the parse tree directly, it is the
+// reference implementation for the compiled matcher.
 func evalMatcherSpecification(t *tree, msg *Message) (b bool) {
 	if t == nil {
 		return false
@@ -99,7 +123,8 @@ func getNumericValue(msg *Message, stmt *Statement) float64 {
 }
 
 func stringTest(s string, stmt *Statement) bool {
-	if stmt.value.tokenId == NUMERIC_VALUE {
+	switch stmt.value.tokenId {
+	case NUMERIC_VALUE, TRUE, FALSE:
 		return false
 	}
 	switch stmt.op.tokenId {
@@ -137,6 +162,14 @@ func stringTest(s string, stmt *Statement) bool {
 		} else if stmt.value.fieldIndex == ENDS_WITH {
 			return !strings.HasSuffix(s, stmt.value.token)
 		}
+	case OP_IN_CIDR:
+		if ip := net.ParseIP(s); ip != nil {
+			return ipInNets(ip, stmt.value.cidrs)
+		}
+	case OP_NOT_IN_CIDR:
+		if ip := net.ParseIP(s); ip != nil {
+			return !ipInNets(ip, stmt.value.cidrs)
+		}
 	}
 	return false
 }
@@ -186,20 +219,11 @@ func testExpr(msg *Message, stmt *Statement) bool {
 		case VAR_TIMESTAMP, VAR_SEVERITY, VAR_PID:
 			return numericTest(getNumericValue(msg, stmt), stmt)
 		case VAR_FIELDS:
-			fi := stmt.field.fieldIndex
 			ai := stmt.field.arrayIndex
-			var field *Field
-
-			if fi != 0 {
-				fields := msg.FindAllFields(stmt.field.token)
-				if fi >= len(fields) {
-					return testNonExistence(stmt)
-				}
-				field = fields[fi]
-			} else {
-				if field = msg.FindFirstField(stmt.field.token); field == nil {
-					return testNonExistence(stmt)
-				}
+			field := lookupField(msg, stmt.field.token, fieldPath(stmt.field.token),
+				stmt.field.fieldIndex)
+			if field == nil {
+				return testNonExistence(stmt)
 			}
 			switch field.GetValueType() {
 			case Field_STRING:
@@ -226,18 +250,25 @@ func testExpr(msg *Message, stmt *Statement) bool {
 				if ai >= len(field.ValueBool) {
 					return testNonExistence(stmt)
 				}
-				if stmt.value.tokenId == NIL_VALUE {
+				b := field.ValueBool[ai]
+				switch stmt.value.tokenId {
+				case NIL_VALUE:
 					if stmt.op.tokenId == OP_EQ {
 						return false
 					}
 					return true
-				}
-				b := field.ValueBool[ai]
-				if stmt.value.tokenId == TRUE {
+				case TRUE:
 					return (b == true)
-				} else {
+				case FALSE:
 					return (b == false)
 				}
+			case Field_OBJECT:
+				if ai >= len(field.ValueObject) {
+					return testNonExistence(stmt)
+				}
+				if stmt.value.tokenId == NIL_VALUE {
+					return stmt.op.tokenId == OP_NE
+				}
 			}
 		}
 	}
@@ -0,0 +1,476 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package message
+
+import (
+	"math"
+	"net"
+	"strings"
+)
+
+// matchFunc is a compiled matcher (sub)expression.
+type matchFunc func(msg *Message) bool
+
+// compiled is the result of compiling a tree node. When constant is true the
+// expression does not depend on the message and value holds its result, fn
+// is only valid for non constant expressions.
+type compiled struct {
+	fn       matchFunc
+	constant bool
+	value    bool
+}
+
+func constantMatch(b bool) compiled {
+	return compiled{constant: true, value: b}
+}
+
+func alwaysFalse(msg *Message) bool { return false }
+func alwaysTrue(msg *Message) bool  { return true }
+
+// matchFunc returns a callable version of the compiled expression.
+func (c compiled) matchFunc() matchFunc {
+	if !c.constant {
+		return c.fn
+	}
+	if c.value {
+		return alwaysTrue
+	}
+	return alwaysFalse
+}
+
+// compileMatcherSpecification converts the parse tree into a tree of Go
+// closures specialized for each statement's variable, operator and value
+// type. Logical operators with a constant operand are folded away.
+func compileMatcherSpecification(t *tree) matchFunc {
+	return compileTree(t).matchFunc()
+}
+
+func compileTree(t *tree) compiled {
+	if t == nil {
+		return constantMatch(false)
+	}
+	if t.left == nil {
+		return compileStatement(t.stmt)
+	}
+
+	left := compileTree(t.left)
+	if t.right == nil {
+		return left
+	}
+	right := compileTree(t.right)
+
+	switch t.stmt.op.tokenId {
+	case OP_AND:
+		if left.constant {
+			if !left.value {
+				return left
+			}
+			return right
+		}
+		if right.constant {
+			if right.value {
+				return left
+			}
+			return right
+		}
+		l, r := left.fn, right.fn
+		return compiled{fn: func(msg *Message) bool {
+			return l(msg) && r(msg)
+		}}
+	case OP_OR:
+		if left.constant {
+			if left.value {
+				return left
+			}
+			return right
+		}
+		if right.constant {
+			if !right.value {
+				return left
+			}
+			return right
+		}
+		l, r := left.fn, right.fn
+		return compiled{fn: func(msg *Message) bool {
+			return l(msg) || r(msg)
+		}}
+	}
+	return right
+}
+
+func compileStatement(stmt *Statement) compiled {
+	switch stmt.op.tokenId {
+	case TRUE:
+		return constantMatch(true)
+	case FALSE:
+		return constantMatch(false)
+	}
+
+	switch stmt.field.tokenId {
+	case VAR_UUID, VAR_TYPE, VAR_LOGGER, VAR_PAYLOAD, VAR_ENVVERSION,
+		VAR_HOSTNAME:
+		return compileStringVar(stmt)
+	case VAR_TIMESTAMP, VAR_SEVERITY, VAR_PID:
+		return compileNumericVar(stmt)
+	case VAR_FIELDS:
+		return compileField(stmt)
+	}
+	return constantMatch(false)
+}
+
+func compileStringVar(stmt *Statement) compiled {
+	test, c := compileStringTest(stmt)
+	if test == nil {
+		return c
+	}
+	switch stmt.field.tokenId {
+	case VAR_UUID:
+		return compiled{fn: func(msg *Message) bool {
+			return test(msg.GetUuidString())
+		}}
+	case VAR_TYPE:
+		return compiled{fn: func(msg *Message) bool {
+			return test(msg.GetType())
+		}}
+	case VAR_LOGGER:
+		return compiled{fn: func(msg *Message) bool {
+			return test(msg.GetLogger())
+		}}
+	case VAR_PAYLOAD:
+		return compiled{fn: func(msg *Message) bool {
+			return test(msg.GetPayload())
+		}}
+	case VAR_ENVVERSION:
+		return compiled{fn: func(msg *Message) bool {
+			return test(msg.GetEnvVersion())
+		}}
+	case VAR_HOSTNAME:
+		return compiled{fn: func(msg *Message) bool {
+			return test(msg.GetHostname())
+		}}
+	}
+	return constantMatch(false)
+}
+
+// compileStringTest returns a function comparing a string against the
+// statement's value. If the outcome doesn't depend on the string the
+// returned function is nil and the constant result is returned instead.
+func compileStringTest(stmt *Statement) (func(string) bool, compiled) {
+	switch stmt.value.tokenId {
+	case STRING_VALUE, REGEXP_VALUE:
+	case NIL_VALUE:
+		// a string is never NIL
+		return nil, constantMatch(stmt.op.tokenId == OP_NE)
+	default:
+		return nil, constantMatch(false)
+	}
+
+	v := stmt.value.token
+	switch stmt.op.tokenId {
+	case OP_EQ:
+		return func(s string) bool { return s == v }, compiled{}
+	case OP_NE:
+		return func(s string) bool { return s != v }, compiled{}
+	case OP_LT:
+		return func(s string) bool { return s < v }, compiled{}
+	case OP_LTE:
+		return func(s string) bool { return s <= v }, compiled{}
+	case OP_GT:
+		return func(s string) bool { return s > v }, compiled{}
+	case OP_GTE:
+		return func(s string) bool { return s >= v }, compiled{}
+	case OP_RE, OP_NRE:
+		var test func(string) bool
+		if re := stmt.value.regexp; re != nil {
+			test = re.MatchString
+		} else if stmt.value.fieldIndex == STARTS_WITH {
+			test = func(s string) bool { return strings.HasPrefix(s, v) }
+		} else if stmt.value.fieldIndex == ENDS_WITH {
+			test = func(s string) bool { return strings.HasSuffix(s, v) }
+		} else {
+			return nil, constantMatch(false)
+		}
+		if stmt.op.tokenId == OP_NRE {
+			return func(s string) bool { return !test(s) }, compiled{}
+		}
+		return test, compiled{}
+	case OP_IN_CIDR:
+		cidrs := stmt.value.cidrs
+		return func(s string) bool {
+			ip := net.ParseIP(s)
+			return ip != nil && ipInNets(ip, cidrs)
+		}, compiled{}
+	case OP_NOT_IN_CIDR:
+		cidrs := stmt.value.cidrs
+		return func(s string) bool {
+			ip := net.ParseIP(s)
+			return ip != nil && !ipInNets(ip, cidrs)
+		}, compiled{}
+	}
+	return nil, constantMatch(false)
+}
+
+func ipInNets(ip net.IP, cidrs []*net.IPNet) bool {
+	for _, ipnet := range cidrs {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileNumericVar(stmt *Statement) compiled {
+	// all numeric header variables are integers so equality against a value
+	// with a fractional part can be decided at compile time
+	if stmt.value.tokenId == NUMERIC_VALUE {
+		if f := stmt.value.double; f != math.Trunc(f) {
+			switch stmt.op.tokenId {
+			case OP_EQ:
+				return constantMatch(false)
+			case OP_NE:
+				return constantMatch(true)
+			}
+		}
+	}
+	test, c := compileNumericTest(stmt)
+	if test == nil {
+		return c
+	}
+	switch stmt.field.tokenId {
+	case VAR_TIMESTAMP:
+		return compiled{fn: func(msg *Message) bool {
+			return test(float64(msg.GetTimestamp()))
+		}}
+	case VAR_SEVERITY:
+		return compiled{fn: func(msg *Message) bool {
+			return test(float64(msg.GetSeverity()))
+		}}
+	case VAR_PID:
+		return compiled{fn: func(msg *Message) bool {
+			return test(float64(msg.GetPid()))
+		}}
+	}
+	return constantMatch(false)
+}
+
+// compileNumericTest is the numeric counterpart of compileStringTest.
+func compileNumericTest(stmt *Statement) (func(float64) bool, compiled) {
+	switch stmt.value.tokenId {
+	case NUMERIC_VALUE:
+	case NIL_VALUE:
+		return nil, constantMatch(stmt.op.tokenId == OP_NE)
+	default:
+		return nil, constantMatch(false)
+	}
+
+	v := stmt.value.double
+	switch stmt.op.tokenId {
+	case OP_EQ:
+		return func(f float64) bool { return f == v }, compiled{}
+	case OP_NE:
+		return func(f float64) bool { return f != v }, compiled{}
+	case OP_LT:
+		return func(f float64) bool { return f < v }, compiled{}
+	case OP_LTE:
+		return func(f float64) bool { return f <= v }, compiled{}
+	case OP_GT:
+		return func(f float64) bool { return f > v }, compiled{}
+	case OP_GTE:
+		return func(f float64) bool { return f >= v }, compiled{}
+	}
+	return nil, constantMatch(false)
+}
+
+// findField returns the fi'th field with the specified name without
+// allocating, nil is returned if there is no such field.
+func findField(msg *Message, name string, fi int) *Field {
+	if msg == nil {
+		return nil
+	}
+	for _, f := range msg.Fields {
+		if f != nil && f.Name != nil && *f.Name == name {
+			if fi == 0 {
+				return f
+			}
+			fi--
+		}
+	}
+	return nil
+}
+
+// lookupField returns the fi'th field with the specified name, if there is
+// none and a path is provided the field is searched for by path.
+func lookupField(msg *Message, name string, path []string, fi int) *Field {
+	f := findField(msg, name, fi)
+	if f == nil && path != nil && msg != nil {
+		f = findFieldPath(msg.Fields, path, fi)
+	}
+	return f
+}
+
+// fieldPath splits a field name into its path segments, nil is returned if
+// the name is not a path.
+func fieldPath(name string) []string {
+	if !strings.Contains(name, ".") {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// fieldRef is a field reference resolved when the matcher is compiled. The
+// name is split into its path and the indices are extracted once; as the
+// position of a field varies from message to message (and names may repeat)
+// the field itself is still located on every match.
+type fieldRef struct {
+	name string
+	path []string
+	fi   int
+	ai   int
+}
+
+func newFieldRef(stmt *Statement) *fieldRef {
+	return &fieldRef{
+		name: stmt.field.token,
+		path: fieldPath(stmt.field.token),
+		fi:   stmt.field.fieldIndex,
+		ai:   stmt.field.arrayIndex,
+	}
+}
+
+// find returns the referenced field, nil if the message has none. The top
+// level fields are scanned once for both the exact name and the first path
+// segment.
+func (r *fieldRef) find(msg *Message) *Field {
+	if msg == nil {
+		return nil
+	}
+	var parent *Field
+	fi := r.fi
+	for _, f := range msg.Fields {
+		if f == nil || f.Name == nil {
+			continue
+		}
+		if *f.Name == r.name {
+			if fi == 0 {
+				return f
+			}
+			fi--
+		} else if parent == nil && r.path != nil && *f.Name == r.path[0] {
+			parent = f
+		}
+	}
+	if parent == nil || parent.GetValueType() != Field_OBJECT ||
+		len(parent.ValueObject) == 0 {
+		return nil
+	}
+	return findFieldPath(parent.ValueObject[0].Fields, r.path[1:], r.fi)
+}
+
+// fieldValueExists reports whether the field holds a value at the array
+// index for its value type.
+func fieldValueExists(f *Field, ai int) bool {
+	switch f.GetValueType() {
+	case Field_STRING:
+		return ai < len(f.ValueString)
+	case Field_BYTES:
+		return ai < len(f.ValueBytes)
+	case Field_INTEGER:
+		return ai < len(f.ValueInteger)
+	case Field_DOUBLE:
+		return ai < len(f.ValueDouble)
+	case Field_BOOL:
+		return ai < len(f.ValueBool)
+	case Field_OBJECT:
+		return ai < len(f.ValueObject)
+	}
+	return false
+}
+
+func compileField(stmt *Statement) compiled {
+	ref := newFieldRef(stmt)
+	ai := ref.ai
+
+	// existence tests
+	if stmt.value.tokenId == NIL_VALUE {
+		if stmt.op.tokenId == OP_EQ {
+			return compiled{fn: func(msg *Message) bool {
+				f := ref.find(msg)
+				return f == nil || !fieldValueExists(f, ai)
+			}}
+		}
+		return compiled{fn: func(msg *Message) bool {
+			f := ref.find(msg)
+			return f != nil && fieldValueExists(f, ai)
+		}}
+	}
+
+	switch stmt.value.tokenId {
+	case TRUE, FALSE:
+		want := stmt.value.tokenId == TRUE
+		return compiled{fn: func(msg *Message) bool {
+			f := ref.find(msg)
+			if f == nil || f.GetValueType() != Field_BOOL || ai >= len(f.ValueBool) {
+				return false
+			}
+			return f.ValueBool[ai] == want
+		}}
+
+	case NUMERIC_VALUE:
+		test, c := compileNumericTest(stmt)
+		if test == nil {
+			return c
+		}
+		return compiled{fn: func(msg *Message) bool {
+			f := ref.find(msg)
+			if f == nil {
+				return false
+			}
+			switch f.GetValueType() {
+			case Field_INTEGER:
+				if ai < len(f.ValueInteger) {
+					return test(float64(f.ValueInteger[ai]))
+				}
+			case Field_DOUBLE:
+				if ai < len(f.ValueDouble) {
+					return test(f.ValueDouble[ai])
+				}
+			}
+			return false
+		}}
+
+	default: // STRING_VALUE, REGEXP_VALUE
+		test, c := compileStringTest(stmt)
+		if test == nil {
+			return c
+		}
+		return compiled{fn: func(msg *Message) bool {
+			f := ref.find(msg)
+			if f == nil {
+				return false
+			}
+			switch f.GetValueType() {
+			case Field_STRING:
+				if ai < len(f.ValueString) {
+					return test(f.ValueString[ai])
+				}
+			case Field_BYTES:
+				if ai < len(f.ValueBytes) {
+					return test(string(f.ValueBytes[ai]))
+				}
+			}
+			return false
+		}}
+	}
+}
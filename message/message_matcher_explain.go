@@ -0,0 +1,247 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package message
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MatchTraceResult is the outcome of a single matcher sub-expression.
+type MatchTraceResult int
+
+const (
+	TRACE_SKIPPED MatchTraceResult = iota // not evaluated due to short circuiting
+	TRACE_FALSE
+	TRACE_TRUE
+)
+
+func (r MatchTraceResult) String() string {
+	switch r {
+	case TRACE_FALSE:
+		return "false"
+	case TRACE_TRUE:
+		return "true"
+	}
+	return "skipped"
+}
+
+// MatchTrace records how a matcher (sub)expression was evaluated against a
+// message.
+type MatchTrace struct {
+	// Expression is the text of the sub-expression.
+	Expression string
+	// Value is the message value the expression tested, NIL if it does not
+	// exist. It is empty for logical operators, constants and skipped
+	// expressions.
+	Value string
+	// Result of the evaluation.
+	Result MatchTraceResult
+	// Children holds the operands of a logical operator.
+	Children []*MatchTrace
+}
+
+// String renders the trace as an indented tree, one sub-expression per line.
+func (mt *MatchTrace) String() string {
+	var buf bytes.Buffer
+	mt.write(&buf, 0)
+	return buf.String()
+}
+
+func (mt *MatchTrace) write(buf *bytes.Buffer, depth int) {
+	buf.WriteString(strings.Repeat("  ", depth))
+	fmt.Fprintf(buf, "[%s] %s", mt.Result, mt.Expression)
+	if mt.Value != "" {
+		fmt.Fprintf(buf, "  (value: %s)", mt.Value)
+	}
+	buf.WriteByte('\n')
+	for _, child := range mt.Children {
+		child.write(buf, depth+1)
+	}
+}
+
+// Explain evaluates the matcher against the message and returns a trace of
+// every sub-expression; the root's Result is the overall match result.
+func (m *MatcherSpecification) Explain(msg *Message) *MatchTrace {
+	if m.vm == nil {
+		return &MatchTrace{Expression: m.spec, Result: TRACE_FALSE}
+	}
+	return explainTree(m.vm, msg, false)
+}
+
+func traceResult(b bool) MatchTraceResult {
+	if b {
+		return TRACE_TRUE
+	}
+	return TRACE_FALSE
+}
+
+// explainTree mirrors evalMatcherSpecification, recording each node; when
+// skip is set the node (and its children) are recorded without being
+// evaluated.
+func explainTree(t *tree, msg *Message, skip bool) *MatchTrace {
+	mt := &MatchTrace{Expression: treeString(t)}
+	if t.left == nil {
+		if !skip {
+			mt.Result = traceResult(testExpr(msg, t.stmt))
+			mt.Value = statementValue(msg, t.stmt)
+		}
+		return mt
+	}
+
+	left := explainTree(t.left, msg, skip)
+	mt.Children = append(mt.Children, left)
+	if t.right == nil {
+		mt.Result = left.Result
+		return mt
+	}
+	if !skip {
+		switch {
+		case left.Result == TRACE_TRUE && t.stmt.op.tokenId == OP_OR,
+			left.Result == TRACE_FALSE && t.stmt.op.tokenId == OP_AND:
+			skip = true // short circuit
+			mt.Result = left.Result
+		}
+	}
+	right := explainTree(t.right, msg, skip)
+	mt.Children = append(mt.Children, right)
+	if !skip {
+		mt.Result = right.Result
+	}
+	return mt
+}
+
+// treeString converts a parse tree back into matcher syntax.
+func treeString(t *tree) string {
+	if t.left == nil {
+		return statementString(t.stmt)
+	}
+	if t.right == nil {
+		return treeString(t.left)
+	}
+	op := t.stmt.op.tokenId
+	operand := func(child *tree) string {
+		s := treeString(child)
+		// && binds tighter than || so an || operand needs parentheses
+		if op == OP_AND && child.left != nil && child.stmt.op.tokenId == OP_OR {
+			return "(" + s + ")"
+		}
+		return s
+	}
+	return operand(t.left) + " " + t.stmt.op.token + " " + operand(t.right)
+}
+
+func statementString(stmt *Statement) string {
+	switch stmt.op.tokenId {
+	case TRUE:
+		return "TRUE"
+	case FALSE:
+		return "FALSE"
+	}
+	return variableString(stmt) + " " + stmt.op.token + " " + valueString(stmt)
+}
+
+func variableString(stmt *Statement) string {
+	if stmt.field.tokenId != VAR_FIELDS {
+		return stmt.field.token
+	}
+	fi, ai := stmt.field.fieldIndex, stmt.field.arrayIndex
+	switch {
+	case ai != 0:
+		return fmt.Sprintf("Fields[%s][%d][%d]", stmt.field.token, fi, ai)
+	case fi != 0:
+		return fmt.Sprintf("Fields[%s][%d]", stmt.field.token, fi)
+	}
+	return fmt.Sprintf("Fields[%s]", stmt.field.token)
+}
+
+func valueString(stmt *Statement) string {
+	v := stmt.value
+	switch v.tokenId {
+	case STRING_VALUE:
+		return "'" + strings.Replace(v.token, "'", "\\'", -1) + "'"
+	case REGEXP_VALUE:
+		var re string
+		switch {
+		case v.regexp != nil:
+			re = v.token
+		case v.fieldIndex == STARTS_WITH:
+			re = "^" + regexp.QuoteMeta(v.token)
+		case v.fieldIndex == ENDS_WITH:
+			re = regexp.QuoteMeta(v.token) + "$"
+		}
+		return "/" + strings.Replace(re, "/", "\\/", -1) + "/"
+	case NIL_VALUE:
+		return "NIL"
+	case TRUE:
+		return "TRUE"
+	case FALSE:
+		return "FALSE"
+	}
+	return v.token
+}
+
+// statementValue returns a printable version of the message value tested by
+// the statement.
+func statementValue(msg *Message, stmt *Statement) string {
+	switch stmt.field.tokenId {
+	case VAR_UUID, VAR_TYPE, VAR_LOGGER, VAR_PAYLOAD, VAR_ENVVERSION,
+		VAR_HOSTNAME:
+		return strconv.Quote(getStringValue(msg, stmt))
+	case VAR_TIMESTAMP, VAR_SEVERITY, VAR_PID:
+		return strconv.FormatFloat(getNumericValue(msg, stmt), 'f', -1, 64)
+	case VAR_FIELDS:
+		f := lookupField(msg, stmt.field.token, fieldPath(stmt.field.token),
+			stmt.field.fieldIndex)
+		if f == nil {
+			return "NIL"
+		}
+		ai := stmt.field.arrayIndex
+		switch f.GetValueType() {
+		case Field_STRING:
+			if ai < len(f.ValueString) {
+				return strconv.Quote(f.ValueString[ai])
+			}
+		case Field_BYTES:
+			if ai < len(f.ValueBytes) {
+				return strconv.Quote(string(f.ValueBytes[ai]))
+			}
+		case Field_INTEGER:
+			if ai < len(f.ValueInteger) {
+				return strconv.FormatInt(f.ValueInteger[ai], 10)
+			}
+		case Field_DOUBLE:
+			if ai < len(f.ValueDouble) {
+				return strconv.FormatFloat(f.ValueDouble[ai], 'g', -1, 64)
+			}
+		case Field_BOOL:
+			if ai < len(f.ValueBool) {
+				if f.ValueBool[ai] {
+					return "TRUE"
+				}
+				return "FALSE"
+			}
+		case Field_OBJECT:
+			if ai < len(f.ValueObject) {
+				return f.ValueObject[ai].String()
+			}
+		}
+		return "NIL"
+	}
+	return ""
+}
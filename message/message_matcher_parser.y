@@ -4,8 +4,10 @@ package message
 import (
 	"fmt"
 	"log"
+	"net"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"unicode/utf8"
 )
@@ -16,19 +18,21 @@ const (
 )
 
 var variables = map[string]int{
-	"Uuid":       VAR_UUID,
-	"Type":       VAR_TYPE,
-	"Logger":     VAR_LOGGER,
-	"Payload":    VAR_PAYLOAD,
-	"EnvVersion": VAR_ENVVERSION,
-	"Hostname":   VAR_HOSTNAME,
-	"Timestamp":  VAR_TIMESTAMP,
-	"Severity":   VAR_SEVERITY,
-	"Pid":        VAR_PID,
-	"Fields":     VAR_FIELDS,
-	"TRUE":       TRUE,
-	"FALSE":      FALSE,
-	"NIL":        NIL_VALUE}
+	"Uuid":        VAR_UUID,
+	"Type":        VAR_TYPE,
+	"Logger":      VAR_LOGGER,
+	"Payload":     VAR_PAYLOAD,
+	"EnvVersion":  VAR_ENVVERSION,
+	"Hostname":    VAR_HOSTNAME,
+	"Timestamp":   VAR_TIMESTAMP,
+	"Severity":    VAR_SEVERITY,
+	"Pid":         VAR_PID,
+	"Fields":      VAR_FIELDS,
+	"TRUE":        TRUE,
+	"FALSE":       FALSE,
+	"NIL":         NIL_VALUE,
+	"IN_CIDR":     OP_IN_CIDR,
+	"NOT_IN_CIDR": OP_NOT_IN_CIDR}
 
 var parseLock sync.Mutex
 
@@ -77,9 +81,11 @@ var nodes []*tree
    fieldIndex  int
    arrayIndex  int
    regexp      *regexp.Regexp
+   cidrs       []*net.IPNet
 }
 
 %token OP_EQ OP_NE OP_GT OP_GTE OP_LT OP_LTE OP_RE OP_NRE
+%token OP_IN_CIDR OP_NOT_IN_CIDR
 %token OP_OR OP_AND
 %token VAR_UUID VAR_TYPE VAR_LOGGER VAR_PAYLOAD VAR_ENVVERSION VAR_HOSTNAME
 %token VAR_TIMESTAMP VAR_SEVERITY VAR_PID
@@ -109,6 +115,9 @@ eqneq : OP_EQ
 regexp : OP_RE
    | OP_NRE
 ;
+cidr : OP_IN_CIDR
+   | OP_NOT_IN_CIDR
+;
 string_vars : VAR_UUID
    | VAR_TYPE
    | VAR_LOGGER
@@ -130,6 +139,11 @@ string_test : string_vars relational STRING_VALUE
        //fmt.Println("string_test regexp", $1, $2, $3)
        nodes = append(nodes, &tree{stmt:&Statement{$1, $2, $3}})
        }
+   |   VAR_HOSTNAME cidr STRING_VALUE
+       {
+       //fmt.Println("string_test cidr", $1, $2, $3)
+       nodes = append(nodes, &tree{stmt:&Statement{$1, $2, $3}})
+       }
 ;
 numeric_test : numeric_vars relational NUMERIC_VALUE
    {
@@ -157,6 +171,11 @@ field_test : VAR_FIELDS relational NUMERIC_VALUE
       //fmt.Println("field_test regexp", $1, $2, $3)
       nodes = append(nodes, &tree{stmt:&Statement{$1, $2, $3}})
       }
+   | VAR_FIELDS cidr STRING_VALUE
+      {
+      //fmt.Println("field_test cidr", $1, $2, $3)
+      nodes = append(nodes, &tree{stmt:&Statement{$1, $2, $3}})
+      }
    | VAR_FIELDS eqneq NIL_VALUE
       {
       //fmt.Println("field_test existence", $1, $2, $3)
@@ -195,7 +214,8 @@ type MatcherSpecificationParser struct {
 	sym      string
 	peekrune rune
 	lexPos   int
-    reToken *regexp.Regexp
+	reToken  *regexp.Regexp
+	cidr     bool // the next string value is a list of CIDR ranges
 }
 
 func parseMatcherSpecification(ms *MatcherSpecification) error {
@@ -239,6 +259,7 @@ func (m *MatcherSpecificationParser) Lex(yylval *yySymType) int {
 	yylval.fieldIndex = 0
 	yylval.arrayIndex = 0
 	yylval.regexp = nil
+	yylval.cidrs = nil
 
 	c = m.peekrune
 	m.peekrune = ' '
@@ -333,6 +354,9 @@ variable:
 		}
 	}
 	yylval.tokenId = variables[m.sym]
+	if yylval.tokenId == OP_IN_CIDR || yylval.tokenId == OP_NOT_IN_CIDR {
+		m.cidr = true
+	}
 	if yylval.tokenId == VAR_FIELDS {
 		if c != '[' {
 			return 0
@@ -434,6 +458,13 @@ quotestring:
 		m.sym += string(c)
 	}
 	yylval.token = m.sym
+	if m.cidr {
+		m.cidr = false
+		if yylval.cidrs, err = parseCIDRs(m.sym); err != nil {
+			log.Printf("invalid CIDR list %v: %s\n", m.sym, err)
+			return 0
+		}
+	}
 	yylval.tokenId = STRING_VALUE
 	return yylval.tokenId
 
@@ -491,8 +522,21 @@ regexpstring:
 	return yylval.tokenId
 }
 
+// parseCIDRs converts a comma separated list of IPv4 and/or IPv6 CIDR
+// ranges, e.g. "10.0.0.0/8, fd00::/8", into the networks they describe.
+func parseCIDRs(s string) (cidrs []*net.IPNet, err error) {
+	for _, r := range strings.Split(s, ",") {
+		var ipnet *net.IPNet
+		if _, ipnet, err = net.ParseCIDR(strings.TrimSpace(r)); err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, ipnet)
+	}
+	return
+}
+
 func rvariable(c rune) bool {
-	if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') {
+	if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '_' {
 		return true
 	}
 	return false
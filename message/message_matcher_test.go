@@ -43,6 +43,9 @@ func MatcherSpecificationSpec(c gospec.Context) {
 	field7, _ := NewField("Timestamp", date, "date-time")
 	field8, _ := NewField("zero", int64(0), "")
 	field9, _ := NewField("string", "43", "")
+	field10, _ := NewField("ipv4", "10.1.2.3", "")
+	field10.AddValue("192.168.0.1")
+	field11, _ := NewField("ipv6", "fd00::1", "")
 	msg.AddField(field1)
 	msg.AddField(field2)
 	msg.AddField(field3)
@@ -52,6 +55,17 @@ func MatcherSpecificationSpec(c gospec.Context) {
 	msg.AddField(field7)
 	msg.AddField(field8)
 	msg.AddField(field9)
+	msg.AddField(field10)
+	msg.AddField(field11)
+	field12, _ := NewField("http", map[string]interface{}{
+		"request": map[string]interface{}{"method": "GET"},
+		"status":  []int{200, 304},
+	}, "")
+	msg.AddField(field12)
+	field13, _ := NewField("dotted", map[string]interface{}{"name": "nested"}, "")
+	msg.AddField(field13)
+	field14, _ := NewField("dotted.name", "exact", "")
+	msg.AddField(field14)
 
 	c.Specify("A MatcherSpecification", func() {
 		malformed := []string{
@@ -79,6 +93,13 @@ func MatcherSpecificationSpec(c gospec.Context) {
 			"NIL",                                                         // invalid use of constant
 			"Type == NIL",                                                 // existence check only works on fields
 			"Fields[test] > NIL",                                          // existence check only works with equals and not equals
+			"Fields[ipv4] IN_CIDR '10.0.0.0'",                             // missing prefix length
+			"Fields[ipv4] IN_CIDR '10.0.0.0/8,'",                          // empty range
+			"Fields[ipv4] IN_CIDR 10",                                     // number instead of CIDR string
+			"Fields[ipv4] IN_CIDR /10/",                                   // regexp instead of CIDR string
+			"Type IN_CIDR '10.0.0.0/8'",                                   // CIDR test only works on Hostname and fields
+			"Host_name == 'test'",                                         // unknown variable name w/ underscore
+			"Type IN_CIDR_X '10.0.0.0/8'",                                 // unknown operator w/ underscore
 		}
 
 		negative := []string{
@@ -116,6 +137,22 @@ func MatcherSpecificationSpec(c gospec.Context) {
 			"Type !~ /^TE/",
 			"Type !~ /ST$/",
 			"Logger =~ /./ && Type =~ /^anything/",
+			"Fields[ipv4] IN_CIDR '192.168.0.0/16'",
+			"Fields[ipv4][0][1] NOT_IN_CIDR '192.168.0.0/16'",
+			"Fields[ipv4] NOT_IN_CIDR '10.0.0.0/8'",
+			"Fields[ipv6] IN_CIDR '10.0.0.0/8'",
+			"Fields[ipv6] IN_CIDR 'fc00::/8'",
+			"Fields[foo] IN_CIDR '0.0.0.0/0'",
+			"Fields[foo] NOT_IN_CIDR '0.0.0.0/0'",
+			"Fields[int] IN_CIDR '0.0.0.0/0'",
+			"Fields[bool] IN_CIDR '0.0.0.0/0'",
+			"Fields[missing] IN_CIDR '0.0.0.0/0'",
+			"Fields[missing] NOT_IN_CIDR '0.0.0.0/0'",
+			"Fields[http.request.method] != 'GET'",
+			"Fields[http.request.method][1] == 'GET'",
+			"Fields[http.status][0][2] != NIL",
+			"Fields[http] == 'GET'",
+			"Fields[http.request.method.value] != NIL",
 		}
 
 		positive := []string{
@@ -169,8 +206,144 @@ func MatcherSpecificationSpec(c gospec.Context) {
 			"Type =~ /ST$/",
 			"Type !~ /^te/",
 			"Type !~ /st$/",
+			"Fields[ipv4] IN_CIDR '10.0.0.0/8'",
+			"Fields[ipv4] IN_CIDR \"172.16.0.0/12, 10.0.0.0/8\"",
+			"Fields[ipv4][0][1] IN_CIDR '192.168.0.0/16'",
+			"Fields[ipv4] NOT_IN_CIDR '192.168.0.0/16'",
+			"Fields[ipv6] IN_CIDR 'fd00::/8'",
+			"Fields[ipv6] IN_CIDR '10.0.0.0/8,fd00::/8'",
+			"Fields[ipv6] NOT_IN_CIDR '10.0.0.0/8'",
+			"Fields[http.request.method] == 'GET'",
+			"Fields[http.status][0][1] == 304",
+			"Fields[http.request] != NIL",
+			"Fields[http.request.missing] == NIL",
+			"Fields[dotted.name] == 'exact'",
 		}
 
+		c.Specify("reports whether Fields are tested", func() {
+			uses := map[string]bool{
+				"TRUE": false,
+				"Type == 'TEST' && Severity == 6":                           false,
+				"Type == 'TEST' && (Severity == 6 || Fields[foo] == 'bar')": true,
+				"Fields[missing] == NIL":                                    true,
+			}
+			for spec, expected := range uses {
+				ms, err := CreateMatcherSpecification(spec)
+				c.Assume(err, gs.IsNil)
+				c.Expect(ms.UsesFields(), gs.Equals, expected)
+			}
+		})
+
+		c.Specify("type mismatch tests", func() {
+			mismatched := []string{
+				"Fields[bool] == 'true'",
+				"Fields[bool] == 0",
+				"Fields[bool] =~ /./",
+				"Fields[foo] == TRUE",
+				"Fields[foo] == FALSE",
+				"Fields[int] == 'data'",
+				"Fields[int] == TRUE",
+			}
+			for _, v := range mismatched {
+				ms, err := CreateMatcherSpecification(v)
+				c.Expect(err, gs.IsNil)
+				c.Expect(ms.Match(msg), gs.IsFalse)
+				c.Expect(evalMatcherSpecification(ms.vm, msg), gs.IsFalse)
+			}
+		})
+
+		c.Specify("constant folding", func() {
+			folded := map[string]bool{
+				"TRUE || Type == 'TEST'":                           true,
+				"Type == 'TEST' || TRUE":                           true,
+				"FALSE && Type == 'TEST'":                          false,
+				"Type == 'TEST' && FALSE":                          false,
+				"Severity == 6.5 && Type == 'TEST'":                false,
+				"Severity != 6.5 || Type == 'TEST'":                true,
+				"(FALSE || Severity == 1.5) && Fields[foo] != NIL": false,
+			}
+			for v, expected := range folded {
+				ms, err := CreateMatcherSpecification(v)
+				c.Expect(err, gs.IsNil)
+				c.Expect(compileTree(ms.vm).constant, gs.IsTrue)
+				c.Expect(ms.Match(msg), gs.Equals, expected)
+				c.Expect(evalMatcherSpecification(ms.vm, msg), gs.Equals, expected)
+			}
+
+			ms, err := CreateMatcherSpecification("TRUE && Type == 'TEST'")
+			c.Expect(err, gs.IsNil)
+			c.Expect(compileTree(ms.vm).constant, gs.IsFalse)
+			c.Expect(ms.Match(msg), gs.IsTrue)
+		})
+
+		c.Specify("explain", func() {
+			ms, err := CreateMatcherSpecification(
+				"(Type == 'foo' || Severity < 7) && Fields[missing] != NIL && Payload =~ /^Test/")
+			c.Expect(err, gs.IsNil)
+			mt := ms.Explain(msg)
+			c.Expect(mt.Result, gs.Equals, TRACE_FALSE)
+			c.Expect(mt.Expression, gs.Equals,
+				"(Type == 'foo' || Severity < 7) && Fields[missing] != NIL && Payload =~ /^Test/")
+			c.Expect(len(mt.Children), gs.Equals, 2)
+
+			and := mt.Children[0]
+			c.Expect(and.Result, gs.Equals, TRACE_FALSE)
+			or := and.Children[0]
+			c.Expect(or.Result, gs.Equals, TRACE_TRUE)
+			c.Expect(or.Children[0].Expression, gs.Equals, "Type == 'foo'")
+			c.Expect(or.Children[0].Value, gs.Equals, `"TEST"`)
+			c.Expect(or.Children[0].Result, gs.Equals, TRACE_FALSE)
+			c.Expect(or.Children[1].Value, gs.Equals, "6")
+			c.Expect(or.Children[1].Result, gs.Equals, TRACE_TRUE)
+			missing := and.Children[1]
+			c.Expect(missing.Expression, gs.Equals, "Fields[missing] != NIL")
+			c.Expect(missing.Value, gs.Equals, "NIL")
+			c.Expect(missing.Result, gs.Equals, TRACE_FALSE)
+
+			skipped := mt.Children[1]
+			c.Expect(skipped.Expression, gs.Equals, "Payload =~ /^Test/")
+			c.Expect(skipped.Result, gs.Equals, TRACE_SKIPPED)
+			c.Expect(skipped.Value, gs.Equals, "")
+
+			c.Expect(mt.String(), gs.Equals,
+				"[false] (Type == 'foo' || Severity < 7) && Fields[missing] != NIL && Payload =~ /^Test/\n"+
+					"  [false] (Type == 'foo' || Severity < 7) && Fields[missing] != NIL\n"+
+					"    [true] Type == 'foo' || Severity < 7\n"+
+					"      [false] Type == 'foo'  (value: \"TEST\")\n"+
+					"      [true] Severity < 7  (value: 6)\n"+
+					"    [false] Fields[missing] != NIL  (value: NIL)\n"+
+					"  [skipped] Payload =~ /^Test/\n")
+
+			// the rendered expressions must parse back into equivalent matchers
+			for _, v := range positive {
+				ms, _ := CreateMatcherSpecification(v)
+				mt := ms.Explain(msg)
+				c.Expect(mt.Result, gs.Equals, TRACE_TRUE)
+				rt, err := CreateMatcherSpecification(mt.Expression)
+				c.Expect(err, gs.IsNil)
+				c.Expect(rt.Match(msg), gs.IsTrue)
+			}
+			for _, v := range negative {
+				ms, _ := CreateMatcherSpecification(v)
+				mt := ms.Explain(msg)
+				c.Expect(mt.Result, gs.Equals, TRACE_FALSE)
+				rt, err := CreateMatcherSpecification(mt.Expression)
+				c.Expect(err, gs.IsNil)
+				c.Expect(rt.Match(msg), gs.IsFalse)
+			}
+		})
+
+		c.Specify("Hostname CIDR tests", func() {
+			hmsg := getTestMessage()
+			hmsg.SetHostname("2001:db8::10")
+			ms, err := CreateMatcherSpecification("Hostname IN_CIDR '2001:db8::/32'")
+			c.Expect(err, gs.IsNil)
+			c.Expect(ms.Match(hmsg), gs.IsTrue)
+			ms, err = CreateMatcherSpecification("Hostname NOT_IN_CIDR '2001:db8::/32'")
+			c.Expect(err, gs.IsNil)
+			c.Expect(ms.Match(hmsg), gs.IsFalse)
+		})
+
 		c.Specify("malformed matcher tests", func() {
 			for _, v := range malformed {
 				_, err := CreateMatcherSpecification(v)
@@ -184,6 +357,7 @@ func MatcherSpecificationSpec(c gospec.Context) {
 				c.Expect(err, gs.IsNil)
 				match := ms.Match(msg)
 				c.Expect(match, gs.IsFalse)
+				c.Expect(evalMatcherSpecification(ms.vm, msg), gs.IsFalse)
 			}
 		})
 
@@ -193,11 +367,25 @@ func MatcherSpecificationSpec(c gospec.Context) {
 				c.Expect(err, gs.IsNil)
 				match := ms.Match(msg)
 				c.Expect(match, gs.IsTrue)
+				c.Expect(evalMatcherSpecification(ms.vm, msg), gs.IsTrue)
 			}
 		})
 	})
 }
 
+// benchmarkInterpreted measures the tree walking reference implementation so
+// the gain from compiling the matcher can be compared against the matching
+// BenchmarkMatcher* result.
+func benchmarkInterpreted(b *testing.B, s string) {
+	b.StopTimer()
+	ms, _ := CreateMatcherSpecification(s)
+	msg := getTestMessage()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		evalMatcherSpecification(ms.vm, msg)
+	}
+}
+
 func BenchmarkMatcherCreate(b *testing.B) {
 	s := "Type == 'Test' && Severity == 6"
 	for i := 0; i < b.N; i++ {
@@ -216,6 +404,10 @@ func BenchmarkMatcherMatch(b *testing.B) {
 	}
 }
 
+func BenchmarkMatcherMatchInterpreted(b *testing.B) {
+	benchmarkInterpreted(b, "Type == 'TEST' && Severity == 6")
+}
+
 func BenchmarkMatcherSimpleRegex(b *testing.B) {
 	b.StopTimer()
 	s := "Type =~ /[Tt]EST/ && Severity == 6"
@@ -249,6 +441,10 @@ func BenchmarkMatcherFieldString(b *testing.B) {
 	}
 }
 
+func BenchmarkMatcherFieldStringInterpreted(b *testing.B) {
+	benchmarkInterpreted(b, "Fields[foo] == 'bar' && Severity == 6")
+}
+
 func BenchmarkMatcherFieldNumeric(b *testing.B) {
 	b.StopTimer()
 	s := "Fields[number] == 64 && Severity == 6"
@@ -260,6 +456,10 @@ func BenchmarkMatcherFieldNumeric(b *testing.B) {
 	}
 }
 
+func BenchmarkMatcherFieldNumericInterpreted(b *testing.B) {
+	benchmarkInterpreted(b, "Fields[number] == 64 && Severity == 6")
+}
+
 func BenchmarkMatcherFieldNonExistence(b *testing.B) {
 	b.StopTimer()
 	s := "Fields[missing] == NIL"
@@ -282,6 +482,38 @@ func BenchmarkMatcherFieldExistence(b *testing.B) {
 	}
 }
 
+func BenchmarkMatcherFieldExistenceInterpreted(b *testing.B) {
+	benchmarkInterpreted(b, "Fields[int] != NIL")
+}
+
+func BenchmarkMatcherFieldCIDR(b *testing.B) {
+	b.StopTimer()
+	s := "Fields[ip] IN_CIDR '172.16.0.0/12,10.0.0.0/8'"
+	ms, _ := CreateMatcherSpecification(s)
+	msg := getTestMessage()
+	field, _ := NewField("ip", "10.1.2.3", "")
+	msg.AddField(field)
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		ms.Match(msg)
+	}
+}
+
+func BenchmarkMatcherFieldPath(b *testing.B) {
+	b.StopTimer()
+	s := "Fields[http.request.method] == 'GET'"
+	ms, _ := CreateMatcherSpecification(s)
+	msg := getTestMessage()
+	field, _ := NewField("http", map[string]interface{}{
+		"request": map[string]interface{}{"method": "GET"},
+	}, "")
+	msg.AddField(field)
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		ms.Match(msg)
+	}
+}
+
 func BenchmarkMatcherStartsWith(b *testing.B) {
 	b.StopTimer()
 	s := "Payload =~ /^Test/"
@@ -303,3 +535,18 @@ func BenchmarkMatcherEndsWith(b *testing.B) {
 		ms.Match(msg)
 	}
 }
+
+func BenchmarkMatcherComplex(b *testing.B) {
+	b.StopTimer()
+	s := "(Type == 'foo' || Type =~ /^TE/) && Severity <= 6 && Fields[foo][0][1] == NIL && Fields[number] > 10"
+	ms, _ := CreateMatcherSpecification(s)
+	msg := getTestMessage()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		ms.Match(msg)
+	}
+}
+
+func BenchmarkMatcherComplexInterpreted(b *testing.B) {
+	benchmarkInterpreted(b, "(Type == 'foo' || Type =~ /^TE/) && Severity <= 6 && Fields[foo][0][1] == NIL && Fields[number] > 10")
+}
@@ -0,0 +1,269 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package message
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts tried, in order, when none of the configured layouts match.
+var detectTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999", // ISO 8601 w/o time zone
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"02/Jan/2006:15:04:05 -0700", // Apache common log format
+	time.Stamp,                   // syslog (no year)
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC850,
+	time.UnixDate,
+	time.RubyDate,
+	time.ANSIC,
+	time.Kitchen,
+}
+
+// ISO 8601 week date e.g. 2015-W05-3, 2015W053 or 2015-W05-3T10:30:00Z.
+var isoWeekRegex = regexp.MustCompile(`^(\d{4})-?W(\d{2})(?:-?([1-7]))?(?:[T ](.+))?$`)
+
+var isoWeekTimeLayouts = []string{
+	"15:04:05.999999999Z07:00",
+	"15:04:05.999999999",
+	"15:04Z07:00",
+	"15:04",
+}
+
+// strftime conversion specifications and their Go layout equivalents.
+var strftimeLayouts = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'B': "January",
+	'd': "02",
+	'D': "01/02/06",
+	'e': "_2",
+	'F': "2006-01-02",
+	'h': "Jan",
+	'H': "15",
+	'I': "03",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'R': "15:04",
+	'S': "05",
+	'T': "15:04:05",
+	'y': "06",
+	'Y': "2006",
+	'z': "-0700",
+	'Z': "MST",
+	'%': "%",
+}
+
+// StrftimeToLayout converts a strftime style pattern (e.g.
+// "%Y-%m-%dT%H:%M:%S") into the equivalent Go time layout.
+func StrftimeToLayout(format string) (string, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			buf.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("strftime pattern '%s' ends with '%%'", format)
+		}
+		layout, ok := strftimeLayouts[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported strftime conversion '%%%c' in '%s'",
+				format[i], format)
+		}
+		buf.WriteString(layout)
+	}
+	return buf.String(), nil
+}
+
+// TimeParser parses timestamps trying a list of layouts in order, falling
+// back to the automatic detection of common formats.
+type TimeParser struct {
+	// Whether common formats are detected when none of the layouts match,
+	// true by default. If false the Go time layout constants are tried
+	// instead, like ForgivingTimeParse does.
+	Detect  bool
+	layouts []string
+	loc     *time.Location
+	now     func() time.Time
+}
+
+// NewTimeParser creates a TimeParser from a list of layouts, each of which
+// can be a Go time layout, the name of a Go time layout constant (e.g.
+// "RFC3339"), one of the "Epoch", "EpochMilli", "EpochMicro" or "EpochNano"
+// variants, or a strftime style pattern. Timestamps without a time zone are
+// presumed to be in loc, UTC if nil.
+func NewTimeParser(layouts []string, loc *time.Location) (*TimeParser, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	tp := &TimeParser{
+		Detect:  true,
+		layouts: make([]string, 0, len(layouts)),
+		loc:     loc,
+		now:     time.Now,
+	}
+	for _, layout := range layouts {
+		if layout == "" {
+			continue
+		}
+		if named, ok := basicTimeLayouts[layout]; ok {
+			layout = named
+		} else if strings.Contains(layout, "%") {
+			var err error
+			if layout, err = StrftimeToLayout(layout); err != nil {
+				return nil, err
+			}
+		}
+		tp.layouts = append(tp.layouts, layout)
+	}
+	return tp, nil
+}
+
+// Parse parses the timestamp with the first matching layout. Timestamps with
+// no date are given the current date and those with no year (e.g. syslog)
+// are given the year that puts them closest to the current time.
+func (tp *TimeParser) Parse(value string) (t time.Time, err error) {
+	for _, layout := range tp.layouts {
+		if strings.HasPrefix(layout, "Epoch") {
+			if t, err = parseEpoch(layout, value); err == nil {
+				return t, nil
+			}
+			continue
+		}
+		if t, err = time.ParseInLocation(layout, value, tp.loc); err == nil {
+			return tp.fillDate(t, layout), nil
+		}
+	}
+	if !tp.Detect {
+		for _, layout := range basicTimeLayouts {
+			if t, err = time.ParseInLocation(layout, value, tp.loc); err == nil {
+				return t, nil
+			}
+		}
+		return t, fmt.Errorf("unrecognized timestamp format: '%s'", value)
+	}
+	for _, layout := range detectTimeLayouts {
+		if t, err = time.ParseInLocation(layout, value, tp.loc); err == nil {
+			return tp.fillDate(t, layout), nil
+		}
+	}
+	if t, err = tp.parseISOWeek(value); err == nil {
+		return t, nil
+	}
+	return t, fmt.Errorf("unrecognized timestamp format: '%s'", value)
+}
+
+// layoutHasDate reports whether the layout contains a month or day.
+func layoutHasDate(layout string) bool {
+	t1 := time.Date(2000, 3, 4, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2000, 5, 6, 0, 0, 0, 0, time.UTC)
+	return t1.Format(layout) != t2.Format(layout)
+}
+
+func (tp *TimeParser) fillDate(t time.Time, layout string) time.Time {
+	if t.Year() != 0 {
+		return t
+	}
+	now := tp.now().In(t.Location())
+	if !layoutHasDate(layout) {
+		// time only, use the current date
+		return t.AddDate(now.Year(), int(now.Month()-1), now.Day()-1)
+	}
+	best := t.AddDate(now.Year(), 0, 0)
+	for _, year := range []int{now.Year() - 1, now.Year() + 1} {
+		candidate := t.AddDate(year, 0, 0)
+		if absDuration(candidate.Sub(now)) < absDuration(best.Sub(now)) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func (tp *TimeParser) parseISOWeek(value string) (t time.Time, err error) {
+	m := isoWeekRegex.FindStringSubmatch(value)
+	if m == nil {
+		return t, fmt.Errorf("not an ISO week date: '%s'", value)
+	}
+	year, _ := strconv.Atoi(m[1])
+	week, _ := strconv.Atoi(m[2])
+	day := 1
+	if m[3] != "" {
+		day, _ = strconv.Atoi(m[3])
+	}
+	if week < 1 || week > 53 {
+		return t, fmt.Errorf("invalid ISO week: %d", week)
+	}
+
+	loc := tp.loc
+	var clock time.Time
+	if m[4] != "" {
+		for _, layout := range isoWeekTimeLayouts {
+			if clock, err = time.ParseInLocation(layout, m[4], loc); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return t, err
+		}
+		loc = clock.Location()
+	}
+
+	// week 1 is the week containing January 4th
+	jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, loc)
+	offset := (int(jan4.Weekday()) + 6) % 7 // days since Monday
+	t = time.Date(year, 1, 4-offset+(week-1)*7+day-1, clock.Hour(), clock.Minute(),
+		clock.Second(), clock.Nanosecond(), loc)
+	return t, nil
+}
+
+// FormatTime formats the time using a Go time layout, the name of a Go time
+// layout constant or one of the Epoch variants.
+func FormatTime(layout string, t time.Time) string {
+	if named, ok := basicTimeLayouts[layout]; ok {
+		layout = named
+	}
+	switch layout {
+	case "Epoch":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "EpochMilli":
+		return strconv.FormatInt(t.UnixNano()/1e6, 10)
+	case "EpochMicro":
+		return strconv.FormatInt(t.UnixNano()/1e3, 10)
+	case "EpochNano":
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
+	return t.Format(layout)
+}
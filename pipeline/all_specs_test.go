@@ -37,9 +37,13 @@ func TestAllSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 	r.Parallel = false
 
+	r.AddSpec(FileWatcherSpec)
 	r.AddSpec(HekaFramingSpec)
 	r.AddSpec(InputRunnerSpec)
+	r.AddSpec(JsonSpec)
+	r.AddSpec(LengthPrefixSpec)
 	r.AddSpec(MessageTemplateSpec)
+	r.AddSpec(MultilineSpec)
 	r.AddSpec(OutputRunnerSpec)
 	r.AddSpec(ProtobufDecoderSpec)
 	r.AddSpec(QueueBufferSpec)
@@ -47,6 +51,7 @@ func TestAllSpecs(t *testing.T) {
 	r.AddSpec(ReportSpec)
 	r.AddSpec(SplitterRunnerSpec)
 	r.AddSpec(StatAccumInputSpec)
+	r.AddSpec(SyslogSplitterSpec)
 	r.AddSpec(TokenSpec)
 
 	gospec.MainGoTest(r, t)
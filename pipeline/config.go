@@ -561,10 +561,12 @@ type CommonFOConfig struct {
 }
 
 type CommonSplitterConfig struct {
-	KeepTruncated   *bool `toml:"keep_truncated"`
-	UseMsgBytes     *bool `toml:"use_message_bytes"`
-	BufferSize      uint  `toml:"min_buffer_size"`
-	IncompleteFinal *bool `toml:"deliver_incomplete_final"`
+	KeepTruncated      *bool  `toml:"keep_truncated"`
+	UseMsgBytes        *bool  `toml:"use_message_bytes"`
+	BufferSize         uint   `toml:"min_buffer_size"`
+	IncompleteFinal    *bool  `toml:"deliver_incomplete_final"`
+	Decompression      string `toml:"decompression"`
+	DecompressionScope string `toml:"decompression_scope"`
 }
 
 // Default configurations.
@@ -0,0 +1,84 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"os"
+	"time"
+)
+
+// Detects the changes of a data file that a plugin reloads while running,
+// e.g. a lookup table or a database, by comparing the file's modification
+// time and size at most once per Interval.
+type FileWatcher struct {
+	Path string
+
+	// Minimum time between the checks for changes, 0 disables them.
+	Interval time.Duration
+
+	// Returns the current time, defaults to time.Now.
+	Now func() time.Time
+
+	lastCheck time.Time
+	modTime   time.Time
+	size      int64
+}
+
+func (fw *FileWatcher) now() time.Time {
+	if fw.Now == nil {
+		return time.Now()
+	}
+	return fw.Now()
+}
+
+// Calls load, remembering the file's modification time and size if it
+// succeeds.
+func (fw *FileWatcher) Load(load func() error) error {
+	info, err := os.Stat(fw.Path)
+	if err != nil {
+		return err
+	}
+	if err = load(); err != nil {
+		return err
+	}
+	fw.modTime = info.ModTime()
+	fw.size = info.Size()
+	fw.lastCheck = fw.now()
+	return nil
+}
+
+// Calls load if the Interval has passed since the last check and the file
+// has changed since it was loaded. Returns whether load was called and the
+// error of either the check or load. A file that fails to load isn't retried
+// until it changes again.
+func (fw *FileWatcher) ReloadIfChanged(load func() error) (reloaded bool, err error) {
+	now := fw.now()
+	if fw.Interval == 0 || now.Sub(fw.lastCheck) < fw.Interval {
+		return false, nil
+	}
+	fw.lastCheck = now
+	info, err := os.Stat(fw.Path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(fw.modTime) && info.Size() == fw.size {
+		return false, nil
+	}
+	if err = fw.Load(load); err != nil {
+		fw.modTime = info.ModTime()
+		fw.size = info.Size()
+	}
+	return true, err
+}
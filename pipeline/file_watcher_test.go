@@ -0,0 +1,106 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func FileWatcherSpec(c gs.Context) {
+	c.Specify("A FileWatcher", func() {
+		dir, err := ioutil.TempDir("", "filewatcher")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "data.txt")
+		err = ioutil.WriteFile(path, []byte("one"), 0644)
+		c.Assume(err, gs.IsNil)
+
+		now := time.Unix(1442403022, 0)
+		fw := &FileWatcher{
+			Path:     path,
+			Interval: 10 * time.Second,
+			Now:      func() time.Time { return now },
+		}
+		loads := 0
+		var loadErr error
+		load := func() error {
+			loads++
+			return loadErr
+		}
+		err = fw.Load(load)
+		c.Assume(err, gs.IsNil)
+		c.Expect(loads, gs.Equals, 1)
+
+		c.Specify("reloads a changed file once the interval has passed", func() {
+			err = ioutil.WriteFile(path, []byte("three"), 0644)
+			c.Assume(err, gs.IsNil)
+			reloaded, err := fw.ReloadIfChanged(load)
+			c.Expect(reloaded, gs.IsFalse)
+			c.Expect(err, gs.IsNil)
+
+			now = now.Add(10 * time.Second)
+			reloaded, err = fw.ReloadIfChanged(load)
+			c.Expect(reloaded, gs.IsTrue)
+			c.Expect(err, gs.IsNil)
+			c.Expect(loads, gs.Equals, 2)
+
+			now = now.Add(10 * time.Second)
+			reloaded, err = fw.ReloadIfChanged(load)
+			c.Expect(reloaded, gs.IsFalse)
+			c.Expect(loads, gs.Equals, 2)
+		})
+
+		c.Specify("doesn't retry a file that fails to load", func() {
+			err = ioutil.WriteFile(path, []byte("three"), 0644)
+			c.Assume(err, gs.IsNil)
+			loadErr = errors.New("broken")
+			now = now.Add(10 * time.Second)
+			reloaded, err := fw.ReloadIfChanged(load)
+			c.Expect(reloaded, gs.IsTrue)
+			c.Expect(err, gs.Equals, loadErr)
+
+			now = now.Add(10 * time.Second)
+			reloaded, err = fw.ReloadIfChanged(load)
+			c.Expect(reloaded, gs.IsFalse)
+			c.Expect(err, gs.IsNil)
+			c.Expect(loads, gs.Equals, 2)
+		})
+
+		c.Specify("reports a missing file", func() {
+			err = os.Remove(path)
+			c.Assume(err, gs.IsNil)
+			now = now.Add(10 * time.Second)
+			reloaded, err := fw.ReloadIfChanged(load)
+			c.Expect(reloaded, gs.IsFalse)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("never checks w/o an interval", func() {
+			fw.Interval = 0
+			err = ioutil.WriteFile(path, []byte("three"), 0644)
+			c.Assume(err, gs.IsNil)
+			now = now.Add(time.Hour)
+			reloaded, err := fw.ReloadIfChanged(load)
+			c.Expect(reloaded, gs.IsFalse)
+			c.Expect(err, gs.IsNil)
+		})
+	})
+}
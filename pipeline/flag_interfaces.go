@@ -43,6 +43,14 @@ type EncodesMsgBytes interface {
 	EncodesMsgBytes() bool
 }
 
+// EncoderOnly is implemented by output plugins that only look at messages
+// through their encoder. When such an output uses the ProtobufEncoder the
+// router passes packs on without decoding any message Fields that a lazy
+// ProtobufDecoder has deferred.
+type EncoderOnly interface {
+	EncoderOnly() bool
+}
+
 // Restarting indicates a plug-in can handle being restart should it exit
 // before heka is shut-down.
 type Restarting interface {
@@ -20,6 +20,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mozilla-services/heka/message"
 	"github.com/pborman/uuid"
@@ -46,37 +47,10 @@ func (mt MessageTemplate) PopulateMessage(msg *message.Message, subs map[string]
 			val = InterpolateString(rawVal, subs)
 		}
 		switch field {
-		case "Logger":
-			msg.SetLogger(val)
-		case "Type":
-			msg.SetType(val)
-		case "Payload":
-			msg.SetPayload(val)
-		case "Hostname":
-			msg.SetHostname(val)
-		case "Pid":
-			intPart := strings.Split(val, ".")[0]
-			pid, err := strconv.ParseInt(intPart, 10, 32)
-			if err != nil {
-				return err
-			}
-			msg.SetPid(int32(pid))
-		case "Severity":
-			severity, err := strconv.ParseInt(val, 10, 32)
-			if err != nil {
+		case "Logger", "Type", "Payload", "Hostname", "Pid", "Severity", "Uuid":
+			if err := SetMessageHeader(msg, field, val); err != nil {
 				return err
 			}
-			msg.SetSeverity(int32(severity))
-		case "Uuid":
-			if len(val) == message.UUID_SIZE {
-				msg.SetUuid([]byte(val))
-			} else {
-				if uuidBytes := uuid.Parse(val); uuidBytes == nil {
-					return errors.New("Invalid UUID string.")
-				} else {
-					msg.SetUuid(uuidBytes)
-				}
-			}
 		default:
 			fi := strings.SplitN(field, "|", 2)
 			if len(fi) < 2 {
@@ -92,6 +66,200 @@ func (mt MessageTemplate) PopulateMessage(msg *message.Message, subs map[string]
 	return nil
 }
 
+// Names of the message headers that decoders can map values onto.
+var MessageHeaders = map[string]bool{
+	"Timestamp":  true,
+	"Severity":   true,
+	"Hostname":   true,
+	"Type":       true,
+	"Logger":     true,
+	"EnvVersion": true,
+	"Pid":        true,
+	"Payload":    true,
+	"Uuid":       true,
+}
+
+// Sets one of the MessageHeaders other than Timestamp, which needs a layout
+// to be parsed, from its string representation.
+func SetMessageHeader(msg *message.Message, header, value string) error {
+	switch header {
+	case "Logger":
+		msg.SetLogger(value)
+	case "Type":
+		msg.SetType(value)
+	case "Payload":
+		msg.SetPayload(value)
+	case "Hostname":
+		msg.SetHostname(value)
+	case "EnvVersion":
+		msg.SetEnvVersion(value)
+	case "Pid":
+		intPart := strings.Split(value, ".")[0]
+		pid, err := strconv.ParseInt(intPart, 10, 32)
+		if err != nil {
+			return err
+		}
+		msg.SetPid(int32(pid))
+	case "Severity":
+		severity, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return err
+		}
+		msg.SetSeverity(int32(severity))
+	case "Uuid":
+		if len(value) == message.UUID_SIZE {
+			msg.SetUuid([]byte(value))
+		} else {
+			if uuidBytes := uuid.Parse(value); uuidBytes == nil {
+				return errors.New("Invalid UUID string.")
+			} else {
+				msg.SetUuid(uuidBytes)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown message header: '%s'", header)
+	}
+	return nil
+}
+
+// Maps the MessageHeaders onto the key paths of nested maps, as decoded
+// from structured data such as Avro records or MessagePack maps.
+type HeaderMap map[string][]string
+
+// Parses a header_map setting, the keys of nested maps being joined w/ the
+// separator.
+func NewHeaderMap(headerMap map[string]string, separator string) (HeaderMap, error) {
+	hm := make(HeaderMap, len(headerMap))
+	for header, key := range headerMap {
+		if !MessageHeaders[header] {
+			return nil, fmt.Errorf("unknown header_map header: '%s'", header)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("empty header_map key for '%s'", header)
+		}
+		hm[header] = strings.Split(key, separator)
+	}
+	return hm, nil
+}
+
+// Removes the mapped values from the nested maps, returning the non-nil ones
+// keyed by header.
+func (hm HeaderMap) Extract(record map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(hm))
+	for header, path := range hm {
+		nested := record
+		for _, key := range path[:len(path)-1] {
+			if nested, _ = nested[key].(map[string]interface{}); nested == nil {
+				break
+			}
+		}
+		if nested == nil {
+			continue
+		}
+		key := path[len(path)-1]
+		if value := nested[key]; value != nil {
+			values[header] = value
+		}
+		delete(nested, key)
+	}
+	return values
+}
+
+// Sets one of the MessageHeaders from a decoded value. Integer timestamps are
+// nanoseconds and floating point ones seconds since the epoch, string
+// timestamps are RFC 3339. Severity and Pid must be integers, a Uuid either
+// 16 bytes or a string, the other headers scalars.
+func SetMessageHeaderValue(msg *message.Message, header string, value interface{}) error {
+	switch header {
+	case "Timestamp":
+		switch v := value.(type) {
+		case time.Time:
+			msg.SetTimestamp(v.UnixNano())
+		case int64:
+			msg.SetTimestamp(v)
+		case float64:
+			msg.SetTimestamp(int64(v * 1e9))
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return fmt.Errorf("Don't recognize Timestamp: '%s'", v)
+			}
+			msg.SetTimestamp(t.UnixNano())
+		default:
+			return fmt.Errorf("Don't recognize Timestamp: '%v'", value)
+		}
+	case "Severity", "Pid":
+		n, ok := value.(int64)
+		if !ok || n < -1<<31 || n >= 1<<31 {
+			return fmt.Errorf("Don't recognize %s: '%v'", header, value)
+		}
+		if header == "Severity" {
+			msg.SetSeverity(int32(n))
+		} else {
+			msg.SetPid(int32(n))
+		}
+	case "Uuid":
+		switch v := value.(type) {
+		case []byte:
+			if len(v) != message.UUID_SIZE {
+				return fmt.Errorf("Don't recognize Uuid: '%x'", v)
+			}
+			msg.SetUuid(v)
+		case string:
+			u := uuid.Parse(v)
+			if u == nil {
+				return fmt.Errorf("Don't recognize Uuid: '%s'", v)
+			}
+			msg.SetUuid(u)
+		default:
+			return fmt.Errorf("Don't recognize Uuid: '%v'", value)
+		}
+	default:
+		var str string
+		switch v := value.(type) {
+		case string:
+			str = v
+		case []byte:
+			str = string(v)
+		case bool, int64, float64:
+			str = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("'%s' value is not a scalar", header)
+		}
+		if err := SetMessageHeader(msg, header, str); err != nil {
+			return fmt.Errorf("Don't recognize %s: '%s'", header, str)
+		}
+	}
+	return nil
+}
+
+// Returns the value of one of the MessageHeaders, the Timestamp as
+// nanoseconds since the epoch, Severity and Pid as int64 and the others as
+// strings.
+func GetMessageHeaderValue(msg *message.Message, header string) interface{} {
+	switch header {
+	case "Timestamp":
+		return msg.GetTimestamp()
+	case "Severity":
+		return int64(msg.GetSeverity())
+	case "Pid":
+		return int64(msg.GetPid())
+	case "Uuid":
+		return msg.GetUuidString()
+	case "Hostname":
+		return msg.GetHostname()
+	case "Type":
+		return msg.GetType()
+	case "Logger":
+		return msg.GetLogger()
+	case "EnvVersion":
+		return msg.GetEnvVersion()
+	case "Payload":
+		return msg.GetPayload()
+	}
+	return nil
+}
+
 // Given a regular expression, return the string resulting from interpolating
 // variables that exist in matchParts
 //
@@ -75,4 +75,70 @@ func MessageTemplateSpec(c gs.Context) {
 			c.Expect(field.GetRepresentation(), gs.Equals, "baz")
 		})
 	})
+
+	c.Specify("SetMessageHeader", func() {
+		msg := ts.GetTestMessage()
+
+		c.Specify("sets headers the template stores as fields", func() {
+			err := SetMessageHeader(msg, "EnvVersion", "0.8")
+			c.Expect(err, gs.IsNil)
+			c.Expect(msg.GetEnvVersion(), gs.Equals, "0.8")
+			c.Expect(msg.FindFirstField("EnvVersion"), gs.IsNil)
+		})
+
+		c.Specify("rejects invalid values and unknown headers", func() {
+			c.Expect(SetMessageHeader(msg, "Severity", "warn"), gs.Not(gs.IsNil))
+			c.Expect(SetMessageHeader(msg, "Uuid", "1234"), gs.Not(gs.IsNil))
+			c.Expect(SetMessageHeader(msg, "Timestamp", "0"), gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A header map", func() {
+		hm, err := NewHeaderMap(map[string]string{
+			"Timestamp":  "time",
+			"EnvVersion": "meta.version",
+			"Pid":        "meta.pid",
+			"Hostname":   "missing.host",
+		}, ".")
+		c.Assume(err, gs.IsNil)
+		msg := ts.GetTestMessage()
+
+		c.Specify("extracts and sets the mapped values", func() {
+			record := map[string]interface{}{
+				"time": "2015-09-16T15:37:50.123456789Z",
+				"meta": map[string]interface{}{
+					"version": "0.8",
+					"pid":     int64(42),
+					"user":    "bob",
+				},
+			}
+			values := hm.Extract(record)
+			c.Expect(len(values), gs.Equals, 3)
+			c.Expect(len(record), gs.Equals, 1)
+			c.Expect(len(record["meta"].(map[string]interface{})), gs.Equals, 1)
+			for header, value := range values {
+				err = SetMessageHeaderValue(msg, header, value)
+				c.Expect(err, gs.IsNil)
+			}
+			c.Expect(msg.GetTimestamp(), gs.Equals, int64(1442417870123456789))
+			c.Expect(msg.GetEnvVersion(), gs.Equals, "0.8")
+			c.Expect(msg.GetPid(), gs.Equals, int32(42))
+			c.Expect(msg.FindFirstField("EnvVersion"), gs.IsNil)
+			c.Expect(GetMessageHeaderValue(msg, "Pid"), gs.Equals, int64(42))
+		})
+
+		c.Specify("rejects invalid values", func() {
+			c.Expect(SetMessageHeaderValue(msg, "Pid", "42"), gs.Not(gs.IsNil))
+			c.Expect(SetMessageHeaderValue(msg, "Uuid", []byte{1}), gs.Not(gs.IsNil))
+			c.Expect(SetMessageHeaderValue(msg, "Type",
+				map[string]interface{}{}), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown headers and empty keys", func() {
+			_, err = NewHeaderMap(map[string]string{"Nope": "a"}, ".")
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = NewHeaderMap(map[string]string{"Type": ""}, ".")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
 }
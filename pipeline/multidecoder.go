@@ -315,7 +315,13 @@ func (md *MultiDecoder) getDecodedPacks(chain []Decoder, inPacks []*PipelinePack
 		if md.sample {
 			startTime = time.Now()
 		}
-		ps, err := decoder.Decode(p)
+		// Fields deferred by a lazy decoder earlier in the chain have to be
+		// decoded before the next decoder gets to see the message.
+		var ps []*PipelinePack
+		err := p.DecodeFields()
+		if err == nil {
+			ps, err = decoder.Decode(p)
+		}
 		if md.sample {
 			duration := time.Since(startTime).Nanoseconds()
 			md.reportLock.Lock()
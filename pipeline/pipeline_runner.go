@@ -175,6 +175,11 @@ type PipelinePack struct {
 	BufferedPack bool
 	// Used to send delivery result error back to the buffered plugin.
 	DelivErrChan chan error
+	// Protobuf encoded message Fields that a lazy decoder has left to be
+	// decoded by DecodeFields.
+	lazyFields []byte
+	fieldsErr  error
+	fieldsLock sync.Mutex
 }
 
 // Returns a new PipelinePack pointer that will recycle itself onto the
@@ -203,6 +208,8 @@ func (p *PipelinePack) Zero() {
 	p.Signer = ""
 	p.diagnostics.Reset()
 	p.TrustMsgBytes = false
+	p.lazyFields = p.lazyFields[:0]
+	p.fieldsErr = nil
 	if p.BufferedPack {
 		p.QueueCursor = ""
 	}
@@ -232,12 +239,30 @@ func (p *PipelinePack) Recycle(delivErr error) {
 	}
 }
 
+// DecodeFields decodes any message Fields a lazy decoder has deferred and adds
+// them to the pack's message. The router calls this before a message is
+// handed to a plugin, so plugins only need to call it if they look at packs
+// before they are injected. It is safe to call more than once, the same
+// decoding error is returned on every call.
+func (p *PipelinePack) DecodeFields() error {
+	p.fieldsLock.Lock()
+	defer p.fieldsLock.Unlock()
+	if len(p.lazyFields) > 0 {
+		p.fieldsErr = message.DecodeLazyFields(p.lazyFields, p.Message)
+		p.lazyFields = p.lazyFields[:0]
+	}
+	return p.fieldsErr
+}
+
 // EncodeMsgBytes protobuf encodes the pack's message struct and copies the
 // result into the pack's MsgBytes attribute.
 func (p *PipelinePack) EncodeMsgBytes() error {
 	if p.TrustMsgBytes {
 		return nil
 	}
+	if err := p.DecodeFields(); err != nil {
+		return err
+	}
 	msgBytes, err := proto.Marshal(p.Message)
 	if err == nil {
 		if cap(p.MsgBytes) < len(msgBytes) {
@@ -16,6 +16,8 @@
 
 package pipeline
 
+import "time"
+
 // Interface for Heka plugins that can be wired up to the config system.
 type Plugin interface {
 	// Receives either PluginConfig or custom config struct, populated from
@@ -46,6 +48,26 @@ type UnframingSplitter interface {
 	UnframeRecord(framed []byte, pack *PipelinePack) []byte
 }
 
+// FlushingSplitter is an interface optionally implemented by splitter plugins
+// that hold on to partial records. It is called with the unconsumed buffer
+// contents once the end of the stream is reached, or when the stream of an
+// IdleFlushingSplitter has been idle, giving the splitter a chance to release
+// the pending data as a record. Other read errors don't trigger a flush.
+type FlushingSplitter interface {
+	FlushRecord(buf []byte) (bytesRead int, record []byte)
+}
+
+// IdleFlushingSplitter is an interface optionally implemented by
+// FlushingSplitters that want their pending data flushed when the stream has
+// been idle for a while. The SplitterRunner stops waiting for a read that
+// hasn't returned within IdleTimeout and calls FlushRecord, so inputs that
+// block on reads get their pending records delivered too. A zero duration
+// disables the timeout.
+type IdleFlushingSplitter interface {
+	FlushingSplitter
+	IdleTimeout() time.Duration
+}
+
 // Heka Decoder plugin interface.
 type Decoder interface {
 	// Extract data loaded into the PipelinePack (usually in pack.MsgBytes)
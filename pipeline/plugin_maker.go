@@ -304,6 +304,11 @@ func (m *pluginMaker) makeSplitterRunner(name string, config interface{}, splitt
 			commonSplitter.BufferSize, message.MAX_RECORD_SIZE)
 		return nil, err
 	}
+	err = checkDecompression(commonSplitter.Decompression,
+		commonSplitter.DecompressionScope)
+	if err != nil {
+		return nil, err
+	}
 	if commonSplitter.IncompleteFinal == nil {
 		commonSplitter.IncompleteFinal, err = getDefaultBool(config, "IncompleteFinal")
 		if err != nil {
@@ -987,6 +987,7 @@ func (foRunner *foRunner) Start(h PluginHelper, wg *sync.WaitGroup) (err error)
 
 	if foRunner.matcher != nil {
 		foRunner.matcher.bufFeeder = bufFeeder
+		foRunner.matcher.keepLazyFields = bufFeeder != nil || foRunner.forwardsMsgBytes()
 		foRunner.matcher.globals = foRunner.pConfig.Globals
 		foRunner.matcher.stopChan = foRunner.stopChan
 		switch foRunner.kind {
@@ -1615,6 +1616,16 @@ func (foRunner *foRunner) OldOutput() OldOutput {
 	return foRunner.plugin.(OldOutput)
 }
 
+// Reports whether the runner's output only ever sends pack.MsgBytes on, i.e.
+// it declares itself EncoderOnly and is using the ProtobufEncoder.
+func (foRunner *foRunner) forwardsMsgBytes() bool {
+	if eo, ok := foRunner.plugin.(EncoderOnly); !ok || !eo.EncoderOnly() {
+		return false
+	}
+	_, ok := foRunner.encoder.(*ProtobufEncoder)
+	return ok
+}
+
 func (foRunner *foRunner) Encoder() Encoder {
 	return foRunner.encoder
 }
@@ -25,6 +25,12 @@ import (
 	"github.com/mozilla-services/heka/message"
 )
 
+type ProtobufDecoderConfig struct {
+	// Defer decoding of the message Fields until the router needs them, the
+	// header variables are always decoded.
+	Lazy bool `toml:"lazy"`
+}
+
 // Decoder for converting ProtocolBuffer data into Message objects.
 type ProtobufDecoder struct {
 	processMessageCount    int64
@@ -35,6 +41,7 @@ type ProtobufDecoder struct {
 	reportLock             sync.Mutex
 	sample                 bool
 	sampleDenominator      int
+	lazy                   bool
 }
 
 // Heka will call this before calling any other methods to give us access to
@@ -43,7 +50,14 @@ func (p *ProtobufDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
 	p.pConfig = pConfig
 }
 
+func (p *ProtobufDecoder) ConfigStruct() interface{} {
+	return new(ProtobufDecoderConfig)
+}
+
 func (p *ProtobufDecoder) Init(config interface{}) error {
+	if conf, ok := config.(*ProtobufDecoderConfig); ok {
+		p.lazy = conf.Lazy
+	}
 	p.sample = true
 	p.sampleDenominator = p.pConfig.Globals.SampleDenominator
 	return nil
@@ -59,7 +73,13 @@ func (p *ProtobufDecoder) Decode(pack *PipelinePack) (
 		startTime = time.Now()
 	}
 
-	if err = proto.Unmarshal(pack.MsgBytes, pack.Message); err == nil {
+	if p.lazy {
+		pack.lazyFields, err = message.UnmarshalLazy(pack.MsgBytes, pack.Message,
+			pack.lazyFields[:0])
+	} else {
+		err = proto.Unmarshal(pack.MsgBytes, pack.Message)
+	}
+	if err == nil {
 		packs = []*PipelinePack{pack}
 		pack.TrustMsgBytes = true
 	} else {
@@ -20,6 +20,7 @@ import (
 	"testing"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/message"
 	ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/rafrombrc/gomock/gomock"
 	"github.com/rafrombrc/gospec/src/gospec"
@@ -56,6 +57,77 @@ func ProtobufDecoderSpec(c gospec.Context) {
 			c.Expect(v, gs.Equals, "bar")
 		})
 
+		c.Specify("lazily decodes a protobuf message", func() {
+			decoder.lazy = true
+			pack.MsgBytes = encoded
+			_, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.TrustMsgBytes, gs.IsTrue)
+			c.Expect(pack.Message.GetUuidString(), gs.Equals, msg.GetUuidString())
+			c.Expect(len(pack.Message.Fields), gs.Equals, 0)
+
+			c.Expect(pack.DecodeFields(), gs.IsNil)
+			c.Expect(pack.Message, gs.Equals, msg)
+			v, ok := pack.Message.GetFieldValue("foo")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(v, gs.Equals, "bar")
+
+			c.Expect(pack.DecodeFields(), gs.IsNil)
+			c.Expect(len(pack.Message.Fields), gs.Equals, len(msg.Fields))
+		})
+
+		c.Specify("decodes lazy Fields before re-encoding", func() {
+			decoder.lazy = true
+			pack.MsgBytes = encoded
+			_, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			pack.TrustMsgBytes = false
+			c.Expect(pack.EncodeMsgBytes(), gs.IsNil)
+			decoded := new(message.Message)
+			c.Expect(proto.Unmarshal(pack.MsgBytes, decoded), gs.IsNil)
+			c.Expect(decoded, gs.Equals, msg)
+		})
+
+		c.Specify("has lazy Fields decoded by the router when needed", func() {
+			decoder.lazy = true
+			pack.MsgBytes = encoded
+			_, err := decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			route := func(matcher string, keepLazyFields bool) int {
+				matchChan := make(chan *PipelinePack, 1)
+				mr, err := NewMatchRunner(matcher, "", nil, 1, matchChan)
+				c.Assume(err, gs.IsNil)
+				mr.keepLazyFields = keepLazyFields
+				mr.Start(1)
+				defer mr.Close()
+				mr.inChan <- pack
+				return len((<-matchChan).Message.Fields)
+			}
+			c.Expect(route("TRUE", true), gs.Equals, 0)
+			c.Expect(route("Fields[foo] == 'bar'", true), gs.Equals, len(msg.Fields))
+			pack.Zero()
+			pack.MsgBytes = encoded
+			decoder.Decode(pack)
+			c.Expect(route("TRUE", false), gs.Equals, len(msg.Fields))
+		})
+
+		c.Specify("reports corrupt lazy Fields", func() {
+			decoder.lazy = true
+			// a Field w/ a truncated value_type varint
+			pack.MsgBytes = append(append([]byte(nil), encoded...), 0x52, 0x02,
+				0x10, 0x80)
+			_, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.DecodeFields(), gs.Not(gs.IsNil))
+			c.Expect(pack.DecodeFields(), gs.Not(gs.IsNil))
+			c.Expect(len(pack.Message.Fields), gs.Equals, 0)
+			pack.TrustMsgBytes = false
+			c.Expect(pack.EncodeMsgBytes(), gs.Not(gs.IsNil))
+
+			pack.Zero()
+			c.Expect(pack.DecodeFields(), gs.IsNil)
+		})
+
 		c.Specify("returns an error for bunk encoding", func() {
 			bunk := append([]byte{0, 0, 0}, encoded...)
 			pack.MsgBytes = bunk
@@ -225,6 +225,9 @@ type MatchRunner struct {
 	bufFeeder     *BufferFeeder
 	globals       *GlobalConfigStruct
 	retry         *RetryHelper
+	// Set if matched packs are only ever forwarded as pack.MsgBytes, so any
+	// lazily decoded message Fields can be left alone.
+	keepLazyFields bool
 }
 
 // Creates and returns a new MatchRunner if possible, or a relevant error if
@@ -304,11 +307,15 @@ func (mr *MatchRunner) run(sampleDenom int) {
 	)
 
 	var capacity int64 = int64(cap(mr.inChan))
+	usesFields := mr.spec.UsesFields()
 	for pack := range mr.inChan {
 		if len(mr.signer) != 0 && mr.signer != pack.Signer {
 			pack.recycle()
 			continue
 		}
+		if usesFields && !mr.decodeFields(pack) {
+			continue
+		}
 		// We may want to keep separate samples for match/nomatch conditions.
 		// In most cases the random sampling will capture the most common
 		// condition which is usesful for the overall system health but not
@@ -334,6 +341,9 @@ func (mr *MatchRunner) run(sampleDenom int) {
 		}
 
 		if match {
+			if !usesFields && !mr.keepLazyFields && !mr.decodeFields(pack) {
+				continue
+			}
 			pack.diagnostics.AddStamp(mr.pluginRunner)
 			err := mr.deliver(pack)
 			if err != nil {
@@ -352,6 +362,17 @@ func (mr *MatchRunner) run(sampleDenom int) {
 	}
 }
 
+// Decodes the pack's lazily decoded message Fields, if any. Packs whose
+// Fields can't be decoded are logged and recycled, and false is returned.
+func (mr *MatchRunner) decodeFields(pack *PipelinePack) bool {
+	if err := pack.DecodeFields(); err != nil {
+		mr.pluginRunner.LogError(fmt.Errorf("can't decode message fields: %s", err))
+		pack.recycle()
+		return false
+	}
+	return true
+}
+
 // Starts the runner listening for messages on its input channel. Any message
 // that is a match will be placed on the provided matchChan, or written out to
 // the disk queue if buffering is in play. Any messages that are not a match
@@ -0,0 +1,234 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy/snappy"
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/zstd"
+)
+
+// Returns an error if the decompression settings are invalid.
+func checkDecompression(decompression, scope string) error {
+	switch decompression {
+	case "", "gzip", "zlib", "snappy", "zstd":
+	default:
+		return fmt.Errorf("unsupported 'decompression' type: %s", decompression)
+	}
+	switch scope {
+	case "", "stream", "record":
+	default:
+		return fmt.Errorf("'decompression_scope' must be 'stream' or 'record': %s",
+			scope)
+	}
+	return nil
+}
+
+func newDecompressor(decompression string, r io.Reader) (io.Reader, error) {
+	switch decompression {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zlib":
+		return zlib.NewReader(r)
+	case "snappy":
+		return snappy.NewReader(r), nil
+	case "zstd":
+		return zstd.NewReader(r), nil
+	}
+	return nil, fmt.Errorf("unsupported 'decompression' type: %s", decompression)
+}
+
+// decompressRecord returns the decompressed contents of a single record.
+func decompressRecord(decompression string, record []byte) ([]byte, error) {
+	var (
+		decompressed []byte
+		err          error
+	)
+	if decompression == "snappy" {
+		// Individual records use the snappy block format, the framing format
+		// is only meaningful for streams.
+		if decompressed, err = snappy.Decode(nil, record); err != nil {
+			return nil, err
+		}
+	} else {
+		var r io.Reader
+		if r, err = newDecompressor(decompression, bytes.NewReader(record)); err != nil {
+			return nil, err
+		}
+		limited := io.LimitReader(r, int64(message.MAX_RECORD_SIZE)+1)
+		if decompressed, err = ioutil.ReadAll(limited); err != nil {
+			return nil, err
+		}
+	}
+	if len(decompressed) > int(message.MAX_RECORD_SIZE) {
+		return nil, fmt.Errorf("decompressed record exceeded MAX_RECORD_SIZE %d",
+			message.MAX_RECORD_SIZE)
+	}
+	return decompressed, nil
+}
+
+type decompressResult struct {
+	data []byte
+	err  error
+}
+
+// streamDecompressor decompresses a stream in a separate goroutine. The
+// decompression readers treat any read error as fatal, so rather than being
+// handed the source stream they are fed through a channel by the
+// SplitterRunner, which is then free to return read timeouts and the like to
+// the input without corrupting the decompression state.
+type streamDecompressor struct {
+	source   io.Reader
+	raw      []byte
+	feed     chan []byte // nil signals the end of the source stream
+	needData chan struct{}
+	output   chan decompressResult
+	quit     chan struct{}
+	pending  []byte // decompressed data not yet consumed
+	fed      []byte // source data not yet consumed by the decompressor
+	waiting  bool   // the decompressor is waiting for source data
+	eof      bool
+	err      error
+}
+
+func newStreamDecompressor(decompression string, source io.Reader) *streamDecompressor {
+	sd := &streamDecompressor{
+		source:   source,
+		raw:      make([]byte, 32*1024),
+		feed:     make(chan []byte),
+		needData: make(chan struct{}),
+		output:   make(chan decompressResult),
+		quit:     make(chan struct{}),
+	}
+	go sd.decompress(decompression)
+	return sd
+}
+
+// readFed is the decompressing goroutine's source, it requests more data from
+// the SplitterRunner whenever it runs out.
+func (sd *streamDecompressor) readFed(p []byte) (int, error) {
+	if len(sd.fed) == 0 {
+		if sd.eof {
+			return 0, io.EOF
+		}
+		select {
+		case sd.needData <- struct{}{}:
+		case <-sd.quit:
+			return 0, io.ErrClosedPipe
+		}
+		select {
+		case sd.fed = <-sd.feed:
+		case <-sd.quit:
+			return 0, io.ErrClosedPipe
+		}
+		if sd.fed == nil {
+			sd.eof = true
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, sd.fed)
+	sd.fed = sd.fed[n:]
+	return n, nil
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+func (sd *streamDecompressor) decompress(decompression string) {
+	var result decompressResult
+	r, err := newDecompressor(decompression, readerFunc(sd.readFed))
+	if err != nil {
+		result.err = err
+	} else {
+		buf := make([]byte, 32*1024)
+		for result.err == nil {
+			var n int
+			n, result.err = r.Read(buf)
+			if n == 0 {
+				continue
+			}
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			select {
+			case sd.output <- decompressResult{data: data}:
+			case <-sd.quit:
+				return
+			}
+		}
+	}
+	select {
+	case sd.output <- result:
+	case <-sd.quit:
+	}
+}
+
+// Read returns decompressed data, reading from the source stream as needed.
+// Errors from the source stream other than EOF are returned as is, reading
+// can be resumed afterwards.
+func (sd *streamDecompressor) Read(p []byte) (n int, err error) {
+	for {
+		if len(sd.pending) > 0 {
+			n = copy(p, sd.pending)
+			sd.pending = sd.pending[n:]
+			return n, nil
+		}
+		if sd.err != nil {
+			return 0, sd.err
+		}
+		if sd.waiting {
+			n, err = sd.source.Read(sd.raw)
+			if n > 0 {
+				data := make([]byte, n)
+				copy(data, sd.raw[:n])
+				sd.waiting = false
+				sd.feed <- data
+				continue
+			}
+			if err == io.EOF {
+				sd.waiting = false
+				sd.feed <- nil
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		select {
+		case result := <-sd.output:
+			sd.pending = result.data
+			if result.err != nil {
+				sd.err = result.err
+			}
+		case <-sd.needData:
+			sd.waiting = true
+		}
+	}
+}
+
+// Close stops the decompressing goroutine.
+func (sd *streamDecompressor) Close() {
+	close(sd.quit)
+}
@@ -48,18 +48,24 @@ type SplitterRunner interface {
 
 type sRunner struct {
 	pRunnerBase
-	splitter        Splitter
-	buf             []byte
-	readPos         int
-	scanPos         int
-	needData        bool
-	keepTruncated   bool
-	useMsgBytes     bool
-	reachedEOF      bool
-	incompleteFinal bool
-	unframer        UnframingSplitter
-	ir              InputRunner
-	packDecorator   func(*PipelinePack)
+	splitter          Splitter
+	buf               []byte
+	readPos           int
+	scanPos           int
+	needData          bool
+	keepTruncated     bool
+	useMsgBytes       bool
+	reachedEOF        bool
+	incompleteFinal   bool
+	unframer          UnframingSplitter
+	flusher           FlushingSplitter
+	idleFlusher       IdleFlushingSplitter
+	idleReader        *idleReader
+	ir                InputRunner
+	packDecorator     func(*PipelinePack)
+	decompression     string
+	decompressRecords bool
+	decompressor      *streamDecompressor
 }
 
 func NewSplitterRunner(name string, splitter Splitter,
@@ -89,10 +95,14 @@ func NewSplitterRunner(name string, splitter Splitter,
 	if config.IncompleteFinal != nil {
 		sr.incompleteFinal = *config.IncompleteFinal
 	}
+	sr.decompression = config.Decompression
+	sr.decompressRecords = config.DecompressionScope == "record"
 	// Cache our unframer so we don't need to do type coersion for every
 	// message. Ignoring the ok is safe here, it just means sr.unframer might
 	// be nil, which we test for later.
 	sr.unframer, _ = splitter.(UnframingSplitter)
+	sr.flusher, _ = splitter.(FlushingSplitter)
+	sr.idleFlusher, _ = splitter.(IdleFlushingSplitter)
 	if wantsSplitterRunner, ok := splitter.(WantsSplitterRunner); ok {
 		wantsSplitterRunner.SetSplitterRunner(sr)
 	}
@@ -132,6 +142,7 @@ func (sr *sRunner) Splitter() Splitter {
 }
 
 func (sr *sRunner) Done() {
+	sr.closeDecompressor()
 	pConfig := sr.h.PipelineConfig()
 	pConfig.allSplittersLock.Lock()
 	for i, otherSr := range pConfig.allSplitters {
@@ -149,6 +160,10 @@ func (sr *sRunner) GetRemainingData() (record []byte) {
 	}
 	sr.scanPos = 0
 	sr.readPos = 0
+	// Data from a read still in flight and the decompression state belong to
+	// the old stream.
+	sr.idleReader = nil
+	sr.closeDecompressor()
 	return record
 }
 
@@ -187,10 +202,100 @@ func (sr *sRunner) read(r io.Reader) (n int, err error) {
 			sr.readPos, sr.scanPos = sr.readPos-sr.scanPos, 0
 		}
 	}
-	n, err = r.Read(sr.buf[sr.readPos:])
+	if sr.idleFlusher != nil && sr.idleFlusher.IdleTimeout() > 0 {
+		if sr.idleReader == nil {
+			sr.idleReader = new(idleReader)
+		}
+		n, err = sr.idleReader.read(sr.streamReader(r), sr.buf[sr.readPos:],
+			sr.idleFlusher.IdleTimeout())
+	} else {
+		n, err = sr.streamReader(r).Read(sr.buf[sr.readPos:])
+	}
+	if err == io.EOF {
+		// The compressed stream is complete, whatever is read next starts a
+		// new one.
+		sr.closeDecompressor()
+	}
+	return n, err
+}
+
+// errIdle is returned by idleReader.read when no data arrived in time.
+var errIdle = errors.New("stream idle")
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// idleReader performs reads in a separate goroutine so that waiting for data
+// can be given up after a timeout. A read that times out stays pending and
+// its result is returned by the next call.
+type idleReader struct {
+	buf     []byte // read into by the pending read
+	data    []byte // read but not yet returned
+	err     error
+	results chan readResult // non-nil while a read is pending
+}
+
+func (ir *idleReader) read(r io.Reader, p []byte, timeout time.Duration) (
+	n int, err error) {
+
+	if len(ir.data) == 0 && ir.err == nil {
+		if ir.results == nil {
+			if cap(ir.buf) < len(p) {
+				ir.buf = make([]byte, len(p))
+			}
+			buf := ir.buf[:len(p)]
+			results := make(chan readResult, 1)
+			go func() {
+				n, err := r.Read(buf)
+				results <- readResult{n, err}
+			}()
+			ir.results = results
+		}
+		timer := time.NewTimer(timeout)
+		select {
+		case res := <-ir.results:
+			timer.Stop()
+			ir.results = nil
+			ir.data, ir.err = ir.buf[:res.n], res.err
+		case <-timer.C:
+			return 0, errIdle
+		}
+	}
+	n = copy(p, ir.data)
+	ir.data = ir.data[n:]
+	if len(ir.data) == 0 {
+		err, ir.err = ir.err, nil
+	}
 	return n, err
 }
 
+// streamReader returns the reader to use for the stream, wrapping it in a
+// decompressor when stream decompression is configured.
+func (sr *sRunner) streamReader(r io.Reader) io.Reader {
+	if sr.decompression == "" || sr.decompressRecords {
+		return r
+	}
+	// The decompressor belongs to the current stream until its end is reached
+	// or GetRemainingData is called to switch to another one. The reader
+	// itself may be a fresh wrapper on every call, so it's only used as the
+	// data source, unless a pending idle read may still be using the old one.
+	if sr.decompressor == nil {
+		sr.decompressor = newStreamDecompressor(sr.decompression, r)
+	} else if sr.idleReader == nil || sr.idleReader.results == nil {
+		sr.decompressor.source = r
+	}
+	return sr.decompressor
+}
+
+func (sr *sRunner) closeDecompressor() {
+	if sr.decompressor != nil {
+		sr.decompressor.Close()
+		sr.decompressor = nil
+	}
+}
+
 func (sr *sRunner) GetRecordFromStream(r io.Reader) (bytesRead int, record []byte, err error) {
 	if sr.needData && !sr.reachedEOF {
 		bytesRead, err = sr.read(r)
@@ -200,7 +305,11 @@ func (sr *sRunner) GetRecordFromStream(r io.Reader) (bytesRead int, record []byt
 		if err == io.EOF {
 			if bytesRead == 0 {
 				// If we didn't read any bytes, we don't need to look for more
-				// records, we can return the EOF.
+				// records, we can return the EOF unless the splitter wants to
+				// flush what it's been holding on to.
+				if bytesRead, record = sr.flushRecord(); len(record) > 0 {
+					err = nil
+				}
 				return bytesRead, record, err
 			}
 			// We did read some bytes, so clear the EOF for now
@@ -213,6 +322,13 @@ func (sr *sRunner) GetRecordFromStream(r io.Reader) (bytesRead int, record []byt
 			record = sr.buf
 		}
 
+		if err == errIdle {
+			// Our own timeout, the read is still pending and the stream is
+			// idle. Other read errors, e.g. the read deadlines of network
+			// inputs, don't flush the splitter as more data may follow.
+			bytesRead, record = sr.flushRecord()
+			return bytesRead, record, nil
+		}
 		if err != nil {
 			return bytesRead, record, err
 		}
@@ -250,6 +366,22 @@ func (sr *sRunner) GetRecordFromStream(r io.Reader) (bytesRead int, record []byt
 	return bytesRead, record, err
 }
 
+// flushRecord gives a FlushingSplitter the opportunity to turn the unconsumed
+// buffer contents into a record.
+func (sr *sRunner) flushRecord() (bytesRead int, record []byte) {
+	if sr.flusher == nil || sr.readPos == sr.scanPos {
+		return 0, nil
+	}
+	bytesRead, record = sr.flusher.FlushRecord(sr.buf[sr.scanPos:sr.readPos])
+	sr.scanPos += bytesRead
+	if sr.readPos == sr.scanPos {
+		sr.readPos = 0
+		sr.scanPos = 0
+	}
+	sr.needData = true
+	return bytesRead, record
+}
+
 func (sr *sRunner) DeliverRecord(record []byte, del Deliverer) {
 	unframed := record
 	pack := <-sr.ir.InChan()
@@ -260,6 +392,14 @@ func (sr *sRunner) DeliverRecord(record []byte, del Deliverer) {
 			return
 		}
 	}
+	if sr.decompressRecords {
+		var err error
+		if unframed, err = decompressRecord(sr.decompression, unframed); err != nil {
+			sr.LogError(err)
+			pack.recycle()
+			return
+		}
+	}
 	if sr.useMsgBytes {
 		// Put the blob in the pack and let the decoder sort it out.
 		messageLen := len(unframed)
@@ -18,6 +18,9 @@ package pipeline
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
 	"io"
 	"io/ioutil"
 	"path/filepath"
@@ -110,6 +113,51 @@ func makeMultiReadReader(data []byte) (mr *MultiReadReader) {
 	return
 }
 
+// Reader returning each chunk of data from a separate Read call, with a
+// timeout error in between.
+type ChunkedTimeoutReader struct {
+	chunks  [][]byte
+	timeout bool
+}
+
+var errMockTimeout = errors.New("mock timeout")
+
+func (cr *ChunkedTimeoutReader) Read(p []byte) (n int, err error) {
+	if len(cr.chunks) == 0 {
+		return 0, io.EOF
+	}
+	if cr.timeout = !cr.timeout; cr.timeout {
+		return 0, errMockTimeout
+	}
+	n = copy(p, cr.chunks[0])
+	if n == len(cr.chunks[0]) {
+		cr.chunks = cr.chunks[1:]
+	} else {
+		cr.chunks[0] = cr.chunks[0][n:]
+	}
+	return n, nil
+}
+
+func gzipData(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// readStream returns the records read from r until an error occurs.
+func readStream(sr *sRunner, r io.Reader) (records []string, err error) {
+	var record []byte
+	for err == nil {
+		_, record, err = sr.GetRecordFromStream(r)
+		if len(record) > 0 {
+			records = append(records, string(record))
+		}
+	}
+	return records, err
+}
+
 func readRecordsFromStream(sr *sRunner, reader io.Reader, getRemaining bool) (count int,
 	errCount int, bytesRead int, foundEOFCount int, remainingDataLength int,
 	finalRecordLength int, eofRecordLength int) {
@@ -475,4 +523,127 @@ func SplitterRunnerSpec(c gs.Context) {
 		})
 
 	})
+
+	c.Specify("A SplitterRunner w/ decompression", func() {
+		splitter := &TokenSplitter{}
+		config := splitter.ConfigStruct().(*TokenSplitterConfig)
+		err := splitter.Init(config)
+		c.Assume(err, gs.IsNil)
+		srConfig.Decompression = "gzip"
+		data := []byte("test1\ntest12\ntest123\n")
+
+		c.Specify("decompresses the stream across read errors", func() {
+			compressed := gzipData(data)
+			half := len(compressed) / 2
+			reader := &ChunkedTimeoutReader{
+				chunks: [][]byte{compressed[:half], compressed[half:]},
+			}
+			sr := NewSplitterRunner("TokenSplitter", splitter, srConfig)
+			sr.h = NewPipelineConfig(nil)
+			defer func() {
+				sr.Done()
+				c.Expect(sr.decompressor, gs.IsNil)
+			}()
+
+			var (
+				records  []string
+				timeouts int
+			)
+			for err != io.EOF {
+				var record []byte
+				_, record, err = sr.GetRecordFromStream(reader)
+				if err == errMockTimeout {
+					timeouts++
+				}
+				if len(record) > 0 {
+					records = append(records, string(record))
+				}
+			}
+			c.Expect(timeouts > 0, gs.IsTrue)
+			c.Expect(len(records), gs.Equals, 3)
+			c.Expect(records[2], gs.Equals, "test123\n")
+		})
+
+		c.Specify("starts over after switching streams", func() {
+			sr := NewSplitterRunner("TokenSplitter", splitter, srConfig)
+			records, err := readStream(sr, bytes.NewReader(gzipData(data)))
+			c.Expect(err, gs.Equals, io.EOF)
+			c.Expect(len(records), gs.Equals, 3)
+
+			// The decompressor can't be reused after the end of a stream.
+			sr.GetRemainingData()
+			c.Expect(sr.decompressor, gs.IsNil)
+			records, err = readStream(sr, bytes.NewReader(gzipData(data)))
+			c.Expect(err, gs.Equals, io.EOF)
+			c.Expect(len(records), gs.Equals, 3)
+			c.Expect(records[0], gs.Equals, "test1\n")
+		})
+
+		c.Specify("starts over on the next stream w/o GetRemainingData", func() {
+			nullSplitter := &NullSplitter{}
+			sr := NewSplitterRunner("NullSplitter", nullSplitter, srConfig)
+			ir := NewMockInputRunner(ctrl)
+			sr.SetInputRunner(ir)
+			recycleChan := make(chan *PipelinePack, 1)
+			recycleChan <- NewPipelinePack(recycleChan)
+			ir.EXPECT().InChan().Return(recycleChan).Times(2)
+			ir.EXPECT().Name().Return("InputRunnerName").Times(2)
+			var payloads []string
+			del := &deliverer{
+				deliver: func(pack *PipelinePack) {
+					payloads = append(payloads, pack.Message.GetPayload())
+					pack.Recycle(nil)
+				},
+			}
+
+			for i := 0; i < 2; i++ {
+				err = sr.SplitStreamNullSplitterToEOF(
+					bytes.NewReader(gzipData(data)), del)
+				c.Expect(err, gs.Equals, io.EOF)
+			}
+			c.Expect(len(payloads), gs.Equals, 2)
+			c.Expect(payloads[1], gs.Equals, string(data))
+		})
+
+		c.Specify("decompresses zstd streams", func() {
+			srConfig.Decompression = "zstd"
+			sr := NewSplitterRunner("TokenSplitter", splitter, srConfig)
+			// "test1\ntest12\ntest123\n" twice, as compressed by `zstd -19`.
+			compressed, _ := hex.DecodeString("28b52ffd0468b500007074657374310a7465" +
+				"73743132330a0200383f565692fa878c34")
+			records, err := readStream(sr, bytes.NewReader(compressed))
+			c.Expect(err, gs.Equals, io.EOF)
+			c.Expect(len(records), gs.Equals, 6)
+			c.Expect(records[5], gs.Equals, "test123\n")
+		})
+
+		c.Specify("decompresses individual records", func() {
+			srConfig.DecompressionScope = "record"
+			sr := NewSplitterRunner("TokenSplitter", splitter, srConfig)
+			ir := NewMockInputRunner(ctrl)
+			sr.SetInputRunner(ir)
+			recycleChan := make(chan *PipelinePack, 1)
+			pack := NewPipelinePack(recycleChan)
+			recycleChan <- pack
+			ir.EXPECT().InChan().Return(recycleChan).Times(2)
+			ir.EXPECT().Name().Return("InputRunnerName")
+			delCall := ir.EXPECT().Deliver(pack)
+			delCall.Do(func(pack *PipelinePack) {
+				c.Expect(pack.Message.GetPayload(), gs.Equals, string(data))
+				pack.Recycle(nil)
+			})
+
+			sr.DeliverRecord(gzipData(data), nil)
+			// Records that can't be decompressed are dropped.
+			sr.DeliverRecord(data, nil)
+			c.Expect(len(recycleChan), gs.Equals, 1)
+		})
+
+		c.Specify("rejects unsupported formats", func() {
+			err = checkDecompression("lzma", "")
+			c.Expect(err.Error(), gs.Equals, "unsupported 'decompression' type: lzma")
+			err = checkDecompression("gzip", "message")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
 }
@@ -21,11 +21,13 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/subtle"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/mozilla-services/heka/message"
 	"hash"
 	"regexp"
+	"time"
 )
 
 type NullSplitter struct {
@@ -152,6 +154,502 @@ func (r *RegexSplitter) FindRecord(buf []byte) (bytesRead int, record []byte) {
 	return bytesRead, record
 }
 
+type MultilineSplitter struct {
+	startRegex    *regexp.Regexp
+	continueRegex *regexp.Regexp
+	maxLines      int
+	maxBytes      int
+	idleTimeout   time.Duration
+	pendingLen    int
+	pendingSince  time.Time
+	now           func() time.Time
+}
+
+type MultilineSplitterConfig struct {
+	// Lines matching this regex begin a new record, all other lines are
+	// appended to the current one.
+	StartRegex string `toml:"start_regex"`
+	// Lines matching this regex are appended to the current record, all other
+	// lines begin a new one.
+	ContinuationRegex string `toml:"continuation_regex"`
+	MaxLines          uint   `toml:"max_lines"`
+	MaxBytes          uint   `toml:"max_bytes"`
+	// Number of milliseconds without new data after which a pending record is
+	// delivered, 0 disables.
+	IdleTimeout uint `toml:"idle_timeout"`
+}
+
+func (m *MultilineSplitter) ConfigStruct() interface{} {
+	return &MultilineSplitterConfig{
+		MaxLines:    500,
+		MaxBytes:    64 * 1024,
+		IdleTimeout: 1000,
+	}
+}
+
+func (m *MultilineSplitter) Init(config interface{}) (err error) {
+	conf := config.(*MultilineSplitterConfig)
+	if (conf.StartRegex == "") == (conf.ContinuationRegex == "") {
+		return errors.New("exactly one of 'start_regex' or 'continuation_regex' " +
+			"must be specified")
+	}
+	if conf.StartRegex != "" {
+		if m.startRegex, err = regexp.Compile(conf.StartRegex); err != nil {
+			return
+		}
+	} else {
+		if m.continueRegex, err = regexp.Compile(conf.ContinuationRegex); err != nil {
+			return
+		}
+	}
+	if conf.MaxLines == 0 {
+		return errors.New("'max_lines' must be greater than 0")
+	}
+	if conf.MaxBytes == 0 || conf.MaxBytes > uint(message.MAX_RECORD_SIZE) {
+		return fmt.Errorf("'max_bytes' must be between 1 and %d",
+			message.MAX_RECORD_SIZE)
+	}
+	m.maxLines = int(conf.MaxLines)
+	m.maxBytes = int(conf.MaxBytes)
+	m.idleTimeout = time.Duration(conf.IdleTimeout) * time.Millisecond
+	if m.now == nil {
+		m.now = time.Now
+	}
+	return
+}
+
+// Returns true if the line begins a new record.
+func (m *MultilineSplitter) isStart(line []byte) bool {
+	if m.startRegex != nil {
+		return m.startRegex.Match(line)
+	}
+	return !m.continueRegex.Match(line)
+}
+
+// Returns the record w/o its final newline.
+func trimEOL(record []byte) []byte {
+	if n := len(record); n > 0 && record[n-1] == '\n' {
+		return record[:n-1]
+	}
+	return record
+}
+
+func (m *MultilineSplitter) FindRecord(buf []byte) (bytesRead int, record []byte) {
+	// The first line always belongs to the record, unless it's too long.
+	n := bytes.IndexByte(buf, '\n')
+	if n > m.maxBytes || (n == -1 && len(buf) > m.maxBytes) {
+		m.pendingLen = 0
+		return m.maxBytes, buf[:m.maxBytes]
+	}
+	if n == -1 {
+		m.setPending(len(buf))
+		return 0, nil
+	}
+	end := n + 1
+	for lines := 1; lines < m.maxLines; lines++ {
+		n = bytes.IndexByte(buf[end:], '\n')
+		if n == -1 {
+			if len(buf) > m.maxBytes {
+				// Too long to be appended, whatever it turns out to be.
+				break
+			}
+			// Can't tell where the record ends until the line is complete.
+			m.setPending(len(buf))
+			return 0, nil
+		}
+		if m.isStart(buf[end:end+n]) || end+n+1 > m.maxBytes {
+			break
+		}
+		end += n + 1
+	}
+	m.pendingLen = 0
+	return end, trimEOL(buf[:end])
+}
+
+func (m *MultilineSplitter) setPending(pendingLen int) {
+	if pendingLen != m.pendingLen {
+		m.pendingLen = pendingLen
+		m.pendingSince = m.now()
+	}
+}
+
+// IdleTimeout tells the SplitterRunner how long to wait for new data before
+// calling FlushRecord.
+func (m *MultilineSplitter) IdleTimeout() time.Duration {
+	return m.idleTimeout
+}
+
+// FlushRecord returns the pending data as a record once no new data has
+// arrived for the configured idle timeout.
+func (m *MultilineSplitter) FlushRecord(buf []byte) (bytesRead int, record []byte) {
+	if m.idleTimeout == 0 || len(buf) == 0 {
+		return 0, nil
+	}
+	if len(buf) != m.pendingLen {
+		m.setPending(len(buf))
+		return 0, nil
+	}
+	if m.now().Sub(m.pendingSince) < m.idleTimeout {
+		return 0, nil
+	}
+	m.pendingLen = 0
+	return len(buf), trimEOL(buf)
+}
+
+type LengthPrefixSplitter struct {
+	prefixSize     int // 0 for a varint prefix
+	byteOrder      binary.ByteOrder
+	includesPrefix bool
+	maxRecordSize  uint64
+	skip           uint64 // bytes of an oversized record still to be discarded
+	sr             SplitterRunner
+}
+
+type LengthPrefixSplitterConfig struct {
+	// One of "uint8", "uint16", "uint32", "uint64" or "varint".
+	PrefixType string `toml:"prefix_type"`
+	// Byte order of the fixed size prefixes, "big" or "little".
+	ByteOrder string `toml:"byte_order"`
+	// Whether the length includes the size of the prefix itself.
+	IncludesPrefix bool `toml:"length_includes_prefix"`
+	// Records longer than this are discarded, defaults to the maximum record
+	// size.
+	MaxRecordSize uint32 `toml:"max_record_size"`
+}
+
+func (l *LengthPrefixSplitter) SetSplitterRunner(sr SplitterRunner) {
+	l.sr = sr
+}
+
+func (l *LengthPrefixSplitter) ConfigStruct() interface{} {
+	return &LengthPrefixSplitterConfig{
+		PrefixType: "uint32",
+		ByteOrder:  "big",
+	}
+}
+
+func (l *LengthPrefixSplitter) Init(config interface{}) error {
+	conf := config.(*LengthPrefixSplitterConfig)
+	switch conf.PrefixType {
+	case "uint8":
+		l.prefixSize = 1
+	case "uint16":
+		l.prefixSize = 2
+	case "uint32":
+		l.prefixSize = 4
+	case "uint64":
+		l.prefixSize = 8
+	case "varint":
+		l.prefixSize = 0
+	default:
+		return fmt.Errorf("invalid prefix_type: %s", conf.PrefixType)
+	}
+	switch conf.ByteOrder {
+	case "big":
+		l.byteOrder = binary.BigEndian
+	case "little":
+		l.byteOrder = binary.LittleEndian
+	default:
+		return fmt.Errorf("invalid byte_order: %s", conf.ByteOrder)
+	}
+	l.includesPrefix = conf.IncludesPrefix
+	if conf.MaxRecordSize > message.MAX_RECORD_SIZE {
+		return fmt.Errorf("max_record_size must not be greater than %d",
+			message.MAX_RECORD_SIZE)
+	}
+	l.maxRecordSize = uint64(conf.MaxRecordSize)
+	if l.maxRecordSize == 0 {
+		l.maxRecordSize = uint64(message.MAX_RECORD_SIZE)
+	}
+	return nil
+}
+
+// Returns the record length and the size of the prefix, a prefix size of 0
+// means more data is needed.
+func (l *LengthPrefixSplitter) readPrefix(buf []byte) (length uint64, prefixSize int) {
+	if l.prefixSize == 0 {
+		return binary.Uvarint(buf)
+	}
+	if len(buf) < l.prefixSize {
+		return 0, 0
+	}
+	switch l.prefixSize {
+	case 1:
+		length = uint64(buf[0])
+	case 2:
+		length = uint64(l.byteOrder.Uint16(buf))
+	case 4:
+		length = uint64(l.byteOrder.Uint32(buf))
+	case 8:
+		length = l.byteOrder.Uint64(buf)
+	}
+	return length, l.prefixSize
+}
+
+func (l *LengthPrefixSplitter) FindRecord(buf []byte) (bytesRead int, record []byte) {
+	for {
+		if l.skip > 0 {
+			n := uint64(len(buf) - bytesRead)
+			if n > l.skip {
+				n = l.skip
+			}
+			l.skip -= n
+			bytesRead += int(n)
+			if l.skip > 0 {
+				return bytesRead, nil // discard more data
+			}
+		}
+
+		length, prefixSize := l.readPrefix(buf[bytesRead:])
+		if prefixSize == 0 {
+			return bytesRead, nil // read more data to get the prefix
+		}
+		if prefixSize < 0 {
+			// The varint overflowed, the stream is corrupt and there is no way
+			// to find the start of the next record.
+			l.sr.LogError(errors.New("invalid varint length prefix, discarding buffer"))
+			return len(buf), nil
+		}
+		if l.includesPrefix {
+			if length < uint64(prefixSize) {
+				l.sr.LogError(fmt.Errorf("record length %d is shorter than its prefix",
+					length))
+				bytesRead += prefixSize
+				continue
+			}
+			length -= uint64(prefixSize)
+		}
+		if length > l.maxRecordSize {
+			l.sr.LogError(fmt.Errorf("record length %d exceeds max_record_size %d, "+
+				"discarding", length, l.maxRecordSize))
+			bytesRead += prefixSize
+			l.skip = length
+			continue
+		}
+		if length == 0 {
+			bytesRead += prefixSize // nothing to deliver
+			continue
+		}
+		end := bytesRead + prefixSize + int(length)
+		if len(buf) < end {
+			return bytesRead, nil // read more data to get the remainder of the record
+		}
+		return end, buf[bytesRead+prefixSize : end]
+	}
+}
+
+type JsonSplitter struct {
+	unwrapArray bool
+	inArray     bool // inside an unwrapped top level array
+	sr          SplitterRunner
+}
+
+type JsonSplitterConfig struct {
+	// Deliver each element of a top level array as a separate record.
+	UnwrapArray bool `toml:"unwrap_array"`
+}
+
+func (j *JsonSplitter) SetSplitterRunner(sr SplitterRunner) {
+	j.sr = sr
+}
+
+func (j *JsonSplitter) ConfigStruct() interface{} {
+	return &JsonSplitterConfig{}
+}
+
+func (j *JsonSplitter) Init(config interface{}) error {
+	conf := config.(*JsonSplitterConfig)
+	j.unwrapArray = conf.UnwrapArray
+	return nil
+}
+
+func isJsonSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// Returns true if the byte terminates a number or literal.
+func isJsonDelimiter(c byte) bool {
+	switch c {
+	case ',', ']', '}', '[', '{', '"':
+		return true
+	}
+	return isJsonSpace(c)
+}
+
+// Returns the end of the JSON value starting at the beginning of the buffer,
+// -1 if the value is incomplete.
+func jsonValueEnd(buf []byte) int {
+	var (
+		depth    int
+		inString bool
+		escaped  bool
+	)
+	literal := buf[0] != '{' && buf[0] != '[' && buf[0] != '"'
+	for i, c := range buf {
+		if literal {
+			if isJsonDelimiter(c) {
+				return i
+			}
+			continue
+		}
+		if inString {
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+				if depth == 0 {
+					return i + 1
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+func (j *JsonSplitter) FindRecord(buf []byte) (bytesRead int, record []byte) {
+	// Skip the whitespace and separators preceding the next value.
+	for ; bytesRead < len(buf); bytesRead++ {
+		c := buf[bytesRead]
+		if isJsonSpace(c) || c == ',' {
+			continue
+		}
+		if j.inArray && c == ']' {
+			j.inArray = false
+			continue
+		}
+		if j.unwrapArray && !j.inArray && c == '[' {
+			j.inArray = true
+			continue
+		}
+		if c == ']' || c == '}' {
+			j.sr.LogError(fmt.Errorf("unexpected '%c' in JSON stream, skipping", c))
+			continue
+		}
+		break
+	}
+	if bytesRead == len(buf) {
+		return bytesRead, nil
+	}
+	end := jsonValueEnd(buf[bytesRead:])
+	if end == -1 {
+		return bytesRead, nil // read more data to complete the value
+	}
+	end += bytesRead
+	return end, buf[bytesRead:end]
+}
+
+// FlushRecord delivers a number or literal left at the end of the stream,
+// FindRecord can't tell it's complete until a delimiter follows. It's only
+// called at EOF, so a value cut short by a read timeout isn't split.
+// Incomplete objects, arrays and strings are kept.
+func (j *JsonSplitter) FlushRecord(buf []byte) (bytesRead int, record []byte) {
+	if len(buf) == 0 || buf[0] == '{' || buf[0] == '[' || buf[0] == '"' {
+		return 0, nil
+	}
+	return len(buf), buf
+}
+
+// Splitter for syslog over TCP as described in RFC 6587. Each record is
+// checked for octet counting framing, falling back to non-transparent framing
+// so senders using either can share the same input.
+type SyslogSplitter struct {
+	trailer byte
+}
+
+type SyslogSplitterConfig struct {
+	// Character terminating records using non-transparent framing.
+	Trailer string `toml:"trailer"`
+}
+
+func (s *SyslogSplitter) ConfigStruct() interface{} {
+	return &SyslogSplitterConfig{
+		Trailer: "\n",
+	}
+}
+
+func (s *SyslogSplitter) Init(config interface{}) error {
+	conf := config.(*SyslogSplitterConfig)
+	if len(conf.Trailer) != 1 {
+		return errors.New("SyslogSplitter trailer must be a single character.")
+	}
+	s.trailer = conf.Trailer[0]
+	return nil
+}
+
+// Parses the MSG-LEN of an octet counted record. Returns the length and the
+// offset of the message, an offset of 0 means more data is needed and -1
+// that the record isn't octet counted.
+func parseOctetCount(buf []byte) (length, offset int) {
+	if len(buf) == 0 || buf[0] < '1' || buf[0] > '9' {
+		return 0, -1
+	}
+	for i, c := range buf {
+		switch {
+		case c >= '0' && c <= '9':
+			length = length*10 + int(c-'0')
+			if length > int(message.MAX_RECORD_SIZE) {
+				return 0, -1
+			}
+		case c == ' ':
+			return length, i + 1
+		default:
+			return 0, -1
+		}
+	}
+	return 0, 0
+}
+
+func (s *SyslogSplitter) FindRecord(buf []byte) (bytesRead int, record []byte) {
+	for bytesRead < len(buf) {
+		length, offset := parseOctetCount(buf[bytesRead:])
+		if offset == 0 {
+			return bytesRead, nil // read more data to get the message length
+		}
+		if offset > 0 {
+			end := bytesRead + offset + length
+			if len(buf) < end {
+				return bytesRead, nil // read more data to get the message
+			}
+			return end, buf[bytesRead+offset : end]
+		}
+
+		n := bytes.IndexByte(buf[bytesRead:], s.trailer)
+		if n == -1 {
+			return bytesRead, nil
+		}
+		record = bytes.TrimRight(buf[bytesRead:bytesRead+n], "\r")
+		bytesRead += n + 1
+		if len(record) > 0 {
+			return bytesRead, record
+		}
+	}
+	return bytesRead, nil
+}
+
+// FlushRecord delivers a final non-transparent record that is missing its
+// trailer at the end of the stream. Incomplete octet counted records are
+// kept.
+func (s *SyslogSplitter) FlushRecord(buf []byte) (bytesRead int, record []byte) {
+	if _, offset := parseOctetCount(buf); offset != -1 {
+		return 0, nil
+	}
+	return len(buf), bytes.TrimRight(buf, "\r")
+}
+
 // Heka Message signer object.
 type Signer struct {
 	HmacKey string `toml:"hmac_key"`
@@ -286,4 +784,16 @@ func init() {
 	RegisterPlugin("HekaFramingSplitter", func() interface{} {
 		return &HekaFramingSplitter{}
 	})
+	RegisterPlugin("MultilineSplitter", func() interface{} {
+		return &MultilineSplitter{}
+	})
+	RegisterPlugin("LengthPrefixSplitter", func() interface{} {
+		return &LengthPrefixSplitter{}
+	})
+	RegisterPlugin("JsonSplitter", func() interface{} {
+		return &JsonSplitter{}
+	})
+	RegisterPlugin("SyslogSplitter", func() interface{} {
+		return &SyslogSplitter{}
+	})
 }
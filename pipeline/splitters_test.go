@@ -21,6 +21,9 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"io"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/mozilla-services/heka/message"
@@ -33,6 +36,57 @@ func makeSplitterRunner(name string, splitter Splitter) SplitterRunner {
 	return NewSplitterRunner(name, splitter, srConfig)
 }
 
+// Returns the records found until the stream is exhausted. Read timeouts are
+// skipped like TcpInput does.
+func readRecords(c gs.Context, sRunner SplitterRunner, reader io.Reader) (
+	records []string) {
+
+	for {
+		_, record, err := sRunner.GetRecordFromStream(reader)
+		if len(record) > 0 {
+			records = append(records, string(record))
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			continue
+		}
+		if err != nil {
+			c.Expect(err, gs.Equals, io.EOF)
+			return
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Returns each chunk from its own read, w/ a read timeout in between the
+// chunks like a network connection w/ a read deadline.
+type timeoutReader struct {
+	chunks    []string
+	timedOut  bool
+	onTimeout func()
+}
+
+func (t *timeoutReader) Read(p []byte) (n int, err error) {
+	if len(t.chunks) == 0 {
+		return 0, io.EOF
+	}
+	if t.timedOut {
+		n = copy(p, t.chunks[0])
+		t.chunks = t.chunks[1:]
+		t.timedOut = false
+		return n, nil
+	}
+	t.timedOut = true
+	if t.onTimeout != nil {
+		t.onTimeout()
+	}
+	return 0, timeoutError{}
+}
+
 func TokenSpec(c gs.Context) {
 	c.Specify("A TokenSplitter", func() {
 		splitter := &TokenSplitter{}
@@ -280,6 +334,315 @@ func RegexSpec(c gs.Context) {
 	})
 }
 
+func MultilineSpec(c gs.Context) {
+	c.Specify("A MultilineSplitter", func() {
+		now := time.Unix(1440000000, 0)
+		splitter := &MultilineSplitter{now: func() time.Time { return now }}
+		config := splitter.ConfigStruct().(*MultilineSplitterConfig)
+		sRunner := makeSplitterRunner("MultilineSplitter", splitter)
+		buf := []byte("Exception in main\n\tat Foo.bar\n\tat Foo.baz\n" +
+			"Exception in worker\n\tat Bar.foo\n")
+
+		c.Specify("fails to init w/o exactly one regex", func() {
+			err := splitter.Init(config)
+			c.Expect(err.Error(), gs.Equals, "exactly one of 'start_regex' or "+
+				"'continuation_regex' must be specified")
+			config.StartRegex = "^Exception"
+			config.ContinuationRegex = "^\\s"
+			err = splitter.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("splits w/ continuation regex", func() {
+			config.ContinuationRegex = "^\\s"
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			reader := bytes.NewReader(buf)
+
+			n, record, err := sRunner.GetRecordFromStream(reader)
+			c.Expect(n, gs.Equals, 42)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(record), gs.Equals,
+				"Exception in main\n\tat Foo.bar\n\tat Foo.baz")
+			c.Expect(len(readRecords(c, sRunner, reader)), gs.Equals, 0)
+
+			c.Specify("and flushes the last record when idle", func() {
+				now = now.Add(time.Second)
+				records := readRecords(c, sRunner, reader)
+				c.Expect(len(records), gs.Equals, 1)
+				c.Expect(records[0], gs.Equals, "Exception in worker\n\tat Bar.foo")
+				c.Expect(len(sRunner.GetRemainingData()), gs.Equals, 0)
+			})
+
+			c.Specify("and holds the last record w/o an idle timeout", func() {
+				splitter.idleTimeout = 0
+				now = now.Add(time.Second)
+				c.Expect(len(readRecords(c, sRunner, reader)), gs.Equals, 0)
+				c.Expect(string(sRunner.GetRemainingData()), gs.Equals,
+					"Exception in worker\n\tat Bar.foo\n")
+			})
+		})
+
+		c.Specify("holds the pending record over read timeouts", func() {
+			config.ContinuationRegex = "^\\s"
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			reader := &timeoutReader{
+				chunks:    []string{"Exception in main\n\tat Foo.bar\n", "\tat Foo.baz\n"},
+				onTimeout: func() { now = now.Add(time.Second) },
+			}
+			c.Expect(len(readRecords(c, sRunner, reader)), gs.Equals, 0)
+			c.Expect(string(sRunner.GetRemainingData()), gs.Equals,
+				"Exception in main\n\tat Foo.bar\n\tat Foo.baz\n")
+		})
+
+		c.Specify("splits w/ start regex", func() {
+			config.StartRegex = "^Exception"
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			reader := bytes.NewReader(append([]byte("orphan\n"), buf...))
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 2)
+			c.Expect(records[0], gs.Equals, "orphan")
+			c.Expect(records[1], gs.Equals,
+				"Exception in main\n\tat Foo.bar\n\tat Foo.baz")
+		})
+
+		c.Specify("limits the number of lines", func() {
+			config.ContinuationRegex = "^\\s"
+			config.MaxLines = 2
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			records := readRecords(c, sRunner, bytes.NewReader(buf))
+			c.Expect(len(records), gs.Equals, 3)
+			c.Expect(records[0], gs.Equals, "Exception in main\n\tat Foo.bar")
+			c.Expect(records[1], gs.Equals, "\tat Foo.baz")
+			c.Expect(records[2], gs.Equals, "Exception in worker\n\tat Bar.foo")
+		})
+
+		c.Specify("limits the number of bytes", func() {
+			config.ContinuationRegex = "^\\s"
+			config.MaxBytes = 31
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			records := readRecords(c, sRunner, bytes.NewReader(buf))
+			c.Expect(len(records), gs.Equals, 3)
+			c.Expect(records[0], gs.Equals, "Exception in main\n\tat Foo.bar")
+			c.Expect(records[1], gs.Equals, "\tat Foo.baz")
+		})
+
+		c.Specify("splits lines longer than max bytes", func() {
+			config.ContinuationRegex = "^\\s"
+			config.MaxBytes = 10
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			reader := strings.NewReader("Exception in main\nNext\n")
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 2)
+			c.Expect(records[0], gs.Equals, "Exception ")
+			c.Expect(records[1], gs.Equals, "in main")
+
+			// Incomplete lines aren't held beyond max bytes either.
+			n, record := splitter.FindRecord([]byte("Exception\n\tat Foo.bar"))
+			c.Expect(n, gs.Equals, 10)
+			c.Expect(string(record), gs.Equals, "Exception")
+		})
+
+		c.Specify("flushes the last record while a read blocks", func() {
+			config.ContinuationRegex = "^\\s"
+			config.IdleTimeout = 10
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			splitter.now = time.Now
+			reader, writer := io.Pipe()
+			defer writer.Close()
+			go writer.Write([]byte("Exception in main\n\tat Foo.bar\n"))
+
+			var record []byte
+			for i := 0; i < 10 && len(record) == 0; i++ {
+				_, record, err = sRunner.GetRecordFromStream(reader)
+				c.Assume(err, gs.IsNil)
+			}
+			c.Expect(string(record), gs.Equals, "Exception in main\n\tat Foo.bar")
+		})
+	})
+}
+
+func LengthPrefixSpec(c gs.Context) {
+	c.Specify("A LengthPrefixSplitter", func() {
+		splitter := &LengthPrefixSplitter{}
+		config := splitter.ConfigStruct().(*LengthPrefixSplitterConfig)
+		sRunner := makeSplitterRunner("LengthPrefixSplitter", splitter)
+
+		c.Specify("fails to init w/ an invalid prefix type", func() {
+			config.PrefixType = "int24"
+			err := splitter.Init(config)
+			c.Expect(err.Error(), gs.Equals, "invalid prefix_type: int24")
+		})
+
+		c.Specify("splits w/ a big endian uint32 prefix", func() {
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			buf := []byte("\x00\x00\x00\x05test1\x00\x00\x00\x06test12\x00\x00\x00\x09partial")
+			records := readRecords(c, sRunner, bytes.NewReader(buf))
+			c.Expect(len(records), gs.Equals, 2)
+			c.Expect(records[0], gs.Equals, "test1")
+			c.Expect(records[1], gs.Equals, "test12")
+			c.Expect(string(sRunner.GetRemainingData()), gs.Equals,
+				"\x00\x00\x00\x09partial")
+		})
+
+		c.Specify("splits w/ a little endian uint16 prefix including itself", func() {
+			config.PrefixType = "uint16"
+			config.ByteOrder = "little"
+			config.IncludesPrefix = true
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			reader := strings.NewReader("\x07\x00test1\x08\x00test12")
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 2)
+			c.Expect(records[0], gs.Equals, "test1")
+			c.Expect(records[1], gs.Equals, "test12")
+		})
+
+		c.Specify("splits w/ a varint prefix", func() {
+			config.PrefixType = "varint"
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			long := strings.Repeat("x", 300)
+			reader := strings.NewReader("\x05test1\xac\x02" + long)
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 2)
+			c.Expect(records[0], gs.Equals, "test1")
+			c.Expect(records[1], gs.Equals, long)
+		})
+
+		c.Specify("discards records exceeding max_record_size", func() {
+			config.PrefixType = "uint8"
+			config.MaxRecordSize = 5
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			reader := strings.NewReader("\x06test12\x00\x05test1")
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 1)
+			c.Expect(records[0], gs.Equals, "test1")
+		})
+	})
+}
+
+func JsonSpec(c gs.Context) {
+	c.Specify("A JsonSplitter", func() {
+		splitter := &JsonSplitter{}
+		config := splitter.ConfigStruct().(*JsonSplitterConfig)
+		sRunner := makeSplitterRunner("JsonSplitter", splitter)
+
+		c.Specify("splits pretty printed values", func() {
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			reader := strings.NewReader("{\n  \"a\": {\"b\": [1, 2]},\n  \"c\": \"}\\\"{\"\n}\n" +
+				"[\"x\"] \"str\" 42\ntrue {\"partial\": ")
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 5)
+			c.Expect(records[0], gs.Equals,
+				"{\n  \"a\": {\"b\": [1, 2]},\n  \"c\": \"}\\\"{\"\n}")
+			c.Expect(records[1], gs.Equals, "[\"x\"]")
+			c.Expect(records[2], gs.Equals, "\"str\"")
+			c.Expect(records[3], gs.Equals, "42")
+			c.Expect(records[4], gs.Equals, "true")
+			c.Expect(string(sRunner.GetRemainingData()), gs.Equals, "{\"partial\": ")
+		})
+
+		c.Specify("unwraps top level arrays", func() {
+			config.UnwrapArray = true
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			reader := strings.NewReader("[{\"a\": 1},\n {\"b\": [2]}, 3]\n[[4]]{\"c\": 5}")
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 5)
+			c.Expect(records[0], gs.Equals, "{\"a\": 1}")
+			c.Expect(records[1], gs.Equals, "{\"b\": [2]}")
+			c.Expect(records[2], gs.Equals, "3")
+			c.Expect(records[3], gs.Equals, "[4]")
+			c.Expect(records[4], gs.Equals, "{\"c\": 5}")
+		})
+
+		c.Specify("delivers a trailing literal at EOF", func() {
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			records := readRecords(c, sRunner, strings.NewReader("{\"a\": 1}\n42"))
+			c.Expect(len(records), gs.Equals, 2)
+			c.Expect(records[1], gs.Equals, "42")
+			c.Expect(len(sRunner.GetRemainingData()), gs.Equals, 0)
+
+			records = readRecords(c, sRunner, strings.NewReader("1 \"str"))
+			c.Expect(len(records), gs.Equals, 1)
+			c.Expect(string(sRunner.GetRemainingData()), gs.Equals, "\"str")
+		})
+
+		c.Specify("doesn't split a literal on a read timeout", func() {
+			err := splitter.Init(config)
+			c.Assume(err, gs.IsNil)
+			reader := &timeoutReader{chunks: []string{"12", "34\n", "tr", "ue\n"}}
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 2)
+			c.Expect(records[0], gs.Equals, "1234")
+			c.Expect(records[1], gs.Equals, "true")
+		})
+	})
+}
+
+func SyslogSplitterSpec(c gs.Context) {
+	c.Specify("A SyslogSplitter", func() {
+		splitter := &SyslogSplitter{}
+		config := splitter.ConfigStruct().(*SyslogSplitterConfig)
+		sRunner := makeSplitterRunner("SyslogSplitter", splitter)
+		err := splitter.Init(config)
+		c.Assume(err, gs.IsNil)
+
+		c.Specify("splits octet counted records", func() {
+			reader := strings.NewReader("23 <13>Oct 11 22:14:15 a\nb30 <13>Oct 11 22:14:15")
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 1)
+			c.Expect(records[0], gs.Equals, "<13>Oct 11 22:14:15 a\nb")
+			c.Expect(string(sRunner.GetRemainingData()), gs.Equals,
+				"30 <13>Oct 11 22:14:15")
+		})
+
+		c.Specify("falls back to non-transparent framing", func() {
+			reader := strings.NewReader("<13>Oct 11 22:14:15 a\r\n\n" +
+				"8 <13>b c\n<13>Oct 11 22:14:15 d\n")
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 3)
+			c.Expect(records[0], gs.Equals, "<13>Oct 11 22:14:15 a")
+			c.Expect(records[1], gs.Equals, "<13>b c\n")
+			c.Expect(records[2], gs.Equals, "<13>Oct 11 22:14:15 d")
+		})
+
+		c.Specify("treats records w/o a valid count as non-transparent", func() {
+			records := readRecords(c, sRunner, strings.NewReader("1x <13>a\n"))
+			c.Expect(len(records), gs.Equals, 1)
+			c.Expect(records[0], gs.Equals, "1x <13>a")
+		})
+
+		c.Specify("delivers a final record w/o a trailer at EOF", func() {
+			reader := strings.NewReader("<13>Oct 11 22:14:15 a\n<13>Oct 11 22:14:15 b")
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 2)
+			c.Expect(records[1], gs.Equals, "<13>Oct 11 22:14:15 b")
+			c.Expect(len(sRunner.GetRemainingData()), gs.Equals, 0)
+		})
+
+		c.Specify("doesn't deliver a partial record on a read timeout", func() {
+			reader := &timeoutReader{
+				chunks: []string{"<13>Oct 11 22:14", ":15 a\n"},
+			}
+			records := readRecords(c, sRunner, reader)
+			c.Expect(len(records), gs.Equals, 1)
+			c.Expect(records[0], gs.Equals, "<13>Oct 11 22:14:15 a")
+		})
+	})
+}
+
 func encodeMessage(hbytes, mbytes []byte) (emsg []byte) {
 	emsg = make([]byte, 3+len(hbytes)+len(mbytes))
 	emsg[0] = message.RECORD_SEPARATOR
@@ -0,0 +1,275 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package avro
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+const (
+	// The Confluent wire format prefixes the Avro data w/ a zero byte and
+	// the 4 byte big endian schema id.
+	confluentMagicByte  = 0
+	confluentHeaderSize = 5
+)
+
+// Returns the path of the schema file for a schema id.
+func schemaIdPath(dir string, id uint32) string {
+	return filepath.Join(dir, strconv.FormatUint(uint64(id), 10)+".avsc")
+}
+
+// Loads a schema, which must be a record.
+func loadRecordSchema(path string) (*avroSchema, error) {
+	schema, err := loadAvroSchema(path)
+	if err != nil {
+		return nil, err
+	}
+	if schema.typ != "record" {
+		return nil, fmt.Errorf("schema '%s' isn't a record", path)
+	}
+	return schema, nil
+}
+
+// Parses the header_map, checking the schema has the mapped record fields.
+func parseHeaderMap(headerMap map[string]string, separator string,
+	schema *avroSchema) (HeaderMap, error) {
+
+	hm, err := NewHeaderMap(headerMap, separator)
+	if err != nil {
+		return nil, err
+	}
+	for header, path := range hm {
+		if schema != nil && schema.schemaAt(path) == nil {
+			return nil, fmt.Errorf("header_map field '%s' not found in schema",
+				headerMap[header])
+		}
+	}
+	return hm, nil
+}
+
+type AvroDecoderConfig struct {
+	// Schema file, relative paths are relative to the share_dir.
+	SchemaFile string `toml:"schema_file"`
+
+	// Whether the data is prefixed w/ the Confluent schema registry magic
+	// byte and schema id.
+	ConfluentWireFormat bool `toml:"confluent_wire_format"`
+
+	// Directory holding the schema files named after their schema id, e.g.
+	// `42.avsc`, used to resolve the schema ids of the Confluent wire format.
+	// Relative paths are relative to the share_dir.
+	SchemaDirectory string `toml:"schema_directory"`
+
+	// Maps message header names to the record fields providing the value,
+	// the fields of nested records are joined w/ the field separator.
+	HeaderMap map[string]string `toml:"header_map"`
+
+	// Separator used to join the names of nested record fields.
+	FieldSeparator string `toml:"field_separator"`
+}
+
+type AvroDecoder struct {
+	pConfig    *PipelineConfig
+	schema     *avroSchema
+	wireFormat bool
+	schemaDir  string
+	schemas    map[uint32]*avroSchema
+	schemaErrs map[uint32]error // schema ids that failed to load
+	headerMap  HeaderMap
+	headerKeys map[string]bool // field names of the mapped record fields
+	separator  string
+}
+
+func (ad *AvroDecoder) ConfigStruct() interface{} {
+	return &AvroDecoderConfig{
+		FieldSeparator: ".",
+	}
+}
+
+func (ad *AvroDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
+	ad.pConfig = pConfig
+}
+
+func (ad *AvroDecoder) Init(config interface{}) (err error) {
+	conf := config.(*AvroDecoderConfig)
+	if conf.FieldSeparator == "" {
+		return errors.New("AvroDecoder field_separator must not be empty")
+	}
+	ad.separator = conf.FieldSeparator
+	ad.wireFormat = conf.ConfluentWireFormat
+	ad.schemas = make(map[uint32]*avroSchema)
+	ad.schemaErrs = make(map[uint32]error)
+	if conf.SchemaDirectory != "" {
+		if !ad.wireFormat {
+			return errors.New(
+				"AvroDecoder schema_directory requires confluent_wire_format")
+		}
+		ad.schemaDir = ad.pConfig.Globals.PrependShareDir(conf.SchemaDirectory)
+	}
+	if conf.SchemaFile != "" {
+		path := ad.pConfig.Globals.PrependShareDir(conf.SchemaFile)
+		if ad.schema, err = loadRecordSchema(path); err != nil {
+			return fmt.Errorf("AvroDecoder %s", err)
+		}
+	} else if ad.schemaDir == "" {
+		return errors.New("AvroDecoder requires a schema_file or a schema_directory")
+	}
+	if ad.headerMap, err = parseHeaderMap(conf.HeaderMap, ad.separator,
+		ad.schema); err != nil {
+		return fmt.Errorf("AvroDecoder %s", err)
+	}
+	ad.headerKeys = make(map[string]bool, len(conf.HeaderMap))
+	for _, key := range conf.HeaderMap {
+		ad.headerKeys[key] = true
+	}
+	return
+}
+
+// Returns the schema for a Confluent schema id, loading it as needed. The
+// schema file is used if there is no schema directory. Ids that fail to load
+// aren't retried, so a broken producer doesn't cause a file read per message.
+func (ad *AvroDecoder) schemaForId(id uint32) (schema *avroSchema, err error) {
+	if ad.schemaDir == "" {
+		return ad.schema, nil
+	}
+	if schema = ad.schemas[id]; schema != nil {
+		return schema, nil
+	}
+	if err = ad.schemaErrs[id]; err != nil {
+		return nil, err
+	}
+	if schema, err = loadRecordSchema(schemaIdPath(ad.schemaDir, id)); err != nil {
+		ad.schemaErrs[id] = err
+		return nil, err
+	}
+	ad.schemas[id] = schema
+	return schema, nil
+}
+
+func (ad *AvroDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	data := []byte(pack.Message.GetPayload())
+	schema := ad.schema
+	if ad.wireFormat {
+		if len(data) < confluentHeaderSize || data[0] != confluentMagicByte {
+			return nil, errors.New("missing Confluent wire format header")
+		}
+		if schema, err = ad.schemaForId(binary.BigEndian.Uint32(data[1:])); err != nil {
+			return nil, err
+		}
+		data = data[confluentHeaderSize:]
+	}
+	value, err := schema.decodeAll(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro data: %s", err)
+	}
+	record := value.(map[string]interface{})
+
+	// The Avro data is replaced by the mapped Payload value, if any.
+	msg := pack.Message
+	msg.SetPayload("")
+	for header, value := range ad.headerMap.Extract(record) {
+		if header == "Timestamp" {
+			value = scaleTimestamp(value, schema.schemaAt(ad.headerMap[header]))
+		}
+		if err = SetMessageHeaderValue(msg, header, value); err != nil {
+			return nil, err
+		}
+	}
+	if err = ad.addFields(msg, "", record); err != nil {
+		return nil, err
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Scales timestamp-millis and timestamp-micros values to nanoseconds.
+func scaleTimestamp(value interface{}, schema *avroSchema) interface{} {
+	ts, ok := value.(int64)
+	if !ok || schema == nil {
+		return value
+	}
+	switch schema.logicalType {
+	case "timestamp-millis":
+		return ts * 1e6
+	case "timestamp-micros":
+		return ts * 1e3
+	}
+	return ts
+}
+
+// Adds the record values as fields, flattening nested records and maps. The
+// record fields mapped to headers are skipped.
+func (ad *AvroDecoder) addFields(msg *message.Message, name string,
+	value interface{}) error {
+
+	if ad.headerKeys[name] {
+		return nil
+	}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		// Sort the keys so the fields are always added in the same order.
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldName := key
+			if name != "" {
+				fieldName = name + ad.separator + key
+			}
+			if err := ad.addFields(msg, fieldName, v[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		if f := message.NewMultiValueField(name, v); f != nil {
+			msg.AddField(f)
+			return nil
+		}
+		for i, item := range v {
+			if err := ad.addFields(msg, name+ad.separator+strconv.Itoa(i),
+				item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	f, err := message.NewField(name, value, "")
+	if err != nil {
+		return fmt.Errorf("field creation error: %s", err)
+	}
+	msg.AddField(f)
+	return nil
+}
+
+func init() {
+	RegisterPlugin("AvroDecoder", func() interface{} {
+		return new(AvroDecoder)
+	})
+}
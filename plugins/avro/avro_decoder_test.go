@@ -0,0 +1,194 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package avro
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+const testLogSchema = `{
+  "type": "record", "name": "Log",
+  "fields": [
+    {"name": "time", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+    {"name": "uuid", "type": "string"},
+    {"name": "meta", "type": {"type": "record", "name": "Meta", "fields": [
+      {"name": "host", "type": "string"},
+      {"name": "pid", "type": ["null", "int"], "default": null},
+      {"name": "version", "type": "string", "default": ""}
+    ]}},
+    {"name": "message", "type": "string"},
+    {"name": "severity", "type": "int", "default": 6},
+    {"name": "tags", "type": {"type": "array", "items": "string"}, "default": []},
+    {"name": "labels", "type": {"type": "map", "values": "string"}, "default": {}},
+    {"name": "took", "type": ["null", "double"], "default": null}
+  ]
+}`
+
+// Writes the schema to a file in the directory, returning the path.
+func writeSchema(dir, name, schema string) string {
+	path := filepath.Join(dir, name)
+	ioutil.WriteFile(path, []byte(schema), 0644)
+	return path
+}
+
+func AvroDecoderSpec(c gs.Context) {
+	c.Specify("An AvroDecoder", func() {
+		dir, err := ioutil.TempDir("", "avro")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(dir)
+		schemaFile := writeSchema(dir, "log.avsc", testLogSchema)
+		schema, err := parseAvroSchema([]byte(testLogSchema))
+		c.Assume(err, gs.IsNil)
+
+		decoder := new(AvroDecoder)
+		decoder.SetPipelineConfig(NewPipelineConfig(nil))
+		conf := decoder.ConfigStruct().(*AvroDecoderConfig)
+		conf.SchemaFile = schemaFile
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		record := map[string]interface{}{
+			"time": int64(1442417870123456),
+			"uuid": "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+			"meta": map[string]interface{}{
+				"host":    "example.org",
+				"pid":     int64(42),
+				"version": "0.8",
+			},
+			"message": "hello",
+			"tags":    []interface{}{"a", "b"},
+			"labels":  map[string]interface{}{"env": "prod"},
+			"took":    0.25,
+		}
+		encode := func(prefix []byte) []byte {
+			buf := bytes.NewBuffer(prefix)
+			err := schema.encode(buf, record)
+			c.Assume(err, gs.IsNil)
+			return buf.Bytes()
+		}
+		decode := func(data []byte) (*message.Message, error) {
+			pack.Message = new(message.Message)
+			pack.Message.SetPayload(string(data))
+			packs, err := decoder.Decode(pack)
+			if packs == nil {
+				return nil, err
+			}
+			return packs[0].Message, err
+		}
+
+		c.Specify("maps record fields to headers and fields", func() {
+			conf.HeaderMap = map[string]string{
+				"Timestamp":  "time",
+				"Uuid":       "uuid",
+				"Hostname":   "meta.host",
+				"Pid":        "meta.pid",
+				"EnvVersion": "meta.version",
+				"Payload":    "message",
+				"Severity":   "severity",
+			}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode(encode(nil))
+			c.Assume(err, gs.IsNil)
+
+			c.Expect(msg.GetTimestamp(), gs.Equals, int64(1442417870123456000))
+			c.Expect(msg.GetUuidString(), gs.Equals, "f47ac10b-58cc-4372-a567-0e02b2c3d479")
+			c.Expect(msg.GetHostname(), gs.Equals, "example.org")
+			c.Expect(msg.GetPid(), gs.Equals, int32(42))
+			c.Expect(msg.GetEnvVersion(), gs.Equals, "0.8")
+			c.Expect(msg.GetPayload(), gs.Equals, "hello")
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(6))
+			for _, name := range conf.HeaderMap {
+				c.Expect(msg.FindFirstField(name), gs.IsNil)
+			}
+			tags := msg.FindFirstField("tags")
+			c.Assume(tags, gs.Not(gs.IsNil))
+			c.Expect(len(tags.GetValueString()), gs.Equals, 2)
+			value, _ := msg.GetFieldValue("labels.env")
+			c.Expect(value, gs.Equals, "prod")
+			value, _ = msg.GetFieldValue("took")
+			c.Expect(value, gs.Equals, 0.25)
+			c.Expect(len(msg.Fields), gs.Equals, 3)
+		})
+
+		c.Specify("decodes all the record fields by default", func() {
+			conf.FieldSeparator = "_"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode(encode(nil))
+			c.Assume(err, gs.IsNil)
+			value, _ := msg.GetFieldValue("meta_host")
+			c.Expect(value, gs.Equals, "example.org")
+			value, _ = msg.GetFieldValue("time")
+			c.Expect(value, gs.Equals, int64(1442417870123456))
+			c.Expect(len(msg.Fields), gs.Equals, 10)
+			c.Expect(msg.GetPayload(), gs.Equals, "")
+		})
+
+		c.Specify("resolves Confluent schema ids", func() {
+			conf.SchemaFile = ""
+			conf.ConfluentWireFormat = true
+			conf.SchemaDirectory = dir
+			writeSchema(dir, "7.avsc", testLogSchema)
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			msg, err := decode(encode([]byte{0, 0, 0, 0, 7}))
+			c.Assume(err, gs.IsNil)
+			value, _ := msg.GetFieldValue("message")
+			c.Expect(value, gs.Equals, "hello")
+			c.Expect(decoder.schemas[7], gs.Not(gs.IsNil))
+
+			_, err = decode(encode([]byte{0, 0, 0, 0, 8}))
+			c.Expect(err, gs.Not(gs.IsNil))
+			// The failed lookup is cached.
+			writeSchema(dir, "8.avsc", testLogSchema)
+			_, err2 := decode(encode([]byte{0, 0, 0, 0, 8}))
+			c.Expect(err2, gs.Equals, err)
+			_, err = decode(encode([]byte{1, 0, 0, 0, 7}))
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("fails on invalid data", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			data := encode(nil)
+			_, err = decode(data[:len(data)-2])
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("fails on invalid settings", func() {
+			conf.HeaderMap = map[string]string{"Timestamp": "nope"}
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.HeaderMap = map[string]string{"Nope": "time"}
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.HeaderMap = nil
+			conf.SchemaFile = ""
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.SchemaDirectory = dir
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.SchemaFile = writeSchema(dir, "int.avsc", `"int"`)
+			conf.SchemaDirectory = ""
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
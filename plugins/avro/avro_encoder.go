@@ -0,0 +1,205 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package avro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type AvroEncoderConfig struct {
+	// Schema file, relative paths are relative to the share_dir. If empty
+	// the schema is loaded from the schema directory using the schema id.
+	SchemaFile string `toml:"schema_file"`
+
+	// Whether to prefix the data w/ the Confluent schema registry magic byte
+	// and schema id.
+	ConfluentWireFormat bool `toml:"confluent_wire_format"`
+
+	// Directory holding the schema files named after their schema id.
+	SchemaDirectory string `toml:"schema_directory"`
+
+	// Schema id written w/ the Confluent wire format.
+	SchemaId uint32 `toml:"schema_id"`
+
+	// Maps message header names to the record fields receiving the value,
+	// the fields of nested records are joined w/ the field separator.
+	HeaderMap map[string]string `toml:"header_map"`
+
+	// Separator used to join the names of nested record fields.
+	FieldSeparator string `toml:"field_separator"`
+}
+
+type AvroEncoder struct {
+	pConfig     *PipelineConfig
+	schema      *avroSchema
+	wireFormat  bool
+	schemaId    uint32
+	pathHeaders map[string]string // record field path -> header name
+	separator   string
+}
+
+func (ae *AvroEncoder) ConfigStruct() interface{} {
+	return &AvroEncoderConfig{
+		FieldSeparator: ".",
+	}
+}
+
+func (ae *AvroEncoder) SetPipelineConfig(pConfig *PipelineConfig) {
+	ae.pConfig = pConfig
+}
+
+func (ae *AvroEncoder) Init(config interface{}) (err error) {
+	conf := config.(*AvroEncoderConfig)
+	if conf.FieldSeparator == "" {
+		return errors.New("AvroEncoder field_separator must not be empty")
+	}
+	ae.separator = conf.FieldSeparator
+	ae.wireFormat = conf.ConfluentWireFormat
+	ae.schemaId = conf.SchemaId
+	if ae.wireFormat && ae.schemaId == 0 {
+		return errors.New("AvroEncoder confluent_wire_format requires a schema_id")
+	}
+
+	var path string
+	switch {
+	case conf.SchemaFile != "":
+		path = ae.pConfig.Globals.PrependShareDir(conf.SchemaFile)
+	case conf.SchemaDirectory != "" && ae.schemaId != 0:
+		path = schemaIdPath(ae.pConfig.Globals.PrependShareDir(conf.SchemaDirectory),
+			ae.schemaId)
+	default:
+		return errors.New(
+			"AvroEncoder requires a schema_file, or a schema_directory and schema_id")
+	}
+	if ae.schema, err = loadRecordSchema(path); err != nil {
+		return fmt.Errorf("AvroEncoder %s", err)
+	}
+
+	headerMap, err := parseHeaderMap(conf.HeaderMap, ae.separator, ae.schema)
+	if err != nil {
+		return fmt.Errorf("AvroEncoder %s", err)
+	}
+	ae.pathHeaders = make(map[string]string, len(headerMap))
+	for header, path := range headerMap {
+		ae.pathHeaders[strings.Join(path, ae.separator)] = header
+	}
+	return
+}
+
+func (ae *AvroEncoder) Encode(pack *PipelinePack) (output []byte, err error) {
+	// A lazily decoding ProtobufDecoder may have left the Fields encoded.
+	if err = pack.DecodeFields(); err != nil {
+		return nil, fmt.Errorf("can't decode message fields: %s", err)
+	}
+	var buf bytes.Buffer
+	if ae.wireFormat {
+		buf.WriteByte(confluentMagicByte)
+		binary.Write(&buf, binary.BigEndian, ae.schemaId)
+	}
+	record := ae.buildRecord(pack.Message, ae.schema, "")
+	if err = ae.schema.encode(&buf, record); err != nil {
+		return nil, fmt.Errorf("Avro encoding error: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Builds the record from the message headers and fields. Record fields w/o a
+// value are left out, so their default value is used. Object fields provide
+// the values of nested records and maps.
+func (ae *AvroEncoder) buildRecord(msg *message.Message, schema *avroSchema,
+	prefix string) map[string]interface{} {
+
+	record := make(map[string]interface{})
+	for _, field := range schema.fields {
+		name := field.name
+		if prefix != "" {
+			name = prefix + ae.separator + field.name
+		}
+		fieldSchema := field.schema.nonNull()
+		if header, ok := ae.pathHeaders[name]; ok {
+			record[field.name] = headerValue(msg, header, fieldSchema)
+			continue
+		}
+		f := msg.FindFirstField(name)
+		if fieldSchema.typ == "record" {
+			nested := ae.buildRecord(msg, fieldSchema, name)
+			if f.GetValueType() == message.Field_OBJECT && len(f.ValueObject) > 0 {
+				for key, value := range f.ValueObject[0].Map() {
+					if _, ok := nested[key]; !ok {
+						nested[key] = value
+					}
+				}
+			}
+			// Nullable or defaulted records are only set if they have values.
+			if len(nested) > 0 || (fieldSchema == field.schema && !field.hasDefault) {
+				record[field.name] = nested
+			}
+			continue
+		}
+		if f == nil {
+			continue
+		}
+		values := f.GetNativeValues()
+		if fieldSchema.typ == "array" {
+			if values == nil {
+				values = []interface{}{}
+			}
+			record[field.name] = values
+		} else if len(values) > 0 {
+			record[field.name] = values[0]
+		}
+	}
+	return record
+}
+
+// Returns the header value in the representation expected by the schema.
+func headerValue(msg *message.Message, header string, schema *avroSchema) interface{} {
+	value := GetMessageHeaderValue(msg, header)
+	switch header {
+	case "Timestamp":
+		ts := value.(int64)
+		switch schema.typ {
+		case "float", "double":
+			return float64(ts) / 1e9
+		case "string":
+			return time.Unix(0, ts).UTC().Format(time.RFC3339Nano)
+		}
+		switch schema.logicalType {
+		case "timestamp-millis":
+			return ts / 1e6
+		case "timestamp-micros":
+			return ts / 1e3
+		}
+	case "Uuid":
+		if schema.typ == "bytes" || schema.typ == "fixed" {
+			return msg.GetUuid()
+		}
+	}
+	return value
+}
+
+func init() {
+	RegisterPlugin("AvroEncoder", func() interface{} {
+		return new(AvroEncoder)
+	})
+}
@@ -0,0 +1,175 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package avro
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func AvroEncoderSpec(c gs.Context) {
+	c.Specify("An AvroEncoder", func() {
+		dir, err := ioutil.TempDir("", "avro")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(dir)
+		schemaFile := writeSchema(dir, "log.avsc", testLogSchema)
+		schema, err := parseAvroSchema([]byte(testLogSchema))
+		c.Assume(err, gs.IsNil)
+
+		encoder := new(AvroEncoder)
+		encoder.SetPipelineConfig(NewPipelineConfig(nil))
+		conf := encoder.ConfigStruct().(*AvroEncoderConfig)
+		conf.SchemaFile = schemaFile
+		conf.HeaderMap = map[string]string{
+			"Timestamp":  "time",
+			"Uuid":       "uuid",
+			"Hostname":   "meta.host",
+			"EnvVersion": "meta.version",
+			"Payload":    "message",
+			"Severity":   "severity",
+		}
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+		msg := pack.Message
+		msg.SetTimestamp(1442417870123456789)
+		msg.SetUuid([]byte{0xf4, 0x7a, 0xc1, 0x0b, 0x58, 0xcc, 0x43, 0x72, 0xa5, 0x67,
+			0x0e, 0x02, 0xb2, 0xc3, 0xd4, 0x79})
+		msg.SetHostname("example.org")
+		msg.SetEnvVersion("0.8")
+		msg.SetPayload("hello")
+		msg.SetSeverity(3)
+		f, _ := message.NewField("tags", "a", "")
+		f.AddValue("b")
+		msg.AddField(f)
+		f, _ = message.NewField("meta.pid", int64(42), "")
+		msg.AddField(f)
+		f, _ = message.NewField("unused", "x", "")
+		msg.AddField(f)
+
+		decode := func(data []byte) map[string]interface{} {
+			value, err := schema.decodeAll(data)
+			c.Assume(err, gs.IsNil)
+			return value.(map[string]interface{})
+		}
+
+		c.Specify("maps headers and fields to record fields", func() {
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			record := decode(output)
+
+			c.Expect(record["time"], gs.Equals, int64(1442417870123456))
+			c.Expect(record["uuid"], gs.Equals, "f47ac10b-58cc-4372-a567-0e02b2c3d479")
+			c.Expect(record["message"], gs.Equals, "hello")
+			c.Expect(record["severity"], gs.Equals, int64(3))
+			meta := record["meta"].(map[string]interface{})
+			c.Expect(meta["host"], gs.Equals, "example.org")
+			c.Expect(meta["pid"], gs.Equals, int64(42))
+			c.Expect(meta["version"], gs.Equals, "0.8")
+			c.Expect(len(record["tags"].([]interface{})), gs.Equals, 2)
+			c.Expect(len(record["labels"].(map[string]interface{})), gs.Equals, 0)
+			c.Expect(record["took"], gs.IsNil)
+		})
+
+		c.Specify("takes nested records and maps from object fields", func() {
+			msg.DeleteField(msg.FindFirstField("meta.pid"))
+			f, err := message.NewField("meta", map[string]interface{}{
+				"pid":  int64(7),
+				"host": "ignored",
+			}, "")
+			c.Assume(err, gs.IsNil)
+			msg.AddField(f)
+			f, err = message.NewField("labels", map[string]interface{}{"env": "prod"}, "")
+			c.Assume(err, gs.IsNil)
+			msg.AddField(f)
+			err = encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			record := decode(output)
+
+			meta := record["meta"].(map[string]interface{})
+			c.Expect(meta["pid"], gs.Equals, int64(7))
+			c.Expect(meta["host"], gs.Equals, "example.org")
+			labels := record["labels"].(map[string]interface{})
+			c.Expect(labels["env"], gs.Equals, "prod")
+		})
+
+		c.Specify("round trips through the AvroDecoder", func() {
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+
+			decoder := new(AvroDecoder)
+			decoder.SetPipelineConfig(NewPipelineConfig(nil))
+			decConf := decoder.ConfigStruct().(*AvroDecoderConfig)
+			decConf.SchemaFile = schemaFile
+			decConf.HeaderMap = conf.HeaderMap
+			err = decoder.Init(decConf)
+			c.Assume(err, gs.IsNil)
+			pack2 := NewPipelinePack(supply)
+			pack2.Message.SetPayload(string(output))
+			_, err = decoder.Decode(pack2)
+			c.Assume(err, gs.IsNil)
+			c.Expect(pack2.Message.GetTimestamp(), gs.Equals, int64(1442417870123456000))
+			c.Expect(bytes.Equal(pack2.Message.GetUuid(), msg.GetUuid()), gs.IsTrue)
+			c.Expect(pack2.Message.GetHostname(), gs.Equals, "example.org")
+			c.Expect(pack2.Message.GetEnvVersion(), gs.Equals, "0.8")
+			c.Expect(pack2.Message.FindFirstField("meta.version"), gs.IsNil)
+			value, _ := pack2.Message.GetFieldValue("meta.pid")
+			c.Expect(value, gs.Equals, int64(42))
+		})
+
+		c.Specify("writes the Confluent wire format", func() {
+			conf.SchemaFile = ""
+			conf.SchemaDirectory = dir
+			conf.SchemaId = 7
+			conf.ConfluentWireFormat = true
+			writeSchema(dir, "7.avsc", testLogSchema)
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(bytes.Equal(output[:5], []byte{0, 0, 0, 0, 7}), gs.IsTrue)
+			c.Expect(decode(output[5:])["message"], gs.Equals, "hello")
+		})
+
+		c.Specify("fails on missing values", func() {
+			delete(conf.HeaderMap, "Payload")
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			_, err = encoder.Encode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("fails on invalid settings", func() {
+			conf.HeaderMap = map[string]string{"Timestamp": "meta.nope"}
+			c.Expect(encoder.Init(conf), gs.Not(gs.IsNil))
+			conf.HeaderMap = nil
+			conf.ConfluentWireFormat = true
+			c.Expect(encoder.Init(conf), gs.Not(gs.IsNil))
+			conf.ConfluentWireFormat = false
+			conf.SchemaFile = ""
+			c.Expect(encoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,352 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package avro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Values are decoded to, and encoded from, the following Go types: nil for
+// null, bool, int64 for int and long, float64 for float and double, []byte
+// for bytes and fixed, string for string and enum, []interface{} for arrays
+// and map[string]interface{} for maps and records. Union values are those of
+// the branch.
+
+var errAvroShortBuffer = errors.New("unexpected end of Avro data")
+
+type avroReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *avroReader) readLong() (int64, error) {
+	n, size := binary.Varint(r.buf[r.pos:])
+	if size <= 0 {
+		return 0, errAvroShortBuffer
+	}
+	r.pos += size
+	return n, nil
+}
+
+func (r *avroReader) readBytes(n int64) ([]byte, error) {
+	if n < 0 || n > int64(len(r.buf)-r.pos) {
+		return nil, errAvroShortBuffer
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// Reads the item count of an array or map block, skipping the block size
+// which follows negative counts.
+func (r *avroReader) readBlockCount() (int64, error) {
+	count, err := r.readLong()
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		if _, err = r.readLong(); err != nil {
+			return 0, err
+		}
+		count = -count
+	}
+	return count, nil
+}
+
+func (s *avroSchema) decode(r *avroReader) (value interface{}, err error) {
+	switch s.typ {
+	case "null":
+		return nil, nil
+	case "boolean":
+		var b []byte
+		if b, err = r.readBytes(1); err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case "int", "long":
+		return r.readLong()
+	case "float":
+		var b []byte
+		if b, err = r.readBytes(4); err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case "double":
+		var b []byte
+		if b, err = r.readBytes(8); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case "bytes", "string":
+		var n int64
+		if n, err = r.readLong(); err != nil {
+			return nil, err
+		}
+		var b []byte
+		if b, err = r.readBytes(n); err != nil {
+			return nil, err
+		}
+		if s.typ == "string" {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case "fixed":
+		var b []byte
+		if b, err = r.readBytes(int64(s.size)); err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case "enum":
+		var i int64
+		if i, err = r.readLong(); err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s.symbols)) {
+			return nil, fmt.Errorf("enum '%s' index out of range: %d", s.name, i)
+		}
+		return s.symbols[i], nil
+	case "union":
+		var i int64
+		if i, err = r.readLong(); err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s.branches)) {
+			return nil, fmt.Errorf("union index out of range: %d", i)
+		}
+		return s.branches[i].decode(r)
+	case "array":
+		values := make([]interface{}, 0)
+		for {
+			var count int64
+			if count, err = r.readBlockCount(); err != nil || count == 0 {
+				return values, err
+			}
+			for ; count > 0; count-- {
+				if value, err = s.items.decode(r); err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+		}
+	case "map":
+		values := make(map[string]interface{})
+		for {
+			var count int64
+			if count, err = r.readBlockCount(); err != nil || count == 0 {
+				return values, err
+			}
+			for ; count > 0; count-- {
+				var n int64
+				if n, err = r.readLong(); err != nil {
+					return nil, err
+				}
+				var key []byte
+				if key, err = r.readBytes(n); err != nil {
+					return nil, err
+				}
+				if values[string(key)], err = s.items.decode(r); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case "record":
+		values := make(map[string]interface{}, len(s.fields))
+		for _, field := range s.fields {
+			if values[field.name], err = field.schema.decode(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unsupported type: %s", s.typ)
+}
+
+// Decodes a single value, failing if any data is left over.
+func (s *avroSchema) decodeAll(data []byte) (interface{}, error) {
+	r := &avroReader{buf: data}
+	value, err := s.decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("%d bytes of trailing Avro data", len(data)-r.pos)
+	}
+	return value, nil
+}
+
+// Returns the value as an int64, if it is an integer.
+func avroInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// Returns the value as a float64, if it is numeric.
+func avroFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	}
+	if i, ok := avroInt(value); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+// Returns true if the value can be encoded w/ the schema, used to pick the
+// branch of a union.
+func (s *avroSchema) accepts(value interface{}) bool {
+	var ok bool
+	switch s.typ {
+	case "null":
+		ok = value == nil
+	case "boolean":
+		_, ok = value.(bool)
+	case "int", "long":
+		_, ok = avroInt(value)
+	case "float", "double":
+		_, ok = avroFloat(value)
+	case "string":
+		_, ok = value.(string)
+	case "bytes":
+		_, ok = value.([]byte)
+	case "fixed":
+		var b []byte
+		b, ok = value.([]byte)
+		ok = ok && len(b) == s.size
+	case "enum":
+		var str string
+		if str, ok = value.(string); ok {
+			ok = s.symbolIndex(str) != -1
+		}
+	case "array":
+		_, ok = value.([]interface{})
+	case "map", "record":
+		_, ok = value.(map[string]interface{})
+	}
+	return ok
+}
+
+func (s *avroSchema) symbolIndex(symbol string) int {
+	for i, sym := range s.symbols {
+		if sym == symbol {
+			return i
+		}
+	}
+	return -1
+}
+
+func writeLong(buf *bytes.Buffer, n int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func (s *avroSchema) encode(buf *bytes.Buffer, value interface{}) error {
+	if s.typ != "union" && !s.accepts(value) {
+		return fmt.Errorf("can't encode %T as %s", value, s.typ)
+	}
+	switch s.typ {
+	case "boolean":
+		if value.(bool) {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case "int", "long":
+		n, _ := avroInt(value)
+		if s.typ == "int" && (n < math.MinInt32 || n > math.MaxInt32) {
+			return fmt.Errorf("int out of range: %d", n)
+		}
+		writeLong(buf, n)
+	case "float":
+		f, _ := avroFloat(value)
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(float32(f)))
+		buf.Write(b[:])
+	case "double":
+		f, _ := avroFloat(value)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+		buf.Write(b[:])
+	case "string":
+		str := value.(string)
+		writeLong(buf, int64(len(str)))
+		buf.WriteString(str)
+	case "bytes":
+		b := value.([]byte)
+		writeLong(buf, int64(len(b)))
+		buf.Write(b)
+	case "fixed":
+		buf.Write(value.([]byte))
+	case "enum":
+		writeLong(buf, int64(s.symbolIndex(value.(string))))
+	case "union":
+		for i, branch := range s.branches {
+			if branch.accepts(value) {
+				writeLong(buf, int64(i))
+				return branch.encode(buf, value)
+			}
+		}
+		return fmt.Errorf("no union branch accepts %T", value)
+	case "array":
+		values := value.([]interface{})
+		if len(values) > 0 {
+			writeLong(buf, int64(len(values)))
+			for _, v := range values {
+				if err := s.items.encode(buf, v); err != nil {
+					return err
+				}
+			}
+		}
+		writeLong(buf, 0)
+	case "map":
+		values := value.(map[string]interface{})
+		if len(values) > 0 {
+			writeLong(buf, int64(len(values)))
+			for key, v := range values {
+				writeLong(buf, int64(len(key)))
+				buf.WriteString(key)
+				if err := s.items.encode(buf, v); err != nil {
+					return fmt.Errorf("map key '%s': %s", key, err)
+				}
+			}
+		}
+		writeLong(buf, 0)
+	case "record":
+		values := value.(map[string]interface{})
+		for _, field := range s.fields {
+			v, ok := values[field.name]
+			if !ok && field.hasDefault {
+				v = field.def
+			}
+			if err := field.schema.encode(buf, v); err != nil {
+				return fmt.Errorf("field '%s': %s", field.name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package avro
+
+import (
+	"bytes"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+const testSchema = `{
+  "type": "record", "name": "Event", "namespace": "com.example",
+  "fields": [
+    {"name": "ts", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "host", "type": ["null", "string"], "default": null},
+    {"name": "level", "type": "int", "default": 6},
+    {"name": "ok", "type": "boolean"},
+    {"name": "ratio", "type": "float"},
+    {"name": "took", "type": "double"},
+    {"name": "id", "type": {"type": "fixed", "name": "Id", "size": 4}},
+    {"name": "raw", "type": "bytes", "default": "ÿ"},
+    {"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["A", "B"]}},
+    {"name": "tags", "type": {"type": "array", "items": "string"}, "default": []},
+    {"name": "attrs", "type": {"type": "map", "values": "long"}, "default": {}},
+    {"name": "source", "type": ["null", {"type": "record", "name": "Source",
+      "fields": [{"name": "ip", "type": "string"}, {"name": "kind", "type": "Kind"}]}],
+      "default": null},
+    {"name": "next", "type": ["null", "Event"], "default": null}
+  ]
+}`
+
+func AvroCodecSpec(c gs.Context) {
+	c.Specify("An Avro schema", func() {
+		schema, err := parseAvroSchema([]byte(testSchema))
+		c.Assume(err, gs.IsNil)
+
+		record := map[string]interface{}{
+			"ts":    int64(1442417870123),
+			"host":  "example.org",
+			"ok":    true,
+			"ratio": 0.5,
+			"took":  0.25,
+			"id":    []byte{1, 2, 3, 4},
+			"kind":  "B",
+			"tags":  []interface{}{"a", "b"},
+			"attrs": map[string]interface{}{"x": int64(-1)},
+			"source": map[string]interface{}{
+				"ip":   "10.0.0.1",
+				"kind": "A",
+			},
+			"next": map[string]interface{}{
+				"ts":    int64(1),
+				"ok":    false,
+				"ratio": int64(2),
+				"took":  1.5,
+				"id":    []byte{0, 0, 0, 0},
+				"kind":  "A",
+			},
+		}
+
+		c.Specify("resolves named types", func() {
+			c.Expect(schema.name, gs.Equals, "com.example.Event")
+			c.Expect(schema.field("next").schema.branches[1], gs.Equals, schema)
+			source := schema.schemaAt([]string{"source"})
+			c.Expect(source.name, gs.Equals, "com.example.Source")
+			c.Expect(source.field("kind").schema, gs.Equals, schema.field("kind").schema)
+			c.Expect(schema.schemaAt([]string{"source", "ip"}).typ, gs.Equals, "string")
+			c.Expect(schema.schemaAt([]string{"ts"}).logicalType, gs.Equals,
+				"timestamp-millis")
+			c.Expect(schema.schemaAt([]string{"source", "nope"}), gs.IsNil)
+		})
+
+		c.Specify("round trips values", func() {
+			var buf bytes.Buffer
+			err := schema.encode(&buf, record)
+			c.Assume(err, gs.IsNil)
+			value, err := schema.decodeAll(buf.Bytes())
+			c.Assume(err, gs.IsNil)
+			decoded := value.(map[string]interface{})
+
+			c.Expect(decoded["ts"], gs.Equals, int64(1442417870123))
+			c.Expect(decoded["host"], gs.Equals, "example.org")
+			c.Expect(decoded["level"], gs.Equals, int64(6))
+			c.Expect(decoded["ok"], gs.Equals, true)
+			c.Expect(decoded["ratio"], gs.Equals, 0.5)
+			c.Expect(decoded["took"], gs.Equals, 0.25)
+			c.Expect(bytes.Equal(decoded["id"].([]byte), []byte{1, 2, 3, 4}), gs.IsTrue)
+			c.Expect(bytes.Equal(decoded["raw"].([]byte), []byte{0xff}), gs.IsTrue)
+			c.Expect(decoded["kind"], gs.Equals, "B")
+			c.Expect(len(decoded["tags"].([]interface{})), gs.Equals, 2)
+			c.Expect(decoded["attrs"].(map[string]interface{})["x"], gs.Equals, int64(-1))
+			source := decoded["source"].(map[string]interface{})
+			c.Expect(source["ip"], gs.Equals, "10.0.0.1")
+			next := decoded["next"].(map[string]interface{})
+			c.Expect(next["ratio"], gs.Equals, 2.0)
+			c.Expect(next["host"], gs.IsNil)
+			c.Expect(next["next"], gs.IsNil)
+		})
+
+		c.Specify("decodes blocks w/ byte sizes", func() {
+			arraySchema, err := parseAvroSchema([]byte(`{"type": "array", "items": "int"}`))
+			c.Assume(err, gs.IsNil)
+			// -2 items, 2 bytes: 1, 2, then 1 item: 3, then the end.
+			value, err := arraySchema.decodeAll([]byte{3, 4, 2, 4, 2, 6, 0})
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(value.([]interface{})), gs.Equals, 3)
+			c.Expect(value.([]interface{})[2], gs.Equals, int64(3))
+		})
+
+		c.Specify("fails on invalid values", func() {
+			var buf bytes.Buffer
+			delete(record, "ok")
+			c.Expect(schema.encode(&buf, record), gs.Not(gs.IsNil))
+			record["ok"] = true
+			record["kind"] = "C"
+			c.Expect(schema.encode(&buf, record), gs.Not(gs.IsNil))
+			record["kind"] = "A"
+			record["level"] = int64(1 << 40)
+			c.Expect(schema.encode(&buf, record), gs.Not(gs.IsNil))
+			record["level"] = "1"
+			c.Expect(schema.encode(&buf, record), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("fails on invalid data", func() {
+			var buf bytes.Buffer
+			err := schema.encode(&buf, record)
+			c.Assume(err, gs.IsNil)
+			data := buf.Bytes()
+			_, err = schema.decodeAll(data[:len(data)-1])
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = schema.decodeAll(append(data, 0))
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("fails on invalid schemas", func() {
+			for _, def := range []string{
+				`"nope"`,
+				`{"type": "record", "name": "R"}`,
+				`{"type": "record", "fields": []}`,
+				`{"type": "record", "name": "R", "fields": [{"name": "a", "type": "R2"}]}`,
+				`{"type": "record", "name": "R", "fields": [{"name": "a", "type": "int", "default": "x"}]}`,
+				`{"type": "enum", "name": "E", "symbols": []}`,
+				`{"type": "fixed", "name": "F"}`,
+				`{"type": "array"}`,
+				`["int", ["string"]]`,
+				`[{"type": "enum", "name": "E", "symbols": ["A"]}, {"type": "enum", "name": "E", "symbols": ["A"]}]`,
+				`{`,
+			} {
+				_, err := parseAvroSchema([]byte(def))
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+	})
+}
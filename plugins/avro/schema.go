@@ -0,0 +1,386 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+// Package avro provides a decoder and an encoder for Avro binary encoded
+// records, w/ schemas read from local .avsc files.
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Parsed Avro schema. Named types referenced more than once share the same
+// schema, so recursive types are represented as cycles.
+type avroSchema struct {
+	typ         string // primitive type name, or "record", "enum", etc.
+	name        string // full name of named types
+	logicalType string
+	fields      []*avroField  // record
+	symbols     []string      // enum
+	items       *avroSchema   // array items and map values
+	branches    []*avroSchema // union
+	size        int           // fixed
+}
+
+type avroField struct {
+	name       string
+	schema     *avroSchema
+	def        interface{}
+	hasDefault bool
+}
+
+var avroPrimitives = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"int":     true,
+	"long":    true,
+	"float":   true,
+	"double":  true,
+	"bytes":   true,
+	"string":  true,
+}
+
+// Parser state, tracks the named types defined so far.
+type avroSchemaParser struct {
+	names map[string]*avroSchema
+}
+
+// Loads and parses an Avro schema file.
+func loadAvroSchema(path string) (*avroSchema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read schema: %s", err)
+	}
+	schema, err := parseAvroSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema '%s': %s", path, err)
+	}
+	return schema, nil
+}
+
+// Parses an Avro schema from its JSON representation.
+func parseAvroSchema(data []byte) (*avroSchema, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var def interface{}
+	if err := dec.Decode(&def); err != nil {
+		return nil, err
+	}
+	p := &avroSchemaParser{names: make(map[string]*avroSchema)}
+	return p.parse(def, "")
+}
+
+func (p *avroSchemaParser) parse(def interface{}, namespace string) (*avroSchema, error) {
+	switch d := def.(type) {
+	case string:
+		return p.lookup(d, namespace)
+	case []interface{}:
+		union := &avroSchema{typ: "union"}
+		for _, branchDef := range d {
+			branch, err := p.parse(branchDef, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if branch.typ == "union" {
+				return nil, errors.New("unions can't contain unions")
+			}
+			union.branches = append(union.branches, branch)
+		}
+		return union, nil
+	case map[string]interface{}:
+		return p.parseComplex(d, namespace)
+	}
+	return nil, fmt.Errorf("invalid schema: %v", def)
+}
+
+// Returns the primitive or previously defined named type.
+func (p *avroSchemaParser) lookup(name, namespace string) (*avroSchema, error) {
+	if avroPrimitives[name] {
+		return &avroSchema{typ: name}, nil
+	}
+	if schema, ok := p.names[avroFullName(name, namespace)]; ok {
+		return schema, nil
+	}
+	if schema, ok := p.names[name]; ok {
+		return schema, nil
+	}
+	return nil, fmt.Errorf("unknown type: '%s'", name)
+}
+
+// Returns the full name of a named type.
+func avroFullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// Defines a named type, returning its namespace.
+func (p *avroSchemaParser) define(schema *avroSchema, def map[string]interface{},
+	namespace string) (string, error) {
+
+	name, _ := def["name"].(string)
+	if name == "" {
+		return "", fmt.Errorf("%s has no name", schema.typ)
+	}
+	if ns, ok := def["namespace"].(string); ok && !strings.Contains(name, ".") {
+		namespace = ns
+	}
+	schema.name = avroFullName(name, namespace)
+	if _, ok := p.names[schema.name]; ok {
+		return "", fmt.Errorf("type '%s' defined twice", schema.name)
+	}
+	p.names[schema.name] = schema
+	if i := strings.LastIndex(schema.name, "."); i != -1 {
+		namespace = schema.name[:i]
+	} else {
+		namespace = ""
+	}
+	return namespace, nil
+}
+
+func (p *avroSchemaParser) parseComplex(def map[string]interface{},
+	namespace string) (schema *avroSchema, err error) {
+
+	typ, ok := def["type"].(string)
+	if !ok {
+		// e.g. {"type": {"type": "array", ...}}
+		if typeDef, ok := def["type"]; ok {
+			return p.parse(typeDef, namespace)
+		}
+		return nil, errors.New("missing type")
+	}
+	schema = &avroSchema{typ: typ}
+	schema.logicalType, _ = def["logicalType"].(string)
+
+	switch typ {
+	case "record", "error":
+		schema.typ = "record"
+		if namespace, err = p.define(schema, def, namespace); err != nil {
+			return nil, err
+		}
+		fieldDefs, ok := def["fields"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record '%s' has no fields", schema.name)
+		}
+		for _, fieldDef := range fieldDefs {
+			var field *avroField
+			if field, err = p.parseField(fieldDef, namespace); err != nil {
+				return nil, fmt.Errorf("record '%s': %s", schema.name, err)
+			}
+			schema.fields = append(schema.fields, field)
+		}
+	case "enum":
+		if _, err = p.define(schema, def, namespace); err != nil {
+			return nil, err
+		}
+		symbols, _ := def["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			s, ok := symbol.(string)
+			if !ok {
+				return nil, fmt.Errorf("enum '%s' has an invalid symbol", schema.name)
+			}
+			schema.symbols = append(schema.symbols, s)
+		}
+		if len(schema.symbols) == 0 {
+			return nil, fmt.Errorf("enum '%s' has no symbols", schema.name)
+		}
+	case "fixed":
+		if _, err = p.define(schema, def, namespace); err != nil {
+			return nil, err
+		}
+		size, ok := def["size"].(json.Number)
+		n, e := size.Int64()
+		if !ok || e != nil || n < 0 {
+			return nil, fmt.Errorf("fixed '%s' has an invalid size", schema.name)
+		}
+		schema.size = int(n)
+	case "array", "map":
+		key := "items"
+		if typ == "map" {
+			key = "values"
+		}
+		itemsDef, ok := def[key]
+		if !ok {
+			return nil, fmt.Errorf("%s has no %s", typ, key)
+		}
+		if schema.items, err = p.parse(itemsDef, namespace); err != nil {
+			return nil, err
+		}
+	default:
+		if !avroPrimitives[typ] {
+			// Named type reference w/ attributes.
+			return p.lookup(typ, namespace)
+		}
+	}
+	return schema, nil
+}
+
+func (p *avroSchemaParser) parseField(def interface{}, namespace string) (
+	field *avroField, err error) {
+
+	fieldDef, ok := def.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid field")
+	}
+	field = new(avroField)
+	if field.name, _ = fieldDef["name"].(string); field.name == "" {
+		return nil, errors.New("field has no name")
+	}
+	typeDef, ok := fieldDef["type"]
+	if !ok {
+		return nil, fmt.Errorf("field '%s' has no type", field.name)
+	}
+	if field.schema, err = p.parse(typeDef, namespace); err != nil {
+		return nil, fmt.Errorf("field '%s': %s", field.name, err)
+	}
+	if def, ok := fieldDef["default"]; ok {
+		// Union defaults correspond to the first branch.
+		defSchema := field.schema
+		if defSchema.typ == "union" {
+			defSchema = defSchema.branches[0]
+		}
+		if field.def, err = avroDefault(defSchema, def); err != nil {
+			return nil, fmt.Errorf("field '%s' default: %s", field.name, err)
+		}
+		field.hasDefault = true
+	}
+	return field, nil
+}
+
+// Converts a default value from its JSON representation to the value encoded
+// for the schema.
+func avroDefault(schema *avroSchema, def interface{}) (interface{}, error) {
+	switch schema.typ {
+	case "null":
+		if def == nil {
+			return nil, nil
+		}
+	case "boolean":
+		if b, ok := def.(bool); ok {
+			return b, nil
+		}
+	case "int", "long":
+		if n, ok := def.(json.Number); ok {
+			return n.Int64()
+		}
+	case "float", "double":
+		if n, ok := def.(json.Number); ok {
+			return n.Float64()
+		}
+	case "string", "enum":
+		if s, ok := def.(string); ok {
+			return s, nil
+		}
+	case "bytes", "fixed":
+		// Bytes are represented as strings of code points 0-255.
+		if s, ok := def.(string); ok {
+			b := make([]byte, 0, len(s))
+			for _, r := range s {
+				if r > 255 {
+					return nil, errors.New("invalid bytes")
+				}
+				b = append(b, byte(r))
+			}
+			return b, nil
+		}
+	case "array":
+		if values, ok := def.([]interface{}); ok {
+			result := make([]interface{}, len(values))
+			for i, value := range values {
+				var err error
+				if result[i], err = avroDefault(schema.items, value); err != nil {
+					return nil, err
+				}
+			}
+			return result, nil
+		}
+	case "map", "record":
+		if obj, ok := def.(map[string]interface{}); ok {
+			result := make(map[string]interface{}, len(obj))
+			if schema.typ == "map" {
+				for key, value := range obj {
+					var err error
+					if result[key], err = avroDefault(schema.items, value); err != nil {
+						return nil, err
+					}
+				}
+				return result, nil
+			}
+			for _, field := range schema.fields {
+				value, ok := obj[field.name]
+				if !ok {
+					continue
+				}
+				fieldSchema := field.schema
+				if fieldSchema.typ == "union" {
+					fieldSchema = fieldSchema.branches[0]
+				}
+				var err error
+				if result[field.name], err = avroDefault(fieldSchema, value); err != nil {
+					return nil, err
+				}
+			}
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s value: %v", schema.typ, def)
+}
+
+// Returns the non-null branch of a union of null and another type, the schema
+// itself otherwise.
+func (s *avroSchema) nonNull() *avroSchema {
+	if s.typ != "union" || len(s.branches) != 2 {
+		return s
+	}
+	if s.branches[0].typ == "null" {
+		return s.branches[1]
+	}
+	if s.branches[1].typ == "null" {
+		return s.branches[0]
+	}
+	return s
+}
+
+// Returns the record field, nil if there is none.
+func (s *avroSchema) field(name string) *avroField {
+	for _, field := range s.fields {
+		if field.name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// Returns the schema of the value at the path of record field names, nil if
+// there is none.
+func (s *avroSchema) schemaAt(path []string) *avroSchema {
+	schema := s
+	for _, name := range path {
+		if schema = schema.nonNull(); schema.typ != "record" {
+			return nil
+		}
+		field := schema.field(name)
+		if field == nil {
+			return nil
+		}
+		schema = field.schema
+	}
+	return schema.nonNull()
+}
@@ -95,7 +95,43 @@ func writeField(first bool, b *bytes.Buffer, f *message.Field, raw bool) {
 
 	writeQuotedString(b, f.GetName())
 	b.WriteString(`:`)
+	writeFieldValue(b, f, raw)
+}
+
+// Writes structured values as nested JSON objects, raw formatting only
+// applies to top level fields.
+func writeObject(b *bytes.Buffer, obj *message.Object) {
+	b.WriteString(`{`)
+	first := true
+	for _, f := range obj.GetFields() {
+		if f == nil || !fieldHasValue(f) {
+			continue
+		}
+		writeField(first, b, f, false)
+		first = false
+	}
+	b.WriteString(`}`)
+}
 
+func fieldHasValue(f *message.Field) bool {
+	switch f.GetValueType() {
+	case message.Field_STRING:
+		return len(f.ValueString) > 0
+	case message.Field_BYTES:
+		return len(f.ValueBytes) > 0
+	case message.Field_INTEGER:
+		return len(f.ValueInteger) > 0
+	case message.Field_DOUBLE:
+		return len(f.ValueDouble) > 0
+	case message.Field_BOOL:
+		return len(f.ValueBool) > 0
+	case message.Field_OBJECT:
+		return len(f.ValueObject) > 0
+	}
+	return false
+}
+
+func writeFieldValue(b *bytes.Buffer, f *message.Field, raw bool) {
 	switch f.GetValueType() {
 	case message.Field_STRING:
 		values := f.GetValueString()
@@ -183,6 +219,20 @@ func writeField(first bool, b *bytes.Buffer, f *message.Field, raw bool) {
 		} else {
 			b.WriteString(strconv.FormatBool(values[0]))
 		}
+	case message.Field_OBJECT:
+		values := f.GetValueObject()
+		if len(values) > 1 {
+			b.WriteString(`[`)
+			for i, value := range values {
+				writeObject(b, value)
+				if i < len(values)-1 {
+					b.WriteString(`,`)
+				}
+			}
+			b.WriteString(`]`)
+		} else {
+			writeObject(b, values[0])
+		}
 	}
 }
 
@@ -379,6 +379,36 @@ func ESEncodersSpec(c gs.Context) {
 			c.Expect(decoded["test_raw_field_bytes_array"].([]interface{})[1].(map[string]interface{})["jkl;"], gs.Equals, 123.0)
 		})
 
+		c.Specify("Should encode structured fields as nested objects", func() {
+			field, err := message.NewField("http", map[string]interface{}{
+				"request": map[string]interface{}{
+					"method": "GET",
+					"bytes":  512,
+				},
+				"secure": true,
+				"codes":  []int{200, 304},
+			}, "")
+			c.Assume(err, gs.IsNil)
+			pack.Message.AddField(field)
+			err = encoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			b, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+
+			lines := strings.Split(string(b), string(NEWLINE))
+			decoded := make(map[string]interface{})
+			err = json.Unmarshal([]byte(lines[1]), &decoded)
+			c.Assume(err, gs.IsNil)
+			http := decoded["http"].(map[string]interface{})
+			request := http["request"].(map[string]interface{})
+			c.Expect(request["method"], gs.Equals, "GET")
+			c.Expect(request["bytes"], gs.Equals, 512.0)
+			c.Expect(http["secure"], gs.Equals, true)
+			codes := http["codes"].([]interface{})
+			c.Expect(len(codes), gs.Equals, 2)
+			c.Expect(codes[1], gs.Equals, 304.0)
+		})
+
 		c.Specify("Should use field mappings", func() {
 			config := encoder.ConfigStruct().(*ESJsonEncoderConfig)
 			config.FieldMappings = &ESFieldMappings{
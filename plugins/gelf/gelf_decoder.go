@@ -0,0 +1,305 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+const (
+	// Chunked GELF datagrams start with these two magic bytes, followed by an
+	// 8 byte message id, the sequence number and the sequence count.
+	gelfChunkMagic0     = 0x1e
+	gelfChunkMagic1     = 0x0f
+	gelfChunkHeaderSize = 12
+	// Maximum number of chunks a message can be split into.
+	gelfMaxChunks = 128
+)
+
+type GelfDecoderConfig struct {
+	// Message Type, left untouched if empty.
+	MessageType string `toml:"message_type"`
+
+	// Seconds to wait for all the chunks of a chunked message to arrive
+	// before discarding the ones received so far.
+	ChunkTimeout uint `toml:"chunk_timeout"`
+
+	// Maximum number of chunked messages being reassembled at any time,
+	// chunks starting new messages beyond this are discarded.
+	MaxChunkedMessages int `toml:"max_chunked_messages"`
+}
+
+// Chunks of a message received so far.
+type gelfChunkedMessage struct {
+	chunks   [][]byte
+	received int
+	first    time.Time
+}
+
+type GelfDecoder struct {
+	msgType      string
+	chunkTimeout time.Duration
+	maxChunked   int
+	pending      map[string]*gelfChunkedMessage
+	lastPurge    time.Time
+	dRunner      DecoderRunner
+}
+
+func (gd *GelfDecoder) ConfigStruct() interface{} {
+	return &GelfDecoderConfig{
+		ChunkTimeout:       5,
+		MaxChunkedMessages: 1000,
+	}
+}
+
+func (gd *GelfDecoder) Init(config interface{}) (err error) {
+	conf := config.(*GelfDecoderConfig)
+	if conf.ChunkTimeout == 0 {
+		return errors.New("GelfDecoder chunk_timeout must be greater than 0")
+	}
+	if conf.MaxChunkedMessages < 1 {
+		return errors.New("GelfDecoder max_chunked_messages must be greater than 0")
+	}
+	gd.msgType = conf.MessageType
+	gd.chunkTimeout = time.Duration(conf.ChunkTimeout) * time.Second
+	gd.maxChunked = conf.MaxChunkedMessages
+	gd.pending = make(map[string]*gelfChunkedMessage)
+	gd.lastPurge = time.Now()
+	return
+}
+
+func (gd *GelfDecoder) SetDecoderRunner(dr DecoderRunner) {
+	gd.dRunner = dr
+}
+
+func (gd *GelfDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	data := []byte(pack.Message.GetPayload())
+	gd.purgeExpired()
+	if len(data) >= 2 && data[0] == gelfChunkMagic0 && data[1] == gelfChunkMagic1 {
+		if data, err = gd.addChunk(data); data == nil {
+			// Incomplete message, the pack is recycled by the DecoderRunner.
+			return nil, err
+		}
+	}
+	if data, err = gelfDecompress(data); err != nil {
+		return nil, err
+	}
+	if err = gd.decodeJson(pack.Message, data); err != nil {
+		return nil, err
+	}
+	if gd.msgType != "" {
+		pack.Message.SetType(gd.msgType)
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Stores a chunk, returning the reassembled message data once all the chunks
+// have arrived.
+func (gd *GelfDecoder) addChunk(chunk []byte) (data []byte, err error) {
+	if len(chunk) <= gelfChunkHeaderSize {
+		return nil, errors.New("GELF chunk too short")
+	}
+	id := string(chunk[2:10])
+	seq, count := int(chunk[10]), int(chunk[11])
+	if count == 0 || count > gelfMaxChunks {
+		return nil, fmt.Errorf("invalid GELF chunk count: %d", count)
+	}
+	if seq >= count {
+		return nil, fmt.Errorf("GELF chunk sequence number %d out of range", seq)
+	}
+
+	msg, ok := gd.pending[id]
+	if !ok {
+		if len(gd.pending) >= gd.maxChunked {
+			return nil, fmt.Errorf("too many chunked GELF messages pending: %d",
+				len(gd.pending))
+		}
+		msg = &gelfChunkedMessage{
+			chunks: make([][]byte, count),
+			first:  time.Now(),
+		}
+		gd.pending[id] = msg
+	} else if len(msg.chunks) != count {
+		return nil, fmt.Errorf("GELF chunk count mismatch: %d != %d", count,
+			len(msg.chunks))
+	}
+	if msg.chunks[seq] != nil {
+		// Duplicate chunk.
+		return nil, nil
+	}
+	msg.chunks[seq] = chunk[gelfChunkHeaderSize:]
+	msg.received++
+	if msg.received < count {
+		return nil, nil
+	}
+	delete(gd.pending, id)
+	return bytes.Join(msg.chunks, nil), nil
+}
+
+// Discards the chunked messages which haven't been completed within the chunk
+// timeout.
+func (gd *GelfDecoder) purgeExpired() {
+	now := time.Now()
+	if now.Sub(gd.lastPurge) < time.Second {
+		return
+	}
+	gd.lastPurge = now
+	for id, msg := range gd.pending {
+		if now.Sub(msg.first) < gd.chunkTimeout {
+			continue
+		}
+		delete(gd.pending, id)
+		if gd.dRunner != nil {
+			gd.dRunner.LogError(fmt.Errorf(
+				"discarding incomplete chunked GELF message, %d of %d chunks received",
+				msg.received, len(msg.chunks)))
+		}
+	}
+}
+
+// Returns the decompressed message data, detecting the compression from the
+// leading bytes. Uncompressed data is returned as is.
+func gelfDecompress(data []byte) ([]byte, error) {
+	var (
+		r   io.Reader
+		err error
+	)
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case len(data) >= 2 && data[0]&0x0f == 8 && (int(data[0])<<8|int(data[1]))%31 == 0:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GELF decompression error: %s", err)
+	}
+	limited := io.LimitReader(r, int64(message.MAX_RECORD_SIZE)+1)
+	if data, err = ioutil.ReadAll(limited); err != nil {
+		return nil, fmt.Errorf("GELF decompression error: %s", err)
+	}
+	if len(data) > int(message.MAX_RECORD_SIZE) {
+		return nil, fmt.Errorf("decompressed GELF message exceeded MAX_RECORD_SIZE %d",
+			message.MAX_RECORD_SIZE)
+	}
+	return data, nil
+}
+
+func (gd *GelfDecoder) decodeJson(msg *message.Message, data []byte) (err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err = dec.Decode(&obj); err != nil {
+		return fmt.Errorf("invalid GELF JSON: %s", err)
+	}
+	if obj == nil {
+		return errors.New("GELF JSON value is not an object")
+	}
+
+	// Sort the keys so the fields are always added in the same order.
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	msg.SetPayload("")
+	for _, key := range keys {
+		value := obj[key]
+		if value == nil {
+			continue
+		}
+		switch key {
+		case "version":
+			msg.SetEnvVersion(fmt.Sprint(value))
+		case "host":
+			msg.SetHostname(fmt.Sprint(value))
+		case "short_message":
+			msg.SetPayload(fmt.Sprint(value))
+		case "timestamp":
+			var secs float64
+			if secs, err = gelfNumber(value); err != nil {
+				return fmt.Errorf("invalid GELF timestamp: %s", err)
+			}
+			// Rounded to microseconds, the float64 precision doesn't go
+			// any further.
+			msg.SetTimestamp(int64(math.Floor(secs*1e6+0.5)) * 1e3)
+		case "level":
+			var level float64
+			if level, err = gelfNumber(value); err != nil {
+				return fmt.Errorf("invalid GELF level: %s", err)
+			}
+			msg.SetSeverity(int32(level))
+		default:
+			// Additional fields are stored w/o their leading underscore,
+			// other keys such as full_message are stored as they are.
+			name := key
+			if strings.HasPrefix(name, "_") && len(name) > 1 {
+				name = name[1:]
+			}
+			var f *message.Field
+			switch v := value.(type) {
+			case json.Number:
+				if i, e := v.Int64(); e == nil {
+					f, err = message.NewField(name, i, "")
+				} else {
+					fv, _ := v.Float64()
+					f, err = message.NewField(name, fv, "")
+				}
+			case string, bool:
+				f, err = message.NewField(name, v, "")
+			default:
+				return fmt.Errorf("GELF field '%s' is not a scalar", key)
+			}
+			if err != nil {
+				return fmt.Errorf("field creation error: %s", err)
+			}
+			msg.AddField(f)
+		}
+	}
+	return nil
+}
+
+// Returns the value of a JSON number, or of a string holding one.
+func gelfNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("'%v' is not a number", value)
+}
+
+func init() {
+	RegisterPlugin("GelfDecoder", func() interface{} {
+		return new(GelfDecoder)
+	})
+}
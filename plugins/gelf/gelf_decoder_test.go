@@ -0,0 +1,202 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Splits data into GELF chunks of at most size bytes.
+func gelfChunks(id string, data []byte, size int) (chunks [][]byte) {
+	count := (len(data) + size - 1) / size
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := []byte{gelfChunkMagic0, gelfChunkMagic1}
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks = append(chunks, append(chunk, data[i*size:end]...))
+	}
+	return
+}
+
+func GelfDecoderSpec(c gs.Context) {
+	c.Specify("A GelfDecoder", func() {
+		decoder := new(GelfDecoder)
+		conf := decoder.ConfigStruct().(*GelfDecoderConfig)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		decode := func(payload []byte) (*message.Message, error) {
+			pack.Message = new(message.Message)
+			pack.Message.SetPayload(string(payload))
+			packs, err := decoder.Decode(pack)
+			if packs == nil {
+				return nil, err
+			}
+			return packs[0].Message, err
+		}
+
+		gelf := []byte(`{"version": "1.1", "host": "example.org", ` +
+			`"short_message": "A short message", "full_message": "Backtrace here\n\nmore stuff", ` +
+			`"timestamp": 1385053862.3072, "level": 1, "_user_id": 9001, ` +
+			`"_some_info": "foo", "_took": 0.25, "_empty": null}`)
+
+		checkMessage := func(msg *message.Message) {
+			c.Expect(msg.GetEnvVersion(), gs.Equals, "1.1")
+			c.Expect(msg.GetHostname(), gs.Equals, "example.org")
+			c.Expect(msg.GetPayload(), gs.Equals, "A short message")
+			c.Expect(msg.GetTimestamp(), gs.Equals, int64(1385053862307200000))
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(1))
+			value, _ := msg.GetFieldValue("full_message")
+			c.Expect(value, gs.Equals, "Backtrace here\n\nmore stuff")
+			value, _ = msg.GetFieldValue("user_id")
+			c.Expect(value, gs.Equals, int64(9001))
+			value, _ = msg.GetFieldValue("some_info")
+			c.Expect(value, gs.Equals, "foo")
+			value, _ = msg.GetFieldValue("took")
+			c.Expect(value, gs.Equals, 0.25)
+			c.Expect(msg.FindFirstField("empty"), gs.IsNil)
+			c.Expect(len(msg.Fields), gs.Equals, 4)
+		}
+
+		c.Specify("decodes uncompressed messages", func() {
+			conf.MessageType = "gelf"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode(gelf)
+			c.Assume(err, gs.IsNil)
+			checkMessage(msg)
+			c.Expect(msg.GetType(), gs.Equals, "gelf")
+		})
+
+		c.Specify("decodes compressed messages", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			gw.Write(gelf)
+			gw.Close()
+			msg, err := decode(buf.Bytes())
+			c.Assume(err, gs.IsNil)
+			checkMessage(msg)
+
+			buf.Reset()
+			zw := zlib.NewWriter(&buf)
+			zw.Write(gelf)
+			zw.Close()
+			msg, err = decode(buf.Bytes())
+			c.Assume(err, gs.IsNil)
+			checkMessage(msg)
+
+			msg, err = decode([]byte{0x1f, 0x8b, 0, 0})
+			c.Expect(msg, gs.IsNil)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("reassembles chunked messages", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			var buf bytes.Buffer
+			zw := zlib.NewWriter(&buf)
+			zw.Write(gelf)
+			zw.Close()
+			chunks := gelfChunks("abcdefgh", buf.Bytes(), 40)
+			c.Assume(len(chunks) > 2, gs.IsTrue)
+			other := gelfChunks("ijklmnop", gelf, 100)
+
+			// Out of order, interleaved w/ another message, and w/ a
+			// duplicate.
+			msg, err := decode(chunks[1])
+			c.Expect(msg, gs.IsNil)
+			c.Expect(err, gs.IsNil)
+			decode(other[0])
+			decode(chunks[1])
+			for i := len(chunks) - 1; i > 1; i-- {
+				msg, err = decode(chunks[i])
+				c.Expect(msg, gs.IsNil)
+				c.Expect(err, gs.IsNil)
+			}
+			c.Expect(len(decoder.pending), gs.Equals, 2)
+			msg, err = decode(chunks[0])
+			c.Assume(err, gs.IsNil)
+			checkMessage(msg)
+			c.Expect(len(decoder.pending), gs.Equals, 1)
+
+			for _, chunk := range other[1:] {
+				msg, err = decode(chunk)
+			}
+			c.Assume(err, gs.IsNil)
+			checkMessage(msg)
+			c.Expect(len(decoder.pending), gs.Equals, 0)
+		})
+
+		c.Specify("discards incomplete chunked messages", func() {
+			conf.MaxChunkedMessages = 1
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			chunks := gelfChunks("abcdefgh", gelf, 100)
+			decode(chunks[0])
+
+			_, err = decode(gelfChunks("ijklmnop", gelf, 100)[0])
+			c.Expect(err, gs.Not(gs.IsNil))
+
+			decoder.lastPurge = time.Now().Add(-2 * time.Second)
+			decoder.pending["abcdefgh"].first = time.Now().Add(-6 * time.Second)
+			msg, err := decode(chunks[1])
+			c.Expect(msg, gs.IsNil)
+			c.Expect(len(decoder.pending), gs.Equals, 1)
+			c.Expect(decoder.pending["abcdefgh"].received, gs.Equals, 1)
+		})
+
+		c.Specify("fails on invalid messages", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			for _, payload := range []string{
+				`{"short_message": "x"`,
+				`[1, 2]`,
+				`{"timestamp": "now"}`,
+				`{"level": true}`,
+				`{"_nested": {"a": 1}}`,
+				"\x1e\x0fabcdefgh\x00",
+				"\x1e\x0fabcdefgh\x00\x00x",
+				"\x1e\x0fabcdefgh\x02\x02x",
+			} {
+				msg, err := decode([]byte(payload))
+				c.Expect(msg, gs.IsNil)
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+
+		c.Specify("fails on invalid settings", func() {
+			conf.ChunkTimeout = 0
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.ChunkTimeout = 5
+			conf.MaxChunkedMessages = 0
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
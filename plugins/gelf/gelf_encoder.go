@@ -0,0 +1,200 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type GelfEncoderConfig struct {
+	// Compression applied to the encoded message, "none", "gzip" or "zlib".
+	Compression string `toml:"compression"`
+
+	// Append a null byte to each message, as expected by GELF TCP inputs.
+	AppendNull bool `toml:"append_null"`
+}
+
+type GelfEncoder struct {
+	compression string
+	appendNull  bool
+	hostname    string
+}
+
+func (ge *GelfEncoder) ConfigStruct() interface{} {
+	return &GelfEncoderConfig{
+		Compression: "none",
+	}
+}
+
+func (ge *GelfEncoder) SetPipelineConfig(pConfig *PipelineConfig) {
+	ge.hostname = pConfig.Hostname()
+}
+
+func (ge *GelfEncoder) Init(config interface{}) (err error) {
+	conf := config.(*GelfEncoderConfig)
+	switch conf.Compression {
+	case "none", "gzip", "zlib":
+	default:
+		return fmt.Errorf("GelfEncoder unsupported compression: %s", conf.Compression)
+	}
+	if conf.AppendNull && conf.Compression != "none" {
+		return fmt.Errorf("GelfEncoder append_null can't be used with compression")
+	}
+	ge.compression = conf.Compression
+	ge.appendNull = conf.AppendNull
+	return
+}
+
+// Replaces the characters not allowed in GELF field names.
+func gelfFieldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || (r >= '0' && r <= '9') ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Returns the GELF representation of a field value, GELF only supports
+// strings and numbers so structured values are serialized as JSON.
+func gelfValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+		return fmt.Sprint(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	}
+	return value
+}
+
+func (ge *GelfEncoder) Encode(pack *PipelinePack) (output []byte, err error) {
+	// A lazily decoding ProtobufDecoder may have left the Fields encoded.
+	if err = pack.DecodeFields(); err != nil {
+		return nil, fmt.Errorf("can't decode message fields: %s", err)
+	}
+	msg := pack.Message
+	gelf := make(map[string]interface{})
+	gelf["version"] = "1.1"
+	if gelf["host"] = msg.GetHostname(); gelf["host"] == "" {
+		gelf["host"] = ge.hostname
+	}
+	// The short message is mandatory.
+	if gelf["short_message"] = msg.GetPayload(); gelf["short_message"] == "" {
+		gelf["short_message"] = "-"
+	}
+	ts := msg.GetTimestamp()
+	gelf["timestamp"] = json.Number(fmt.Sprintf("%d.%06d", ts/1e9, ts%1e9/1e3))
+	gelf["level"] = msg.GetSeverity()
+
+	headers := map[string]string{
+		"_type":        msg.GetType(),
+		"_logger":      msg.GetLogger(),
+		"_env_version": msg.GetEnvVersion(),
+		"_uuid":        msg.GetUuidString(),
+	}
+	for name, value := range headers {
+		if value != "" {
+			gelf[name] = value
+		}
+	}
+	if msg.Pid != nil {
+		gelf["_pid"] = msg.GetPid()
+	}
+
+	for _, field := range msg.GetFields() {
+		name := gelfFieldName(field.GetName())
+		if name == "full_message" {
+			gelf[name] = fmt.Sprint(field.GetValue())
+			continue
+		}
+		name = "_" + name
+		if name == "_id" {
+			// _id is reserved by Graylog.
+			name = "__id"
+		}
+		for {
+			// Don't overwrite the headers or a field of the same name.
+			if _, ok := gelf[name]; !ok {
+				break
+			}
+			name = "_" + name
+		}
+		values := field.GetNativeValues()
+		for i, value := range values {
+			values[i] = gelfValue(value)
+		}
+		if len(values) == 1 {
+			gelf[name] = values[0]
+		} else if len(values) > 1 {
+			// GELF has no arrays, multiple values are joined into a string.
+			strs := make([]string, len(values))
+			for i, value := range values {
+				strs[i] = fmt.Sprint(value)
+			}
+			gelf[name] = strings.Join(strs, ",")
+		}
+	}
+
+	if output, err = json.Marshal(gelf); err != nil {
+		return nil, fmt.Errorf("GELF encoding error: %s", err)
+	}
+	switch ge.compression {
+	case "gzip", "zlib":
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		if ge.compression == "gzip" {
+			w = gzip.NewWriter(&buf)
+		} else {
+			w = zlib.NewWriter(&buf)
+		}
+		w.Write(output)
+		if err = w.Close(); err != nil {
+			return nil, fmt.Errorf("GELF compression error: %s", err)
+		}
+		output = buf.Bytes()
+	default:
+		if ge.appendNull {
+			output = append(output, 0)
+		}
+	}
+	return
+}
+
+func init() {
+	RegisterPlugin("GelfEncoder", func() interface{} {
+		return new(GelfEncoder)
+	})
+}
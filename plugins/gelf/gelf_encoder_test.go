@@ -0,0 +1,204 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func GelfEncoderSpec(c gs.Context) {
+	c.Specify("A GelfEncoder", func() {
+		encoder := new(GelfEncoder)
+		pConfig := NewPipelineConfig(nil)
+		encoder.SetPipelineConfig(pConfig)
+		conf := encoder.ConfigStruct().(*GelfEncoderConfig)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+		msg := pack.Message
+		msg.SetTimestamp(1385053862307200000)
+		msg.SetType("app")
+		msg.SetLogger("GelfInput")
+		msg.SetSeverity(3)
+		msg.SetPid(42)
+		msg.SetHostname("example.org")
+		msg.SetPayload("A short message")
+		message.NewStringField(msg, "full_message", "Backtrace here")
+		message.NewInt64Field(msg, "user_id", 9001, "")
+		message.NewStringField(msg, "id", "reserved")
+		message.NewStringField(msg, "bad name!", "foo")
+		f, _ := message.NewField("flags", true, "")
+		f.AddValue(false)
+		msg.AddField(f)
+		f, _ = message.NewField("took", 0.25, "")
+		msg.AddField(f)
+
+		decodeJson := func(data []byte) map[string]interface{} {
+			var gelf map[string]interface{}
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.UseNumber()
+			err := dec.Decode(&gelf)
+			c.Assume(err, gs.IsNil)
+			return gelf
+		}
+
+		c.Specify("maps headers and fields", func() {
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			gelf := decodeJson(output)
+
+			c.Expect(gelf["version"], gs.Equals, "1.1")
+			c.Expect(gelf["host"], gs.Equals, "example.org")
+			c.Expect(gelf["short_message"], gs.Equals, "A short message")
+			c.Expect(gelf["full_message"], gs.Equals, "Backtrace here")
+			c.Expect(gelf["timestamp"], gs.Equals, json.Number("1385053862.307200"))
+			c.Expect(gelf["level"], gs.Equals, json.Number("3"))
+			c.Expect(gelf["_type"], gs.Equals, "app")
+			c.Expect(gelf["_logger"], gs.Equals, "GelfInput")
+			c.Expect(gelf["_pid"], gs.Equals, json.Number("42"))
+			c.Expect(gelf["_user_id"], gs.Equals, json.Number("9001"))
+			c.Expect(gelf["_took"], gs.Equals, json.Number("0.25"))
+			c.Expect(gelf["__id"], gs.Equals, "reserved")
+			c.Expect(gelf["_bad_name_"], gs.Equals, "foo")
+			c.Expect(gelf["_flags"], gs.Equals, "true,false")
+			_, ok := gelf["_env_version"]
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("round trips through the GelfDecoder", func() {
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+
+			decoder := new(GelfDecoder)
+			err = decoder.Init(decoder.ConfigStruct())
+			c.Assume(err, gs.IsNil)
+			pack2 := NewPipelinePack(supply)
+			pack2.Message.SetPayload(string(output))
+			_, err = decoder.Decode(pack2)
+			c.Assume(err, gs.IsNil)
+			c.Expect(pack2.Message.GetTimestamp(), gs.Equals, msg.GetTimestamp())
+			c.Expect(pack2.Message.GetSeverity(), gs.Equals, msg.GetSeverity())
+			c.Expect(pack2.Message.GetPayload(), gs.Equals, msg.GetPayload())
+			value, _ := pack2.Message.GetFieldValue("user_id")
+			c.Expect(value, gs.Equals, int64(9001))
+		})
+
+		c.Specify("encodes lazily decoded fields", func() {
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder := new(ProtobufDecoder)
+			decoder.SetPipelineConfig(pConfig)
+			decoderConf := decoder.ConfigStruct().(*ProtobufDecoderConfig)
+			decoderConf.Lazy = true
+			err = decoder.Init(decoderConf)
+			c.Assume(err, gs.IsNil)
+
+			pack2 := NewPipelinePack(supply)
+			pack2.MsgBytes, err = proto.Marshal(msg)
+			c.Assume(err, gs.IsNil)
+			_, err = decoder.Decode(pack2)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(pack2.Message.Fields), gs.Equals, 0)
+
+			output, err := encoder.Encode(pack2)
+			c.Assume(err, gs.IsNil)
+			gelf := decodeJson(output)
+			c.Expect(gelf["full_message"], gs.Equals, "Backtrace here")
+			c.Expect(gelf["_user_id"], gs.Equals, json.Number("9001"))
+			c.Expect(gelf["_flags"], gs.Equals, "true,false")
+		})
+
+		c.Specify("serializes object fields as JSON", func() {
+			f, err := message.NewField("http", map[string]interface{}{
+				"method": "GET",
+				"status": 200,
+			}, "")
+			c.Assume(err, gs.IsNil)
+			msg.AddField(f)
+			err = encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			gelf := decodeJson(output)
+			c.Expect(gelf["_http"], gs.Equals, `{"method":"GET","status":200}`)
+		})
+
+		c.Specify("renames fields clashing w/ the headers", func() {
+			message.NewStringField(msg, "pid", "worker")
+			message.NewStringField(msg, "user_id", "duplicate")
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			gelf := decodeJson(output)
+			c.Expect(gelf["_pid"], gs.Equals, json.Number("42"))
+			c.Expect(gelf["__pid"], gs.Equals, "worker")
+			c.Expect(gelf["_user_id"], gs.Equals, json.Number("9001"))
+			c.Expect(gelf["__user_id"], gs.Equals, "duplicate")
+		})
+
+		c.Specify("falls back to the Heka hostname", func() {
+			msg.SetHostname("")
+			msg.SetPayload("")
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			gelf := decodeJson(output)
+			c.Expect(gelf["host"], gs.Equals, pConfig.Hostname())
+			c.Expect(gelf["short_message"], gs.Equals, "-")
+		})
+
+		c.Specify("compresses and null terminates", func() {
+			conf.Compression = "gzip"
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			r, err := gzip.NewReader(bytes.NewReader(output))
+			c.Assume(err, gs.IsNil)
+			output, err = ioutil.ReadAll(r)
+			c.Assume(err, gs.IsNil)
+			c.Expect(decodeJson(output)["_user_id"], gs.Equals, json.Number("9001"))
+
+			conf.Compression = "none"
+			conf.AppendNull = true
+			err = encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err = encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(output[len(output)-1], gs.Equals, byte(0))
+		})
+
+		c.Specify("fails on invalid settings", func() {
+			conf.Compression = "snappy"
+			c.Expect(encoder.Init(conf), gs.Not(gs.IsNil))
+			conf.Compression = "zlib"
+			conf.AppendNull = true
+			c.Expect(encoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -1,5 +1,3 @@
-// +build geoip
-
 /***** BEGIN LICENSE BLOCK *****
 # This Source Code Form is subject to the terms of the Mozilla Public
 # License, v. 2.0. If a copy of the MPL was not distributed with this file,
@@ -12,33 +10,63 @@
 # Contributor(s):
 #   Michael Gibson (michael.gibson79@gmail.com)
 #   Rob Miller (rmiller@mozilla.com)
+#   agent (agent@local)
 #
 # ***** END LICENSE BLOCK *****/
 
 package geoip
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"github.com/abh/geoip"
+	"path/filepath"
+	"strings"
+	"time"
+
 	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
-	"strconv"
 )
 
 type GeoIpDecoderConfig struct {
-	DatabaseFile  string `toml:"db_file"`
-	SourceIpField string `toml:"source_ip_field"`
-	TargetField   string `toml:"target_field"`
+	DatabaseFile   string   `toml:"db_file"`
+	SourceIpField  string   `toml:"source_ip_field"`
+	SourceIpFields []string `toml:"source_ip_fields"`
+	TargetField    string   `toml:"target_field"`
+
+	// Attributes of mmdb databases added to the messages, defaults to the
+	// main attributes of the database type.
+	Attributes []string `toml:"attributes"`
+
+	// Language of the names found in mmdb databases.
+	Language string `toml:"language"`
+
+	// Number of seconds between the checks for changes of the database file,
+	// 0 disables the reloading.
+	ReloadInterval uint `toml:"reload_interval"`
+}
+
+// A GeoIP database adding the data found for IP addresses to messages.
+type geoIpDatabase interface {
+	// Adds the data found for the IP address to fields of the message, named
+	// after or prefixed w/ the given name.
+	addFields(msg *message.Message, ip, name string) error
+
+	// Releases the database once it has been replaced by a reload.
+	close()
 }
 
 type GeoIpDecoder struct {
-	DatabaseFile  string
-	SourceIpField string
-	TargetField   string
-	gi            *geoip.GeoIP
-	pConfig       *PipelineConfig
+	DatabaseFile   string
+	SourceIpField  string
+	TargetField    string
+	sourceIpFields []string
+	attributes     []string
+	language       string
+	db             geoIpDatabase
+	pConfig        *PipelineConfig
+	dRunner        DecoderRunner
+	watcher        *FileWatcher
+	now            func() time.Time
 }
 
 // Heka will call this before calling any other methods to give us access to
@@ -47,141 +75,124 @@ func (ld *GeoIpDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
 	ld.pConfig = pConfig
 }
 
+func (ld *GeoIpDecoder) SetDecoderRunner(dr DecoderRunner) {
+	ld.dRunner = dr
+}
+
 func (ld *GeoIpDecoder) ConfigStruct() interface{} {
 	globals := ld.pConfig.Globals
 	return &GeoIpDecoderConfig{
-		DatabaseFile:  globals.PrependShareDir("GeoLiteCity.dat"),
-		SourceIpField: "",
-		TargetField:   "geoip",
+		DatabaseFile:   globals.PrependShareDir("GeoLiteCity.dat"),
+		SourceIpField:  "",
+		TargetField:    "geoip",
+		Language:       "en",
+		ReloadInterval: 60,
 	}
 }
 
 func (ld *GeoIpDecoder) Init(config interface{}) (err error) {
 	conf := config.(*GeoIpDecoderConfig)
 
-	if string(conf.SourceIpField) == "" {
-		return errors.New("`source_ip_field` must be specified")
+	sources := conf.SourceIpFields
+	if conf.SourceIpField != "" {
+		sources = append([]string{conf.SourceIpField}, sources...)
+	}
+	if len(sources) == 0 {
+		return errors.New("`source_ip_field` or `source_ip_fields` must be specified")
 	}
 
 	if conf.TargetField == "" {
 		return errors.New("`target_field` must be specified")
 	}
 
+	ld.DatabaseFile = conf.DatabaseFile
 	ld.TargetField = conf.TargetField
-	ld.SourceIpField = conf.SourceIpField
-
-	if ld.gi == nil {
-		ld.gi, err = geoip.Open(conf.DatabaseFile)
+	ld.SourceIpField = sources[0]
+	ld.sourceIpFields = sources
+	ld.attributes = conf.Attributes
+	ld.language = conf.Language
+	ld.watcher = &FileWatcher{
+		Path:     ld.DatabaseFile,
+		Interval: time.Duration(conf.ReloadInterval) * time.Second,
+		Now:      ld.now,
 	}
-	if err != nil {
-		return fmt.Errorf("Could not open GeoIP database: %s\n")
+
+	if err = ld.watcher.Load(ld.load); err != nil {
+		return fmt.Errorf("Could not open GeoIP database: %s", err)
 	}
 
 	return
 }
 
-func (ld *GeoIpDecoder) GetRecord(ip string) *geoip.GeoIPRecord {
-	return ld.gi.GetRecord(ip)
+// Opens a mmdb database if the file has the .mmdb extension, a legacy GeoIP
+// database otherwise.
+func (ld *GeoIpDecoder) openDatabase() (geoIpDatabase, error) {
+	if strings.ToLower(filepath.Ext(ld.DatabaseFile)) == ".mmdb" {
+		return openMmdbDatabase(ld.DatabaseFile, ld.attributes, ld.language)
+	}
+	if len(ld.attributes) > 0 {
+		return nil, errors.New("`attributes` are only supported w/ mmdb databases")
+	}
+	return openLegacyDatabase(ld.DatabaseFile)
 }
 
-func (ld *GeoIpDecoder) GeoBuff(rec *geoip.GeoIPRecord) bytes.Buffer {
-	buf := bytes.Buffer{}
-
-	latitudeString := strconv.FormatFloat(float64(rec.Latitude), 'g', 16, 32)
-	longitudeString := strconv.FormatFloat(float64(rec.Longitude), 'g', 16, 32)
-	areacodeString := strconv.FormatInt(int64(rec.AreaCode), 10)
-	charsetString := strconv.FormatInt(int64(rec.CharSet), 10)
-
-	buf.WriteString(`{`)
-
-	buf.WriteString(`"latitude":`)
-	buf.WriteString(latitudeString)
-
-	buf.WriteString(`,"longitude":`)
-	buf.WriteString(longitudeString)
-
-	buf.WriteString(`,"location":[`)
-	buf.WriteString(longitudeString)
-	buf.WriteString(`,`)
-	buf.WriteString(latitudeString)
-	buf.WriteString(`]`)
-
-	buf.WriteString(`,"coordinates":["`)
-	buf.WriteString(longitudeString)
-	buf.WriteString(`","`)
-	buf.WriteString(latitudeString)
-	buf.WriteString(`"]`)
-
-	buf.WriteString(`,"countrycode":"`)
-	buf.WriteString(rec.CountryCode)
-	buf.WriteString(`"`)
-
-	buf.WriteString(`,"countrycode3":"`)
-	buf.WriteString(rec.CountryCode3)
-	buf.WriteString(`"`)
-
-	buf.WriteString(`,"countryname":"`)
-	buf.WriteString(rec.CountryName)
-	buf.WriteString(`"`)
-
-	buf.WriteString(`,"region":"`)
-	buf.WriteString(rec.Region)
-	buf.WriteString(`"`)
-
-	buf.WriteString(`,"city":"`)
-	buf.WriteString(rec.City)
-	buf.WriteString(`"`)
-
-	buf.WriteString(`,"postalcode":"`)
-	buf.WriteString(rec.PostalCode)
-	buf.WriteString(`"`)
-
-	buf.WriteString(`,"areacode":`)
-	buf.WriteString(areacodeString)
-
-	buf.WriteString(`,"charset":`)
-	buf.WriteString(charsetString)
-
-	buf.WriteString(`,"continentcode":"`)
-	buf.WriteString(rec.ContinentCode)
-	buf.WriteString(`"`)
+func (ld *GeoIpDecoder) load() error {
+	db, err := ld.openDatabase()
+	if err != nil {
+		return err
+	}
+	old := ld.db
+	ld.db = db
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
 
-	buf.WriteString(`}`)
+// Reopens the database if the file has changed since it was opened. The
+// current database is kept if the file can't be opened.
+func (ld *GeoIpDecoder) reloadIfChanged() {
+	reloaded, err := ld.watcher.ReloadIfChanged(ld.load)
+	switch {
+	case err != nil && !reloaded:
+		ld.dRunner.LogError(fmt.Errorf("can't check GeoIP database: %s", err))
+	case err != nil:
+		ld.dRunner.LogError(fmt.Errorf("can't reload GeoIP database: %s", err))
+	case reloaded:
+		ld.dRunner.LogMessage(fmt.Sprintf("reloaded GeoIP database '%s'",
+			ld.DatabaseFile))
+	}
+}
 
-	return buf
+// Returns the name of the field(s) receiving the data of a source field, the
+// source field name is prepended when there are several.
+func (ld *GeoIpDecoder) targetField(source string) string {
+	if len(ld.sourceIpFields) > 1 {
+		return source + "_" + ld.TargetField
+	}
+	return ld.TargetField
 }
 
 func (ld *GeoIpDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
-	var buf bytes.Buffer
-	var ipAddr, _ = pack.Message.GetFieldValue(ld.SourceIpField)
-
-	ip, ok := ipAddr.(string)
-
-	if !ok {
-		// IP field was not a string. Field could just be blank. Return without error.
-		packs = []*PipelinePack{pack}
+	packs = []*PipelinePack{pack}
+	if ld.db == nil {
 		return
 	}
-
-	if ld.gi != nil {
-		rec := ld.gi.GetRecord(ip)
-		if rec != nil {
-			buf = ld.GeoBuff(rec)
-		} else {
-			// IP address did not return a valid GeoIp record but that's ok sometimes(private ip?). Return without error.
-			packs = []*PipelinePack{pack}
-			return
+	ld.reloadIfChanged()
+
+	for _, source := range ld.sourceIpFields {
+		var ipAddr, _ = pack.Message.GetFieldValue(source)
+		ip, ok := ipAddr.(string)
+		if !ok {
+			// IP field was not a string. Field could just be blank. Skip without error.
+			continue
+		}
+		// IP addresses w/o a record (private ip?) are skipped by the database.
+		if err = ld.db.addFields(pack.Message, ip, ld.targetField(source)); err != nil {
+			return nil, err
 		}
 	}
 
-	if buf.Len() > 0 {
-		var nf *message.Field
-		nf, err = message.NewField(ld.TargetField, buf.Bytes(), "")
-		pack.Message.AddField(nf)
-	}
-
-	packs = []*PipelinePack{pack}
-
 	return
 }
 
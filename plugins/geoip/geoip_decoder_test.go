@@ -1,3 +1,5 @@
+// +build geoip
+
 /***** BEGIN LICENSE BLOCK *****
 # This Source Code Form is subject to the terms of the Mozilla Public
 # License, v. 2.0. If a copy of the MPL was not distributed with this file,
@@ -25,7 +27,7 @@ import (
 	"testing"
 )
 
-func TestAllSpecs(t *testing.T) {
+func TestLegacySpecs(t *testing.T) {
 	r := gs.NewRunner()
 	r.Parallel = false
 
@@ -0,0 +1,138 @@
+// +build geoip
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2014
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   Michael Gibson (michael.gibson79@gmail.com)
+#   Rob Miller (rmiller@mozilla.com)
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package geoip
+
+import (
+	"bytes"
+	"github.com/abh/geoip"
+	"github.com/mozilla-services/heka/message"
+	"strconv"
+)
+
+// Legacy GeoIP database, read w/ the GeoIP C library.
+type legacyDatabase struct {
+	gi *geoip.GeoIP
+}
+
+func openLegacyDatabase(path string) (geoIpDatabase, error) {
+	gi, err := geoip.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &legacyDatabase{gi}, nil
+}
+
+// Adds the city record of the IP address as a JSON object to the field.
+func (db *legacyDatabase) addFields(msg *message.Message, ip, name string) error {
+	rec := db.gi.GetRecord(ip)
+	if rec == nil {
+		return nil
+	}
+	buf := geoBuff(rec)
+	nf, err := message.NewField(name, buf.Bytes(), "")
+	if err != nil {
+		return err
+	}
+	msg.AddField(nf)
+	return nil
+}
+
+// The GeoIP library has no Close, it frees the C database in a finalizer
+// once the handle is no longer referenced.
+func (db *legacyDatabase) close() {
+	db.gi = nil
+}
+
+func (ld *GeoIpDecoder) GetRecord(ip string) *geoip.GeoIPRecord {
+	if db, ok := ld.db.(*legacyDatabase); ok {
+		return db.gi.GetRecord(ip)
+	}
+	return nil
+}
+
+func (ld *GeoIpDecoder) GeoBuff(rec *geoip.GeoIPRecord) bytes.Buffer {
+	return geoBuff(rec)
+}
+
+func geoBuff(rec *geoip.GeoIPRecord) bytes.Buffer {
+	buf := bytes.Buffer{}
+
+	latitudeString := strconv.FormatFloat(float64(rec.Latitude), 'g', 16, 32)
+	longitudeString := strconv.FormatFloat(float64(rec.Longitude), 'g', 16, 32)
+	areacodeString := strconv.FormatInt(int64(rec.AreaCode), 10)
+	charsetString := strconv.FormatInt(int64(rec.CharSet), 10)
+
+	buf.WriteString(`{`)
+
+	buf.WriteString(`"latitude":`)
+	buf.WriteString(latitudeString)
+
+	buf.WriteString(`,"longitude":`)
+	buf.WriteString(longitudeString)
+
+	buf.WriteString(`,"location":[`)
+	buf.WriteString(longitudeString)
+	buf.WriteString(`,`)
+	buf.WriteString(latitudeString)
+	buf.WriteString(`]`)
+
+	buf.WriteString(`,"coordinates":["`)
+	buf.WriteString(longitudeString)
+	buf.WriteString(`","`)
+	buf.WriteString(latitudeString)
+	buf.WriteString(`"]`)
+
+	buf.WriteString(`,"countrycode":"`)
+	buf.WriteString(rec.CountryCode)
+	buf.WriteString(`"`)
+
+	buf.WriteString(`,"countrycode3":"`)
+	buf.WriteString(rec.CountryCode3)
+	buf.WriteString(`"`)
+
+	buf.WriteString(`,"countryname":"`)
+	buf.WriteString(rec.CountryName)
+	buf.WriteString(`"`)
+
+	buf.WriteString(`,"region":"`)
+	buf.WriteString(rec.Region)
+	buf.WriteString(`"`)
+
+	buf.WriteString(`,"city":"`)
+	buf.WriteString(rec.City)
+	buf.WriteString(`"`)
+
+	buf.WriteString(`,"postalcode":"`)
+	buf.WriteString(rec.PostalCode)
+	buf.WriteString(`"`)
+
+	buf.WriteString(`,"areacode":`)
+	buf.WriteString(areacodeString)
+
+	buf.WriteString(`,"charset":`)
+	buf.WriteString(charsetString)
+
+	buf.WriteString(`,"continentcode":"`)
+	buf.WriteString(rec.ContinentCode)
+	buf.WriteString(`"`)
+
+	buf.WriteString(`}`)
+
+	return buf
+}
@@ -0,0 +1,189 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package geoip
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// Returns the path of an attribute in the GeoIP2 and GeoLite2 records, nil
+// if the attribute is unknown. Names are looked up in the given language.
+func mmdbAttributePath(name, language string) []interface{} {
+	switch name {
+	case "continent_code":
+		return []interface{}{"continent", "code"}
+	case "continent_name":
+		return []interface{}{"continent", "names", language}
+	case "country_iso_code":
+		return []interface{}{"country", "iso_code"}
+	case "country_name":
+		return []interface{}{"country", "names", language}
+	case "registered_country_iso_code":
+		return []interface{}{"registered_country", "iso_code"}
+	case "region_iso_code":
+		return []interface{}{"subdivisions", 0, "iso_code"}
+	case "region_name":
+		return []interface{}{"subdivisions", 0, "names", language}
+	case "city_name":
+		return []interface{}{"city", "names", language}
+	case "postal_code":
+		return []interface{}{"postal", "code"}
+	case "latitude":
+		return []interface{}{"location", "latitude"}
+	case "longitude":
+		return []interface{}{"location", "longitude"}
+	case "location":
+		return []interface{}{"location"}
+	case "accuracy_radius":
+		return []interface{}{"location", "accuracy_radius"}
+	case "metro_code":
+		return []interface{}{"location", "metro_code"}
+	case "time_zone":
+		return []interface{}{"location", "time_zone"}
+	case "asn":
+		return []interface{}{"autonomous_system_number"}
+	case "as_org":
+		return []interface{}{"autonomous_system_organization"}
+	case "isp":
+		return []interface{}{"isp"}
+	case "organization":
+		return []interface{}{"organization"}
+	}
+	return nil
+}
+
+// Returns the attributes added by default for a database type, e.g.
+// "GeoLite2-City".
+func mmdbDefaultAttributes(databaseType string) []string {
+	switch {
+	case strings.HasSuffix(databaseType, "-City"):
+		return []string{"continent_code", "country_iso_code", "country_name",
+			"region_iso_code", "region_name", "city_name", "postal_code",
+			"latitude", "longitude"}
+	case strings.HasSuffix(databaseType, "-Country"):
+		return []string{"continent_code", "country_iso_code", "country_name"}
+	case strings.HasSuffix(databaseType, "-ASN"):
+		return []string{"asn", "as_org"}
+	}
+	return nil
+}
+
+type mmdbAttribute struct {
+	name string
+	path []interface{}
+}
+
+// GeoIP2 or GeoLite2 database in the MaxMind DB format.
+type mmdbDatabase struct {
+	reader     *mmdbReader
+	attributes []mmdbAttribute
+}
+
+func openMmdbDatabase(path string, attributes []string, language string) (
+	geoIpDatabase, error) {
+
+	reader, err := openMmdb(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(attributes) == 0 {
+		if attributes = mmdbDefaultAttributes(reader.databaseType); attributes == nil {
+			return nil, fmt.Errorf("`attributes` must be specified for the '%s' "+
+				"database type", reader.databaseType)
+		}
+	}
+	db := &mmdbDatabase{reader: reader}
+	for _, name := range attributes {
+		path := mmdbAttributePath(name, language)
+		if path == nil {
+			return nil, fmt.Errorf("unknown attribute: '%s'", name)
+		}
+		db.attributes = append(db.attributes, mmdbAttribute{name, path})
+	}
+	return db, nil
+}
+
+// Returns the value of an attribute as a field value, nil if it has no
+// matching type. The location is the [longitude, latitude] pair.
+func mmdbFieldValue(name string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string, float64, int64, bool, []byte:
+		return v
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v)
+		}
+	case map[string]interface{}:
+		if name != "location" {
+			return nil
+		}
+		lat, latOk := v["latitude"].(float64)
+		lon, lonOk := v["longitude"].(float64)
+		if latOk && lonOk {
+			return []float64{lon, lat}
+		}
+	}
+	return nil
+}
+
+// The database is held in memory, there's nothing to release.
+func (db *mmdbDatabase) close() {}
+
+// Adds the attributes found for the IP address as fields named
+// <name>_<attribute>. Addresses which aren't valid or aren't in the database
+// are skipped.
+func (db *mmdbDatabase) addFields(msg *message.Message, ip, name string) error {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+	offset, found, err := db.reader.lookup(addr)
+	if err != nil {
+		return fmt.Errorf("GeoIP lookup of '%s' failed: %s", ip, err)
+	}
+	if !found {
+		return nil
+	}
+	for _, attr := range db.attributes {
+		value, found, err := db.reader.data.find(offset, attr.path)
+		if err != nil {
+			return fmt.Errorf("GeoIP lookup of '%s' failed: %s", ip, err)
+		}
+		if !found {
+			continue
+		}
+		var f *message.Field
+		switch v := mmdbFieldValue(attr.name, value).(type) {
+		case nil:
+			continue
+		case []float64:
+			if f, err = message.NewField(name+"_"+attr.name, v[0], ""); err == nil {
+				err = f.AddValue(v[1])
+			}
+		default:
+			f, err = message.NewField(name+"_"+attr.name, v, "")
+		}
+		if err != nil {
+			return fmt.Errorf("field creation error: %s", err)
+		}
+		msg.AddField(f)
+	}
+	return nil
+}
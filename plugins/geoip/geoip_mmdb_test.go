@@ -0,0 +1,268 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package geoip
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Records when the wrapped database is closed.
+type closeRecorder struct {
+	geoIpDatabase
+	closed bool
+}
+
+func (r *closeRecorder) close() {
+	r.closed = true
+}
+
+func cityTestRecord(city string) map[string]interface{} {
+	return map[string]interface{}{
+		"city": map[string]interface{}{
+			"names": map[string]interface{}{"de": city + " (de)", "en": city},
+		},
+		"continent": map[string]interface{}{
+			"code":  "NA",
+			"names": map[string]interface{}{"en": "North America"},
+		},
+		"country": map[string]interface{}{
+			"iso_code": "US",
+			"names": map[string]interface{}{"de": "Vereinigte Staaten",
+				"en": "United States"},
+		},
+		"location": map[string]interface{}{
+			"accuracy_radius": uint16(1000),
+			"latitude":        37.4192,
+			"longitude":       -122.0574,
+			"metro_code":      uint16(807),
+			"time_zone":       "America/Los_Angeles",
+		},
+		"postal": map[string]interface{}{"code": "94043"},
+		"subdivisions": []interface{}{
+			map[string]interface{}{
+				"iso_code": "CA",
+				"names":    map[string]interface{}{"en": "California"},
+			},
+		},
+	}
+}
+
+func GeoIpDecoderMmdbSpec(c gs.Context) {
+	t := &ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A GeoIpDecoder w/ a mmdb database", func() {
+		dir, err := ioutil.TempDir("", "geoip")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(dir)
+
+		decoder := new(GeoIpDecoder)
+		decoder.SetPipelineConfig(NewPipelineConfig(nil))
+		dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+		decoder.SetDecoderRunner(dRunner)
+		now := time.Unix(1442403022, 0)
+		decoder.now = func() time.Time { return now }
+		conf := decoder.ConfigStruct().(*GeoIpDecoderConfig)
+		conf.SourceIpField = "remote_host"
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		writeDb := func(name string, ipVersion int, databaseType string,
+			networks []mmdbTestNetwork) string {
+
+			path := filepath.Join(dir, name)
+			data := buildTestMmdb(ipVersion, 28, databaseType, networks)
+			err := ioutil.WriteFile(path, data, 0644)
+			c.Assume(err, gs.IsNil)
+			return path
+		}
+		cityNetworks := []mmdbTestNetwork{
+			{"74.125.0.0/16", cityTestRecord("Mountain View")},
+			{"2001:4860::/32", cityTestRecord("Palo Alto")},
+		}
+		decode := func(fields ...string) *message.Message {
+			pack.Message = new(message.Message)
+			for i := 0; i < len(fields); i += 2 {
+				f, _ := message.NewField(fields[i], fields[i+1], "")
+				pack.Message.AddField(f)
+			}
+			packs, err := decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+			return packs[0].Message
+		}
+		getValue := func(msg *message.Message, name string) interface{} {
+			value, _ := msg.GetFieldValue(name)
+			return value
+		}
+
+		c.Specify("adds the default city attributes", func() {
+			conf.DatabaseFile = writeDb("GeoLite2-City.mmdb", 6, "GeoLite2-City",
+				cityNetworks)
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			msg := decode("remote_host", "74.125.142.147")
+			c.Expect(len(msg.Fields), gs.Equals, 10)
+			c.Expect(msg.Fields[1].GetName(), gs.Equals, "geoip_continent_code")
+			c.Expect(getValue(msg, "geoip_continent_code"), gs.Equals, "NA")
+			c.Expect(getValue(msg, "geoip_country_iso_code"), gs.Equals, "US")
+			c.Expect(getValue(msg, "geoip_country_name"), gs.Equals, "United States")
+			c.Expect(getValue(msg, "geoip_region_iso_code"), gs.Equals, "CA")
+			c.Expect(getValue(msg, "geoip_region_name"), gs.Equals, "California")
+			c.Expect(getValue(msg, "geoip_city_name"), gs.Equals, "Mountain View")
+			c.Expect(getValue(msg, "geoip_postal_code"), gs.Equals, "94043")
+			c.Expect(getValue(msg, "geoip_latitude"), gs.Equals, 37.4192)
+			c.Expect(getValue(msg, "geoip_longitude"), gs.Equals, -122.0574)
+
+			msg = decode("remote_host", "2001:4860:4860::8888")
+			c.Expect(getValue(msg, "geoip_city_name"), gs.Equals, "Palo Alto")
+
+			// Unknown, invalid and missing addresses are passed through.
+			c.Expect(len(decode("remote_host", "10.0.0.1").Fields), gs.Equals, 1)
+			c.Expect(len(decode("remote_host", "not an ip").Fields), gs.Equals, 1)
+			c.Expect(len(decode("other_host", "74.125.142.147").Fields), gs.Equals, 1)
+		})
+
+		c.Specify("adds the configured attributes", func() {
+			conf.DatabaseFile = writeDb("city.mmdb", 6, "GeoIP2-City",
+				cityNetworks)
+			conf.Attributes = []string{"city_name", "country_name", "location",
+				"accuracy_radius", "time_zone", "postal_code", "asn"}
+			conf.Language = "de"
+			conf.TargetField = "geo"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			msg := decode("remote_host", "74.125.142.147")
+			c.Expect(len(msg.Fields), gs.Equals, 7)
+			c.Expect(getValue(msg, "geo_city_name"), gs.Equals, "Mountain View (de)")
+			c.Expect(getValue(msg, "geo_country_name"), gs.Equals,
+				"Vereinigte Staaten")
+			location := msg.FindFirstField("geo_location")
+			c.Assume(location, gs.Not(gs.IsNil))
+			c.Expect(len(location.ValueDouble), gs.Equals, 2)
+			c.Expect(location.ValueDouble[0], gs.Equals, -122.0574)
+			c.Expect(location.ValueDouble[1], gs.Equals, 37.4192)
+			c.Expect(getValue(msg, "geo_accuracy_radius"), gs.Equals, int64(1000))
+			c.Expect(getValue(msg, "geo_time_zone"), gs.Equals,
+				"America/Los_Angeles")
+		})
+
+		c.Specify("looks up several source fields", func() {
+			conf.DatabaseFile = writeDb("asn.mmdb", 4, "GeoLite2-ASN",
+				[]mmdbTestNetwork{
+					{"74.125.0.0/16", map[string]interface{}{
+						"autonomous_system_number":       uint32(15169),
+						"autonomous_system_organization": "Google LLC",
+					}},
+					{"93.184.216.0/24", map[string]interface{}{
+						"autonomous_system_number":       uint32(15133),
+						"autonomous_system_organization": "Edgecast Inc.",
+					}},
+				})
+			conf.SourceIpField = ""
+			conf.SourceIpFields = []string{"client_ip", "server_ip"}
+			conf.TargetField = "as"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			msg := decode("client_ip", "74.125.142.147", "server_ip", "93.184.216.34")
+			c.Expect(len(msg.Fields), gs.Equals, 6)
+			c.Expect(getValue(msg, "client_ip_as_asn"), gs.Equals, int64(15169))
+			c.Expect(getValue(msg, "client_ip_as_as_org"), gs.Equals, "Google LLC")
+			c.Expect(getValue(msg, "server_ip_as_asn"), gs.Equals, int64(15133))
+			c.Expect(getValue(msg, "server_ip_as_as_org"), gs.Equals,
+				"Edgecast Inc.")
+
+			// IPv6 addresses aren't in IPv4 databases.
+			msg = decode("client_ip", "2001:4860:4860::8888")
+			c.Expect(len(msg.Fields), gs.Equals, 1)
+		})
+
+		c.Specify("reloads the database when the file changes", func() {
+			conf.DatabaseFile = writeDb("city.mmdb", 6, "GeoLite2-City",
+				cityNetworks)
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			first := &closeRecorder{geoIpDatabase: decoder.db}
+			decoder.db = first
+
+			writeDb("city.mmdb", 6, "GeoLite2-City", []mmdbTestNetwork{
+				{"74.125.0.0/16", cityTestRecord("Sunnyvale")},
+			})
+			// Not checked before the reload interval has passed.
+			now = now.Add(30 * time.Second)
+			msg := decode("remote_host", "74.125.142.147")
+			c.Expect(getValue(msg, "geoip_city_name"), gs.Equals, "Mountain View")
+
+			now = now.Add(30 * time.Second)
+			dRunner.EXPECT().LogMessage(gomock.Any())
+			msg = decode("remote_host", "74.125.142.147")
+			c.Expect(getValue(msg, "geoip_city_name"), gs.Equals, "Sunnyvale")
+			c.Expect(first.closed, gs.IsTrue)
+
+			// Invalid files are reported and the current database is kept.
+			err = ioutil.WriteFile(conf.DatabaseFile, []byte("updating"), 0644)
+			c.Assume(err, gs.IsNil)
+			now = now.Add(time.Minute)
+			dRunner.EXPECT().LogError(gomock.Any())
+			msg = decode("remote_host", "74.125.142.147")
+			c.Expect(getValue(msg, "geoip_city_name"), gs.Equals, "Sunnyvale")
+		})
+
+		c.Specify("fails on invalid settings", func() {
+			conf.DatabaseFile = writeDb("city.mmdb", 6, "GeoLite2-City",
+				cityNetworks)
+			c.Assume(decoder.Init(conf), gs.IsNil)
+
+			conf.Attributes = []string{"city_name", "planet_name"}
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.Attributes = nil
+
+			conf.DatabaseFile = writeDb("other.mmdb", 6, "Other", cityNetworks)
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.Attributes = []string{"city_name"}
+			c.Expect(decoder.Init(conf), gs.IsNil)
+
+			conf.DatabaseFile = filepath.Join(dir, "GeoLiteCity.dat")
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.DatabaseFile = filepath.Join(dir, "missing.mmdb")
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.DatabaseFile = filepath.Join(dir, "invalid.mmdb")
+			err = ioutil.WriteFile(conf.DatabaseFile, []byte("not a database"), 0644)
+			c.Assume(err, gs.IsNil)
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+
+			conf.DatabaseFile = filepath.Join(dir, "city.mmdb")
+			conf.SourceIpField = ""
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.SourceIpField = "remote_host"
+			conf.TargetField = ""
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
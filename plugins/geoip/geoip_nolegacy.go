@@ -0,0 +1,26 @@
+// +build !geoip
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2014
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package geoip
+
+import (
+	"errors"
+)
+
+func openLegacyDatabase(path string) (geoIpDatabase, error) {
+	return nil, errors.New("legacy GeoIP databases aren't supported by this " +
+		"build, which lacks the GeoIP C library. Use a mmdb database.")
+}
@@ -0,0 +1,447 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+)
+
+// Marker preceding the metadata at the end of the file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const (
+	// Maximum size of the metadata, the marker is searched for in it.
+	mmdbMetadataMaxSize = 128 * 1024
+	// Size of the zero bytes separating the search tree from the data.
+	mmdbDataSeparatorSize = 16
+	// Maximum nesting of the data structures.
+	mmdbMaxDepth = 32
+)
+
+// Data field types.
+const (
+	mmdbPointer = 1 + iota
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+var errMmdbInvalid = errors.New("invalid MaxMind DB data")
+
+// Data section of a database, the offsets are relative to its start.
+type mmdbData []byte
+
+// Decodes the control byte of the field at the offset, returning its type,
+// its size and the offset of its payload. The size of pointers is left as
+// the 5 size bits, which are part of the pointer.
+func (d mmdbData) decodeControl(offset uint) (typ int, size, next uint, err error) {
+	if offset >= uint(len(d)) {
+		return 0, 0, 0, errMmdbInvalid
+	}
+	ctrl := d[offset]
+	offset++
+	typ = int(ctrl >> 5)
+	if typ == 0 {
+		// Extended type, in the following byte.
+		if offset >= uint(len(d)) {
+			return 0, 0, 0, errMmdbInvalid
+		}
+		typ = 7 + int(d[offset])
+		offset++
+	}
+	size = uint(ctrl & 0x1f)
+	if typ == mmdbPointer || size < 29 {
+		return typ, size, offset, nil
+	}
+	n := size - 28
+	if offset+n > uint(len(d)) {
+		return 0, 0, 0, errMmdbInvalid
+	}
+	var ext uint
+	for _, b := range d[offset : offset+n] {
+		ext = ext<<8 | uint(b)
+	}
+	switch n {
+	case 1:
+		size = 29 + ext
+	case 2:
+		size = 285 + ext
+	default:
+		size = 65821 + ext
+	}
+	return typ, size, offset + n, nil
+}
+
+// Decodes a pointer from the size bits of its control byte and the following
+// bytes, returning the offset it points to and the offset after the pointer.
+func (d mmdbData) decodePointer(bits, offset uint) (pointer, next uint, err error) {
+	n := bits>>3 + 1
+	if offset+n > uint(len(d)) {
+		return 0, 0, errMmdbInvalid
+	}
+	if n < 4 {
+		pointer = bits & 0x7
+	}
+	for _, b := range d[offset : offset+n] {
+		pointer = pointer<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		pointer += 2048
+	case 3:
+		pointer += 526336
+	}
+	return pointer, offset + n, nil
+}
+
+// Returns the offset of the field at the offset, following a pointer.
+func (d mmdbData) resolve(offset uint) (uint, error) {
+	typ, size, next, err := d.decodeControl(offset)
+	if err != nil || typ != mmdbPointer {
+		return offset, err
+	}
+	pointer, _, err := d.decodePointer(size, next)
+	return pointer, err
+}
+
+// Returns the offset following the field at the offset.
+func (d mmdbData) skip(offset uint, depth int) (uint, error) {
+	if depth > mmdbMaxDepth {
+		return 0, errMmdbInvalid
+	}
+	typ, size, next, err := d.decodeControl(offset)
+	if err != nil {
+		return 0, err
+	}
+	switch typ {
+	case mmdbPointer:
+		_, next, err = d.decodePointer(size, next)
+		return next, err
+	case mmdbMap, mmdbArray:
+		if typ == mmdbMap {
+			size *= 2
+		}
+		for i := uint(0); i < size; i++ {
+			if next, err = d.skip(next, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return next, nil
+	case mmdbBool:
+		return next, nil
+	}
+	if next+size > uint(len(d)) {
+		return 0, errMmdbInvalid
+	}
+	return next + size, nil
+}
+
+// Decodes the field at the offset, returning the value and the offset
+// following the field. Maps are decoded to map[string]interface{}, arrays to
+// []interface{}, the unsigned integers to uint64, the signed ones to int64,
+// uint128 to *big.Int and the floating point numbers to float64.
+func (d mmdbData) decode(offset uint, depth int) (value interface{}, next uint,
+	err error) {
+
+	if depth > mmdbMaxDepth {
+		return nil, 0, errMmdbInvalid
+	}
+	typ, size, next, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch typ {
+	case mmdbPointer:
+		var pointer uint
+		if pointer, next, err = d.decodePointer(size, next); err != nil {
+			return nil, 0, err
+		}
+		// Pointers to pointers aren't valid.
+		if typ, _, _, err = d.decodeControl(pointer); err != nil {
+			return nil, 0, err
+		} else if typ == mmdbPointer {
+			return nil, 0, errMmdbInvalid
+		}
+		value, _, err = d.decode(pointer, depth+1)
+		return value, next, err
+	case mmdbMap:
+		m := make(map[string]interface{})
+		for i := uint(0); i < size; i++ {
+			var key, v interface{}
+			if key, next, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errMmdbInvalid
+			}
+			if v, next, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[k] = v
+		}
+		return m, next, nil
+	case mmdbArray:
+		var a []interface{}
+		for i := uint(0); i < size; i++ {
+			var v interface{}
+			if v, next, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, next, nil
+	case mmdbBool:
+		if size > 1 {
+			return nil, 0, errMmdbInvalid
+		}
+		return size == 1, next, nil
+	}
+
+	// The remaining types are stored in the following size bytes.
+	if next+size > uint(len(d)) {
+		return nil, 0, errMmdbInvalid
+	}
+	b := d[next : next+size]
+	next += size
+	switch typ {
+	case mmdbString:
+		return string(b), next, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errMmdbInvalid
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errMmdbInvalid
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		maxSize := uint(4)
+		switch typ {
+		case mmdbUint16:
+			maxSize = 2
+		case mmdbUint64:
+			maxSize = 8
+		}
+		if size > maxSize {
+			return nil, 0, errMmdbInvalid
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		if typ == mmdbInt32 {
+			// Shorter values are always positive.
+			return int64(int32(n)), next, nil
+		}
+		return n, next, nil
+	case mmdbUint128:
+		if size > 16 {
+			return nil, 0, errMmdbInvalid
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	}
+	return nil, 0, errMmdbInvalid
+}
+
+// Finds the value of the field at the offset following a path of map keys
+// (strings) and array indexes (ints). Returns false if there is no such
+// value.
+func (d mmdbData) find(offset uint, path []interface{}) (value interface{},
+	found bool, err error) {
+
+	for _, elem := range path {
+		if offset, err = d.resolve(offset); err != nil {
+			return nil, false, err
+		}
+		typ, size, next, err := d.decodeControl(offset)
+		if err != nil {
+			return nil, false, err
+		}
+		switch e := elem.(type) {
+		case string:
+			if typ != mmdbMap {
+				return nil, false, nil
+			}
+			found = false
+			for i := uint(0); i < size && !found; i++ {
+				var key interface{}
+				if key, next, err = d.decode(next, 0); err != nil {
+					return nil, false, err
+				}
+				if k, ok := key.(string); ok && k == e {
+					found = true
+				} else if next, err = d.skip(next, 0); err != nil {
+					return nil, false, err
+				}
+			}
+			if !found {
+				return nil, false, nil
+			}
+		case int:
+			if typ != mmdbArray || uint(e) >= size {
+				return nil, false, nil
+			}
+			for i := 0; i < e; i++ {
+				if next, err = d.skip(next, 0); err != nil {
+					return nil, false, err
+				}
+			}
+		}
+		offset = next
+	}
+	value, _, err = d.decode(offset, 0)
+	return value, err == nil, err
+}
+
+// Reader of the MaxMind DB format used by the GeoIP2 and GeoLite2 databases,
+// see http://maxmind.github.io/MaxMind-DB/.
+type mmdbReader struct {
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	tree         []byte
+	data         mmdbData
+	// Node of the IPv4 addresses in IPv6 trees, i.e. after 96 zero bits.
+	ipv4Start uint
+}
+
+// Loads a MaxMind DB file in memory.
+func openMmdb(path string) (*mmdbReader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newMmdbReader(buf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB file '%s': %s", path, err)
+	}
+	return r, nil
+}
+
+func newMmdbReader(buf []byte) (*mmdbReader, error) {
+	start := 0
+	if len(buf) > mmdbMetadataMaxSize {
+		start = len(buf) - mmdbMetadataMaxSize
+	}
+	i := bytes.LastIndex(buf[start:], mmdbMetadataMarker)
+	if i < 0 {
+		return nil, errors.New("metadata not found")
+	}
+	dataEnd := start + i
+	value, _, err := mmdbData(buf[dataEnd+len(mmdbMetadataMarker):]).decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+	getUint := func(key string) uint {
+		n, _ := metadata[key].(uint64)
+		return uint(n)
+	}
+
+	r := &mmdbReader{
+		nodeCount:  getUint("node_count"),
+		recordSize: getUint("record_size"),
+		ipVersion:  getUint("ip_version"),
+	}
+	r.databaseType, _ = metadata["database_type"].(string)
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size: %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version: %d", r.ipVersion)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if r.nodeCount == 0 || treeSize+mmdbDataSeparatorSize > uint(dataEnd) {
+		return nil, errors.New("invalid node count")
+	}
+	r.tree = buf[:treeSize]
+	r.data = mmdbData(buf[treeSize+mmdbDataSeparatorSize : dataEnd])
+
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// Returns the left (bit 0) or right (bit 1) record of a search tree node.
+func (r *mmdbReader) record(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.tree[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.tree[node*8+bit*4:]))
+	}
+}
+
+// Returns the data offset of the record of an IP address, false if the
+// address isn't in the database.
+func (r *mmdbReader) lookup(ip net.IP) (offset uint, found bool, err error) {
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, node = ip4, r.ipv4Start
+	} else if r.ipVersion == 4 || len(ip) != net.IPv6len {
+		return 0, false, nil
+	}
+	for i := uint(0); i < uint(len(ip))*8 && node < r.nodeCount; i++ {
+		node = r.record(node, uint(ip[i/8]>>(7-i%8))&1)
+	}
+	if node == r.nodeCount {
+		return 0, false, nil
+	}
+	if node < r.nodeCount+mmdbDataSeparatorSize {
+		return 0, false, errMmdbInvalid
+	}
+	offset = node - r.nodeCount - mmdbDataSeparatorSize
+	if offset >= uint(len(r.data)) {
+		return 0, false, errMmdbInvalid
+	}
+	return offset, true, nil
+}
@@ -0,0 +1,467 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package geoip
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Writer of the data section of MaxMind DB files, repeated strings are
+// written as pointers.
+type mmdbTestWriter struct {
+	data    []byte
+	strings map[string]int
+}
+
+func newMmdbTestWriter() *mmdbTestWriter {
+	return &mmdbTestWriter{strings: make(map[string]int)}
+}
+
+func (w *mmdbTestWriter) control(typ, size int) {
+	var ext []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		ext = []byte{byte(size - 29)}
+		size = 29
+	case size < 65821:
+		ext = []byte{byte((size - 285) >> 8), byte(size - 285)}
+		size = 30
+	default:
+		n := size - 65821
+		ext = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+		size = 31
+	}
+	if typ < 8 {
+		w.data = append(w.data, byte(typ<<5|size))
+	} else {
+		w.data = append(w.data, byte(size), byte(typ-7))
+	}
+	w.data = append(w.data, ext...)
+}
+
+func (w *mmdbTestWriter) pointer(offset int) {
+	switch {
+	case offset < 2048:
+		w.data = append(w.data, byte(1<<5|offset>>8), byte(offset))
+	case offset < 526336:
+		o := offset - 2048
+		w.data = append(w.data, byte(1<<5|1<<3|o>>16), byte(o>>8), byte(o))
+	default:
+		o := offset - 526336
+		w.data = append(w.data, byte(1<<5|2<<3|o>>24), byte(o>>16), byte(o>>8),
+			byte(o))
+	}
+}
+
+func (w *mmdbTestWriter) uint(typ int, n uint64) {
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	w.control(typ, len(b))
+	w.data = append(w.data, b...)
+}
+
+func (w *mmdbTestWriter) write(value interface{}) {
+	switch v := value.(type) {
+	case string:
+		if offset, ok := w.strings[v]; ok {
+			w.pointer(offset)
+			return
+		}
+		w.strings[v] = len(w.data)
+		w.control(mmdbString, len(v))
+		w.data = append(w.data, v...)
+	case []byte:
+		w.control(mmdbBytes, len(v))
+		w.data = append(w.data, v...)
+	case float64:
+		w.control(mmdbDouble, 8)
+		w.data = append(w.data, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(w.data[len(w.data)-8:], math.Float64bits(v))
+	case float32:
+		w.control(mmdbFloat, 4)
+		w.data = append(w.data, make([]byte, 4)...)
+		binary.BigEndian.PutUint32(w.data[len(w.data)-4:], math.Float32bits(v))
+	case uint16:
+		w.uint(mmdbUint16, uint64(v))
+	case uint32:
+		w.uint(mmdbUint32, uint64(v))
+	case uint64:
+		w.uint(mmdbUint64, v)
+	case int32:
+		w.uint(mmdbInt32, uint64(uint32(v)))
+	case bool:
+		if v {
+			w.control(mmdbBool, 1)
+		} else {
+			w.control(mmdbBool, 0)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w.control(mmdbMap, len(v))
+		for _, k := range keys {
+			w.write(k)
+			w.write(v[k])
+		}
+	case []interface{}:
+		w.control(mmdbArray, len(v))
+		for _, item := range v {
+			w.write(item)
+		}
+	default:
+		panic("unsupported value")
+	}
+}
+
+type mmdbTestNetwork struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+// Builds a MaxMind DB file holding the networks, which must be given from
+// the least to the most specific.
+func buildTestMmdb(ipVersion, recordSize int, databaseType string,
+	networks []mmdbTestNetwork) []byte {
+
+	// Child node indexes and data offsets + 1 of each node, 0 if none.
+	type node struct {
+		next [2]int
+		data [2]int
+	}
+	nodes := []*node{new(node)}
+	w := newMmdbTestWriter()
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			panic(err)
+		}
+		ones, bits := ipNet.Mask.Size()
+		ip := ipNet.IP
+		if bits == 32 {
+			ip = ip.To4()
+			if ipVersion == 6 {
+				ip, ones = append(make(net.IP, 12), ip...), ones+96
+			}
+		}
+		offset := len(w.data) + 1
+		w.write(network.record)
+		n := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[n].data[bit] = offset
+				break
+			}
+			if nodes[n].next[bit] == 0 {
+				// Less specific networks are pushed down.
+				child := new(node)
+				if d := nodes[n].data[bit]; d != 0 {
+					child.data = [2]int{d, d}
+					nodes[n].data[bit] = 0
+				}
+				nodes = append(nodes, child)
+				nodes[n].next[bit] = len(nodes) - 1
+			}
+			n = nodes[n].next[bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	var buf []byte
+	for _, n := range nodes {
+		var records [2]uint32
+		for bit := range records {
+			switch {
+			case n.next[bit] != 0:
+				records[bit] = uint32(n.next[bit])
+			case n.data[bit] != 0:
+				records[bit] = uint32(nodeCount + mmdbDataSeparatorSize + n.data[bit] - 1)
+			default:
+				records[bit] = uint32(nodeCount)
+			}
+		}
+		l, r := records[0], records[1]
+		switch recordSize {
+		case 24:
+			buf = append(buf, byte(l>>16), byte(l>>8), byte(l), byte(r>>16),
+				byte(r>>8), byte(r))
+		case 28:
+			buf = append(buf, byte(l>>16), byte(l>>8), byte(l),
+				byte(l>>24<<4|r>>24&0x0f), byte(r>>16), byte(r>>8), byte(r))
+		default:
+			buf = append(buf, make([]byte, 8)...)
+			binary.BigEndian.PutUint32(buf[len(buf)-8:], l)
+			binary.BigEndian.PutUint32(buf[len(buf)-4:], r)
+		}
+	}
+	buf = append(buf, make([]byte, mmdbDataSeparatorSize)...)
+	buf = append(buf, w.data...)
+	buf = append(buf, mmdbMetadataMarker...)
+	meta := newMmdbTestWriter()
+	meta.write(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1442403022),
+		"database_type":               databaseType,
+		"description":                 map[string]interface{}{"en": "Test"},
+		"ip_version":                  uint16(ipVersion),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+	return append(buf, meta.data...)
+}
+
+func MmdbSpec(c gs.Context) {
+	c.Specify("MaxMind DB data", func() {
+		c.Specify("decodes all the types", func() {
+			w := newMmdbTestWriter()
+			long := strings.Repeat("x", 300)
+			longer := strings.Repeat("y", 70000)
+			w.write(map[string]interface{}{
+				"string":   "héllo",
+				"long":     long,
+				"longer":   longer,
+				"repeated": []interface{}{"héllo", long, longer},
+				"bytes":    []byte{1, 2, 3},
+				"double":   -122.0574,
+				"float":    float32(1.5),
+				"uint16":   uint16(650),
+				"uint32":   uint32(0),
+				"uint64":   uint64(math.MaxUint64),
+				"int32":    int32(-5),
+				"positive": int32(94043),
+				"true":     true,
+				"false":    false,
+				"empty":    map[string]interface{}{},
+			})
+			// uint128 w/ an extended type.
+			w.data[0]++
+			w.write("uint128")
+			w.data = append(w.data, 0x03, 0x03, 0x01, 0x00, 0x00)
+
+			value, next, err := mmdbData(w.data).decode(0, 0)
+			c.Assume(err, gs.IsNil)
+			c.Expect(next, gs.Equals, uint(len(w.data)))
+			m := value.(map[string]interface{})
+			c.Expect(len(m), gs.Equals, 16)
+			c.Expect(m["string"], gs.Equals, "héllo")
+			c.Expect(m["long"], gs.Equals, long)
+			c.Expect(m["longer"], gs.Equals, longer)
+			repeated := m["repeated"].([]interface{})
+			c.Expect(len(repeated), gs.Equals, 3)
+			c.Expect(repeated[0], gs.Equals, "héllo")
+			c.Expect(repeated[1], gs.Equals, long)
+			c.Expect(repeated[2], gs.Equals, longer)
+			c.Expect(string(m["bytes"].([]byte)), gs.Equals, "\x01\x02\x03")
+			c.Expect(m["double"], gs.Equals, -122.0574)
+			c.Expect(m["float"], gs.Equals, 1.5)
+			c.Expect(m["uint16"], gs.Equals, uint64(650))
+			c.Expect(m["uint32"], gs.Equals, uint64(0))
+			c.Expect(m["uint64"], gs.Equals, uint64(math.MaxUint64))
+			c.Expect(m["int32"], gs.Equals, int64(-5))
+			c.Expect(m["positive"], gs.Equals, int64(94043))
+			c.Expect(m["true"], gs.Equals, true)
+			c.Expect(m["false"], gs.Equals, false)
+			c.Expect(len(m["empty"].(map[string]interface{})), gs.Equals, 0)
+			c.Expect(m["uint128"].(*big.Int).Cmp(big.NewInt(0x10000)), gs.Equals, 0)
+
+			next, err = mmdbData(w.data).skip(0, 0)
+			c.Expect(err, gs.IsNil)
+			c.Expect(next, gs.Equals, uint(len(w.data)))
+		})
+
+		c.Specify("decodes pointers of all sizes", func() {
+			d := mmdbData{
+				0x25, 0xff,
+				0x2b, 0x01, 0x02,
+				0x31, 0x01, 0x02, 0x03,
+				0x3f, 0x01, 0x02, 0x03, 0x04,
+			}
+			pointers := []uint{1535, 3<<16 | 0x0102 + 2048,
+				1<<24 | 0x010203 + 526336, 0x01020304}
+			offset := uint(0)
+			for _, expected := range pointers {
+				typ, bits, next, err := d.decodeControl(offset)
+				c.Assume(err, gs.IsNil)
+				c.Expect(typ, gs.Equals, mmdbPointer)
+				pointer, next, err := d.decodePointer(bits, next)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pointer, gs.Equals, expected)
+				offset = next
+			}
+			c.Expect(offset, gs.Equals, uint(len(d)))
+		})
+
+		c.Specify("finds values by path", func() {
+			w := newMmdbTestWriter()
+			w.write(map[string]interface{}{
+				"city": map[string]interface{}{
+					"names": map[string]interface{}{"de": "Köln", "en": "Cologne"},
+				},
+				"country": map[string]interface{}{
+					"names": map[string]interface{}{"de": "Deutschland", "en": "Germany"},
+				},
+				"subdivisions": []interface{}{
+					map[string]interface{}{"iso_code": "NW"},
+					map[string]interface{}{"iso_code": "K"},
+				},
+			})
+			d := mmdbData(w.data)
+			tests := []struct {
+				path  []interface{}
+				value interface{}
+			}{
+				{[]interface{}{"city", "names", "en"}, "Cologne"},
+				{[]interface{}{"country", "names", "de"}, "Deutschland"},
+				{[]interface{}{"subdivisions", 0, "iso_code"}, "NW"},
+				{[]interface{}{"subdivisions", 1, "iso_code"}, "K"},
+				{[]interface{}{"subdivisions", 2, "iso_code"}, nil},
+				{[]interface{}{"city", "names", "fr"}, nil},
+				{[]interface{}{"city", 0}, nil},
+				{[]interface{}{"postal", "code"}, nil},
+			}
+			for _, test := range tests {
+				value, found, err := d.find(0, test.path)
+				c.Expect(err, gs.IsNil)
+				c.Expect(found, gs.Equals, test.value != nil)
+				c.Expect(value, gs.Equals, test.value)
+			}
+			value, found, err := d.find(0, []interface{}{"subdivisions", 1})
+			c.Expect(err, gs.IsNil)
+			c.Expect(found, gs.IsTrue)
+			c.Expect(value.(map[string]interface{})["iso_code"], gs.Equals, "K")
+		})
+
+		c.Specify("rejects invalid data", func() {
+			for _, data := range []mmdbData{
+				{},
+				{0x44, 'a', 'b'},         // truncated string
+				{0xe1, 0x44},             // truncated map
+				{0x20},                   // truncated pointer
+				{0x20, 0x00},             // pointer to a pointer
+				{0xa3, 0x00, 0x00, 0x00}, // uint16 w/ 3 bytes
+				{0x61, 0x00},             // double w/ 1 byte
+				{0xe1, 0xa1, 0x00, 0x41}, // map w/ a uint16 key
+				{0x02, 0x07},             // boolean of size 2
+				{0x5e, 0x01},             // truncated size
+				{0x00, 0x05, 0x00, 0x00}, // data cache container
+			} {
+				_, _, err := data.decode(0, 0)
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+			deep := mmdbData(strings.Repeat("\x01\x04", 40) + "\x40")
+			_, _, err := deep.decode(0, 0)
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = deep.skip(0, 0)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A MaxMind DB reader", func() {
+		networks := []mmdbTestNetwork{
+			{"1.2.0.0/16", map[string]interface{}{"name": "Alpha"}},
+			{"1.2.3.0/24", map[string]interface{}{"name": "Beta"}},
+			{"1.2.3.4/32", map[string]interface{}{"name": "Delta"}},
+			{"2001:db8::/32", map[string]interface{}{"name": "Gamma"}},
+		}
+		lookup := func(r *mmdbReader, ip string) interface{} {
+			offset, found, err := r.lookup(net.ParseIP(ip))
+			c.Assume(err, gs.IsNil)
+			if !found {
+				return nil
+			}
+			value, _, err := r.data.find(offset, []interface{}{"name"})
+			c.Assume(err, gs.IsNil)
+			return value
+		}
+
+		c.Specify("looks up IPv4 and IPv6 addresses", func() {
+			for _, recordSize := range []int{24, 28, 32} {
+				r, err := newMmdbReader(buildTestMmdb(6, recordSize, "Test",
+					networks))
+				c.Assume(err, gs.IsNil)
+				c.Expect(r.databaseType, gs.Equals, "Test")
+				c.Expect(r.recordSize, gs.Equals, uint(recordSize))
+				c.Expect(lookup(r, "1.2.200.1"), gs.Equals, "Alpha")
+				c.Expect(lookup(r, "1.2.3.200"), gs.Equals, "Beta")
+				c.Expect(lookup(r, "1.2.3.4"), gs.Equals, "Delta")
+				c.Expect(lookup(r, "::ffff:1.2.3.5"), gs.Equals, "Beta")
+				c.Expect(lookup(r, "2001:db8:1::1"), gs.Equals, "Gamma")
+				c.Expect(lookup(r, "2001:db9::1"), gs.IsNil)
+				c.Expect(lookup(r, "1.3.0.0"), gs.IsNil)
+				c.Expect(lookup(r, "8.8.8.8"), gs.IsNil)
+			}
+		})
+
+		c.Specify("looks up IPv4 addresses in IPv4 databases", func() {
+			r, err := newMmdbReader(buildTestMmdb(4, 24, "Test", networks[:3]))
+			c.Assume(err, gs.IsNil)
+			c.Expect(lookup(r, "1.2.3.4"), gs.Equals, "Delta")
+			c.Expect(lookup(r, "1.2.3.5"), gs.Equals, "Beta")
+			c.Expect(lookup(r, "2001:db8::1"), gs.IsNil)
+		})
+
+		c.Specify("reads 28 bit records", func() {
+			r := &mmdbReader{recordSize: 28,
+				tree: []byte{0x12, 0x34, 0x56, 0xab, 0x78, 0x9a, 0xbc}}
+			c.Expect(r.record(0, 0), gs.Equals, uint(0xa123456))
+			c.Expect(r.record(0, 1), gs.Equals, uint(0xb789abc))
+		})
+
+		c.Specify("rejects invalid files", func() {
+			valid := buildTestMmdb(6, 24, "Test", networks)
+			_, err := newMmdbReader(valid[:len(valid)/2])
+			c.Expect(err, gs.Not(gs.IsNil))
+
+			for _, test := range []struct {
+				key   string
+				value interface{}
+			}{
+				{"record_size", uint16(20)},
+				{"ip_version", uint16(5)},
+				{"node_count", uint32(0)},
+				{"node_count", uint32(1000)},
+			} {
+				data := append([]byte(nil), valid...)
+				i := strings.LastIndex(string(data), string(mmdbMetadataMarker))
+				data = data[:i+len(mmdbMetadataMarker)]
+				meta := map[string]interface{}{
+					"ip_version":  uint16(6),
+					"node_count":  uint32(5),
+					"record_size": uint16(24),
+				}
+				meta[test.key] = test.value
+				w := newMmdbTestWriter()
+				w.write(meta)
+				_, err = newMmdbReader(append(data, w.data...))
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+	})
+}
@@ -15,6 +15,7 @@
 package kafka
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
@@ -172,6 +173,15 @@ func getFieldAsString(msg *message.Message, mvar *messageVariable) string {
 			return ""
 		}
 		return fmt.Sprintf("%t", field.ValueBool[mvar.ai])
+	case message.Field_OBJECT:
+		if mvar.ai >= len(field.ValueObject) {
+			return ""
+		}
+		b, err := json.Marshal(field.ValueObject[mvar.ai].Map())
+		if err != nil {
+			return ""
+		}
+		return string(b)
 	}
 	return ""
 }
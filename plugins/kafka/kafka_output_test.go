@@ -64,11 +64,13 @@ func TestGetMessageVariable(t *testing.T) {
 	field3, _ := message.NewField("double", float64(99.9), "")
 	field4, _ := message.NewField("bool", true, "")
 	field5, _ := message.NewField("foo", "alternate", "")
+	field6, _ := message.NewField("object", map[string]interface{}{"a": "b"}, "")
 	msg.AddField(field1)
 	msg.AddField(field2)
 	msg.AddField(field3)
 	msg.AddField(field4)
 	msg.AddField(field5)
+	msg.AddField(field6)
 
 	tests := []string{
 		"Type",
@@ -82,6 +84,7 @@ func TestGetMessageVariable(t *testing.T) {
 		"Fields[bool]",
 		"Fields[foo][1]",
 		"Fields[int][0][1]",
+		"Fields[object]",
 	}
 	results := []string{
 		"TEST",
@@ -95,6 +98,7 @@ func TestGetMessageVariable(t *testing.T) {
 		"true",
 		"alternate",
 		"1024",
+		`{"a":"b"}`,
 	}
 
 	for i, v := range tests {
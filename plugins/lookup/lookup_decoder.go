@@ -0,0 +1,208 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package lookup
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type LookupDecoderConfig struct {
+	// Lookup file, relative paths are relative to the share_dir.
+	LookupFile string `toml:"lookup_file"`
+
+	// File format, "csv" or "json", defaults to the file extension.
+	Format string `toml:"format"`
+
+	// Field holding the key, or one of the Type, Logger, Hostname, Payload
+	// and EnvVersion headers.
+	KeyField string `toml:"key_field"`
+
+	// Column holding the keys, defaults to the first CSV column.
+	KeyColumn string `toml:"key_column"`
+
+	// Key matching, "exact", "prefix" or "cidr".
+	Match string `toml:"match"`
+
+	// Prefix of the names of the fields added from the table columns.
+	FieldPrefix string `toml:"field_prefix"`
+
+	// CSV field delimiter.
+	Delimiter string `toml:"delimiter"`
+
+	// Number of seconds between the checks for changes of the lookup file,
+	// 0 disables the reloading.
+	ReloadInterval uint `toml:"reload_interval"`
+}
+
+type LookupDecoder struct {
+	pConfig   *PipelineConfig
+	dRunner   DecoderRunner
+	path      string
+	format    string
+	keyField  string
+	keyColumn string
+	match     string
+	prefix    string
+	delimiter rune
+	table     *lookupTable
+	watcher   *FileWatcher
+	now       func() time.Time
+}
+
+func (ld *LookupDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
+	ld.pConfig = pConfig
+}
+
+func (ld *LookupDecoder) SetDecoderRunner(dr DecoderRunner) {
+	ld.dRunner = dr
+}
+
+func (ld *LookupDecoder) ConfigStruct() interface{} {
+	return &LookupDecoderConfig{
+		Match:          "exact",
+		Delimiter:      ",",
+		ReloadInterval: 10,
+	}
+}
+
+func (ld *LookupDecoder) Init(config interface{}) (err error) {
+	conf := config.(*LookupDecoderConfig)
+	if conf.LookupFile == "" {
+		return errors.New("`lookup_file` must be specified")
+	}
+	if conf.KeyField == "" {
+		return errors.New("`key_field` must be specified")
+	}
+	ld.path = ld.pConfig.Globals.PrependShareDir(conf.LookupFile)
+	ld.format = conf.Format
+	if ld.format == "" {
+		ld.format = strings.TrimPrefix(strings.ToLower(filepath.Ext(ld.path)), ".")
+	}
+	if ld.format != "csv" && ld.format != "json" {
+		return fmt.Errorf("unsupported lookup file format: '%s'", ld.format)
+	}
+	switch conf.Match {
+	case "exact", "prefix", "cidr":
+		ld.match = conf.Match
+	default:
+		return fmt.Errorf("unsupported match: '%s'", conf.Match)
+	}
+	if utf8.RuneCountInString(conf.Delimiter) != 1 {
+		return errors.New("`delimiter` must be a single character")
+	}
+	ld.delimiter, _ = utf8.DecodeRuneInString(conf.Delimiter)
+	ld.keyField = conf.KeyField
+	ld.keyColumn = conf.KeyColumn
+	ld.prefix = conf.FieldPrefix
+	ld.watcher = &FileWatcher{
+		Path:     ld.path,
+		Interval: time.Duration(conf.ReloadInterval) * time.Second,
+		Now:      ld.now,
+	}
+	if err = ld.watcher.Load(ld.load); err != nil {
+		return fmt.Errorf("LookupDecoder %s", err)
+	}
+	return
+}
+
+func (ld *LookupDecoder) load() error {
+	table, err := loadLookupTable(ld.path, ld.format, ld.match, ld.keyColumn,
+		ld.delimiter)
+	if err != nil {
+		return err
+	}
+	ld.table = table
+	return nil
+}
+
+// Reloads the lookup table if the file has changed since it was loaded. The
+// current table is kept if the file can't be loaded.
+func (ld *LookupDecoder) reloadIfChanged() {
+	reloaded, err := ld.watcher.ReloadIfChanged(ld.load)
+	switch {
+	case err != nil && !reloaded:
+		ld.dRunner.LogError(fmt.Errorf("can't check lookup file: %s", err))
+	case err != nil:
+		ld.dRunner.LogError(fmt.Errorf("can't reload lookup file: %s", err))
+	case reloaded:
+		ld.dRunner.LogMessage(fmt.Sprintf("reloaded lookup file '%s'", ld.path))
+	}
+}
+
+func (ld *LookupDecoder) key(msg *message.Message) (string, bool) {
+	switch ld.keyField {
+	case "Type":
+		return msg.GetType(), true
+	case "Logger":
+		return msg.GetLogger(), true
+	case "Hostname":
+		return msg.GetHostname(), true
+	case "Payload":
+		return msg.GetPayload(), true
+	case "EnvVersion":
+		return msg.GetEnvVersion(), true
+	}
+	value, _ := msg.GetFieldValue(ld.keyField)
+	s, ok := value.(string)
+	return s, ok
+}
+
+func (ld *LookupDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	ld.reloadIfChanged()
+	packs = []*PipelinePack{pack}
+	key, ok := ld.key(pack.Message)
+	if !ok || key == "" {
+		return
+	}
+	row := ld.table.lookup(key)
+	if row == nil {
+		return
+	}
+
+	// Sort the columns so the fields are always added in the same order.
+	names := make([]string, 0, len(row))
+	for name := range row {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		name = ld.prefix + name
+		// Existing fields are left untouched.
+		if pack.Message.FindFirstField(name) != nil {
+			continue
+		}
+		f, err := message.NewField(name, row[name[len(ld.prefix):]], "")
+		if err != nil {
+			return nil, fmt.Errorf("field creation error: %s", err)
+		}
+		pack.Message.AddField(f)
+	}
+	return
+}
+
+func init() {
+	RegisterPlugin("LookupDecoder", func() interface{} {
+		return new(LookupDecoder)
+	})
+}
@@ -0,0 +1,293 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package lookup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func LookupDecoderSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A LookupDecoder", func() {
+		dir, err := ioutil.TempDir("", "lookup")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(dir)
+
+		decoder := new(LookupDecoder)
+		decoder.SetPipelineConfig(NewPipelineConfig(nil))
+		dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+		decoder.SetDecoderRunner(dRunner)
+		now := time.Unix(1442403022, 0)
+		decoder.now = func() time.Time { return now }
+		conf := decoder.ConfigStruct().(*LookupDecoderConfig)
+		conf.KeyField = "host"
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		writeFile := func(name, data string) string {
+			path := filepath.Join(dir, name)
+			err := ioutil.WriteFile(path, []byte(data), 0644)
+			c.Assume(err, gs.IsNil)
+			return path
+		}
+		decode := func(key string) *message.Message {
+			pack.Message = new(message.Message)
+			f, _ := message.NewField("host", key, "")
+			pack.Message.AddField(f)
+			packs, err := decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+			return packs[0].Message
+		}
+		getValue := func(msg *message.Message, name string) interface{} {
+			value, _ := msg.GetFieldValue(name)
+			return value
+		}
+
+		hostsCsv := "host,team,datacenter\n" +
+			"web1.example.com,web,us-east\n" +
+			"db1.example.com,storage,\n"
+
+		c.Specify("adds the columns of exactly matching CSV rows", func() {
+			conf.LookupFile = writeFile("hosts.csv", "\xef\xbb\xbf"+hostsCsv)
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			msg := decode("web1.example.com")
+			c.Expect(len(msg.Fields), gs.Equals, 3)
+			c.Expect(msg.Fields[1].GetName(), gs.Equals, "datacenter")
+			c.Expect(getValue(msg, "datacenter"), gs.Equals, "us-east")
+			c.Expect(getValue(msg, "team"), gs.Equals, "web")
+
+			// Empty values aren't added.
+			msg = decode("db1.example.com")
+			c.Expect(len(msg.Fields), gs.Equals, 2)
+			c.Expect(getValue(msg, "team"), gs.Equals, "storage")
+
+			msg = decode("web1.example.com.evil")
+			c.Expect(len(msg.Fields), gs.Equals, 1)
+		})
+
+		c.Specify("uses the key column, delimiter and field prefix", func() {
+			conf.LookupFile = writeFile("hosts.txt",
+				"team;host\nweb;web1.example.com\n")
+			conf.Format = "csv"
+			conf.KeyColumn = "host"
+			conf.Delimiter = ";"
+			conf.FieldPrefix = "lookup_"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			msg := decode("web1.example.com")
+			c.Expect(len(msg.Fields), gs.Equals, 2)
+			c.Expect(getValue(msg, "lookup_team"), gs.Equals, "web")
+		})
+
+		c.Specify("doesn't overwrite existing fields", func() {
+			conf.LookupFile = writeFile("hosts.csv", hostsCsv)
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			pack.Message = new(message.Message)
+			f, _ := message.NewField("host", "web1.example.com", "")
+			pack.Message.AddField(f)
+			f, _ = message.NewField("team", "ops", "")
+			pack.Message.AddField(f)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(getValue(pack.Message, "team"), gs.Equals, "ops")
+			c.Expect(getValue(pack.Message, "datacenter"), gs.Equals, "us-east")
+		})
+
+		c.Specify("reads the key from the message headers", func() {
+			conf.LookupFile = writeFile("hosts.csv", hostsCsv)
+			conf.KeyField = "Hostname"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			pack.Message = new(message.Message)
+			pack.Message.SetHostname("db1.example.com")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(getValue(pack.Message, "team"), gs.Equals, "storage")
+		})
+
+		c.Specify("passes messages w/o key through", func() {
+			conf.LookupFile = writeFile("hosts.csv", hostsCsv)
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			pack.Message = new(message.Message)
+			packs, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+			c.Expect(len(pack.Message.Fields), gs.Equals, 0)
+		})
+
+		c.Specify("matches the longest prefix of JSON rows", func() {
+			conf.LookupFile = writeFile("codes.json", `{
+				"E1": {"category": "network", "severity": 3},
+				"E12": {"category": "dns", "retry": true},
+				"E2": {"category": "disk", "ratio": 0.5, "note": null}
+			}`)
+			conf.KeyField = "code"
+			conf.Match = "prefix"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			decodeCode := func(code string) *message.Message {
+				pack.Message = new(message.Message)
+				f, _ := message.NewField("code", code, "")
+				pack.Message.AddField(f)
+				_, err := decoder.Decode(pack)
+				c.Assume(err, gs.IsNil)
+				return pack.Message
+			}
+			msg := decodeCode("E1234")
+			c.Expect(getValue(msg, "category"), gs.Equals, "dns")
+			c.Expect(getValue(msg, "retry"), gs.Equals, true)
+			msg = decodeCode("E19")
+			c.Expect(getValue(msg, "category"), gs.Equals, "network")
+			c.Expect(getValue(msg, "severity"), gs.Equals, int64(3))
+			msg = decodeCode("E2")
+			c.Expect(getValue(msg, "ratio"), gs.Equals, 0.5)
+			c.Expect(len(msg.Fields), gs.Equals, 3)
+			msg = decodeCode("E3")
+			c.Expect(len(msg.Fields), gs.Equals, 1)
+		})
+
+		c.Specify("loads JSON arrays of rows", func() {
+			conf.LookupFile = writeFile("hosts.json", `[
+				{"host": "web1.example.com", "team": "web"},
+				{"host": "db1.example.com", "team": "storage"}
+			]`)
+			conf.KeyColumn = "host"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			msg := decode("db1.example.com")
+			c.Expect(len(msg.Fields), gs.Equals, 2)
+			c.Expect(getValue(msg, "team"), gs.Equals, "storage")
+		})
+
+		c.Specify("matches the most specific CIDR", func() {
+			conf.LookupFile = writeFile("networks.csv", "network,zone\n"+
+				"10.0.0.0/8,internal\n"+
+				"10.1.0.0/16,office\n"+
+				"10.1.2.3,printer\n"+
+				"2001:db8::/32,ipv6\n")
+			conf.Match = "cidr"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			tests := map[string]interface{}{
+				"10.200.1.1":       "internal",
+				"10.1.200.1":       "office",
+				"10.1.2.3":         "printer",
+				"2001:db8::1":      "ipv6",
+				"::ffff:10.1.2.3":  "printer",
+				"192.168.1.1":      nil,
+				"not.an.ip.string": nil,
+			}
+			for ip, zone := range tests {
+				c.Expect(getValue(decode(ip), "zone"), gs.Equals, zone)
+			}
+		})
+
+		c.Specify("reloads the table when the file changes", func() {
+			conf.LookupFile = writeFile("hosts.csv", hostsCsv)
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			writeFile("hosts.csv", "host,team\nweb1.example.com,frontend\n")
+			// Not checked before the reload interval has passed.
+			now = now.Add(5 * time.Second)
+			c.Expect(getValue(decode("web1.example.com"), "team"), gs.Equals, "web")
+
+			now = now.Add(5 * time.Second)
+			dRunner.EXPECT().LogMessage(gomock.Any())
+			msg := decode("web1.example.com")
+			c.Expect(getValue(msg, "team"), gs.Equals, "frontend")
+			c.Expect(getValue(msg, "datacenter"), gs.IsNil)
+
+			// Invalid files are reported and the current table is kept.
+			writeFile("hosts.csv", "host,team\n\"web1.example.com,broken\n")
+			now = now.Add(10 * time.Second)
+			dRunner.EXPECT().LogError(gomock.Any())
+			c.Expect(getValue(decode("web1.example.com"), "team"), gs.Equals,
+				"frontend")
+		})
+
+		c.Specify("doesn't reload w/ a zero reload interval", func() {
+			conf.LookupFile = writeFile("hosts.csv", hostsCsv)
+			conf.ReloadInterval = 0
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			writeFile("hosts.csv", "host,team\nweb1.example.com,frontend\n")
+			now = now.Add(time.Hour)
+			c.Expect(getValue(decode("web1.example.com"), "team"), gs.Equals, "web")
+		})
+
+		c.Specify("fails on invalid settings", func() {
+			conf.LookupFile = writeFile("hosts.csv", hostsCsv)
+			c.Assume(decoder.Init(conf), gs.IsNil)
+
+			for _, test := range []struct{ name, data string }{
+				{"empty.csv", ""},
+				{"cidr.csv", "network,zone\n10.0.0.0/33,internal\n"},
+				{"nested.json", `{"a": {"b": {"c": 1}}}`},
+				{"array.json", `[{"team": "web"}]`},
+				{"scalar.json", `42`},
+				{"hosts.xml", "<hosts/>"},
+			} {
+				conf.LookupFile = writeFile(test.name, test.data)
+				if test.name == "cidr.csv" {
+					conf.Match = "cidr"
+				}
+				c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+				conf.Match = "exact"
+			}
+
+			conf.LookupFile = filepath.Join(dir, "missing.csv")
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.LookupFile = filepath.Join(dir, "hosts.csv")
+			conf.KeyColumn = "missing"
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.KeyColumn = ""
+			conf.Match = "regex"
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.Match = "exact"
+			conf.Delimiter = ""
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.Delimiter = ","
+			conf.KeyField = ""
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,267 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+/*
+Package lookup provides a decoder enriching messages w/ the values found in a
+CSV or JSON lookup table.
+*/
+package lookup
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Values of a table row by column name, strings for CSV tables and strings,
+// int64, float64 or bool values for JSON tables.
+type lookupRow map[string]interface{}
+
+// Rows indexed by key length, used for the prefix and CIDR matching. The
+// lengths are sorted longest first so the longest match wins.
+type lengthIndex struct {
+	lengths []int
+	rows    map[int]map[string]lookupRow
+}
+
+func (li *lengthIndex) add(length int, key string, row lookupRow) {
+	if li.rows == nil {
+		li.rows = make(map[int]map[string]lookupRow)
+	}
+	rows, ok := li.rows[length]
+	if !ok {
+		rows = make(map[string]lookupRow)
+		li.rows[length] = rows
+		li.lengths = append(li.lengths, length)
+		sort.Sort(sort.Reverse(sort.IntSlice(li.lengths)))
+	}
+	rows[key] = row
+}
+
+type lookupTable struct {
+	match  string
+	exact  map[string]lookupRow
+	prefix lengthIndex
+	ipv4   lengthIndex
+	ipv6   lengthIndex
+}
+
+func newLookupTable(match string) *lookupTable {
+	return &lookupTable{
+		match: match,
+		exact: make(map[string]lookupRow),
+	}
+}
+
+func (t *lookupTable) add(key string, row lookupRow) error {
+	switch t.match {
+	case "prefix":
+		t.prefix.add(len(key), key, row)
+	case "cidr":
+		if !strings.Contains(key, "/") {
+			if strings.Contains(key, ":") {
+				key += "/128"
+			} else {
+				key += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(key)
+		if err != nil {
+			return err
+		}
+		ones, bits := ipNet.Mask.Size()
+		if bits == 32 {
+			t.ipv4.add(ones, string(ipNet.IP.To4()), row)
+		} else {
+			t.ipv6.add(ones, string(ipNet.IP.To16()), row)
+		}
+	default:
+		t.exact[key] = row
+	}
+	return nil
+}
+
+// Returns the row matching the key, nil if there is none.
+func (t *lookupTable) lookup(key string) lookupRow {
+	switch t.match {
+	case "prefix":
+		for _, length := range t.prefix.lengths {
+			if length > len(key) {
+				continue
+			}
+			if row, ok := t.prefix.rows[length][key[:length]]; ok {
+				return row
+			}
+		}
+	case "cidr":
+		ip := net.ParseIP(key)
+		if ip == nil {
+			return nil
+		}
+		index, bits := &t.ipv6, 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, index, bits = ip4, &t.ipv4, 32
+		}
+		for _, ones := range index.lengths {
+			masked := ip.Mask(net.CIDRMask(ones, bits))
+			if row, ok := index.rows[ones][string(masked)]; ok {
+				return row
+			}
+		}
+	default:
+		return t.exact[key]
+	}
+	return nil
+}
+
+// Loads a table from a CSV file whose first line holds the column names.
+func loadCsvTable(r io.Reader, match, keyColumn string, delimiter rune) (
+	*lookupTable, error) {
+
+	reader := csv.NewReader(r)
+	reader.Comma = delimiter
+	columns, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing header line")
+	} else if err != nil {
+		return nil, err
+	}
+	keyIndex := 0
+	if keyColumn != "" {
+		keyIndex = -1
+		for i, column := range columns {
+			if column == keyColumn {
+				keyIndex = i
+			}
+		}
+		if keyIndex == -1 {
+			return nil, fmt.Errorf("key column '%s' not found", keyColumn)
+		}
+	}
+
+	table := newLookupTable(match)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		row := make(lookupRow, len(columns)-1)
+		for i, value := range record {
+			if i != keyIndex && value != "" {
+				row[columns[i]] = value
+			}
+		}
+		if err = table.add(record[keyIndex], row); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+// Loads a table from a JSON file holding either an object mapping the keys
+// to objects of values, or an array of objects including the key column.
+func loadJsonTable(r io.Reader, match, keyColumn string) (*lookupTable, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var data interface{}
+	if err := dec.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	table := newLookupTable(match)
+	add := func(key string, obj interface{}) error {
+		values, ok := obj.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("row '%s' is not an object", key)
+		}
+		row := make(lookupRow, len(values))
+		for name, value := range values {
+			if name == keyColumn {
+				continue
+			}
+			switch v := value.(type) {
+			case json.Number:
+				if n, err := v.Int64(); err == nil {
+					row[name] = n
+				} else if f, err := v.Float64(); err == nil {
+					row[name] = f
+				} else {
+					return err
+				}
+			case string, bool:
+				row[name] = v
+			case nil:
+			default:
+				return fmt.Errorf("row '%s' value '%s' is not a scalar", key, name)
+			}
+		}
+		return table.add(key, row)
+	}
+
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, obj := range v {
+			if err := add(key, obj); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		if keyColumn == "" {
+			return nil, errors.New("key_column is required for arrays of rows")
+		}
+		for i, obj := range v {
+			values, _ := obj.(map[string]interface{})
+			key, ok := values[keyColumn].(string)
+			if !ok {
+				return nil, fmt.Errorf("row %d has no '%s' string", i, keyColumn)
+			}
+			if err := add(key, obj); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, errors.New("not an object or an array")
+	}
+	return table, nil
+}
+
+// Loads the table from a file in the given format, "csv" or "json".
+func loadLookupTable(path, format, match, keyColumn string, delimiter rune) (
+	table *lookupTable, err error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Skip a UTF-8 byte order mark, as written by some spreadsheets.
+	r := bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if format == "json" {
+		table, err = loadJsonTable(r, match, keyColumn)
+	} else {
+		table, err = loadCsvTable(r, match, keyColumn, delimiter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid lookup file '%s': %s", path, err)
+	}
+	return table, nil
+}
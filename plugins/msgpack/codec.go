@@ -0,0 +1,407 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+/*
+Package msgpack provides a decoder, an encoder and a splitter for MessagePack
+encoded maps, including the Fluentd forward protocol.
+*/
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Extension type of the MessagePack timestamps.
+const msgpackTimestampType = -1
+
+var (
+	errMsgpackShort   = errors.New("unexpected end of data")
+	errMsgpackInvalid = errors.New("invalid type byte 0xc1")
+)
+
+// Value of an extension type other than the timestamp.
+type msgpackExt struct {
+	typ  int8
+	data []byte
+}
+
+// Decodes MessagePack values into nil, bool, int64, float64, string, []byte,
+// time.Time, msgpackExt, []interface{} and map[string]interface{} values.
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errMsgpackShort
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// Reads a big endian unsigned integer of n bytes.
+func (r *msgpackReader) readUint(n int) (uint64, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// Reads a length of n bytes, checking it doesn't exceed the remaining data
+// given the minimum size of each item.
+func (r *msgpackReader) readLength(n, itemSize int) (int, error) {
+	v, err := r.readUint(n)
+	if err != nil {
+		return 0, err
+	}
+	if v > uint64(len(r.data)-r.pos)/uint64(itemSize) {
+		return 0, errMsgpackShort
+	}
+	return int(v), nil
+}
+
+func (r *msgpackReader) readValue() (interface{}, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return r.readMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return r.readArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return r.readString(int(c & 0x1f))
+	}
+
+	var n int
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		if n, err = r.readLength(1<<(c-0xc4), 1); err != nil {
+			return nil, err
+		}
+		b, err = r.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0xc7, 0xc8, 0xc9:
+		if n, err = r.readLength(1<<(c-0xc7), 1); err != nil {
+			return nil, err
+		}
+		return r.readExt(n)
+	case 0xca:
+		v, err := r.readUint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := r.readUint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := r.readUint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if v > math.MaxInt64 {
+			return nil, fmt.Errorf("integer %d overflows int64", v)
+		}
+		return int64(v), nil
+	case 0xd0:
+		v, err := r.readUint(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := r.readUint(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := r.readUint(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := r.readUint(8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return r.readExt(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		if n, err = r.readLength(1<<(c-0xd9), 1); err != nil {
+			return nil, err
+		}
+		return r.readString(n)
+	case 0xdc, 0xdd:
+		if n, err = r.readLength(2<<(c-0xdc), 1); err != nil {
+			return nil, err
+		}
+		return r.readArray(n)
+	case 0xde, 0xdf:
+		if n, err = r.readLength(2<<(c-0xde), 2); err != nil {
+			return nil, err
+		}
+		return r.readMap(n)
+	}
+	return nil, errMsgpackInvalid
+}
+
+func (r *msgpackReader) readString(n int) (interface{}, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (r *msgpackReader) readArray(n int) (interface{}, error) {
+	values := make([]interface{}, n)
+	for i := range values {
+		value, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Reads a map, non string keys are formatted as strings.
+func (r *msgpackReader) readMap(n int) (interface{}, error) {
+	values := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case string:
+			values[k] = value
+		case []byte:
+			values[string(k)] = value
+		case int64:
+			values[strconv.FormatInt(k, 10)] = value
+		case map[string]interface{}, []interface{}:
+			return nil, errors.New("map key is not a scalar")
+		default:
+			values[fmt.Sprint(k)] = value
+		}
+	}
+	return values, nil
+}
+
+func (r *msgpackReader) readExt(n int) (interface{}, error) {
+	b, err := r.next(n + 1)
+	if err != nil {
+		return nil, err
+	}
+	typ, data := int8(b[0]), b[1:]
+	if typ != msgpackTimestampType {
+		return msgpackExt{typ, append([]byte(nil), data...)}, nil
+	}
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0), nil
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(data)
+		sec := int64(binary.BigEndian.Uint64(data[4:]))
+		return time.Unix(sec, int64(nsec)), nil
+	}
+	return nil, fmt.Errorf("invalid timestamp length %d", len(data))
+}
+
+// Decodes a single value, which must use all the data.
+func msgpackDecode(data []byte) (interface{}, error) {
+	r := &msgpackReader{data: data}
+	value, err := r.readValue()
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("%d trailing bytes", len(data)-r.pos)
+	}
+	return value, nil
+}
+
+// Returns the size of the value at the beginning of the data.
+func msgpackValueSize(data []byte) (int, error) {
+	r := &msgpackReader{data: data}
+	_, err := r.readValue()
+	return r.pos, err
+}
+
+// Writes a type byte followed by a big endian unsigned integer of n bytes.
+func writeUint(buf *bytes.Buffer, c byte, v uint64, n int) {
+	buf.WriteByte(c)
+	for i := n - 1; i >= 0; i-- {
+		buf.WriteByte(byte(v >> uint(8*i)))
+	}
+}
+
+func writeInt(buf *bytes.Buffer, v int64) {
+	switch {
+	case v >= 0 && v <= 0x7f, v < 0 && v >= -32:
+		buf.WriteByte(byte(v))
+	case v >= 0 && v <= math.MaxUint8:
+		writeUint(buf, 0xcc, uint64(v), 1)
+	case v >= 0 && v <= math.MaxUint16:
+		writeUint(buf, 0xcd, uint64(v), 2)
+	case v >= 0 && v <= math.MaxUint32:
+		writeUint(buf, 0xce, uint64(v), 4)
+	case v >= 0:
+		writeUint(buf, 0xcf, uint64(v), 8)
+	case v >= math.MinInt8:
+		writeUint(buf, 0xd0, uint64(v), 1)
+	case v >= math.MinInt16:
+		writeUint(buf, 0xd1, uint64(v), 2)
+	case v >= math.MinInt32:
+		writeUint(buf, 0xd2, uint64(v), 4)
+	default:
+		writeUint(buf, 0xd3, uint64(v), 8)
+	}
+}
+
+// Writes a length w/ the fix type byte if it fits in the fix bits, otherwise
+// w/ the type byte for 8 (if c8 isn't zero), 16 or 32 bit lengths.
+func writeLength(buf *bytes.Buffer, n int, fix byte, fixMax int, c8 byte, c16 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case c8 != 0 && n <= math.MaxUint8:
+		writeUint(buf, c8, uint64(n), 1)
+	case n <= math.MaxUint16:
+		writeUint(buf, c16, uint64(n), 2)
+	default:
+		writeUint(buf, c16+1, uint64(n), 4)
+	}
+}
+
+func writeExt(buf *bytes.Buffer, typ int8, data []byte) {
+	switch len(data) {
+	case 1, 2, 4, 8, 16:
+		c := byte(0xd4)
+		for n := len(data); n > 1; n >>= 1 {
+			c++
+		}
+		buf.WriteByte(c)
+	default:
+		switch {
+		case len(data) <= math.MaxUint8:
+			writeUint(buf, 0xc7, uint64(len(data)), 1)
+		case len(data) <= math.MaxUint16:
+			writeUint(buf, 0xc8, uint64(len(data)), 2)
+		default:
+			writeUint(buf, 0xc9, uint64(len(data)), 4)
+		}
+	}
+	buf.WriteByte(byte(typ))
+	buf.Write(data)
+}
+
+// Writes a timestamp using the smallest of the timestamp formats.
+func writeTimestamp(buf *bytes.Buffer, t time.Time) {
+	sec, nsec := t.Unix(), uint64(t.Nanosecond())
+	data := make([]byte, 12)
+	switch {
+	case nsec == 0 && sec >= 0 && sec <= math.MaxUint32:
+		binary.BigEndian.PutUint32(data, uint32(sec))
+		data = data[:4]
+	case sec >= 0 && sec < 1<<34:
+		binary.BigEndian.PutUint64(data, nsec<<34|uint64(sec))
+		data = data[:8]
+	default:
+		binary.BigEndian.PutUint32(data, uint32(nsec))
+		binary.BigEndian.PutUint64(data[4:], uint64(sec))
+	}
+	writeExt(buf, msgpackTimestampType, data)
+}
+
+// Encodes a value, map keys are sorted so the output is deterministic.
+func msgpackEncode(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int:
+		writeInt(buf, int64(v))
+	case int32:
+		writeInt(buf, int64(v))
+	case int64:
+		writeInt(buf, v)
+	case float64:
+		writeUint(buf, 0xcb, math.Float64bits(v), 8)
+	case string:
+		writeLength(buf, len(v), 0xa0, 31, 0xd9, 0xda)
+		buf.WriteString(v)
+	case []byte:
+		writeLength(buf, len(v), 0xc4, -1, 0xc4, 0xc5)
+		buf.Write(v)
+	case time.Time:
+		writeTimestamp(buf, v)
+	case msgpackExt:
+		writeExt(buf, v.typ, v.data)
+	case []interface{}:
+		writeLength(buf, len(v), 0x90, 15, 0, 0xdc)
+		for _, item := range v {
+			if err := msgpackEncode(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeLength(buf, len(v), 0x80, 15, 0, 0xde)
+		for _, key := range keys {
+			msgpackEncode(buf, key)
+			if err := msgpackEncode(buf, v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("can't encode %T value", value)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func MsgpackCodecSpec(c gs.Context) {
+	encode := func(value interface{}) []byte {
+		var buf bytes.Buffer
+		err := msgpackEncode(&buf, value)
+		c.Assume(err, gs.IsNil)
+		return buf.Bytes()
+	}
+
+	c.Specify("MessagePack encoding", func() {
+		c.Specify("uses the smallest integer formats", func() {
+			expected := map[int64][]byte{
+				0:              {0x00},
+				127:            {0x7f},
+				-32:            {0xe0},
+				128:            {0xcc, 0x80},
+				-33:            {0xd0, 0xdf},
+				65535:          {0xcd, 0xff, 0xff},
+				-32769:         {0xd2, 0xff, 0xff, 0x7f, 0xff},
+				1 << 32:        {0xcf, 0, 0, 0, 1, 0, 0, 0, 0},
+				math.MinInt64:  {0xd3, 0x80, 0, 0, 0, 0, 0, 0, 0},
+				math.MaxUint32: {0xce, 0xff, 0xff, 0xff, 0xff},
+				math.MinInt16:  {0xd1, 0x80, 0x00},
+			}
+			for v, data := range expected {
+				c.Expect(bytes.Equal(encode(v), data), gs.IsTrue)
+			}
+		})
+
+		c.Specify("encodes maps w/ sorted keys", func() {
+			data := encode(map[string]interface{}{"b": true, "a": int64(1)})
+			c.Expect(bytes.Equal(data, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0xc3}),
+				gs.IsTrue)
+		})
+
+		c.Specify("round trips values", func() {
+			long := strings.Repeat("x", 300)
+			items := make([]interface{}, 20)
+			for i := range items {
+				items[i] = int64(i)
+			}
+			value := map[string]interface{}{
+				"nil":    nil,
+				"bool":   false,
+				"int":    int64(-1 << 40),
+				"float":  0.1,
+				"str":    "hello",
+				"str8":   strings.Repeat("y", 32),
+				"str16":  long,
+				"bin":    []byte{0, 1, 2},
+				"array":  items,
+				"nested": map[string]interface{}{"a": []interface{}{"b"}},
+				"time":   time.Unix(1442417870, 123456789),
+				"ext":    msgpackExt{5, []byte{1, 2, 3}},
+			}
+			decoded, err := msgpackDecode(encode(value))
+			c.Assume(err, gs.IsNil)
+			m := decoded.(map[string]interface{})
+			c.Expect(m["nil"], gs.IsNil)
+			c.Expect(m["bool"], gs.Equals, false)
+			c.Expect(m["int"], gs.Equals, int64(-1<<40))
+			c.Expect(m["float"], gs.Equals, 0.1)
+			c.Expect(m["str"], gs.Equals, "hello")
+			c.Expect(m["str16"], gs.Equals, long)
+			c.Expect(bytes.Equal(m["bin"].([]byte), []byte{0, 1, 2}), gs.IsTrue)
+			c.Expect(m["array"].([]interface{})[19], gs.Equals, int64(19))
+			nested := m["nested"].(map[string]interface{})
+			c.Expect(nested["a"].([]interface{})[0], gs.Equals, "b")
+			c.Expect(m["time"].(time.Time).UnixNano(), gs.Equals, int64(1442417870123456789))
+			ext := m["ext"].(msgpackExt)
+			c.Expect(ext.typ, gs.Equals, int8(5))
+			c.Expect(bytes.Equal(ext.data, []byte{1, 2, 3}), gs.IsTrue)
+		})
+
+		c.Specify("round trips timestamps in all formats", func() {
+			for _, t := range []time.Time{
+				time.Unix(1442417870, 0),
+				time.Unix(1442417870, 1),
+				time.Unix(-1, 500),
+			} {
+				decoded, err := msgpackDecode(encode(t))
+				c.Assume(err, gs.IsNil)
+				c.Expect(decoded.(time.Time).Equal(t), gs.IsTrue)
+			}
+			c.Expect(len(encode(time.Unix(1, 0))), gs.Equals, 6)
+			c.Expect(len(encode(time.Unix(1, 1))), gs.Equals, 10)
+			c.Expect(len(encode(time.Unix(-1, 0))), gs.Equals, 15)
+		})
+	})
+
+	c.Specify("MessagePack decoding", func() {
+		c.Specify("decodes float32 values and non string keys", func() {
+			// {1: 1.5 as float32}
+			value, err := msgpackDecode([]byte{0x81, 0x01, 0xca, 0x3f, 0xc0, 0, 0})
+			c.Assume(err, gs.IsNil)
+			c.Expect(value.(map[string]interface{})["1"], gs.Equals, 1.5)
+		})
+
+		c.Specify("fails on invalid data", func() {
+			for _, data := range [][]byte{
+				{},
+				{0xc1},
+				{0x92, 0x01},
+				{0xdb, 0xff, 0xff, 0xff, 0xff, 'a'},
+				{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+				{0x81, 0x91, 0x01, 0x01},
+				{0xd6, 0xff, 0, 0},
+				{0xd5, 0xff, 0, 0},
+				{0x01, 0x02},
+			} {
+				_, err := msgpackDecode(data)
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+	})
+
+	c.Specify("A MsgpackSplitter", func() {
+		splitter := new(MsgpackSplitter)
+		first := encode(map[string]interface{}{"a": "b"})
+		second := encode([]interface{}{"tag", int64(1), map[string]interface{}{}})
+		buf := append(append([]byte{}, first...), second...)
+
+		c.Specify("finds complete values", func() {
+			n, record := splitter.FindRecord(buf)
+			c.Expect(n, gs.Equals, len(first))
+			c.Expect(bytes.Equal(record, first), gs.IsTrue)
+			n, record = splitter.FindRecord(buf[n:])
+			c.Expect(bytes.Equal(record, second), gs.IsTrue)
+		})
+
+		c.Specify("waits for incomplete values", func() {
+			n, record := splitter.FindRecord(buf[len(first) : len(buf)-1])
+			c.Expect(n, gs.Equals, 0)
+			c.Expect(record, gs.IsNil)
+		})
+
+		c.Specify("delivers invalid values", func() {
+			n, record := splitter.FindRecord([]byte{0xc1, 0x01})
+			c.Expect(n, gs.Equals, 1)
+			c.Expect(len(record), gs.Equals, 1)
+		})
+	})
+}
@@ -0,0 +1,316 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package msgpack
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Extension type of the Fluentd EventTime, seconds and nanoseconds as big
+// endian 32 bit integers.
+const fluentdEventTimeType = 0
+
+type MsgpackDecoderConfig struct {
+	// Maps message header names to the map keys providing the value, the
+	// keys of nested maps are joined w/ the field separator.
+	HeaderMap map[string]string `toml:"header_map"`
+
+	// Separator used to join the keys of nested maps.
+	FieldSeparator string `toml:"field_separator"`
+
+	// Sets the message 'Type' header to the specified value, left untouched
+	// if empty.
+	MessageType string `toml:"message_type"`
+
+	// Whether the data are Fluentd forward protocol events rather than plain
+	// maps.
+	FluentdForward bool `toml:"fluentd_forward"`
+}
+
+type MsgpackDecoder struct {
+	dRunner     DecoderRunner
+	headerMap   HeaderMap
+	separator   string
+	messageType string
+	fluentd     bool
+}
+
+func (md *MsgpackDecoder) ConfigStruct() interface{} {
+	return &MsgpackDecoderConfig{
+		FieldSeparator: ".",
+	}
+}
+
+func (md *MsgpackDecoder) SetDecoderRunner(dr DecoderRunner) {
+	md.dRunner = dr
+}
+
+func (md *MsgpackDecoder) Init(config interface{}) (err error) {
+	conf := config.(*MsgpackDecoderConfig)
+	if conf.FieldSeparator == "" {
+		return errors.New("MsgpackDecoder field_separator must not be empty")
+	}
+	md.separator = conf.FieldSeparator
+	md.messageType = conf.MessageType
+	md.fluentd = conf.FluentdForward
+	if md.headerMap, err = NewHeaderMap(conf.HeaderMap, md.separator); err != nil {
+		return fmt.Errorf("MsgpackDecoder %s", err)
+	}
+	return
+}
+
+func (md *MsgpackDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	value, err := msgpackDecode([]byte(pack.Message.GetPayload()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MessagePack data: %s", err)
+	}
+	if md.fluentd {
+		return md.decodeFluentd(pack, value)
+	}
+	record, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("MessagePack value is not a map: %T", value)
+	}
+	if err = md.populateMessage(pack.Message, record); err != nil {
+		return nil, err
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Decodes the Fluentd forward protocol Message ([tag, time, record]), Forward
+// ([tag, [[time, record], ...]]) and PackedForward ([tag, entries]) modes,
+// each mode possibly followed by an option map. Each event produces a
+// message, its tag is stored in the Logger header.
+func (md *MsgpackDecoder) decodeFluentd(pack *PipelinePack, value interface{}) (
+	packs []*PipelinePack, err error) {
+
+	event, ok := value.([]interface{})
+	if !ok || len(event) < 2 {
+		return nil, errors.New("invalid Fluentd event: not an array")
+	}
+	tag, ok := event[0].(string)
+	if !ok {
+		return nil, errors.New("invalid Fluentd event: tag is not a string")
+	}
+
+	var entries []interface{}
+	switch v := event[1].(type) {
+	case []interface{}:
+		entries = v
+	case string, []byte:
+		var data []byte
+		if s, ok := v.(string); ok {
+			data = []byte(s)
+		} else {
+			data = v.([]byte)
+		}
+		if len(event) > 2 {
+			option, _ := event[2].(map[string]interface{})
+			if option["compressed"] == "gzip" {
+				if data, err = fluentdDecompress(data); err != nil {
+					return nil, err
+				}
+			}
+		}
+		r := &msgpackReader{data: data}
+		for r.pos < len(data) {
+			entry, err := r.readValue()
+			if err != nil {
+				return nil, fmt.Errorf("invalid Fluentd entries: %s", err)
+			}
+			entries = append(entries, entry)
+		}
+	default:
+		if len(event) < 3 {
+			return nil, errors.New("invalid Fluentd event: missing record")
+		}
+		entries = []interface{}{event[1:3]}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	// Keep the original message to copy it to the extra packs.
+	orig := message.CopyMessage(pack.Message)
+	for i, e := range entries {
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) < 2 {
+			err = errors.New("invalid Fluentd entry: not an array")
+			break
+		}
+		record, ok := entry[1].(map[string]interface{})
+		if !ok {
+			err = errors.New("invalid Fluentd entry: record is not a map")
+			break
+		}
+		p := pack
+		if i > 0 {
+			if p = md.dRunner.NewPack(); p == nil {
+				break // We're aborting.
+			}
+			orig.Copy(p.Message)
+		}
+		packs = append(packs, p)
+		if err = setFluentdTime(p.Message, entry[0]); err != nil {
+			break
+		}
+		p.Message.SetLogger(tag)
+		if err = md.populateMessage(p.Message, record); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		// The first pack is recycled by the DecoderRunner.
+		for i := 1; i < len(packs); i++ {
+			packs[i].Recycle(nil)
+		}
+		return nil, err
+	}
+	return packs, nil
+}
+
+func fluentdDecompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Fluentd decompression error: %s", err)
+	}
+	limited := io.LimitReader(r, int64(message.MAX_RECORD_SIZE)+1)
+	if data, err = ioutil.ReadAll(limited); err != nil {
+		return nil, fmt.Errorf("Fluentd decompression error: %s", err)
+	}
+	if len(data) > int(message.MAX_RECORD_SIZE) {
+		return nil, fmt.Errorf("decompressed Fluentd entries exceeded MAX_RECORD_SIZE %d",
+			message.MAX_RECORD_SIZE)
+	}
+	return data, nil
+}
+
+// Sets the Timestamp from a Fluentd time, seconds or an EventTime.
+func setFluentdTime(msg *message.Message, value interface{}) error {
+	switch v := value.(type) {
+	case int64:
+		msg.SetTimestamp(v * 1e9)
+		return nil
+	case msgpackExt:
+		if v.typ == fluentdEventTimeType && len(v.data) == 8 {
+			sec := int64(binary.BigEndian.Uint32(v.data))
+			nsec := int64(binary.BigEndian.Uint32(v.data[4:]))
+			msg.SetTimestamp(sec*1e9 + nsec)
+			return nil
+		}
+	case time.Time:
+		msg.SetTimestamp(v.UnixNano())
+		return nil
+	}
+	return fmt.Errorf("invalid Fluentd time: '%v'", value)
+}
+
+func (md *MsgpackDecoder) populateMessage(msg *message.Message,
+	record map[string]interface{}) (err error) {
+
+	// The MessagePack data is replaced by the mapped Payload value, if any.
+	msg.SetPayload("")
+	if md.messageType != "" {
+		msg.SetType(md.messageType)
+	}
+	for header, value := range md.headerMap.Extract(record) {
+		if err = SetMessageHeaderValue(msg, header, value); err != nil {
+			return
+		}
+	}
+	return md.addFields(msg, "", record)
+}
+
+// Adds the map values as fields, flattening nested maps. Timestamps are
+// stored as nanoseconds since the epoch, extension values as bytes.
+func (md *MsgpackDecoder) addFields(msg *message.Message, name string,
+	value interface{}) error {
+
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		// Sort the keys so the fields are always added in the same order.
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldName := key
+			if name != "" {
+				fieldName = name + md.separator + key
+			}
+			if err := md.addFields(msg, fieldName, v[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = fieldValue(item)
+		}
+		if f := message.NewMultiValueField(name, values); f != nil {
+			msg.AddField(f)
+			return nil
+		}
+		for i, item := range v {
+			if err := md.addFields(msg, name+md.separator+strconv.Itoa(i),
+				item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	f, err := message.NewField(name, fieldValue(value), "")
+	if err != nil {
+		return fmt.Errorf("field creation error: %s", err)
+	}
+	msg.AddField(f)
+	return nil
+}
+
+// Returns the field value of a scalar.
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UnixNano()
+	case msgpackExt:
+		return v.data
+	}
+	return value
+}
+
+func init() {
+	RegisterPlugin("MsgpackDecoder", func() interface{} {
+		return new(MsgpackDecoder)
+	})
+}
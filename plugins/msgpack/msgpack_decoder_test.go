@@ -0,0 +1,216 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package msgpack
+
+import (
+	"bytes"
+	"compress/gzip"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func MsgpackDecoderSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A MsgpackDecoder", func() {
+		decoder := new(MsgpackDecoder)
+		dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+		decoder.SetDecoderRunner(dRunner)
+		conf := decoder.ConfigStruct().(*MsgpackDecoderConfig)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		record := map[string]interface{}{
+			"time":    time.Unix(1442417870, 123456789),
+			"host":    "example.org",
+			"message": "hello",
+			"level":   int64(3),
+			"meta": map[string]interface{}{
+				"pid":     int64(42),
+				"user":    "bob",
+				"version": "0.8",
+			},
+			"tags":  []interface{}{"a", "b"},
+			"took":  0.25,
+			"ok":    true,
+			"raw":   []byte{0, 1},
+			"empty": nil,
+		}
+		encode := func(value interface{}) string {
+			var buf bytes.Buffer
+			err := msgpackEncode(&buf, value)
+			c.Assume(err, gs.IsNil)
+			return buf.String()
+		}
+
+		c.Specify("maps keys to headers and fields", func() {
+			conf.HeaderMap = map[string]string{
+				"Timestamp":  "time",
+				"Hostname":   "host",
+				"Payload":    "message",
+				"Severity":   "level",
+				"Pid":        "meta.pid",
+				"EnvVersion": "meta.version",
+			}
+			conf.MessageType = "mp"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload(encode(record))
+			packs, err := decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+			msg := packs[0].Message
+
+			c.Expect(msg.GetTimestamp(), gs.Equals, int64(1442417870123456789))
+			c.Expect(msg.GetHostname(), gs.Equals, "example.org")
+			c.Expect(msg.GetPayload(), gs.Equals, "hello")
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(3))
+			c.Expect(msg.GetPid(), gs.Equals, int32(42))
+			c.Expect(msg.GetEnvVersion(), gs.Equals, "0.8")
+			c.Expect(msg.GetType(), gs.Equals, "mp")
+			value, _ := msg.GetFieldValue("meta.user")
+			c.Expect(value, gs.Equals, "bob")
+			c.Expect(len(msg.FindFirstField("tags").GetValueString()), gs.Equals, 2)
+			value, _ = msg.GetFieldValue("took")
+			c.Expect(value, gs.Equals, 0.25)
+			value, _ = msg.GetFieldValue("ok")
+			c.Expect(value, gs.Equals, true)
+			c.Expect(msg.FindFirstField("raw").GetValueType(), gs.Equals,
+				message.Field_BYTES)
+			c.Expect(msg.FindFirstField("empty"), gs.IsNil)
+			c.Expect(len(msg.Fields), gs.Equals, 5)
+		})
+
+		c.Specify("stores all keys as fields by default", func() {
+			conf.FieldSeparator = "_"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload(encode(record))
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			value, _ := pack.Message.GetFieldValue("meta_pid")
+			c.Expect(value, gs.Equals, int64(42))
+			value, _ = pack.Message.GetFieldValue("time")
+			c.Expect(value, gs.Equals, int64(1442417870123456789))
+			value, _ = pack.Message.GetFieldValue("level")
+			c.Expect(value, gs.Equals, int64(3))
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "")
+		})
+
+		c.Specify("decodes Fluentd forward protocol events", func() {
+			conf.FluentdForward = true
+			conf.HeaderMap = map[string]string{"Payload": "message"}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			eventTime := msgpackExt{fluentdEventTimeType,
+				[]byte{0x55, 0xf9, 0x52, 0xce, 0x07, 0x5b, 0xcd, 0x15}}
+			pack.Message.SetHostname("sender")
+
+			c.Specify("in Message mode", func() {
+				pack.Message.SetPayload(encode([]interface{}{"app.access", eventTime,
+					map[string]interface{}{"message": "hello"}}))
+				packs, err := decoder.Decode(pack)
+				c.Assume(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 1)
+				c.Expect(pack.Message.GetLogger(), gs.Equals, "app.access")
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1442403022123456789))
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "hello")
+			})
+
+			c.Specify("in Forward mode", func() {
+				extra := NewPipelinePack(supply)
+				dRunner.EXPECT().NewPack().Return(extra)
+				pack.Message.SetPayload(encode([]interface{}{"app", []interface{}{
+					[]interface{}{int64(1442417870), map[string]interface{}{"message": "one"}},
+					[]interface{}{eventTime, map[string]interface{}{"message": "two"}},
+				}}))
+				packs, err := decoder.Decode(pack)
+				c.Assume(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 2)
+				c.Expect(packs[0].Message.GetPayload(), gs.Equals, "one")
+				c.Expect(packs[0].Message.GetTimestamp(), gs.Equals, int64(1442417870e9))
+				c.Expect(packs[1], gs.Equals, extra)
+				c.Expect(extra.Message.GetPayload(), gs.Equals, "two")
+				c.Expect(extra.Message.GetLogger(), gs.Equals, "app")
+				c.Expect(extra.Message.GetHostname(), gs.Equals, "sender")
+			})
+
+			c.Specify("in compressed PackedForward mode", func() {
+				dRunner.EXPECT().NewPack().Return(NewPipelinePack(supply))
+				entries := encode([]interface{}{int64(1),
+					map[string]interface{}{"message": "one"}})
+				entries += encode([]interface{}{int64(2),
+					map[string]interface{}{"message": "two"}})
+				var buf bytes.Buffer
+				w := gzip.NewWriter(&buf)
+				w.Write([]byte(entries))
+				w.Close()
+				pack.Message.SetPayload(encode([]interface{}{"app", buf.Bytes(),
+					map[string]interface{}{"compressed": "gzip", "size": int64(2)}}))
+				packs, err := decoder.Decode(pack)
+				c.Assume(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 2)
+				c.Expect(packs[1].Message.GetPayload(), gs.Equals, "two")
+				c.Expect(packs[1].Message.GetTimestamp(), gs.Equals, int64(2e9))
+			})
+
+			c.Specify("fails on invalid events", func() {
+				for _, event := range []interface{}{
+					map[string]interface{}{},
+					[]interface{}{int64(1), int64(1), map[string]interface{}{}},
+					[]interface{}{"app", int64(1)},
+					[]interface{}{"app", "not msgpack \xc1"},
+					[]interface{}{"app", []interface{}{int64(1)}},
+					[]interface{}{"app", "time", map[string]interface{}{}},
+				} {
+					pack.Message.SetPayload(encode(event))
+					_, err := decoder.Decode(pack)
+					c.Expect(err, gs.Not(gs.IsNil))
+				}
+			})
+		})
+
+		c.Specify("fails on invalid data", func() {
+			conf.HeaderMap = map[string]string{"Uuid": "host"}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			for _, payload := range []string{
+				encode(record),
+				encode([]interface{}{"a"}),
+				"\x81\xa1a",
+			} {
+				pack.Message.SetPayload(payload)
+				_, err = decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+
+		c.Specify("fails on invalid settings", func() {
+			conf.HeaderMap = map[string]string{"Nope": "a"}
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.HeaderMap = nil
+			conf.FieldSeparator = ""
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,170 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type MsgpackEncoderConfig struct {
+	// Maps message header names to the map keys receiving the value, the
+	// keys of nested maps are joined w/ the field separator.
+	HeaderMap map[string]string `toml:"header_map"`
+
+	// Separator used to split the field names into the keys of nested maps.
+	FieldSeparator string `toml:"field_separator"`
+
+	// Whether to wrap the map in a Fluentd forward protocol event.
+	FluentdForward bool `toml:"fluentd_forward"`
+
+	// Fluentd tag, defaults to the message Logger.
+	FluentdTag string `toml:"fluentd_tag"`
+
+	// Whether to send the event time as an EventTime w/ nanosecond precision
+	// rather than as seconds, requires Fluentd v0.14 or later.
+	FluentdEventTime bool `toml:"fluentd_event_time"`
+}
+
+type MsgpackEncoder struct {
+	headerMap HeaderMap
+	separator string
+	fluentd   bool
+	tag       string
+	eventTime bool
+}
+
+func (me *MsgpackEncoder) ConfigStruct() interface{} {
+	return &MsgpackEncoderConfig{
+		FieldSeparator: ".",
+	}
+}
+
+func (me *MsgpackEncoder) Init(config interface{}) (err error) {
+	conf := config.(*MsgpackEncoderConfig)
+	if conf.FieldSeparator == "" {
+		return errors.New("MsgpackEncoder field_separator must not be empty")
+	}
+	me.separator = conf.FieldSeparator
+	me.fluentd = conf.FluentdForward
+	me.tag = conf.FluentdTag
+	me.eventTime = conf.FluentdEventTime
+	if me.headerMap, err = NewHeaderMap(conf.HeaderMap, me.separator); err != nil {
+		return fmt.Errorf("MsgpackEncoder %s", err)
+	}
+	return
+}
+
+func (me *MsgpackEncoder) Encode(pack *PipelinePack) (output []byte, err error) {
+	// A lazily decoding ProtobufDecoder may have left the Fields encoded.
+	if err = pack.DecodeFields(); err != nil {
+		return nil, fmt.Errorf("can't decode message fields: %s", err)
+	}
+	var value interface{}
+	msg := pack.Message
+	record := me.buildRecord(msg)
+	value = record
+	if me.fluentd {
+		tag := me.tag
+		if tag == "" {
+			if tag = msg.GetLogger(); tag == "" {
+				tag = "heka"
+			}
+		}
+		value = []interface{}{tag, fluentdTime(msg.GetTimestamp(), me.eventTime), record}
+	}
+	var buf bytes.Buffer
+	if err = msgpackEncode(&buf, value); err != nil {
+		return nil, fmt.Errorf("MessagePack encoding error: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Returns the Fluentd time for a timestamp, either seconds or an EventTime.
+func fluentdTime(ts int64, eventTime bool) interface{} {
+	sec := ts / 1e9
+	if !eventTime {
+		return sec
+	}
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, uint32(sec))
+	binary.BigEndian.PutUint32(data[4:], uint32(ts%1e9))
+	return msgpackExt{fluentdEventTimeType, data}
+}
+
+// Builds the map from the mapped headers and the message fields. Field names
+// are split into the keys of nested maps, unless a key is already in use.
+// Object field values are written as nested maps.
+func (me *MsgpackEncoder) buildRecord(msg *message.Message) map[string]interface{} {
+	record := make(map[string]interface{})
+	for header, path := range me.headerMap {
+		value := GetMessageHeaderValue(msg, header)
+		if header == "Timestamp" {
+			value = time.Unix(0, value.(int64)).UTC()
+		}
+		setPath(record, path, value)
+	}
+	for _, f := range msg.GetFields() {
+		var value interface{}
+		if values := f.GetNativeValues(); len(values) == 1 {
+			value = values[0]
+		} else if len(values) > 1 {
+			value = values
+		} else {
+			continue
+		}
+		name := f.GetName()
+		if !setPath(record, strings.Split(name, me.separator), value) {
+			setPath(record, []string{name}, value)
+		}
+	}
+	return record
+}
+
+// Sets the value at the path of nested maps, creating them as needed.
+// Returns false if a key along the path is already in use.
+func setPath(record map[string]interface{}, path []string, value interface{}) bool {
+	for _, key := range path[:len(path)-1] {
+		existing, ok := record[key]
+		if !ok {
+			nested := make(map[string]interface{})
+			record[key] = nested
+			record = nested
+			continue
+		}
+		if record, ok = existing.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	key := path[len(path)-1]
+	if _, ok := record[key]; ok {
+		return false
+	}
+	record[key] = value
+	return true
+}
+
+func init() {
+	RegisterPlugin("MsgpackEncoder", func() interface{} {
+		return new(MsgpackEncoder)
+	})
+}
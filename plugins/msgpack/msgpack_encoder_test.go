@@ -0,0 +1,195 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package msgpack
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func MsgpackEncoderSpec(c gs.Context) {
+	c.Specify("A MsgpackEncoder", func() {
+		encoder := new(MsgpackEncoder)
+		conf := encoder.ConfigStruct().(*MsgpackEncoderConfig)
+		conf.HeaderMap = map[string]string{
+			"Timestamp": "time",
+			"Hostname":  "host",
+			"Payload":   "message",
+			"Severity":  "level",
+			"Pid":       "meta.pid",
+		}
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+		msg := pack.Message
+		msg.SetTimestamp(1442417870123456789)
+		msg.SetHostname("example.org")
+		msg.SetLogger("app")
+		msg.SetPayload("hello")
+		msg.SetSeverity(3)
+		msg.SetPid(42)
+		f, _ := message.NewField("tags", "a", "")
+		f.AddValue("b")
+		msg.AddField(f)
+		f, _ = message.NewField("meta.user", "bob", "")
+		msg.AddField(f)
+		f, _ = message.NewField("took", 0.25, "")
+		msg.AddField(f)
+		f, _ = message.NewField("count", 7, "")
+		msg.AddField(f)
+		f, _ = message.NewField("raw", []byte{0, 1}, "")
+		msg.AddField(f)
+		f, _ = message.NewField("host.name", "clash", "")
+		msg.AddField(f)
+
+		decode := func(data []byte) interface{} {
+			value, err := msgpackDecode(data)
+			c.Assume(err, gs.IsNil)
+			return value
+		}
+
+		c.Specify("maps headers and fields to keys", func() {
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			record := decode(output).(map[string]interface{})
+
+			c.Expect(record["time"].(time.Time).UnixNano(), gs.Equals,
+				int64(1442417870123456789))
+			c.Expect(record["host"], gs.Equals, "example.org")
+			c.Expect(record["message"], gs.Equals, "hello")
+			c.Expect(record["level"], gs.Equals, int64(3))
+			meta := record["meta"].(map[string]interface{})
+			c.Expect(meta["pid"], gs.Equals, int64(42))
+			c.Expect(meta["user"], gs.Equals, "bob")
+			c.Expect(len(record["tags"].([]interface{})), gs.Equals, 2)
+			c.Expect(record["took"], gs.Equals, 0.25)
+			c.Expect(record["count"], gs.Equals, int64(7))
+			c.Expect(bytes.Equal(record["raw"].([]byte), []byte{0, 1}), gs.IsTrue)
+			c.Expect(record["host.name"], gs.Equals, "clash")
+		})
+
+		c.Specify("writes object fields as nested maps", func() {
+			f, err := message.NewField("http", map[string]interface{}{
+				"request": map[string]interface{}{"method": "GET"},
+				"codes":   []interface{}{200, 304},
+			}, "")
+			c.Assume(err, gs.IsNil)
+			msg.AddField(f)
+			err = encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+			record := decode(output).(map[string]interface{})
+			http := record["http"].(map[string]interface{})
+			c.Expect(len(http["codes"].([]interface{})), gs.Equals, 2)
+			request := http["request"].(map[string]interface{})
+			c.Expect(request["method"], gs.Equals, "GET")
+		})
+
+		c.Specify("encodes lazily decoded fields", func() {
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder := new(ProtobufDecoder)
+			decoder.SetPipelineConfig(NewPipelineConfig(nil))
+			decoderConf := decoder.ConfigStruct().(*ProtobufDecoderConfig)
+			decoderConf.Lazy = true
+			err = decoder.Init(decoderConf)
+			c.Assume(err, gs.IsNil)
+
+			pack2 := NewPipelinePack(supply)
+			pack2.MsgBytes, err = proto.Marshal(msg)
+			c.Assume(err, gs.IsNil)
+			_, err = decoder.Decode(pack2)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(pack2.Message.Fields), gs.Equals, 0)
+
+			output, err := encoder.Encode(pack2)
+			c.Assume(err, gs.IsNil)
+			record := decode(output).(map[string]interface{})
+			c.Expect(record["count"], gs.Equals, int64(7))
+			c.Expect(len(record["tags"].([]interface{})), gs.Equals, 2)
+		})
+
+		c.Specify("round trips through the MsgpackDecoder", func() {
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Assume(err, gs.IsNil)
+
+			decoder := new(MsgpackDecoder)
+			decConf := decoder.ConfigStruct().(*MsgpackDecoderConfig)
+			decConf.HeaderMap = conf.HeaderMap
+			err = decoder.Init(decConf)
+			c.Assume(err, gs.IsNil)
+			pack2 := NewPipelinePack(supply)
+			pack2.Message.SetPayload(string(output))
+			_, err = decoder.Decode(pack2)
+			c.Assume(err, gs.IsNil)
+			msg2 := pack2.Message
+			c.Expect(msg2.GetTimestamp(), gs.Equals, msg.GetTimestamp())
+			c.Expect(msg2.GetPayload(), gs.Equals, "hello")
+			c.Expect(msg2.GetPid(), gs.Equals, int32(42))
+			for _, name := range []string{"meta.user", "took", "count", "raw"} {
+				f2 := msg2.FindFirstField(name)
+				c.Assume(f2, gs.Not(gs.IsNil))
+				c.Expect(f2.GetValueType(), gs.Equals, msg.FindFirstField(name).GetValueType())
+			}
+		})
+
+		c.Specify("writes Fluentd forward protocol events", func() {
+			conf.FluentdForward = true
+
+			c.Specify("tagged w/ the Logger and w/ seconds", func() {
+				err := encoder.Init(conf)
+				c.Assume(err, gs.IsNil)
+				output, err := encoder.Encode(pack)
+				c.Assume(err, gs.IsNil)
+				event := decode(output).([]interface{})
+				c.Expect(len(event), gs.Equals, 3)
+				c.Expect(event[0], gs.Equals, "app")
+				c.Expect(event[1], gs.Equals, int64(1442417870))
+				c.Expect(event[2].(map[string]interface{})["message"], gs.Equals, "hello")
+			})
+
+			c.Specify("w/ a tag and an EventTime", func() {
+				conf.FluentdTag = "heka.app"
+				conf.FluentdEventTime = true
+				err := encoder.Init(conf)
+				c.Assume(err, gs.IsNil)
+				output, err := encoder.Encode(pack)
+				c.Assume(err, gs.IsNil)
+				event := decode(output).([]interface{})
+				c.Expect(event[0], gs.Equals, "heka.app")
+				ext := event[1].(msgpackExt)
+				c.Expect(ext.typ, gs.Equals, int8(fluentdEventTimeType))
+				c.Expect(bytes.Equal(ext.data,
+					[]byte{0x55, 0xf9, 0x8c, 0xce, 0x07, 0x5b, 0xcd, 0x15}), gs.IsTrue)
+			})
+		})
+
+		c.Specify("fails on invalid settings", func() {
+			conf.HeaderMap = map[string]string{"Nope": "a"}
+			c.Expect(encoder.Init(conf), gs.Not(gs.IsNil))
+			conf.HeaderMap = map[string]string{"Payload": ""}
+			c.Expect(encoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
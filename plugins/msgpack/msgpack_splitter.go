@@ -0,0 +1,45 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package msgpack
+
+import (
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Splitter extracting the complete top level values from a stream of
+// concatenated MessagePack values, e.g. Fluentd forward protocol events.
+type MsgpackSplitter struct{}
+
+func (m *MsgpackSplitter) Init(config interface{}) error {
+	return nil
+}
+
+func (m *MsgpackSplitter) FindRecord(buf []byte) (bytesRead int, record []byte) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	n, err := msgpackValueSize(buf)
+	if err == errMsgpackShort {
+		return 0, nil // read more data to complete the value
+	}
+	// Invalid values are delivered as is, the decoder reports the error.
+	return n, buf[:n]
+}
+
+func init() {
+	RegisterPlugin("MsgpackSplitter", func() interface{} {
+		return new(MsgpackSplitter)
+	})
+}
@@ -0,0 +1,435 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// How the value of a log format variable is stored.
+const (
+	accessLogString = iota
+	accessLogInt
+	accessLogDouble
+	accessLogTime
+	accessLogRequest
+	accessLogPid
+)
+
+type accessLogVar struct {
+	name           string
+	kind           int
+	representation string
+	layout         string  // time layout, or "epoch", "epoch_ms", "epoch_us"
+	scale          float64 // multiplier applied to doubles
+	bracketed      bool    // Apache's %t logs the time within brackets
+}
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Nginx variables that aren't stored as strings, see
+// http://nginx.org/en/docs/http/ngx_http_log_module.html.
+var nginxVars = map[string]accessLogVar{
+	"status":                 {kind: accessLogInt},
+	"body_bytes_sent":        {kind: accessLogInt, representation: "B"},
+	"bytes_sent":             {kind: accessLogInt, representation: "B"},
+	"request_length":         {kind: accessLogInt, representation: "B"},
+	"content_length":         {kind: accessLogInt, representation: "B"},
+	"connection":             {kind: accessLogInt},
+	"connection_requests":    {kind: accessLogInt},
+	"server_port":            {kind: accessLogInt},
+	"remote_port":            {kind: accessLogInt},
+	"request_time":           {kind: accessLogDouble, representation: "s"},
+	"upstream_connect_time":  {kind: accessLogDouble, representation: "s"},
+	"upstream_header_time":   {kind: accessLogDouble, representation: "s"},
+	"upstream_response_time": {kind: accessLogDouble, representation: "s"},
+	"time_local":             {kind: accessLogTime, layout: clfTimeLayout},
+	"time_iso8601":           {kind: accessLogTime, layout: time.RFC3339},
+	"msec":                   {kind: accessLogTime, layout: "epoch"},
+	"request":                {kind: accessLogRequest},
+	"pid":                    {kind: accessLogPid},
+}
+
+// Apache format directives, named after the equivalent nginx variables where
+// there is one, see http://httpd.apache.org/docs/2.4/mod/mod_log_config.html.
+var apacheDirectives = map[byte]string{
+	'a': "remote_addr",
+	'A': "server_addr",
+	'B': "body_bytes_sent",
+	'b': "body_bytes_sent",
+	'D': "request_time",
+	'f': "request_filename",
+	'h': "remote_addr",
+	'H': "server_protocol",
+	'I': "request_length",
+	'k': "connection_requests",
+	'l': "remote_logname",
+	'L': "request_log_id",
+	'm': "request_method",
+	'O': "bytes_sent",
+	'p': "server_port",
+	'P': "pid",
+	'q': "query_string",
+	'r': "request",
+	'R': "handler",
+	's': "status",
+	't': "time_local",
+	'T': "request_time",
+	'u': "remote_user",
+	'U': "uri",
+	'v': "server_name",
+	'V': "server_name",
+	'X': "connection_status",
+}
+
+// Parses the nginx variable or Apache directive at the start of the string,
+// returning its definition and length.
+type accessLogVarParser func(format string) (v accessLogVar, n int, err error)
+
+func parseNginxVar(format string) (v accessLogVar, n int, err error) {
+	if strings.HasPrefix(format, "${") {
+		end := strings.IndexByte(format, '}')
+		if end < 3 {
+			return v, 0, fmt.Errorf("invalid variable: %s", format)
+		}
+		v.name, n = format[2:end], end+1
+	} else {
+		for n = 1; n < len(format); n++ {
+			c := format[n]
+			if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+				c >= '0' && c <= '9') {
+				break
+			}
+		}
+		if n == 1 {
+			return v, 0, fmt.Errorf("invalid variable: %s", format)
+		}
+		v.name = format[1:n]
+	}
+	if typed, ok := nginxVars[v.name]; ok {
+		typed.name = v.name
+		v = typed
+	}
+	return
+}
+
+func parseApacheDirective(format string) (v accessLogVar, n int, err error) {
+	// Skip the status code conditions and the original/final request
+	// modifiers, e.g. "%!200,304{Referer}i" or "%>s".
+	for n = 1; n < len(format) && strings.IndexByte("!0123456789,<>", format[n]) != -1; n++ {
+	}
+	var arg string
+	if n < len(format) && format[n] == '{' {
+		end := strings.IndexByte(format[n:], '}')
+		if end == -1 {
+			return v, 0, fmt.Errorf("unterminated directive: %s", format)
+		}
+		arg, n = format[n+1:n+end], n+end+1
+	}
+	if n >= len(format) {
+		return v, 0, fmt.Errorf("invalid directive: %s", format)
+	}
+	directive := format[n]
+	n++
+	headerName := strings.ToLower(strings.Replace(arg, "-", "_", -1))
+
+	switch {
+	case directive == 'i':
+		v.name = "http_" + headerName
+	case directive == 'o':
+		v.name = "sent_http_" + headerName
+	case directive == 'C':
+		v.name = "cookie_" + headerName
+	case directive == 'e' || directive == 'n':
+		v.name = arg
+	case directive == 't' && arg != "":
+		v.name, v.kind = "time_local", accessLogTime
+		switch arg {
+		case "sec":
+			v.layout = "epoch"
+		case "msec":
+			v.layout = "epoch_ms"
+		case "usec":
+			v.layout = "epoch_us"
+		default:
+			format := strings.TrimPrefix(strings.TrimPrefix(arg, "begin:"), "end:")
+			if v.layout, err = message.StrftimeToLayout(format); err != nil {
+				return v, 0, err
+			}
+		}
+		return
+	default:
+		name, ok := apacheDirectives[directive]
+		if !ok {
+			return v, 0, fmt.Errorf("unsupported directive: %%%c", directive)
+		}
+		v.name = name
+	}
+	if v.name == "" {
+		return v, 0, fmt.Errorf("directive missing a name: %s", format[:n])
+	}
+	if typed, ok := nginxVars[v.name]; ok {
+		typed.name = v.name
+		v = typed
+	}
+	switch directive {
+	case 'D':
+		// Microseconds, rather than seconds as for %T.
+		v.scale = 1e-6
+	case 't':
+		v.bracketed = true
+	}
+	return
+}
+
+// Unescapes the backslash sequences Apache allows in LogFormat strings.
+func unescapeApacheFormat(format string) string {
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\t`, "\t", `\n`, "\n").
+		Replace(format)
+}
+
+// Generates a regular expression matching the log lines, each variable
+// matching everything up to the first character of the literal following it.
+// Returns the regular expression and the variables of its capture groups.
+func compileLogFormat(format string, varStart byte,
+	parseVar accessLogVarParser) (re *regexp.Regexp, vars []accessLogVar, err error) {
+
+	var (
+		literals []string
+		literal  bytes.Buffer
+	)
+	for i := 0; i < len(format); {
+		if format[i] != varStart {
+			literal.WriteByte(format[i])
+			i++
+			continue
+		}
+		if varStart == '%' && i+1 < len(format) && format[i+1] == '%' {
+			literal.WriteByte('%')
+			i += 2
+			continue
+		}
+		v, n, err := parseVar(format[i:])
+		if err != nil {
+			return nil, nil, err
+		}
+		if v.bracketed {
+			literal.WriteByte('[')
+		}
+		literals = append(literals, literal.String())
+		literal.Reset()
+		vars = append(vars, v)
+		if v.bracketed {
+			literal.WriteByte(']')
+		}
+		i += n
+	}
+	if len(vars) == 0 {
+		return nil, nil, errors.New("log format has no variables")
+	}
+	literals = append(literals, literal.String())
+
+	var expr bytes.Buffer
+	expr.WriteString("^")
+	for i, v := range vars {
+		expr.WriteString(regexp.QuoteMeta(literals[i]))
+		next := literals[i+1]
+		switch {
+		case next != "":
+			expr.WriteString("([^" + regexp.QuoteMeta(next[:1]) + "]*)")
+		case i+1 == len(vars):
+			expr.WriteString("(.*)")
+		default:
+			expr.WriteString("(.*?)")
+		}
+		if v.scale == 0 {
+			vars[i].scale = 1
+		}
+	}
+	expr.WriteString(regexp.QuoteMeta(literals[len(vars)]))
+	expr.WriteString("$")
+	re, err = regexp.Compile(expr.String())
+	return
+}
+
+type AccessLogDecoderConfig struct {
+	// The nginx 'log_format' or Apache 'LogFormat' configuration directive.
+	LogFormat string `toml:"log_format"`
+
+	// Either "nginx" or "apache", determines how log_format is interpreted.
+	FormatType string `toml:"format_type"`
+
+	// Message Type, left untouched if empty.
+	MessageType string `toml:"message_type"`
+
+	// Whether to preserve the original log line in the message payload.
+	PayloadKeep bool `toml:"payload_keep"`
+}
+
+type AccessLogDecoder struct {
+	re          *regexp.Regexp
+	vars        []accessLogVar
+	msgType     string
+	payloadKeep bool
+}
+
+func (ad *AccessLogDecoder) ConfigStruct() interface{} {
+	return &AccessLogDecoderConfig{
+		FormatType: "nginx",
+	}
+}
+
+func (ad *AccessLogDecoder) Init(config interface{}) (err error) {
+	conf := config.(*AccessLogDecoderConfig)
+	ad.msgType = conf.MessageType
+	ad.payloadKeep = conf.PayloadKeep
+	if conf.LogFormat == "" {
+		return errors.New("AccessLogDecoder 'log_format' must be specified")
+	}
+	switch conf.FormatType {
+	case "nginx":
+		ad.re, ad.vars, err = compileLogFormat(conf.LogFormat, '$', parseNginxVar)
+	case "apache":
+		ad.re, ad.vars, err = compileLogFormat(unescapeApacheFormat(conf.LogFormat),
+			'%', parseApacheDirective)
+	default:
+		return fmt.Errorf("AccessLogDecoder unknown format_type '%s'", conf.FormatType)
+	}
+	if err != nil {
+		return fmt.Errorf("AccessLogDecoder invalid log_format: %s", err)
+	}
+	return
+}
+
+func (ad *AccessLogDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	line := strings.TrimRight(pack.Message.GetPayload(), "\r\n")
+	values := ad.re.FindStringSubmatch(line)
+	if values == nil {
+		return nil, errors.New("log line doesn't match the log format")
+	}
+	msg := pack.Message
+	for i, v := range ad.vars {
+		if err = ad.addValue(msg, v, values[i+1]); err != nil {
+			return nil, fmt.Errorf("invalid '%s' value '%s': %s", v.name,
+				values[i+1], err)
+		}
+	}
+	if ad.msgType != "" {
+		msg.SetType(ad.msgType)
+	}
+	if !ad.payloadKeep {
+		msg.SetPayload("")
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+func (ad *AccessLogDecoder) addValue(msg *message.Message, v accessLogVar,
+	value string) (err error) {
+
+	// Typed values are omitted when not available, e.g. a "-" request time.
+	if v.kind != accessLogString && (value == "-" || value == "") {
+		return
+	}
+
+	switch v.kind {
+	case accessLogInt:
+		var i int64
+		if i, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return
+		}
+		message.NewInt64Field(msg, v.name, i, v.representation)
+	case accessLogDouble:
+		// Upstream times list a value per upstream server contacted.
+		f := message.NewFieldInit(v.name, message.Field_DOUBLE, v.representation)
+		for _, s := range strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ':' || r == ' '
+		}) {
+			if s == "-" {
+				continue
+			}
+			var d float64
+			if d, err = strconv.ParseFloat(s, 64); err != nil {
+				return
+			}
+			f.AddValue(d * v.scale)
+		}
+		if len(f.ValueDouble) > 0 {
+			msg.AddField(f)
+		}
+	case accessLogTime:
+		var t time.Time
+		if t, err = parseAccessLogTime(v.layout, value); err != nil {
+			return
+		}
+		msg.SetTimestamp(t.UnixNano())
+	case accessLogPid:
+		var pid int64
+		if pid, err = strconv.ParseInt(value, 10, 32); err != nil {
+			return
+		}
+		msg.SetPid(int32(pid))
+	case accessLogRequest:
+		parts := strings.Split(value, " ")
+		if len(parts) < 2 || len(parts) > 3 {
+			// Leave malformed request lines intact.
+			message.NewStringField(msg, v.name, value)
+			break
+		}
+		message.NewStringField(msg, "request_method", parts[0])
+		message.NewStringField(msg, "request_uri", parts[1])
+		if len(parts) == 3 {
+			message.NewStringField(msg, "server_protocol", parts[2])
+		}
+	default:
+		message.NewStringField(msg, v.name, value)
+	}
+	return
+}
+
+func parseAccessLogTime(layout, value string) (t time.Time, err error) {
+	var unit float64
+	switch layout {
+	case "epoch":
+		unit = 1e9
+	case "epoch_ms":
+		unit = 1e6
+	case "epoch_us":
+		unit = 1e3
+	default:
+		return time.Parse(layout, value)
+	}
+	var f float64
+	if f, err = strconv.ParseFloat(value, 64); err != nil {
+		return
+	}
+	secs := f * unit / 1e9
+	whole := int64(secs)
+	return time.Unix(whole, int64((secs-float64(whole))*1e9)), nil
+}
+
+func init() {
+	RegisterPlugin("AccessLogDecoder", func() interface{} {
+		return new(AccessLogDecoder)
+	})
+}
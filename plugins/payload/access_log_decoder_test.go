@@ -0,0 +1,163 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func AccessLogDecoderSpec(c gs.Context) {
+	c.Specify("An AccessLogDecoder", func() {
+		decoder := new(AccessLogDecoder)
+		conf := decoder.ConfigStruct().(*AccessLogDecoderConfig)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+		timestamp := time.Date(2014, 1, 10, 15, 4, 56, 0, time.UTC).UnixNano()
+
+		c.Specify("decodes nginx logs", func() {
+			conf.LogFormat = `$remote_addr - $remote_user [$time_local] "$request" ` +
+				`$status $body_bytes_sent "$http_referer" "$http_user_agent" ` +
+				`$request_time ${upstream_response_time}s`
+			conf.MessageType = "nginx.access"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload(`62.195.113.219 - - [10/Jan/2014:07:04:56 -0800] ` +
+				`"GET /v1/status?x=1 HTTP/1.1" 200 82 "-" "Mozilla/5.0 (Mobile; rv:29.0)" ` +
+				"0.125 0.100, 0.020s\n")
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			c.Expect(msg.GetTimestamp(), gs.Equals, timestamp)
+			c.Expect(msg.GetType(), gs.Equals, "nginx.access")
+			c.Expect(msg.GetPayload(), gs.Equals, "")
+			c.Expect(msg.FindFirstField("time_local"), gs.IsNil)
+			value, _ := msg.GetFieldValue("remote_addr")
+			c.Expect(value, gs.Equals, "62.195.113.219")
+			value, _ = msg.GetFieldValue("remote_user")
+			c.Expect(value, gs.Equals, "-")
+			value, _ = msg.GetFieldValue("request_method")
+			c.Expect(value, gs.Equals, "GET")
+			value, _ = msg.GetFieldValue("request_uri")
+			c.Expect(value, gs.Equals, "/v1/status?x=1")
+			value, _ = msg.GetFieldValue("server_protocol")
+			c.Expect(value, gs.Equals, "HTTP/1.1")
+			value, _ = msg.GetFieldValue("status")
+			c.Expect(value, gs.Equals, int64(200))
+			f := msg.FindFirstField("body_bytes_sent")
+			c.Expect(f.GetRepresentation(), gs.Equals, "B")
+			c.Expect(f.ValueInteger[0], gs.Equals, int64(82))
+			value, _ = msg.GetFieldValue("http_user_agent")
+			c.Expect(value, gs.Equals, "Mozilla/5.0 (Mobile; rv:29.0)")
+			value, _ = msg.GetFieldValue("request_time")
+			c.Expect(value, gs.Equals, 0.125)
+			f = msg.FindFirstField("upstream_response_time")
+			c.Expect(f.GetValueType(), gs.Equals, message.Field_DOUBLE)
+			c.Expect(len(f.ValueDouble), gs.Equals, 2)
+			c.Expect(f.ValueDouble[1], gs.Equals, 0.02)
+
+			c.Specify("and omits unavailable typed values", func() {
+				pack.Message = new(message.Message)
+				pack.Message.SetPayload(`10.0.0.1 - bob [10/Jan/2014:07:04:56 -0800] ` +
+					`"-" 400 0 "-" "-" 0.000 -s`)
+				_, err = decoder.Decode(pack)
+				c.Assume(err, gs.IsNil)
+				msg = pack.Message
+				c.Expect(msg.FindFirstField("upstream_response_time"), gs.IsNil)
+				c.Expect(msg.FindFirstField("request"), gs.IsNil)
+				c.Expect(msg.FindFirstField("request_method"), gs.IsNil)
+				value, _ = msg.GetFieldValue("request_time")
+				c.Expect(value, gs.Equals, 0.0)
+			})
+		})
+
+		c.Specify("decodes Apache logs", func() {
+			conf.LogFormat = `%v:%p %h %l %u %t \"%r\" %>s %b \"%{Referer}i\" %D %P`
+			conf.FormatType = "apache"
+			conf.PayloadKeep = true
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			line := `www.example.com:80 127.0.0.1 - frank [10/Jan/2014:07:04:56 -0800] ` +
+				`"POST /form HTTP/1.0" 302 - "http://example.com/" 1500 4242`
+			pack.Message.SetPayload(line)
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			c.Expect(msg.GetTimestamp(), gs.Equals, timestamp)
+			c.Expect(msg.GetPayload(), gs.Equals, line)
+			c.Expect(msg.GetPid(), gs.Equals, int32(4242))
+			value, _ := msg.GetFieldValue("server_name")
+			c.Expect(value, gs.Equals, "www.example.com")
+			value, _ = msg.GetFieldValue("server_port")
+			c.Expect(value, gs.Equals, int64(80))
+			value, _ = msg.GetFieldValue("remote_logname")
+			c.Expect(value, gs.Equals, "-")
+			value, _ = msg.GetFieldValue("remote_user")
+			c.Expect(value, gs.Equals, "frank")
+			value, _ = msg.GetFieldValue("request_method")
+			c.Expect(value, gs.Equals, "POST")
+			value, _ = msg.GetFieldValue("status")
+			c.Expect(value, gs.Equals, int64(302))
+			c.Expect(msg.FindFirstField("body_bytes_sent"), gs.IsNil)
+			value, _ = msg.GetFieldValue("http_referer")
+			c.Expect(value, gs.Equals, "http://example.com/")
+			value, _ = msg.GetFieldValue("request_time")
+			c.Expect(value, gs.Equals, 0.0015)
+		})
+
+		c.Specify("decodes Apache logs w/ a custom time format", func() {
+			conf.LogFormat = `[%{%d/%b/%Y:%H:%M:%S %z}t] %{sec}t %s`
+			conf.FormatType = "apache"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload("[10/Jan/2014:07:04:56 -0800] 1389366296 200")
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, timestamp)
+		})
+
+		c.Specify("fails on non-matching lines", func() {
+			conf.LogFormat = `$remote_addr [$time_local] $status`
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload("10.0.0.1 - [10/Jan/2014:07:04:56 -0800] 200")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+			pack.Message.SetPayload("10.0.0.1 [10/Jan/2014:07:04:56 -0800] OK")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("fails on invalid log formats", func() {
+			for _, format := range []string{"", "no variables", "$ x"} {
+				conf.LogFormat = format
+				c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			}
+			conf.FormatType = "apache"
+			for _, format := range []string{"%Z", "%{Referer", "%h %"} {
+				conf.LogFormat = format
+				c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			}
+			conf.FormatType = "lighttpd"
+			conf.LogFormat = "$status"
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -23,6 +23,11 @@ func TestAllSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 	r.Parallel = false
 
+	r.AddSpec(AccessLogDecoderSpec)
+	r.AddSpec(CsvDecoderSpec)
+	r.AddSpec(GrokDecoderSpec)
+	r.AddSpec(JsonDecoderSpec)
+	r.AddSpec(KeyValueDecoderSpec)
 	r.AddSpec(MultiDecoderSpec)
 	r.AddSpec(PayloadDecodersSpec)
 
@@ -0,0 +1,335 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type CsvDecoderConfig struct {
+	// Character separating the values, e.g. "\t" for TSV.
+	Delimiter string `toml:"delimiter"`
+
+	// Character enclosing values containing the delimiter, an empty string
+	// disables quoting.
+	Quote string `toml:"quote"`
+
+	// Character escaping quotes within quoted values. If empty quotes are
+	// escaped by doubling them.
+	Escape string `toml:"escape"`
+
+	// Column names, in order. Empty names cause the column to be ignored.
+	Columns []string `toml:"columns"`
+
+	// Whether the first record of each stream is a header line providing the
+	// column names.
+	HeaderLine bool `toml:"header_line"`
+
+	// Maps column names to their type: "string", "int", "double", "bool" or
+	// "timestamp". Columns are strings by default.
+	ColumnTypes map[string]string `toml:"column_types"`
+
+	// Column setting the message Timestamp, parsed using the timestamp
+	// layouts.
+	TimestampColumn string `toml:"timestamp_column"`
+
+	// Column setting the message Severity, translated by the severity map.
+	SeverityColumn string `toml:"severity_column"`
+
+	// Maps severity strings to their int version
+	SeverityMap map[string]int32 `toml:"severity_map"`
+
+	// Keyed to the message field that should be filled in, the value will be
+	// interpolated so it can use the column values, e.g. "%hostname%".
+	MessageFields MessageTemplate `toml:"message_fields"`
+
+	// Timestamp layouts tried, in order, for the timestamp column and columns
+	// of the "timestamp" type, see PayloadRegexDecoder.
+	TimestampLayouts []string `toml:"timestamp_layouts"`
+
+	// Time zone in which the timestamps without zone information are presumed
+	// to be in. Defaults to "UTC".
+	TimestampLocation string `toml:"timestamp_location"`
+}
+
+type csvColumnType int
+
+const (
+	csvString csvColumnType = iota
+	csvInt
+	csvDouble
+	csvBool
+	csvTimestamp
+)
+
+var csvColumnTypes = map[string]csvColumnType{
+	"string":    csvString,
+	"int":       csvInt,
+	"double":    csvDouble,
+	"bool":      csvBool,
+	"timestamp": csvTimestamp,
+}
+
+type CsvDecoder struct {
+	delimiter       byte
+	quote           byte // 0 if quoting is disabled
+	escape          byte // 0 if quotes are escaped by doubling them
+	columns         []string
+	header          string // the header line once seen
+	headerLine      bool
+	columnTypes     map[string]csvColumnType
+	timestampColumn string
+	severityColumn  string
+	severityMap     map[string]int32
+	messageFields   MessageTemplate
+	timeParser      *message.TimeParser
+	dRunner         DecoderRunner
+}
+
+func (cd *CsvDecoder) ConfigStruct() interface{} {
+	return &CsvDecoderConfig{
+		Delimiter:       ",",
+		Quote:           `"`,
+		TimestampColumn: "Timestamp",
+		SeverityColumn:  "Severity",
+	}
+}
+
+// Returns the single character of a setting, 0 if empty.
+func csvChar(name, value string, optional bool) (byte, error) {
+	if len(value) == 1 || (optional && value == "") {
+		if value == "" {
+			return 0, nil
+		}
+		return value[0], nil
+	}
+	return 0, fmt.Errorf("CsvDecoder '%s' must be a single character", name)
+}
+
+func (cd *CsvDecoder) Init(config interface{}) (err error) {
+	conf := config.(*CsvDecoderConfig)
+	if cd.delimiter, err = csvChar("delimiter", conf.Delimiter, false); err != nil {
+		return
+	}
+	if cd.quote, err = csvChar("quote", conf.Quote, true); err != nil {
+		return
+	}
+	if cd.escape, err = csvChar("escape", conf.Escape, true); err != nil {
+		return
+	}
+	if cd.quote == cd.delimiter {
+		return errors.New("CsvDecoder 'quote' and 'delimiter' must differ")
+	}
+	if cd.escape == cd.quote {
+		// Same as doubling the quotes.
+		cd.escape = 0
+	}
+
+	cd.headerLine = conf.HeaderLine
+	if cd.headerLine == (len(conf.Columns) > 0) {
+		return errors.New("CsvDecoder requires either 'columns' or 'header_line'")
+	}
+	cd.columns = conf.Columns
+
+	cd.columnTypes = make(map[string]csvColumnType, len(conf.ColumnTypes))
+	for column, typeName := range conf.ColumnTypes {
+		colType, ok := csvColumnTypes[typeName]
+		if !ok {
+			return fmt.Errorf("CsvDecoder unknown type '%s' for column '%s'",
+				typeName, column)
+		}
+		cd.columnTypes[column] = colType
+	}
+
+	cd.timestampColumn = conf.TimestampColumn
+	cd.severityColumn = conf.SeverityColumn
+	cd.severityMap = conf.SeverityMap
+	cd.messageFields = conf.MessageFields
+
+	tzLocation, err := time.LoadLocation(conf.TimestampLocation)
+	if err != nil {
+		return fmt.Errorf("CsvDecoder unknown timestamp_location '%s': %s",
+			conf.TimestampLocation, err)
+	}
+	if cd.timeParser, err = message.NewTimeParser(conf.TimestampLayouts,
+		tzLocation); err != nil {
+		return fmt.Errorf("CsvDecoder timestamp layout error: %s", err)
+	}
+	return
+}
+
+// Heka will call this to give us access to the runner.
+func (cd *CsvDecoder) SetDecoderRunner(dr DecoderRunner) {
+	cd.dRunner = dr
+}
+
+// Splits a record into its values, removing the quotes and escapes.
+func (cd *CsvDecoder) splitRecord(record string) (values []string, err error) {
+	var value bytes.Buffer
+	for i := 0; ; i++ {
+		value.Reset()
+		if cd.quote != 0 && i < len(record) && record[i] == cd.quote {
+			closed := false
+			for i++; i < len(record) && !closed; i++ {
+				c := record[i]
+				switch {
+				case cd.escape != 0 && c == cd.escape && i+1 < len(record):
+					i++
+					c = record[i]
+				case c == cd.quote && cd.escape == 0 && i+1 < len(record) &&
+					record[i+1] == cd.quote:
+					i++
+				case c == cd.quote:
+					closed = true
+					continue
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return nil, errors.New("unterminated quoted value")
+			}
+			if i < len(record) && record[i] != cd.delimiter {
+				return nil, fmt.Errorf("unexpected '%c' after quoted value", record[i])
+			}
+		} else {
+			end := strings.IndexByte(record[i:], cd.delimiter)
+			if end == -1 {
+				end = len(record) - i
+			}
+			value.WriteString(record[i : i+end])
+			i += end
+		}
+		values = append(values, value.String())
+		if i >= len(record) {
+			return
+		}
+	}
+}
+
+func (cd *CsvDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	record := strings.TrimRight(pack.Message.GetPayload(), "\r\n")
+	if record == "" {
+		return
+	}
+	if cd.headerLine {
+		// The decoder is created per stream by inputs using a Deliverer per
+		// stream, the header is also skipped if repeated e.g. after a log
+		// rotation.
+		if cd.header == "" || record == cd.header {
+			if cd.header == "" {
+				if cd.columns, err = cd.splitRecord(record); err != nil {
+					return nil, fmt.Errorf("invalid header line: %s", err)
+				}
+				cd.header = record
+			}
+			return
+		}
+	}
+
+	values, err := cd.splitRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) > len(cd.columns) {
+		return nil, fmt.Errorf("record has %d values, expected %d", len(values),
+			len(cd.columns))
+	}
+
+	captures := make(map[string]string, len(values))
+	msg := pack.Message
+	for i, value := range values {
+		column := cd.columns[i]
+		if column == "" {
+			continue
+		}
+		captures[column] = value
+		switch column {
+		case cd.timestampColumn:
+			captures["Timestamp"] = value
+			continue
+		case cd.severityColumn:
+			captures["Severity"] = value
+			continue
+		}
+		if err = cd.addField(msg, column, value); err != nil {
+			return nil, fmt.Errorf("column '%s': %s", column, err)
+		}
+	}
+
+	pdh := &PayloadDecoderHelper{
+		Captures:    captures,
+		dRunner:     cd.dRunner,
+		TimeParser:  cd.timeParser,
+		SeverityMap: cd.severityMap,
+	}
+	pdh.DecodeTimestamp(pack)
+	pdh.DecodeSeverity(pack)
+
+	if err = cd.messageFields.PopulateMessage(msg, captures); err != nil {
+		return nil, err
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+func (cd *CsvDecoder) addField(msg *message.Message, column, value string) (err error) {
+	colType := cd.columnTypes[column]
+	if colType == csvString {
+		message.NewStringField(msg, column, value)
+		return
+	}
+	if value == "" {
+		// Typed columns are omitted when empty.
+		return
+	}
+
+	var typed interface{}
+	representation := ""
+	switch colType {
+	case csvInt:
+		typed, err = strconv.ParseInt(value, 10, 64)
+	case csvDouble:
+		typed, err = strconv.ParseFloat(value, 64)
+	case csvBool:
+		typed, err = strconv.ParseBool(value)
+	case csvTimestamp:
+		var t time.Time
+		if t, err = cd.timeParser.Parse(value); err == nil {
+			typed, representation = t.UnixNano(), "ns"
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value '%s'", value)
+	}
+	f, err := message.NewField(column, typed, representation)
+	if err != nil {
+		return fmt.Errorf("field creation error: %s", err)
+	}
+	msg.AddField(f)
+	return
+}
+
+func init() {
+	RegisterPlugin("CsvDecoder", func() interface{} {
+		return new(CsvDecoder)
+	})
+}
@@ -0,0 +1,163 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CsvDecoderSpec(c gs.Context) {
+	c.Specify("A CsvDecoder", func() {
+		decoder := new(CsvDecoder)
+		conf := decoder.ConfigStruct().(*CsvDecoderConfig)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		decode := func(record string) (*message.Message, error) {
+			pack.Message = new(message.Message)
+			pack.Message.SetPayload(record)
+			packs, err := decoder.Decode(pack)
+			if packs == nil {
+				return nil, err
+			}
+			return packs[0].Message, err
+		}
+
+		c.Specify("splits records", func() {
+			err := decoder.Init(&CsvDecoderConfig{Delimiter: ",", Quote: `"`,
+				Columns: []string{"a"}})
+			c.Assume(err, gs.IsNil)
+			values, err := decoder.splitRecord(`x,"y, ""z""",,"",w`)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(values), gs.Equals, 5)
+			c.Expect(values[1], gs.Equals, `y, "z"`)
+			c.Expect(values[2], gs.Equals, "")
+			c.Expect(values[3], gs.Equals, "")
+			c.Expect(values[4], gs.Equals, "w")
+
+			values, err = decoder.splitRecord("a,")
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(values), gs.Equals, 2)
+
+			_, err = decoder.splitRecord(`a,"b`)
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = decoder.splitRecord(`"a"b,c`)
+			c.Expect(err, gs.Not(gs.IsNil))
+
+			c.Specify("w/ an escape character", func() {
+				decoder.escape = '\\'
+				values, err = decoder.splitRecord(`"a \"b\" \\",c`)
+				c.Expect(err, gs.IsNil)
+				c.Expect(values[0], gs.Equals, `a "b" \`)
+			})
+		})
+
+		c.Specify("decodes typed columns", func() {
+			conf.Columns = []string{"Timestamp", "account", "", "cost", "count",
+				"paid", "Severity", "due"}
+			conf.ColumnTypes = map[string]string{
+				"cost":  "double",
+				"count": "int",
+				"paid":  "bool",
+				"due":   "timestamp",
+			}
+			conf.SeverityMap = map[string]int32{"warn": 4}
+			conf.MessageFields = MessageTemplate{
+				"Type":     "billing",
+				"Hostname": "%account%.example.com",
+			}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode("2015-08-21T14:30:05Z,acme,skipped,12.5,3,true,warn," +
+				"2015-09-01T00:00:00Z\n")
+			c.Assume(err, gs.IsNil)
+
+			c.Expect(msg.GetTimestamp(), gs.Equals,
+				time.Date(2015, 8, 21, 14, 30, 5, 0, time.UTC).UnixNano())
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(4))
+			c.Expect(msg.GetType(), gs.Equals, "billing")
+			c.Expect(msg.GetHostname(), gs.Equals, "acme.example.com")
+			c.Expect(msg.FindFirstField("Timestamp"), gs.IsNil)
+			c.Expect(msg.FindFirstField(""), gs.IsNil)
+			value, _ := msg.GetFieldValue("account")
+			c.Expect(value, gs.Equals, "acme")
+			value, _ = msg.GetFieldValue("cost")
+			c.Expect(value, gs.Equals, 12.5)
+			value, _ = msg.GetFieldValue("count")
+			c.Expect(value, gs.Equals, int64(3))
+			value, _ = msg.GetFieldValue("paid")
+			c.Expect(value, gs.Equals, true)
+			f := msg.FindFirstField("due")
+			c.Expect(f.GetRepresentation(), gs.Equals, "ns")
+			c.Expect(f.ValueInteger[0], gs.Equals,
+				time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+
+			c.Specify("omitting empty typed values", func() {
+				msg, err = decode("2015-08-21T14:30:05Z,,,,")
+				c.Assume(err, gs.IsNil)
+				value, _ = msg.GetFieldValue("account")
+				c.Expect(value, gs.Equals, "")
+				c.Expect(msg.FindFirstField("cost"), gs.IsNil)
+				c.Expect(msg.FindFirstField("paid"), gs.IsNil)
+			})
+
+			c.Specify("failing on invalid values", func() {
+				_, err = decode("2015-08-21T14:30:05Z,acme,,lots")
+				c.Expect(err, gs.Not(gs.IsNil))
+				_, err = decode("2015-08-21T14:30:05Z,acme,,1,2,true,warn,,extra")
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+
+		c.Specify("reads column names from the header line", func() {
+			conf.Delimiter = "\t"
+			conf.HeaderLine = true
+			conf.ColumnTypes = map[string]string{"rows": "int"}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			msg, err := decode("table\trows\n")
+			c.Expect(msg, gs.IsNil)
+			c.Expect(err, gs.IsNil)
+			msg, err = decode("users\t42\n")
+			c.Assume(err, gs.IsNil)
+			value, _ := msg.GetFieldValue("table")
+			c.Expect(value, gs.Equals, "users")
+			value, _ = msg.GetFieldValue("rows")
+			c.Expect(value, gs.Equals, int64(42))
+
+			msg, err = decode("table\trows")
+			c.Expect(msg, gs.IsNil)
+			c.Expect(err, gs.IsNil)
+		})
+
+		c.Specify("fails on invalid config", func() {
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.Columns = []string{"a"}
+			conf.HeaderLine = true
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.HeaderLine = false
+			conf.Delimiter = "||"
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.Delimiter = ","
+			conf.ColumnTypes = map[string]string{"a": "float"}
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,415 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type GrokDecoderConfig struct {
+	// Grok expressions tried in order, e.g. "%{IP:client} %{WORD:method}".
+	Match []string `toml:"match"`
+
+	// Files of additional patterns, one "NAME regex" definition per line, in
+	// the Logstash format. Relative paths are relative to the share_dir.
+	PatternFiles []string `toml:"pattern_files"`
+
+	// Additional pattern definitions, keyed by pattern name.
+	PatternDefinitions map[string]string `toml:"pattern_definitions"`
+
+	// Whether only the patterns given a name, e.g. "%{IP:client}", are
+	// captured. Otherwise unnamed patterns are captured using the pattern
+	// name.
+	NamedCapturesOnly bool `toml:"named_captures_only"`
+
+	// Whether the captures are added to the message as fields.
+	CapturesAsFields bool `toml:"captures_as_fields"`
+
+	// Maps severity strings to their int version
+	SeverityMap map[string]int32 `toml:"severity_map"`
+
+	// Keyed to the message field that should be filled in, the value will be
+	// interpolated so it can use the captures.
+	MessageFields MessageTemplate `toml:"message_fields"`
+
+	// Timestamp layouts tried, in order, for the "Timestamp" capture, see
+	// PayloadRegexDecoder.
+	TimestampLayouts []string `toml:"timestamp_layouts"`
+
+	// Time zone in which the timestamps without zone information are presumed
+	// to be in. Defaults to "UTC".
+	TimestampLocation string `toml:"timestamp_location"`
+
+	// Whether payloads that do not match any of the expressions should be
+	// logged.
+	LogErrors bool `toml:"log_errors"`
+}
+
+// A named capture and the conversion applied to its value.
+type grokCapture struct {
+	name       string
+	conversion string // "", "int" or "float"
+}
+
+type grokExpression struct {
+	re       *regexp.Regexp
+	captures []*grokCapture // by subexpression index, nil if not captured
+}
+
+type GrokDecoder struct {
+	expressions      []grokExpression
+	capturesAsFields bool
+	severityMap      map[string]int32
+	messageFields    MessageTemplate
+	timeParser       *message.TimeParser
+	logErrors        bool
+	dRunner          DecoderRunner
+	pConfig          *PipelineConfig
+}
+
+// Heka will call this before calling any other methods to give us access to
+// the pipeline configuration.
+func (gd *GrokDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
+	gd.pConfig = pConfig
+}
+
+func (gd *GrokDecoder) ConfigStruct() interface{} {
+	return &GrokDecoderConfig{
+		NamedCapturesOnly: true,
+		CapturesAsFields:  true,
+		LogErrors:         true,
+	}
+}
+
+func (gd *GrokDecoder) Init(config interface{}) (err error) {
+	conf := config.(*GrokDecoderConfig)
+	if len(conf.Match) == 0 {
+		return errors.New("GrokDecoder 'match' must be specified")
+	}
+
+	patterns := make(map[string]string, len(grokPatterns))
+	for name, pattern := range grokPatterns {
+		patterns[name] = pattern
+	}
+	for _, path := range conf.PatternFiles {
+		path = gd.pConfig.Globals.PrependShareDir(path)
+		if err = loadGrokPatterns(path, patterns); err != nil {
+			return fmt.Errorf("GrokDecoder pattern file '%s': %s", path, err)
+		}
+	}
+	for name, pattern := range conf.PatternDefinitions {
+		patterns[name] = pattern
+	}
+
+	gd.expressions = make([]grokExpression, len(conf.Match))
+	for i, expr := range conf.Match {
+		if gd.expressions[i], err = compileGrok(expr, patterns,
+			conf.NamedCapturesOnly); err != nil {
+			return fmt.Errorf("GrokDecoder '%s': %s", expr, err)
+		}
+	}
+
+	gd.capturesAsFields = conf.CapturesAsFields
+	gd.severityMap = conf.SeverityMap
+	gd.messageFields = conf.MessageFields
+	gd.logErrors = conf.LogErrors
+
+	tzLocation, err := time.LoadLocation(conf.TimestampLocation)
+	if err != nil {
+		return fmt.Errorf("GrokDecoder unknown timestamp_location '%s': %s",
+			conf.TimestampLocation, err)
+	}
+	if gd.timeParser, err = message.NewTimeParser(conf.TimestampLayouts,
+		tzLocation); err != nil {
+		return fmt.Errorf("GrokDecoder timestamp layout error: %s", err)
+	}
+	return
+}
+
+// Heka will call this to give us access to the runner.
+func (gd *GrokDecoder) SetDecoderRunner(dr DecoderRunner) {
+	gd.dRunner = dr
+}
+
+// Reads Logstash style pattern definitions, skipping comments and blank lines.
+func loadGrokPatterns(path string, patterns map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		sep := strings.IndexAny(line, " \t")
+		if sep == -1 {
+			return fmt.Errorf("line %d: missing pattern", lineNum)
+		}
+		patterns[line[:sep]] = strings.TrimLeft(line[sep:], " \t")
+	}
+	return scanner.Err()
+}
+
+// Matches pattern references, e.g. "%{NUMBER:bytes:int}". Logstash style
+// nested field names like "[http][status]" are accepted.
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@\[\]-]+))?(?::(\w+))?\}`)
+
+type grokCompiler struct {
+	patterns  map[string]string
+	namedOnly bool
+	captures  map[string]*grokCapture // by subexpression name
+	expanding map[string]bool         // detects recursive patterns
+}
+
+// Recursively replaces the pattern references with the pattern expressions,
+// captured ones being wrapped in groups with generated names.
+func (gc *grokCompiler) expand(expr string) (expanded string, err error) {
+	expanded = grokReference.ReplaceAllStringFunc(expr, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		m := grokReference.FindStringSubmatch(ref)
+		name, field, conversion := m[1], m[2], m[3]
+		pattern, ok := gc.patterns[name]
+		if !ok {
+			err = fmt.Errorf("unknown pattern '%s'", name)
+			return ""
+		}
+		if gc.expanding[name] {
+			err = fmt.Errorf("pattern '%s' references itself", name)
+			return ""
+		}
+		gc.expanding[name] = true
+		pattern, err = gc.expand(pattern)
+		delete(gc.expanding, name)
+		if err != nil {
+			return ""
+		}
+
+		if field == "" {
+			if gc.namedOnly {
+				return "(?:" + pattern + ")"
+			}
+			field = name
+		}
+		if conversion != "" && conversion != "int" && conversion != "float" {
+			err = fmt.Errorf("unsupported conversion '%s' for '%s'", conversion, field)
+			return ""
+		}
+		group := fmt.Sprintf("_grok%d", len(gc.captures))
+		gc.captures[group] = &grokCapture{name: field, conversion: conversion}
+		return "(?P<" + group + ">" + pattern + ")"
+	})
+	return
+}
+
+// Rewrites the regular expression syntax used by Logstash patterns that Go's
+// regexp package doesn't support: "(?<name>" named groups, atomic groups and
+// possessive quantifiers. Lookaround assertions can't be rewritten.
+func convertOniguruma(expr string) (string, error) {
+	var (
+		buf        bytes.Buffer
+		inClass    bool
+		quantified bool // the previous token was a quantifier
+	)
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		wasQuantified := quantified
+		quantified = false
+		switch {
+		case c == '\\' && i+1 < len(expr):
+			n := 2
+			if strings.IndexByte("pPx", expr[i+1]) != -1 &&
+				strings.HasPrefix(expr[i+2:], "{") {
+				// e.g. "\p{Greek}", the braces aren't a repetition.
+				if end := strings.IndexByte(expr[i:], '}'); end != -1 {
+					n = end + 1
+				}
+			}
+			buf.WriteString(expr[i : i+n])
+			i += n - 1
+			continue
+		case inClass && strings.HasPrefix(expr[i:], "[:"):
+			// POSIX class, e.g. "[[:alpha:]]".
+			if end := strings.Index(expr[i:], ":]"); end != -1 {
+				buf.WriteString(expr[i : i+end+2])
+				i += end + 1
+				continue
+			}
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+			buf.WriteByte(c)
+			// A leading ']' is a literal.
+			if strings.HasPrefix(expr[i+1:], "^]") {
+				buf.WriteString("^]")
+				i += 2
+			} else if strings.HasPrefix(expr[i+1:], "]") {
+				buf.WriteByte(']')
+				i++
+			}
+			continue
+		case strings.HasPrefix(expr[i:], "(?=") || strings.HasPrefix(expr[i:], "(?!") ||
+			strings.HasPrefix(expr[i:], "(?<=") || strings.HasPrefix(expr[i:], "(?<!"):
+			return "", errors.New("lookaround assertions are not supported")
+		case strings.HasPrefix(expr[i:], "(?<"):
+			buf.WriteString("(?P<")
+			i += 2
+			continue
+		case strings.HasPrefix(expr[i:], "(?>"):
+			buf.WriteString("(?:")
+			i += 2
+			continue
+		case c == '+' && wasQuantified:
+			// Possessive quantifier, matched as a greedy one.
+			continue
+		case c == '*' || c == '+' || c == '?' || c == '}':
+			quantified = true
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String(), nil
+}
+
+func compileGrok(expr string, patterns map[string]string,
+	namedOnly bool) (ge grokExpression, err error) {
+
+	gc := &grokCompiler{
+		patterns:  patterns,
+		namedOnly: namedOnly,
+		captures:  make(map[string]*grokCapture),
+		expanding: make(map[string]bool),
+	}
+	if expr, err = gc.expand(expr); err != nil {
+		return
+	}
+	if expr, err = convertOniguruma(expr); err != nil {
+		return
+	}
+	if ge.re, err = regexp.Compile(expr); err != nil {
+		return
+	}
+	names := ge.re.SubexpNames()
+	ge.captures = make([]*grokCapture, len(names))
+	for i, name := range names {
+		if capture, ok := gc.captures[name]; ok {
+			ge.captures[i] = capture
+		} else if name != "" {
+			// Named group written as a regular expression.
+			ge.captures[i] = &grokCapture{name: name}
+		}
+	}
+	return
+}
+
+func (gd *GrokDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	payload := pack.Message.GetPayload()
+	var (
+		ge      grokExpression
+		indexes []int
+	)
+	for _, ge = range gd.expressions {
+		if indexes = ge.re.FindStringSubmatchIndex(payload); indexes != nil {
+			break
+		}
+	}
+	if indexes == nil {
+		if gd.logErrors {
+			err = fmt.Errorf("No match: %s", payload)
+		}
+		return
+	}
+
+	captures := make(map[string]string)
+	msg := pack.Message
+	for i, capture := range ge.captures {
+		if capture == nil || indexes[2*i] == -1 {
+			continue
+		}
+		value := payload[indexes[2*i]:indexes[2*i+1]]
+		captures[capture.name] = value
+		if !gd.capturesAsFields || capture.name == "Timestamp" ||
+			capture.name == "Severity" {
+			continue
+		}
+		if err = addGrokField(msg, capture, value); err != nil {
+			return nil, err
+		}
+	}
+
+	pdh := &PayloadDecoderHelper{
+		Captures:    captures,
+		dRunner:     gd.dRunner,
+		TimeParser:  gd.timeParser,
+		SeverityMap: gd.severityMap,
+	}
+	pdh.DecodeTimestamp(pack)
+	pdh.DecodeSeverity(pack)
+
+	if err = gd.messageFields.PopulateMessage(msg, captures); err != nil {
+		return nil, err
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+func addGrokField(msg *message.Message, capture *grokCapture, value string) error {
+	var typed interface{} = value
+	switch capture.conversion {
+	case "int":
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			// Matches Logstash, e.g. for a NUMBER with a fractional part.
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("'%s' is not an int: '%s'", capture.name, value)
+			}
+			i = int64(f)
+		}
+		typed = i
+	case "float":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a float: '%s'", capture.name, value)
+		}
+		typed = f
+	}
+	f, err := message.NewField(capture.name, typed, "")
+	if err != nil {
+		return fmt.Errorf("field creation error: %s", err)
+	}
+	msg.AddField(f)
+	return nil
+}
+
+func init() {
+	RegisterPlugin("GrokDecoder", func() interface{} {
+		return new(GrokDecoder)
+	})
+}
@@ -0,0 +1,167 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func GrokDecoderSpec(c gs.Context) {
+	c.Specify("A GrokDecoder", func() {
+		decoder := new(GrokDecoder)
+		decoder.SetPipelineConfig(NewPipelineConfig(nil))
+		conf := decoder.ConfigStruct().(*GrokDecoderConfig)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		decode := func(payload string) (*message.Message, error) {
+			pack.Message = new(message.Message)
+			pack.Message.SetPayload(payload)
+			packs, err := decoder.Decode(pack)
+			if packs == nil {
+				return nil, err
+			}
+			return packs[0].Message, err
+		}
+
+		c.Specify("compiles all the bundled patterns", func() {
+			for name := range grokPatterns {
+				_, err := compileGrok("%{"+name+"}", grokPatterns, true)
+				c.Expect(err, gs.IsNil)
+			}
+		})
+
+		c.Specify("decodes combined Apache logs", func() {
+			conf.Match = []string{`%{COMBINEDAPACHELOG}`}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode(`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] ` +
+				`"GET /apache_pb.gif HTTP/1.0" 200 2326 "http://example.com/start.html" ` +
+				`"Mozilla/4.08 [en] (Win98; I ;Nav)"`)
+			c.Assume(err, gs.IsNil)
+
+			value, _ := msg.GetFieldValue("clientip")
+			c.Expect(value, gs.Equals, "127.0.0.1")
+			value, _ = msg.GetFieldValue("auth")
+			c.Expect(value, gs.Equals, "frank")
+			value, _ = msg.GetFieldValue("timestamp")
+			c.Expect(value, gs.Equals, "10/Oct/2000:13:55:36 -0700")
+			value, _ = msg.GetFieldValue("verb")
+			c.Expect(value, gs.Equals, "GET")
+			value, _ = msg.GetFieldValue("request")
+			c.Expect(value, gs.Equals, "/apache_pb.gif")
+			value, _ = msg.GetFieldValue("response")
+			c.Expect(value, gs.Equals, "200")
+			value, _ = msg.GetFieldValue("agent")
+			c.Expect(value, gs.Equals, `"Mozilla/4.08 [en] (Win98; I ;Nav)"`)
+			c.Expect(msg.FindFirstField("rawrequest"), gs.IsNil)
+		})
+
+		c.Specify("converts captures and populates the message", func() {
+			conf.Match = []string{
+				`^%{TIMESTAMP_ISO8601:Timestamp} %{LOGLEVEL:Severity} %{NUMBER:took:float}s %{NUMBER:bytes:int}`,
+				`^%{IP:client} (?<user>\w++) %{GREEDYDATA:rest}`,
+			}
+			conf.SeverityMap = map[string]int32{"WARN": 4}
+			conf.MessageFields = MessageTemplate{"Type": "app.%took%"}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			msg, err := decode("2015-08-21T14:30:05Z WARN 0.25s 1024.0")
+			c.Assume(err, gs.IsNil)
+			c.Expect(msg.GetTimestamp(), gs.Equals,
+				time.Date(2015, 8, 21, 14, 30, 5, 0, time.UTC).UnixNano())
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(4))
+			c.Expect(msg.GetType(), gs.Equals, "app.0.25")
+			c.Expect(msg.FindFirstField("Timestamp"), gs.IsNil)
+			value, _ := msg.GetFieldValue("took")
+			c.Expect(value, gs.Equals, 0.25)
+			value, _ = msg.GetFieldValue("bytes")
+			c.Expect(value, gs.Equals, int64(1024))
+
+			msg, err = decode("fe80::1%eth0 bob logged in")
+			c.Assume(err, gs.IsNil)
+			value, _ = msg.GetFieldValue("client")
+			c.Expect(value, gs.Equals, "fe80::1%eth0")
+			value, _ = msg.GetFieldValue("user")
+			c.Expect(value, gs.Equals, "bob")
+
+			msg, err = decode("no match")
+			c.Expect(msg, gs.IsNil)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("loads patterns from files and definitions", func() {
+			file, err := ioutil.TempFile("", "grok")
+			c.Assume(err, gs.IsNil)
+			defer os.Remove(file.Name())
+			file.WriteString("# custom patterns\n\nQUEUE_ID [0-9A-F]{10,11}\n" +
+				"POSTFIX_QUEUE %{QUEUE_ID:queue_id}:\n")
+			file.Close()
+
+			conf.PatternFiles = []string{file.Name()}
+			conf.PatternDefinitions = map[string]string{"STATUS": `(?:sent|bounced)`}
+			conf.Match = []string{`%{POSTFIX_QUEUE} status=%{STATUS}`}
+			conf.NamedCapturesOnly = false
+			err = decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode("4F9D195432C: status=sent")
+			c.Assume(err, gs.IsNil)
+			value, _ := msg.GetFieldValue("queue_id")
+			c.Expect(value, gs.Equals, "4F9D195432C")
+			value, _ = msg.GetFieldValue("STATUS")
+			c.Expect(value, gs.Equals, "sent")
+		})
+
+		c.Specify("converts Oniguruma syntax", func() {
+			for expr, expected := range map[string]string{
+				`(?<name>a)`:        `(?P<name>a)`,
+				`(?>a|b)c`:          `(?:a|b)c`,
+				`a++b*+c?+d{2}+`:    `a+b*c?d{2}`,
+				`\++[+]+\p{Greek}+`: `\++[+]+\p{Greek}+`,
+				`[[:alpha:]]++`:     `[[:alpha:]]+`,
+			} {
+				converted, err := convertOniguruma(expr)
+				c.Expect(err, gs.IsNil)
+				c.Expect(converted, gs.Equals, expected)
+				_, err = regexp.Compile(converted)
+				c.Expect(err, gs.IsNil)
+			}
+			_, err := convertOniguruma(`(?<![0-9])a`)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("fails on invalid expressions", func() {
+			conf.PatternDefinitions = map[string]string{"LOOP": "%{LOOP}"}
+			for _, expr := range []string{"%{MISSING}", "%{LOOP}", "%{INT:n:bool}",
+				"(?=x)", "("} {
+				conf.Match = []string{expr}
+				c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			}
+			conf.Match = nil
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.Match = []string{"%{INT}"}
+			conf.PatternFiles = []string{"/nonexistent/patterns"}
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,362 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type JsonDecoderConfig struct {
+	// Name of the field containing the JSON, the payload is used if empty.
+	SourceField string `toml:"source_field"`
+
+	// Maps message header names (Timestamp, Severity, Hostname, Type, Logger,
+	// EnvVersion, Pid, Payload or Uuid) to the JSON key providing the value.
+	// Keys of nested objects are joined with the field separator.
+	HeaderMap map[string]string `toml:"header_map"`
+
+	// Whether the keys mapped onto the header are also added as fields.
+	KeepHeaderKeys bool `toml:"keep_header_keys"`
+
+	// Separator used to join the keys of nested objects into field names.
+	FieldSeparator string `toml:"field_separator"`
+
+	// Whether arrays of values of the same type are stored as a single
+	// multi-value field, otherwise each element gets its own field with the
+	// index appended to the name.
+	MultiValueArrays bool `toml:"multi_value_arrays"`
+
+	// Whether nested objects are stored as OBJECT fields instead of being
+	// flattened.
+	ObjectFields bool `toml:"object_fields"`
+
+	// Maps severity strings to their int version
+	SeverityMap map[string]int32 `toml:"severity_map"`
+
+	// Timestamp layouts tried, in order, see PayloadRegexDecoder. Numeric
+	// timestamps are treated as seconds since the epoch unless one of the
+	// Epoch layouts matches.
+	TimestampLayouts []string `toml:"timestamp_layouts"`
+
+	// Time zone in which the timestamps without zone information are presumed
+	// to be in. Defaults to "UTC".
+	TimestampLocation string `toml:"timestamp_location"`
+}
+
+type JsonDecoder struct {
+	sourceField      string
+	headerMap        map[string]string // JSON key -> header name
+	keepHeaderKeys   bool
+	separator        string
+	multiValueArrays bool
+	objectFields     bool
+	severityMap      map[string]int32
+	timeParser       *message.TimeParser
+}
+
+func (jd *JsonDecoder) ConfigStruct() interface{} {
+	return &JsonDecoderConfig{
+		FieldSeparator:   ".",
+		MultiValueArrays: true,
+	}
+}
+
+func (jd *JsonDecoder) Init(config interface{}) (err error) {
+	conf := config.(*JsonDecoderConfig)
+	jd.sourceField = conf.SourceField
+	jd.keepHeaderKeys = conf.KeepHeaderKeys
+	jd.separator = conf.FieldSeparator
+	jd.multiValueArrays = conf.MultiValueArrays
+	jd.objectFields = conf.ObjectFields
+	jd.severityMap = conf.SeverityMap
+
+	jd.headerMap = make(map[string]string, len(conf.HeaderMap))
+	for header, key := range conf.HeaderMap {
+		if !MessageHeaders[header] {
+			return fmt.Errorf("JsonDecoder unknown header_map header: '%s'", header)
+		}
+		jd.headerMap[key] = header
+	}
+
+	tzLocation, err := time.LoadLocation(conf.TimestampLocation)
+	if err != nil {
+		return fmt.Errorf("JsonDecoder unknown timestamp_location '%s': %s",
+			conf.TimestampLocation, err)
+	}
+	if jd.timeParser, err = message.NewTimeParser(conf.TimestampLayouts,
+		tzLocation); err != nil {
+		return fmt.Errorf("JsonDecoder timestamp layout error: %s", err)
+	}
+	return
+}
+
+func (jd *JsonDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	var source string
+	if jd.sourceField == "" {
+		source = pack.Message.GetPayload()
+	} else {
+		value, ok := pack.Message.GetFieldValue(jd.sourceField)
+		if !ok {
+			return nil, fmt.Errorf("field '%s' not found", jd.sourceField)
+		}
+		switch v := value.(type) {
+		case string:
+			source = v
+		case []byte:
+			source = string(v)
+		default:
+			return nil, fmt.Errorf("field '%s' is not a string", jd.sourceField)
+		}
+	}
+
+	dec := json.NewDecoder(strings.NewReader(source))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err = dec.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %s", err)
+	}
+	if obj == nil {
+		return nil, errors.New("JSON value is not an object")
+	}
+	if err = jd.addObject(pack.Message, "", obj); err != nil {
+		return nil, err
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+func (jd *JsonDecoder) addObject(msg *message.Message, prefix string,
+	obj map[string]interface{}) error {
+
+	// Sort the keys so the fields are always added in the same order.
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if prefix != "" {
+			if err := jd.addValue(msg, prefix+jd.separator+key, obj[key]); err != nil {
+				return err
+			}
+		} else if err := jd.addValue(msg, key, obj[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (jd *JsonDecoder) addValue(msg *message.Message, name string,
+	value interface{}) (err error) {
+
+	if header, ok := jd.headerMap[name]; ok {
+		if err = jd.setHeader(msg, header, value); err != nil {
+			return
+		}
+		if !jd.keepHeaderKeys {
+			return
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		if jd.objectFields {
+			return jd.addObjectField(msg, name, v)
+		}
+		return jd.addObject(msg, name, v)
+	case []interface{}:
+		return jd.addArray(msg, name, v)
+	}
+	var f *message.Field
+	if f, err = message.NewField(name, jsonScalar(value), ""); err != nil {
+		return fmt.Errorf("field creation error: %s", err)
+	}
+	msg.AddField(f)
+	return
+}
+
+// Adds a nested object as an OBJECT field, once the keys mapped onto the
+// header have been set. Objects that can't be stored, e.g. because an array
+// mixes types, are flattened.
+func (jd *JsonDecoder) addObjectField(msg *message.Message, name string,
+	obj map[string]interface{}) error {
+
+	members, err := jd.objectMembers(msg, name, obj)
+	if err != nil {
+		return err
+	}
+	value, err := message.NewObject(members)
+	if err != nil {
+		return jd.addObject(msg, name, members)
+	}
+	f := message.NewFieldInit(name, message.Field_OBJECT, "")
+	f.AddValue(value)
+	msg.AddField(f)
+	return nil
+}
+
+// Returns the members of a nested object w/ the field values of its numbers,
+// null values and empty arrays being dropped.
+func (jd *JsonDecoder) objectMembers(msg *message.Message, prefix string,
+	obj map[string]interface{}) (map[string]interface{}, error) {
+
+	members := make(map[string]interface{}, len(obj))
+	for key, value := range obj {
+		name := prefix + jd.separator + key
+		if header, ok := jd.headerMap[name]; ok {
+			if err := jd.setHeader(msg, header, value); err != nil {
+				return nil, err
+			}
+			if !jd.keepHeaderKeys {
+				continue
+			}
+		}
+		var err error
+		if value, err = jd.memberValue(msg, name, value); err != nil {
+			return nil, err
+		}
+		if value != nil {
+			members[key] = value
+		}
+	}
+	return members, nil
+}
+
+func (jd *JsonDecoder) memberValue(msg *message.Message, name string,
+	value interface{}) (interface{}, error) {
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return jd.objectMembers(msg, name, v)
+	case []interface{}:
+		if len(v) == 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, len(v))
+		for i, item := range v {
+			item, err := jd.memberValue(msg, name+jd.separator+strconv.Itoa(i), item)
+			if err != nil {
+				return nil, err
+			}
+			if item != nil {
+				items = append(items, item)
+			}
+		}
+		if len(items) == 0 {
+			return nil, nil
+		}
+		return items, nil
+	}
+	return jsonScalar(value), nil
+}
+
+// Returns the Go value stored in a field for a JSON number, string or bool.
+func jsonScalar(value interface{}) interface{} {
+	if n, ok := value.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		f, _ := n.Float64()
+		return f
+	}
+	return value
+}
+
+func (jd *JsonDecoder) addArray(msg *message.Message, name string,
+	values []interface{}) (err error) {
+
+	if len(values) == 0 {
+		return
+	}
+	if jd.multiValueArrays {
+		scalars := make([]interface{}, len(values))
+		for i, value := range values {
+			scalars[i] = jsonScalar(value)
+		}
+		if f := message.NewMultiValueField(name, scalars); f != nil {
+			msg.AddField(f)
+			return
+		}
+	}
+	for i, value := range values {
+		if err = jd.addValue(msg, name+jd.separator+strconv.Itoa(i), value); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (jd *JsonDecoder) setHeader(msg *message.Message, header string,
+	value interface{}) error {
+
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case json.Number:
+		str = v.String()
+	case bool:
+		str = strconv.FormatBool(v)
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("'%s' value is not a scalar", header)
+	}
+
+	switch header {
+	case "Timestamp":
+		t, err := jd.timeParser.Parse(str)
+		if err != nil {
+			n, ok := value.(json.Number)
+			if !ok {
+				return fmt.Errorf("Don't recognize Timestamp: '%s'", str)
+			}
+			secs, err := n.Float64()
+			if err != nil {
+				return fmt.Errorf("Don't recognize Timestamp: '%s'", str)
+			}
+			whole := int64(secs)
+			t = time.Unix(whole, int64((secs-float64(whole))*1e9))
+		}
+		msg.SetTimestamp(t.UnixNano())
+	case "Severity":
+		if sev, ok := jd.severityMap[str]; ok {
+			msg.SetSeverity(sev)
+			break
+		}
+		fallthrough
+	default:
+		if err := SetMessageHeader(msg, header, str); err != nil {
+			return fmt.Errorf("Don't recognize %s: '%s'", header, str)
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterPlugin("JsonDecoder", func() interface{} {
+		return new(JsonDecoder)
+	})
+}
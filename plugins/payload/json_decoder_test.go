@@ -0,0 +1,203 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func JsonDecoderSpec(c gs.Context) {
+	c.Specify("A JsonDecoder", func() {
+		decoder := new(JsonDecoder)
+		conf := decoder.ConfigStruct().(*JsonDecoderConfig)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+		pack.Message.SetPayload(`{"@timestamp": "2015-08-21T14:30:05.123Z",
+			"level": "warn", "host": {"name": "web1", "ip": "10.0.0.1"},
+			"status": 200, "duration": 0.25, "cached": false, "user": null,
+			"tags": ["a", "b"], "sizes": [1, 2.5], "mixed": [1, "x"]}`)
+
+		c.Specify("flattens nested objects preserving types", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			value, _ := msg.GetFieldValue("host.name")
+			c.Expect(value, gs.Equals, "web1")
+			value, _ = msg.GetFieldValue("status")
+			c.Expect(value, gs.Equals, int64(200))
+			value, _ = msg.GetFieldValue("duration")
+			c.Expect(value, gs.Equals, 0.25)
+			value, _ = msg.GetFieldValue("cached")
+			c.Expect(value, gs.Equals, false)
+			c.Expect(msg.FindFirstField("user"), gs.IsNil)
+
+			c.Specify("and keeps arrays as multi-value fields", func() {
+				tags := msg.FindFirstField("tags")
+				c.Expect(len(tags.ValueString), gs.Equals, 2)
+				c.Expect(tags.ValueString[1], gs.Equals, "b")
+				sizes := msg.FindFirstField("sizes")
+				c.Expect(sizes.GetValueType(), gs.Equals, message.Field_DOUBLE)
+				c.Expect(sizes.ValueDouble[0], gs.Equals, 1.0)
+				value, _ = msg.GetFieldValue("mixed.1")
+				c.Expect(value, gs.Equals, "x")
+			})
+		})
+
+		c.Specify("flattens arrays w/ a custom separator", func() {
+			conf.FieldSeparator = "_"
+			conf.MultiValueArrays = false
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			value, _ := pack.Message.GetFieldValue("host_ip")
+			c.Expect(value, gs.Equals, "10.0.0.1")
+			value, _ = pack.Message.GetFieldValue("tags_0")
+			c.Expect(value, gs.Equals, "a")
+			value, _ = pack.Message.GetFieldValue("sizes_0")
+			c.Expect(value, gs.Equals, int64(1))
+		})
+
+		c.Specify("stores nested objects as object fields", func() {
+			pack.Message.SetPayload(`{"host": {"name": "web1", "ip": "10.0.0.1",
+				"ports": [80, 443], "os": {"name": "linux"}, "none": null},
+				"bad": {"mixed": [1, "x"]}}`)
+			conf.ObjectFields = true
+			conf.HeaderMap = map[string]string{"Hostname": "host.name"}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			c.Expect(msg.GetHostname(), gs.Equals, "web1")
+			host := msg.FindFirstField("host")
+			c.Assume(host, gs.Not(gs.IsNil))
+			c.Expect(host.GetValueType(), gs.Equals, message.Field_OBJECT)
+			c.Expect(msg.FindFieldPath("host.name"), gs.IsNil)
+			c.Expect(msg.FindFieldPath("host.ports").ValueInteger, gs.Equals,
+				[]int64{80, 443})
+			c.Expect(msg.FindFieldPath("host.os.name").ValueString[0], gs.Equals, "linux")
+			c.Expect(msg.FindFieldPath("host.none"), gs.IsNil)
+			value, _ := msg.GetFieldValue("bad.mixed.1")
+			c.Expect(value, gs.Equals, "x")
+		})
+
+		c.Specify("maps keys onto the header", func() {
+			conf.HeaderMap = map[string]string{
+				"Timestamp": "@timestamp",
+				"Severity":  "level",
+				"Hostname":  "host.name",
+			}
+			conf.SeverityMap = map[string]int32{"warn": 4}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			t := time.Date(2015, 8, 21, 14, 30, 5, 123e6, time.UTC)
+			c.Expect(msg.GetTimestamp(), gs.Equals, t.UnixNano())
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(4))
+			c.Expect(msg.GetHostname(), gs.Equals, "web1")
+			c.Expect(msg.FindFirstField("host.name"), gs.IsNil)
+			c.Expect(msg.FindFirstField("level"), gs.IsNil)
+
+			c.Specify("and treats numeric timestamps as epoch seconds", func() {
+				pack.Message = new(message.Message)
+				pack.Message.SetPayload(`{"@timestamp": 1440167405.5}`)
+				_, err = decoder.Decode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1440167405500000000))
+			})
+		})
+
+		c.Specify("maps keys onto every header", func() {
+			conf.HeaderMap = map[string]string{
+				"Timestamp":  "ts",
+				"Severity":   "sev",
+				"Hostname":   "host",
+				"Type":       "type",
+				"Logger":     "logger",
+				"EnvVersion": "env",
+				"Pid":        "pid",
+				"Payload":    "body",
+				"Uuid":       "id",
+			}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload(`{"ts": "2015-08-21T14:30:05Z", "sev": 3,
+				"host": "web1", "type": "access", "logger": "nginx", "env": "0.8",
+				"pid": 1234, "body": "GET /",
+				"id": "a9f9bd0a-6a8b-4ff0-8c39-3ed8f6b0d3a1"}`)
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			t := time.Date(2015, 8, 21, 14, 30, 5, 0, time.UTC)
+			c.Expect(msg.GetTimestamp(), gs.Equals, t.UnixNano())
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(3))
+			c.Expect(msg.GetHostname(), gs.Equals, "web1")
+			c.Expect(msg.GetType(), gs.Equals, "access")
+			c.Expect(msg.GetLogger(), gs.Equals, "nginx")
+			c.Expect(msg.GetEnvVersion(), gs.Equals, "0.8")
+			c.Expect(msg.GetPid(), gs.Equals, int32(1234))
+			c.Expect(msg.GetPayload(), gs.Equals, "GET /")
+			c.Expect(msg.GetUuidString(), gs.Equals, "a9f9bd0a-6a8b-4ff0-8c39-3ed8f6b0d3a1")
+			c.Expect(len(msg.Fields), gs.Equals, 0)
+
+			c.Specify("and reports invalid values", func() {
+				pack.Message.SetPayload(`{"pid": "main"}`)
+				_, err = decoder.Decode(pack)
+				c.Expect(err.Error(), gs.Equals, "Don't recognize Pid: 'main'")
+			})
+		})
+
+		c.Specify("decodes JSON from a field", func() {
+			conf.SourceField = "json"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			message.NewStringField(pack.Message, "json", `{"a": {"b": true}}`)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			value, _ := pack.Message.GetFieldValue("a.b")
+			c.Expect(value, gs.Equals, true)
+		})
+
+		c.Specify("fails on invalid input", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload(`{"a": `)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+			pack.Message.SetPayload(`[1, 2]`)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown headers", func() {
+			conf.HeaderMap = map[string]string{"Host": "host"}
+			err := decoder.Init(conf)
+			c.Expect(err.Error(), gs.Equals, "JsonDecoder unknown header_map header: 'Host'")
+		})
+	})
+}
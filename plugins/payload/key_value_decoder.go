@@ -0,0 +1,225 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type KeyValueDecoderConfig struct {
+	// Separator between the pairs. A single space matches any run of
+	// whitespace.
+	PairSeparator string `toml:"pair_separator"`
+
+	// Separator between a key and its value.
+	KeyValueSeparator string `toml:"kv_separator"`
+
+	// Whether unquoted numeric values are stored as integers or doubles.
+	TypeNumbers bool `toml:"type_numbers"`
+
+	// Only the listed keys are added as fields, if specified.
+	IncludeKeys []string `toml:"include_keys"`
+
+	// The listed keys are never added as fields.
+	ExcludeKeys []string `toml:"exclude_keys"`
+}
+
+type KeyValueDecoder struct {
+	pairSep     string // empty for any whitespace
+	kvSep       string
+	typeNumbers bool
+	includeKeys map[string]bool
+	excludeKeys map[string]bool
+}
+
+func (kd *KeyValueDecoder) ConfigStruct() interface{} {
+	return &KeyValueDecoderConfig{
+		PairSeparator:     " ",
+		KeyValueSeparator: "=",
+		TypeNumbers:       true,
+	}
+}
+
+func keySet(keys []string) map[string]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+	return set
+}
+
+func (kd *KeyValueDecoder) Init(config interface{}) (err error) {
+	conf := config.(*KeyValueDecoderConfig)
+	if conf.PairSeparator == "" || conf.KeyValueSeparator == "" {
+		return errors.New("KeyValueDecoder separators must not be empty")
+	}
+	if conf.PairSeparator == conf.KeyValueSeparator {
+		return errors.New("KeyValueDecoder 'pair_separator' and 'kv_separator' must differ")
+	}
+	if conf.PairSeparator != " " {
+		kd.pairSep = conf.PairSeparator
+	}
+	kd.kvSep = conf.KeyValueSeparator
+	kd.typeNumbers = conf.TypeNumbers
+	kd.includeKeys = keySet(conf.IncludeKeys)
+	kd.excludeKeys = keySet(conf.ExcludeKeys)
+	return
+}
+
+func isKvSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// Returns the length of the pair separator at the start of the string, 0 if
+// there is none.
+func (kd *KeyValueDecoder) pairSepLen(s string) int {
+	if kd.pairSep != "" {
+		if strings.HasPrefix(s, kd.pairSep) {
+			return len(kd.pairSep)
+		}
+		return 0
+	}
+	n := 0
+	for n < len(s) && isKvSpace(s[n]) {
+		n++
+	}
+	return n
+}
+
+// Returns the index of the next pair separator, or the length of the string.
+func (kd *KeyValueDecoder) pairEnd(s string) int {
+	for i := 0; i < len(s); i++ {
+		if kd.pairSepLen(s[i:]) > 0 {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// Parses a quoted value, returning the unescaped value and its length
+// including the quotes.
+func parseQuoted(s string) (value string, n int, err error) {
+	var buf bytes.Buffer
+	for n = 1; n < len(s); n++ {
+		c := s[n]
+		switch c {
+		case '"':
+			return buf.String(), n + 1, nil
+		case '\\':
+			if n+1 < len(s) {
+				n++
+				switch c = s[n]; c {
+				case 'n':
+					c = '\n'
+				case 't':
+					c = '\t'
+				case 'r':
+					c = '\r'
+				}
+			}
+		}
+		buf.WriteByte(c)
+	}
+	return "", 0, errors.New("unterminated quoted value")
+}
+
+func (kd *KeyValueDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	s := pack.Message.GetPayload()
+	for i := kd.pairSepLen(s); i < len(s); i += kd.pairSepLen(s[i:]) {
+		end := kd.pairEnd(s[i:])
+		key := s[i : i+end]
+		kv := strings.Index(key, kd.kvSep)
+		if kv == -1 {
+			// A key without a value is a flag, as in logfmt.
+			if err = kd.addField(pack.Message, key, true); err != nil {
+				return nil, err
+			}
+			i += end
+			continue
+		}
+		key = key[:kv]
+		i += kv + len(kd.kvSep)
+
+		var value interface{}
+		if i < len(s) && s[i] == '"' {
+			var n int
+			if value, n, err = parseQuoted(s[i:]); err != nil {
+				return nil, fmt.Errorf("key '%s': %s", key, err)
+			}
+			i += n
+			if i < len(s) && kd.pairSepLen(s[i:]) == 0 {
+				return nil, fmt.Errorf("key '%s': unexpected '%c' after quoted value",
+					key, s[i])
+			}
+		} else {
+			end = kd.pairEnd(s[i:])
+			value = kd.typedValue(s[i : i+end])
+			i += end
+		}
+		if err = kd.addField(pack.Message, key, value); err != nil {
+			return nil, err
+		}
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Converts unquoted numbers to int64 or float64 values if enabled.
+func (kd *KeyValueDecoder) typedValue(value string) interface{} {
+	if !kd.typeNumbers || value == "" {
+		return value
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil &&
+		strings.IndexAny(value, "nN") == -1 {
+		// Excludes "NaN" and "Inf", which are much more likely to be words.
+		return f
+	}
+	return value
+}
+
+func (kd *KeyValueDecoder) addField(msg *message.Message, key string,
+	value interface{}) error {
+
+	if key == "" {
+		return errors.New("empty key")
+	}
+	if kd.includeKeys != nil && !kd.includeKeys[key] || kd.excludeKeys[key] {
+		return nil
+	}
+	f, err := message.NewField(key, value, "")
+	if err != nil {
+		return fmt.Errorf("field creation error: %s", err)
+	}
+	msg.AddField(f)
+	return nil
+}
+
+func init() {
+	RegisterPlugin("KeyValueDecoder", func() interface{} {
+		return new(KeyValueDecoder)
+	})
+}
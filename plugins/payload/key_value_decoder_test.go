@@ -0,0 +1,108 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func KeyValueDecoderSpec(c gs.Context) {
+	c.Specify("A KeyValueDecoder", func() {
+		decoder := new(KeyValueDecoder)
+		conf := decoder.ConfigStruct().(*KeyValueDecoderConfig)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		decode := func(payload string) (*message.Message, error) {
+			pack.Message = new(message.Message)
+			pack.Message.SetPayload(payload)
+			_, err := decoder.Decode(pack)
+			return pack.Message, err
+		}
+
+		c.Specify("decodes logfmt", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode(`time="2015-08-21T14:30:05Z" level=info  msg="user \"bob\" logged in" ` +
+				"status=200 took=0.25 id=\"42\" empty= dryrun host=NaN\n")
+			c.Assume(err, gs.IsNil)
+
+			value, _ := msg.GetFieldValue("time")
+			c.Expect(value, gs.Equals, "2015-08-21T14:30:05Z")
+			value, _ = msg.GetFieldValue("level")
+			c.Expect(value, gs.Equals, "info")
+			value, _ = msg.GetFieldValue("msg")
+			c.Expect(value, gs.Equals, `user "bob" logged in`)
+			value, _ = msg.GetFieldValue("status")
+			c.Expect(value, gs.Equals, int64(200))
+			value, _ = msg.GetFieldValue("took")
+			c.Expect(value, gs.Equals, 0.25)
+			value, _ = msg.GetFieldValue("id")
+			c.Expect(value, gs.Equals, "42")
+			value, _ = msg.GetFieldValue("empty")
+			c.Expect(value, gs.Equals, "")
+			value, _ = msg.GetFieldValue("dryrun")
+			c.Expect(value, gs.Equals, true)
+			value, _ = msg.GetFieldValue("host")
+			c.Expect(value, gs.Equals, "NaN")
+		})
+
+		c.Specify("decodes w/ custom separators", func() {
+			conf.PairSeparator = ", "
+			conf.KeyValueSeparator = ":"
+			conf.TypeNumbers = false
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode(`src:10.0.0.1, dst:10.0.0.2, note:"a, b", port:443`)
+			c.Assume(err, gs.IsNil)
+
+			value, _ := msg.GetFieldValue("dst")
+			c.Expect(value, gs.Equals, "10.0.0.2")
+			value, _ = msg.GetFieldValue("note")
+			c.Expect(value, gs.Equals, "a, b")
+			value, _ = msg.GetFieldValue("port")
+			c.Expect(value, gs.Equals, "443")
+		})
+
+		c.Specify("filters keys", func() {
+			conf.IncludeKeys = []string{"a", "b"}
+			conf.ExcludeKeys = []string{"b"}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode("a=1 b=2 c=3")
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(msg.Fields), gs.Equals, 1)
+			c.Expect(msg.Fields[0].GetName(), gs.Equals, "a")
+		})
+
+		c.Specify("fails on invalid payloads", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			for _, payload := range []string{`a="b`, `a="b"c`, "=b"} {
+				_, err = decode(payload)
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+
+		c.Specify("fails on invalid separators", func() {
+			conf.PairSeparator = "="
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.PairSeparator = ""
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
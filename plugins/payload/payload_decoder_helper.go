@@ -27,7 +27,9 @@ type PayloadDecoderHelper struct {
 	dRunner         DecoderRunner
 	TimestampLayout string
 	TzLocation      *time.Location
-	SeverityMap     map[string]int32
+	// If set used instead of TimestampLayout and TzLocation.
+	TimeParser  *TimeParser
+	SeverityMap map[string]int32
 }
 
 /*
@@ -38,7 +40,15 @@ timestamp is written and the default of 0 is used.
 */
 func (pdh *PayloadDecoderHelper) DecodeTimestamp(pack *PipelinePack) {
 	if timeStamp, ok := pdh.Captures["Timestamp"]; ok {
-		val, err := ForgivingTimeParse(pdh.TimestampLayout, timeStamp, pdh.TzLocation)
+		var (
+			val time.Time
+			err error
+		)
+		if pdh.TimeParser != nil {
+			val, err = pdh.TimeParser.Parse(timeStamp)
+		} else {
+			val, err = ForgivingTimeParse(pdh.TimestampLayout, timeStamp, pdh.TzLocation)
+		}
 		if err != nil {
 			pdh.dRunner.LogError(fmt.Errorf("Don't recognize Timestamp: '%s'", timeStamp))
 		}
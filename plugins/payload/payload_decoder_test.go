@@ -237,6 +237,72 @@ func PayloadDecodersSpec(c gospec.Context) {
 			pack.Zero()
 		})
 
+		c.Specify("tries timestamp layouts in order", func() {
+			conf.MatchRegex = `\[(?P<Timestamp>[^\]]+)\]`
+			conf.TimestampLayouts = []string{"%Y-%m-%d %H:%M:%S", "EpochMilli"}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+			decoder.SetDecoderRunner(dRunner)
+
+			pack.Message.SetPayload("[2013-04-18 21:00:28]")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1366318828000000000))
+
+			pack.Message.SetPayload("[1366318828000]")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1366318828000000000))
+			pack.Zero()
+		})
+
+		c.Specify("detects common timestamp formats if asked to", func() {
+			conf.MatchRegex = `\[(?P<Timestamp>[^\]]+)\]`
+			dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+			pack.Message.SetPayload("[2013-W16-4T21:00:28Z]")
+
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder.SetDecoderRunner(dRunner)
+			dRunner.EXPECT().LogError(gomock.Any())
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Not(gs.Equals),
+				int64(1366318828000000000))
+
+			conf.TimestampDetect = true
+			err = decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder.SetDecoderRunner(dRunner)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1366318828000000000))
+			pack.Zero()
+		})
+
+		c.Specify("uses ForgivingTimeParse for a lone timestamp_layout", func() {
+			conf.MatchRegex = `\[(?P<Timestamp>[^\]]+)\]`
+			conf.TimestampLayout = "2006-01-02 15:04:05"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			c.Expect(decoder.timeParser, gs.IsNil)
+			dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+			decoder.SetDecoderRunner(dRunner)
+			pack.Message.SetPayload("[2013-04-18 21:00:28]")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1366318828000000000))
+			pack.Zero()
+		})
+
+		c.Specify("rejects invalid strftime layouts", func() {
+			conf.MatchRegex = `\[(?P<Timestamp>[^\]]+)\]`
+			conf.TimestampLayouts = []string{"%Y %Q"}
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
 		c.Specify("apply representation metadata to a captured field", func() {
 			value := "0.23"
 			payload := "header"
@@ -17,6 +17,7 @@ package payload
 
 import (
 	"fmt"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	"regexp"
 	"time"
@@ -40,6 +41,16 @@ type PayloadRegexDecoderConfig struct {
 	// http://golang.org/pkg/time/#pkg-constants).
 	TimestampLayout string `toml:"timestamp_layout"`
 
+	// Additional timestamp layouts tried, in order, after `timestamp_layout`.
+	// Each layout can be a Go time layout, the name of a Go time layout
+	// constant (e.g. "RFC3339"), an Epoch variant or a strftime style pattern
+	// (e.g. "%Y-%m-%dT%H:%M:%S").
+	TimestampLayouts []string `toml:"timestamp_layouts"`
+
+	// If true common timestamp formats are detected when none of the layouts
+	// match. Defaults to false.
+	TimestampDetect bool `toml:"timestamp_detect"`
+
 	// Time zone in which the timestamps in the text are presumed to be in.
 	// Should be a location name corresponding to a file in the IANA Time Zone
 	// database (e.g. "America/Los_Angeles"), as parsed by Go's
@@ -59,6 +70,7 @@ type PayloadRegexDecoder struct {
 	MessageFields   MessageTemplate
 	TimestampLayout string
 	tzLocation      *time.Location
+	timeParser      *message.TimeParser
 	dRunner         DecoderRunner
 	logErrors       bool
 }
@@ -96,6 +108,17 @@ func (ld *PayloadRegexDecoder) Init(config interface{}) (err error) {
 	if ld.tzLocation, err = time.LoadLocation(conf.TimestampLocation); err != nil {
 		err = fmt.Errorf("PayloadRegexDecoder unknown timestamp_location '%s': %s",
 			conf.TimestampLocation, err)
+		return
+	}
+	// A lone timestamp_layout is handled by ForgivingTimeParse, a TimeParser
+	// is only needed for additional layouts or format detection.
+	if len(conf.TimestampLayouts) > 0 || conf.TimestampDetect {
+		layouts := append([]string{conf.TimestampLayout}, conf.TimestampLayouts...)
+		if ld.timeParser, err = message.NewTimeParser(layouts, ld.tzLocation); err != nil {
+			err = fmt.Errorf("PayloadRegexDecoder timestamp layout error: %s", err)
+			return
+		}
+		ld.timeParser.Detect = conf.TimestampDetect
 	}
 	ld.logErrors = conf.LogErrors
 	return
@@ -145,6 +168,7 @@ func (ld *PayloadRegexDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack
 		dRunner:         ld.dRunner,
 		TimestampLayout: ld.TimestampLayout,
 		TzLocation:      ld.tzLocation,
+		TimeParser:      ld.timeParser,
 		SeverityMap:     ld.SeverityMap,
 	}
 
@@ -17,6 +17,7 @@ package payload
 import (
 	"fmt"
 	"github.com/crankycoder/xmlpath"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	"strings"
 	"time"
@@ -39,6 +40,16 @@ type PayloadXmlDecoderConfig struct {
 	// match, all the default time layout's will be tried.
 	TimestampLayout string `toml:"timestamp_layout"`
 
+	// Additional timestamp layouts tried, in order, after `timestamp_layout`.
+	// Each layout can be a Go time layout, the name of a Go time layout
+	// constant (e.g. "RFC3339"), an Epoch variant or a strftime style pattern
+	// (e.g. "%Y-%m-%dT%H:%M:%S").
+	TimestampLayouts []string `toml:"timestamp_layouts"`
+
+	// If true common timestamp formats are detected when none of the layouts
+	// match. Defaults to false.
+	TimestampDetect bool `toml:"timestamp_detect"`
+
 	// Time zone in which the timestamps in the text are presumed to be in.
 	// Should be a location name corresponding to a file in the IANA Time Zone
 	// database (e.g. "America/Los_Angeles"), as parsed by Go's
@@ -55,6 +66,7 @@ type PayloadXmlDecoder struct {
 	MessageFields   MessageTemplate
 	TimestampLayout string
 	tzLocation      *time.Location
+	timeParser      *message.TimeParser
 	dRunner         DecoderRunner
 }
 
@@ -88,6 +100,17 @@ func (pxd *PayloadXmlDecoder) Init(config interface{}) (err error) {
 	if pxd.tzLocation, err = time.LoadLocation(conf.TimestampLocation); err != nil {
 		err = fmt.Errorf("PayloadXmlDecoder unknown timestamp_location '%s': %s",
 			conf.TimestampLocation, err)
+		return
+	}
+	// A lone timestamp_layout is handled by ForgivingTimeParse, a TimeParser
+	// is only needed for additional layouts or format detection.
+	if len(conf.TimestampLayouts) > 0 || conf.TimestampDetect {
+		layouts := append([]string{conf.TimestampLayout}, conf.TimestampLayouts...)
+		if pxd.timeParser, err = message.NewTimeParser(layouts, pxd.tzLocation); err != nil {
+			err = fmt.Errorf("PayloadXmlDecoder timestamp layout error: %s", err)
+			return
+		}
+		pxd.timeParser.Detect = conf.TimestampDetect
 	}
 	return
 }
@@ -132,6 +155,7 @@ func (pxd *PayloadXmlDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack,
 		dRunner:         pxd.dRunner,
 		TimestampLayout: pxd.TimestampLayout,
 		TzLocation:      pxd.tzLocation,
+		TimeParser:      pxd.timeParser,
 		SeverityMap:     pxd.SeverityMap,
 	}
 
@@ -15,14 +15,16 @@
 package plugins
 
 import (
-	"github.com/mozilla-services/heka/pipeline"
 	"github.com/cactus/gostrftime"
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
 	"strings"
 	"time"
 )
 
 type PayloadEncoder struct {
-	config *PayloadEncoderConfig
+	config   *PayloadEncoderConfig
+	strftime bool
 }
 
 type PayloadEncoderConfig struct {
@@ -42,7 +44,8 @@ func (pe *PayloadEncoder) ConfigStruct() interface{} {
 
 func (pe *PayloadEncoder) Init(config interface{}) (err error) {
 	pe.config = config.(*PayloadEncoderConfig)
-	if !strings.HasSuffix(pe.config.TsFormat, " ") {
+	pe.strftime = strings.Contains(pe.config.TsFormat, "%")
+	if pe.strftime && !strings.HasSuffix(pe.config.TsFormat, " ") {
 		pe.config.TsFormat += " "
 	}
 	return
@@ -72,7 +75,14 @@ func (pe *PayloadEncoder) Encode(pack *pipeline.PipelinePack) (output []byte, er
 	} else {
 		tm = time.Now()
 	}
-	ts := gostrftime.Strftime(pe.config.TsFormat, tm)
+	var ts string
+	if pe.strftime {
+		ts = gostrftime.Strftime(pe.config.TsFormat, tm)
+	} else {
+		if ts = message.FormatTime(pe.config.TsFormat, tm); !strings.HasSuffix(ts, " ") {
+			ts += " "
+		}
+	}
 
 	// Timestamp + payload [+ optional newline].
 	l := len(ts) + len(payload)
@@ -83,5 +83,18 @@ func PayloadEncoderSpec(c gs.Context) {
 			expected := fmt.Sprintf("%s %s\n", formattedTime, payload)
 			c.Expect(string(output), gs.Equals, expected)
 		})
+
+		c.Specify("supports Go time layouts", func() {
+			config.PrefixTs = true
+			config.TsFormat = "RFC3339"
+			err = encoder.Init(config)
+			c.Expect(err, gs.IsNil)
+
+			output, err = encoder.Encode(pack)
+			c.Expect(err, gs.IsNil)
+			formattedTime := ts.Format(time.RFC3339)
+			expected := fmt.Sprintf("%s %s\n", formattedTime, payload)
+			c.Expect(string(output), gs.Equals, expected)
+		})
 	})
 }
@@ -125,6 +125,12 @@ func (re *RstEncoder) Encode(pack *pipeline.PipelinePack) (output []byte, err er
 				for i, v := range vBools {
 					values[i] = strconv.FormatBool(v)
 				}
+			case message.Field_OBJECT:
+				vObjects := field.GetValueObject()
+				values = make([]string, len(vObjects))
+				for i, v := range vObjects {
+					values[i] = v.String()
+				}
 			}
 			re.writeField(buf, field.GetName(), typeName, field.GetRepresentation(),
 				values)
@@ -0,0 +1,206 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+// Package schema provides per message Type schemas and plugins that validate
+// messages against them.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// Schema definition for a single message field.
+type FieldSchemaConfig struct {
+	Name string `toml:"name"`
+	// Message must contain the field.
+	Required bool `toml:"required"`
+	// One of "string", "bytes", "integer", "double", "bool" or "object", any
+	// type is allowed if empty.
+	ValueType string `toml:"value_type"`
+	// Required representation, any representation is allowed if empty.
+	Representation string `toml:"representation"`
+}
+
+// Schema definition for all messages of a given Type.
+type SchemaConfig struct {
+	MessageType string `toml:"message_type"`
+	// Allowed severity range, inclusive. No limit is enforced if unset.
+	MinSeverity *int32 `toml:"min_severity"`
+	MaxSeverity *int32 `toml:"max_severity"`
+	// Reject fields that aren't declared in the schema.
+	Strict bool                `toml:"strict"`
+	Fields []FieldSchemaConfig `toml:"field"`
+}
+
+type fieldSchema struct {
+	name           string
+	required       bool
+	checkType      bool
+	valueType      message.Field_ValueType
+	representation string
+}
+
+// Schema is the compiled version of a SchemaConfig.
+type Schema struct {
+	messageType string
+	minSeverity *int32
+	maxSeverity *int32
+	strict      bool
+	fields      []*fieldSchema
+	byName      map[string]*fieldSchema
+}
+
+// NewSchema validates the schema configuration and compiles it.
+func NewSchema(conf SchemaConfig) (*Schema, error) {
+	if conf.MessageType == "" {
+		return nil, errors.New("schema 'message_type' is required")
+	}
+	if conf.MinSeverity != nil && conf.MaxSeverity != nil &&
+		*conf.MinSeverity > *conf.MaxSeverity {
+		return nil, fmt.Errorf("schema '%s': min_severity %d > max_severity %d",
+			conf.MessageType, *conf.MinSeverity, *conf.MaxSeverity)
+	}
+	s := &Schema{
+		messageType: conf.MessageType,
+		minSeverity: conf.MinSeverity,
+		maxSeverity: conf.MaxSeverity,
+		strict:      conf.Strict,
+		byName:      make(map[string]*fieldSchema, len(conf.Fields)),
+	}
+	for _, fc := range conf.Fields {
+		if fc.Name == "" {
+			return nil, fmt.Errorf("schema '%s': field 'name' is required",
+				conf.MessageType)
+		}
+		if _, ok := s.byName[fc.Name]; ok {
+			return nil, fmt.Errorf("schema '%s': duplicate field '%s'",
+				conf.MessageType, fc.Name)
+		}
+		fs := &fieldSchema{
+			name:           fc.Name,
+			required:       fc.Required,
+			representation: fc.Representation,
+		}
+		if fc.ValueType != "" {
+			vt, ok := message.Field_ValueType_value[strings.ToUpper(fc.ValueType)]
+			if !ok {
+				return nil, fmt.Errorf("schema '%s': field '%s' invalid value_type '%s'",
+					conf.MessageType, fc.Name, fc.ValueType)
+			}
+			fs.checkType = true
+			fs.valueType = message.Field_ValueType(vt)
+		}
+		s.fields = append(s.fields, fs)
+		s.byName[fc.Name] = fs
+	}
+	return s, nil
+}
+
+// MessageType returns the message Type the schema applies to.
+func (s *Schema) MessageType() string {
+	return s.messageType
+}
+
+// Validate checks the message against the schema, the returned error lists
+// every violation found, nil is returned if the message conforms.
+func (s *Schema) Validate(msg *message.Message) error {
+	var problems []string
+
+	sev := msg.GetSeverity()
+	if (s.minSeverity != nil && sev < *s.minSeverity) ||
+		(s.maxSeverity != nil && sev > *s.maxSeverity) {
+		problems = append(problems, fmt.Sprintf("severity %d out of range", sev))
+	}
+
+	for _, f := range msg.GetFields() {
+		fs, ok := s.byName[f.GetName()]
+		if !ok {
+			if s.strict {
+				problems = append(problems,
+					fmt.Sprintf("field '%s' not in schema", f.GetName()))
+			}
+			continue
+		}
+		if fs.checkType && f.GetValueType() != fs.valueType {
+			problems = append(problems, fmt.Sprintf("field '%s' type %s, expected %s",
+				fs.name, f.GetValueType(), fs.valueType))
+		}
+		if fs.representation != "" && f.GetRepresentation() != fs.representation {
+			problems = append(problems, fmt.Sprintf(
+				"field '%s' representation '%s', expected '%s'", fs.name,
+				f.GetRepresentation(), fs.representation))
+		}
+	}
+
+	for _, fs := range s.fields {
+		if fs.required && msg.FindFirstField(fs.name) == nil {
+			problems = append(problems,
+				fmt.Sprintf("required field '%s' missing", fs.name))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("schema '%s': %s", s.messageType,
+		strings.Join(problems, "; "))
+}
+
+// Registry holds the schemas keyed by message Type.
+type Registry struct {
+	schemas map[string]*Schema
+	// Whether messages with a Type that has no schema are valid.
+	AllowUnknown bool
+}
+
+// NewRegistry compiles the schema configurations into a registry.
+func NewRegistry(confs []SchemaConfig, allowUnknown bool) (*Registry, error) {
+	r := &Registry{
+		schemas:      make(map[string]*Schema, len(confs)),
+		AllowUnknown: allowUnknown,
+	}
+	for _, conf := range confs {
+		s, err := NewSchema(conf)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := r.schemas[s.messageType]; ok {
+			return nil, fmt.Errorf("duplicate schema for message_type '%s'",
+				s.messageType)
+		}
+		r.schemas[s.messageType] = s
+	}
+	return r, nil
+}
+
+// Lookup returns the schema for the message Type, nil if there is none.
+func (r *Registry) Lookup(msgType string) *Schema {
+	return r.schemas[msgType]
+}
+
+// Validate checks the message against the schema registered for its Type.
+func (r *Registry) Validate(msg *message.Message) error {
+	s := r.schemas[msg.GetType()]
+	if s == nil {
+		if r.AllowUnknown {
+			return nil
+		}
+		return fmt.Errorf("no schema for message_type '%s'", msg.GetType())
+	}
+	return s.Validate(msg)
+}
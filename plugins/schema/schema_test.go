@@ -0,0 +1,176 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package schema
+
+import (
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func nginxSchema() SchemaConfig {
+	return SchemaConfig{
+		MessageType: "nginx.access",
+		MinSeverity: int32Ptr(4),
+		MaxSeverity: int32Ptr(7),
+		Fields: []FieldSchemaConfig{
+			{Name: "status", Required: true, ValueType: "integer"},
+			{Name: "request_time", ValueType: "double", Representation: "s"},
+			{Name: "remote_addr", Required: true},
+		},
+	}
+}
+
+func nginxMessage() *message.Message {
+	msg := new(message.Message)
+	msg.SetType("nginx.access")
+	msg.SetSeverity(6)
+	f, _ := message.NewField("status", 200, "")
+	msg.AddField(f)
+	f, _ = message.NewField("request_time", 0.25, "s")
+	msg.AddField(f)
+	f, _ = message.NewField("remote_addr", "10.0.0.1", "")
+	msg.AddField(f)
+	return msg
+}
+
+func SchemaSpec(c gs.Context) {
+	c.Specify("A Schema", func() {
+		s, err := NewSchema(nginxSchema())
+		c.Assume(err, gs.IsNil)
+		msg := nginxMessage()
+
+		c.Specify("accepts a conforming message", func() {
+			c.Expect(s.Validate(msg), gs.IsNil)
+		})
+
+		c.Specify("accepts undeclared fields when not strict", func() {
+			f, _ := message.NewField("extra", "x", "")
+			msg.AddField(f)
+			c.Expect(s.Validate(msg), gs.IsNil)
+		})
+
+		c.Specify("rejects", func() {
+			expectError := func(expected string) {
+				err := s.Validate(msg)
+				c.Expect(err, gs.Not(gs.IsNil))
+				if err != nil {
+					c.Expect(strings.Contains(err.Error(), expected), gs.IsTrue)
+				}
+			}
+
+			c.Specify("undeclared fields when strict", func() {
+				s.strict = true
+				f, _ := message.NewField("extra", "x", "")
+				msg.AddField(f)
+				expectError("field 'extra' not in schema")
+			})
+
+			c.Specify("a missing required field", func() {
+				msg.DeleteField(msg.FindFirstField("remote_addr"))
+				expectError("required field 'remote_addr' missing")
+			})
+
+			c.Specify("the wrong value type", func() {
+				msg.DeleteField(msg.FindFirstField("status"))
+				f, _ := message.NewField("status", "200", "")
+				msg.AddField(f)
+				expectError("field 'status' type STRING, expected INTEGER")
+			})
+
+			c.Specify("the wrong representation", func() {
+				msg.FindFirstField("request_time").Representation = proto.String("ms")
+				expectError("field 'request_time' representation 'ms', expected 's'")
+			})
+
+			c.Specify("an out of range severity", func() {
+				msg.SetSeverity(3)
+				expectError("severity 3 out of range")
+			})
+		})
+
+		c.Specify("reports every violation", func() {
+			msg.SetSeverity(0)
+			msg.DeleteField(msg.FindFirstField("status"))
+			err := s.Validate(msg)
+			c.Expect(err.Error(), gs.Equals, "schema 'nginx.access': severity 0 out of "+
+				"range; required field 'status' missing")
+		})
+	})
+
+	c.Specify("NewSchema rejects", func() {
+		conf := nginxSchema()
+
+		c.Specify("a missing message_type", func() {
+			conf.MessageType = ""
+			_, err := NewSchema(conf)
+			c.Expect(err.Error(), gs.Equals, "schema 'message_type' is required")
+		})
+
+		c.Specify("an unknown value_type", func() {
+			conf.Fields[0].ValueType = "float"
+			_, err := NewSchema(conf)
+			c.Expect(err.Error(), gs.Equals,
+				"schema 'nginx.access': field 'status' invalid value_type 'float'")
+		})
+
+		c.Specify("an inverted severity range", func() {
+			conf.MinSeverity = int32Ptr(7)
+			conf.MaxSeverity = int32Ptr(4)
+			_, err := NewSchema(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("duplicate fields", func() {
+			conf.Fields = append(conf.Fields, FieldSchemaConfig{Name: "status"})
+			_, err := NewSchema(conf)
+			c.Expect(err.Error(), gs.Equals,
+				"schema 'nginx.access': duplicate field 'status'")
+		})
+	})
+
+	c.Specify("A Registry", func() {
+		r, err := NewRegistry([]SchemaConfig{nginxSchema()}, true)
+		c.Assume(err, gs.IsNil)
+		msg := nginxMessage()
+
+		c.Specify("validates by Type", func() {
+			c.Expect(r.Lookup("nginx.access"), gs.Not(gs.IsNil))
+			c.Expect(r.Validate(msg), gs.IsNil)
+			msg.SetSeverity(0)
+			c.Expect(r.Validate(msg), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("handles unknown types", func() {
+			msg.SetType("unknown")
+			c.Expect(r.Validate(msg), gs.IsNil)
+			r.AllowUnknown = false
+			err := r.Validate(msg)
+			c.Expect(err.Error(), gs.Equals, "no schema for message_type 'unknown'")
+		})
+
+		c.Specify("rejects duplicate schemas", func() {
+			_, err := NewRegistry([]SchemaConfig{nginxSchema(), nginxSchema()}, true)
+			c.Expect(err.Error(), gs.Equals,
+				"duplicate schema for message_type 'nginx.access'")
+		})
+	})
+}
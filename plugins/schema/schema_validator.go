@@ -0,0 +1,148 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package schema
+
+import (
+	"fmt"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type SchemaValidatorConfig struct {
+	Schemas []SchemaConfig `toml:"schema"`
+	// Whether messages with a Type that has no schema are considered valid.
+	// Defaults to true.
+	AllowUnknownTypes bool `toml:"allow_unknown_types"`
+	// If set non-conforming messages are re-typed to this value, the original
+	// Type is kept in an `original_type` field.
+	InvalidType string `toml:"invalid_type"`
+}
+
+// markInvalid tags a non-conforming message with the decode failure fields
+// and re-types it if an invalid type is specified.
+func markInvalid(msg *message.Message, verr error, invalidType string) error {
+	if invalidType != "" {
+		f, err := message.NewField("original_type", msg.GetType(), "")
+		if err != nil {
+			return fmt.Errorf("field creation error: %s", err.Error())
+		}
+		msg.AddField(f)
+		msg.SetType(invalidType)
+	}
+	return AddDecodeFailureFields(msg, verr.Error())
+}
+
+// Decoder that validates messages against the schema registered for their
+// Type, meant to be used as the last step of a MultiDecoder chain.
+// Non-conforming messages are tagged and passed through.
+type SchemaValidatorDecoder struct {
+	registry    *Registry
+	invalidType string
+}
+
+func (sv *SchemaValidatorDecoder) ConfigStruct() interface{} {
+	return &SchemaValidatorConfig{AllowUnknownTypes: true}
+}
+
+func (sv *SchemaValidatorDecoder) Init(config interface{}) (err error) {
+	conf := config.(*SchemaValidatorConfig)
+	if sv.registry, err = NewRegistry(conf.Schemas, conf.AllowUnknownTypes); err != nil {
+		return
+	}
+	sv.invalidType = conf.InvalidType
+	return
+}
+
+func (sv *SchemaValidatorDecoder) Decode(pack *PipelinePack) (
+	packs []*PipelinePack, err error) {
+
+	if verr := sv.registry.Validate(pack.Message); verr != nil {
+		if err = markInvalid(pack.Message, verr, sv.invalidType); err != nil {
+			return
+		}
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Filter that validates messages against the schema registered for their
+// Type and injects a tagged copy of every non-conforming message, re-typed
+// so it can be routed to a quarantine output. Messages of the re-typed Type
+// are skipped, so the filter's own output is never validated again.
+type SchemaValidatorFilter struct {
+	registry    *Registry
+	invalidType string
+}
+
+func (sv *SchemaValidatorFilter) ConfigStruct() interface{} {
+	return &SchemaValidatorConfig{
+		AllowUnknownTypes: true,
+		InvalidType:       "heka.schema-invalid",
+	}
+}
+
+func (sv *SchemaValidatorFilter) Init(config interface{}) (err error) {
+	conf := config.(*SchemaValidatorConfig)
+	if conf.InvalidType == "" {
+		return fmt.Errorf("'invalid_type' is required")
+	}
+	if sv.registry, err = NewRegistry(conf.Schemas, conf.AllowUnknownTypes); err != nil {
+		return
+	}
+	sv.invalidType = conf.InvalidType
+	return
+}
+
+func (sv *SchemaValidatorFilter) Run(fr FilterRunner, h PluginHelper) (err error) {
+	for pack := range fr.InChan() {
+		if pack.Message.GetType() == sv.invalidType {
+			fr.UpdateCursor(pack.QueueCursor)
+			pack.Recycle(nil)
+			continue
+		}
+		if verr := sv.registry.Validate(pack.Message); verr != nil {
+			sv.inject(fr, h, pack, verr)
+		}
+		fr.UpdateCursor(pack.QueueCursor)
+		pack.Recycle(nil)
+	}
+	return
+}
+
+func (sv *SchemaValidatorFilter) inject(fr FilterRunner, h PluginHelper,
+	pack *PipelinePack, verr error) {
+
+	newPack, err := h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		fr.LogError(err)
+		return
+	}
+	pack.Message.Copy(newPack.Message)
+	if err = markInvalid(newPack.Message, verr, sv.invalidType); err != nil {
+		fr.LogError(err)
+		newPack.Recycle(nil)
+		return
+	}
+	fr.Inject(newPack)
+}
+
+func init() {
+	RegisterPlugin("SchemaValidatorDecoder", func() interface{} {
+		return new(SchemaValidatorDecoder)
+	})
+	RegisterPlugin("SchemaValidatorFilter", func() interface{} {
+		return new(SchemaValidatorFilter)
+	})
+}
@@ -0,0 +1,127 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package schema
+
+import (
+	. "github.com/mozilla-services/heka/pipeline"
+	ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	pm "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SchemaValidatorSpec(c gs.Context) {
+	t := &ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	config := NewPipelineConfig(nil)
+
+	c.Specify("A SchemaValidatorDecoder", func() {
+		decoder := new(SchemaValidatorDecoder)
+		conf := decoder.ConfigStruct().(*SchemaValidatorConfig)
+		conf.Schemas = []SchemaConfig{nginxSchema()}
+		pack := NewPipelinePack(config.InputRecycleChan())
+		pack.Message = nginxMessage()
+
+		c.Specify("passes conforming messages through untouched", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			packs, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+			_, ok := pack.Message.GetFieldValue("decode_failure")
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("tags non-conforming messages", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetSeverity(0)
+			packs, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+			failed, ok := pack.Message.GetFieldValue("decode_failure")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(failed, gs.Equals, true)
+			errMsg, _ := pack.Message.GetFieldValue("decode_error")
+			c.Expect(errMsg, gs.Equals, "schema 'nginx.access': severity 0 out of range")
+			c.Expect(pack.Message.GetType(), gs.Equals, "nginx.access")
+		})
+
+		c.Specify("re-types non-conforming messages", func() {
+			conf.InvalidType = "quarantine"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetSeverity(0)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetType(), gs.Equals, "quarantine")
+			orig, _ := pack.Message.GetFieldValue("original_type")
+			c.Expect(orig, gs.Equals, "nginx.access")
+		})
+
+		c.Specify("fails to initialize with an invalid schema", func() {
+			conf.Schemas[0].Fields[0].ValueType = "float"
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A SchemaValidatorFilter", func() {
+		filter := new(SchemaValidatorFilter)
+		conf := filter.ConfigStruct().(*SchemaValidatorConfig)
+		conf.Schemas = []SchemaConfig{nginxSchema()}
+		conf.AllowUnknownTypes = false
+		err := filter.Init(conf)
+		c.Assume(err, gs.IsNil)
+
+		fth := pm.NewMockFilterRunner(ctrl)
+		helper := pm.NewMockPluginHelper(ctrl)
+		inChan := make(chan *PipelinePack, 3)
+		fth.EXPECT().InChan().Return(inChan)
+		fth.EXPECT().UpdateCursor("").Times(3)
+
+		supply := make(chan *PipelinePack, 3)
+		valid := NewPipelinePack(supply)
+		valid.Message = nginxMessage()
+		invalid := NewPipelinePack(supply)
+		invalid.Message = nginxMessage()
+		invalid.Message.SetSeverity(0)
+		// A previously injected message has no schema but mustn't be
+		// injected again.
+		own := NewPipelinePack(supply)
+		own.Message = nginxMessage()
+		own.Message.SetType("heka.schema-invalid")
+		inChan <- valid
+		inChan <- invalid
+		inChan <- own
+		close(inChan)
+
+		c.Specify("injects a re-typed copy of non-conforming messages", func() {
+			injected := NewPipelinePack(supply)
+			helper.EXPECT().PipelinePack(uint(0)).Return(injected, nil)
+			fth.EXPECT().Inject(injected).Return(true)
+
+			err := filter.Run(fth, helper)
+			c.Expect(err, gs.IsNil)
+			c.Expect(injected.Message.GetType(), gs.Equals, "heka.schema-invalid")
+			orig, _ := injected.Message.GetFieldValue("original_type")
+			c.Expect(orig, gs.Equals, "nginx.access")
+			_, ok := injected.Message.GetFieldValue("decode_error")
+			c.Expect(ok, gs.IsTrue)
+		})
+	})
+}
@@ -0,0 +1,317 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Names of the fields holding the CEF header values, in order.
+var cefHeaderFields = []string{"cef_version", "device_vendor", "device_product",
+	"device_version", "signature_id", "name", "severity"}
+
+// Names of the fields holding the LEEF header values, in order.
+var leefHeaderFields = []string{"leef_version", "device_vendor", "device_product",
+	"device_version", "event_id"}
+
+// Extension keys holding integers, stored as int64 fields when they parse.
+var cefIntegerKeys = map[string]bool{
+	// CEF
+	"cnt": true, "cn1": true, "cn2": true, "cn3": true, "dpid": true,
+	"dpt": true, "dvcpid": true, "fsize": true, "in": true,
+	"oldFileSize": true, "out": true, "spid": true, "spt": true, "type": true,
+	// LEEF
+	"sev": true, "srcPort": true, "dstPort": true, "srcPreNATPort": true,
+	"dstPreNATPort": true, "srcPostNATPort": true, "dstPostNATPort": true,
+	"srcBytes": true, "dstBytes": true, "totalPackets": true,
+	"srcPackets": true, "dstPackets": true,
+}
+
+// Extension keys holding the event time.
+var cefTimeKeys = map[string]bool{"rt": true, "devTime": true}
+
+// Timestamp layouts used for the event time.
+var cefTimeLayouts = []string{"EpochMilli", "Jan 02 2006 15:04:05 MST",
+	"Jan 02 2006 15:04:05", "Jan 02 15:04:05 MST", "Jan 02 15:04:05"}
+
+// Matches the start of a CEF extension key=value pair, the key must be
+// preceded by a space and the '=' must not be escaped.
+var cefKeyRegex = regexp.MustCompile(`(?:^|\s)([\w.\-\[\]]+)=`)
+
+// Replaces the escape sequences of CEF extension values.
+var cefValueReplacer = strings.NewReplacer(`\\`, `\`, `\=`, `=`, `\|`, `|`,
+	`\n`, "\n", `\r`, "\r")
+
+// Returns the index of the CEF or LEEF prefix, which must be at the start of
+// the line or follow a space, and the length of the prefix. Returns -1 if
+// there is none.
+func findCefStart(line string) (start, prefixLen int) {
+	for _, prefix := range []string{"CEF:", "LEEF:"} {
+		for offset := 0; offset < len(line); {
+			i := strings.Index(line[offset:], prefix)
+			if i == -1 {
+				break
+			}
+			i += offset
+			if i == 0 || line[i-1] == ' ' {
+				return i, len(prefix)
+			}
+			offset = i + 1
+		}
+	}
+	return -1, 0
+}
+
+// Splits the header on unescaped pipes, returning at most n fields, the last
+// of which is the remainder of the string. Escaped pipes and backslashes are
+// unescaped in all but the remainder.
+func splitCefHeader(s string, n int) (fields []string) {
+	field := make([]byte, 0, 32)
+	for i := 0; i < len(s); i++ {
+		if len(fields) == n-1 {
+			return append(fields, s[i:])
+		}
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '|' || s[i+1] == '\\'):
+			i++
+			c = s[i]
+		case c == '|':
+			fields = append(fields, string(field))
+			field = field[:0]
+			continue
+		}
+		field = append(field, c)
+	}
+	return append(fields, string(field))
+}
+
+// Parses the CEF extension into key/value pairs. Values end where the next
+// key begins, so spaces in values needn't be escaped.
+func parseCefExtension(ext string) (keys, values []string) {
+	matches := cefKeyRegex.FindAllStringSubmatchIndex(ext, -1)
+	for i, m := range matches {
+		end := len(ext)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		keys = append(keys, ext[m[2]:m[3]])
+		value := strings.TrimRight(ext[m[1]:end], " ")
+		values = append(values, cefValueReplacer.Replace(value))
+	}
+	return
+}
+
+// Returns the LEEF 2.0 attribute delimiter specified as a single character or
+// a hex value prefixed by "x" or "0x". Defaults to a tab if empty.
+func parseLeefDelimiter(s string) (string, error) {
+	var hex string
+	switch {
+	case s == "":
+		return "\t", nil
+	case len(s) == 1:
+		return s, nil
+	case strings.HasPrefix(s, "0x"):
+		hex = s[2:]
+	case strings.HasPrefix(s, "x"):
+		hex = s[1:]
+	}
+	n, err := strconv.ParseUint(hex, 16, 8)
+	if err != nil {
+		return "", fmt.Errorf("invalid LEEF delimiter: '%s'", s)
+	}
+	return string([]byte{byte(n)}), nil
+}
+
+// Parses the LEEF attributes, key=value pairs separated by the delimiter.
+func parseLeefAttributes(attrs, delimiter string) (keys, values []string) {
+	for _, attr := range strings.Split(attrs, delimiter) {
+		eq := strings.IndexByte(attr, '=')
+		if eq < 1 {
+			continue
+		}
+		keys = append(keys, strings.TrimSpace(attr[:eq]))
+		values = append(values, attr[eq+1:])
+	}
+	return
+}
+
+// Maps the CEF severity, either 0-10 or one of the Unknown, Low, Medium, High
+// and Very-High levels, to the syslog severity. Returns -1 if unknown.
+func cefSeverity(sev string) int32 {
+	switch strings.ToLower(sev) {
+	case "low":
+		return 5
+	case "medium":
+		return 4
+	case "high":
+		return 3
+	case "very-high":
+		return 2
+	}
+	n, err := strconv.Atoi(sev)
+	switch {
+	case err != nil || n < 0 || n > 10:
+		return -1
+	case n <= 3:
+		return 5
+	case n <= 6:
+		return 4
+	case n <= 8:
+		return 3
+	}
+	return 2
+}
+
+type CefDecoderConfig struct {
+	// Message Type, left untouched if empty.
+	MessageType string `toml:"message_type"`
+
+	// Time zone in which timestamps without zone information are presumed to
+	// be. Defaults to "UTC".
+	TimestampLocation string `toml:"timestamp_location"`
+
+	// Keep the Hostname set by the input rather than using the one from the
+	// syslog prefix.
+	HostnameKeep bool `toml:"hostname_keep"`
+}
+
+type CefDecoder struct {
+	msgType          string
+	hostnameKeep     bool
+	syslogTimeParser *message.TimeParser
+	timeParser       *message.TimeParser
+}
+
+func (cd *CefDecoder) ConfigStruct() interface{} {
+	return new(CefDecoderConfig)
+}
+
+func (cd *CefDecoder) Init(config interface{}) (err error) {
+	conf := config.(*CefDecoderConfig)
+	cd.msgType = conf.MessageType
+	cd.hostnameKeep = conf.HostnameKeep
+	loc, err := time.LoadLocation(conf.TimestampLocation)
+	if err != nil {
+		return fmt.Errorf("CefDecoder unknown timestamp_location '%s': %s",
+			conf.TimestampLocation, err)
+	}
+	if cd.syslogTimeParser, err = message.NewTimeParser([]string{time.Stamp,
+		time.RFC3339Nano}, loc); err != nil {
+		return
+	}
+	cd.timeParser, err = message.NewTimeParser(cefTimeLayouts, loc)
+	return
+}
+
+func (cd *CefDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	line := strings.TrimRight(pack.Message.GetPayload(), "\r\n\x00")
+	start, prefixLen := findCefStart(line)
+	if start == -1 {
+		return nil, errors.New("no CEF or LEEF header found")
+	}
+
+	var (
+		header       []string
+		headerFields []string
+		keys, values []string
+		severity     = int32(-1)
+	)
+	if prefixLen == len("CEF:") {
+		headerFields = cefHeaderFields
+		if header = splitCefHeader(line[start+prefixLen:], 8); len(header) < 8 {
+			return nil, errors.New("incomplete CEF header")
+		}
+		keys, values = parseCefExtension(header[7])
+		severity = cefSeverity(header[6])
+	} else {
+		headerFields = leefHeaderFields
+		if header = splitCefHeader(line[start+prefixLen:], 6); len(header) < 6 {
+			return nil, errors.New("incomplete LEEF header")
+		}
+		attrs, delimiter := header[5], "\t"
+		if !strings.HasPrefix(header[0], "1") {
+			// LEEF 2.0 has an optional delimiter field.
+			if i := strings.IndexByte(attrs, '|'); i != -1 && !strings.Contains(attrs[:i], "=") {
+				if delimiter, err = parseLeefDelimiter(attrs[:i]); err != nil {
+					return nil, err
+				}
+				attrs = attrs[i+1:]
+			}
+		}
+		keys, values = parseLeefAttributes(attrs, delimiter)
+	}
+
+	msg := pack.Message
+	if cd.msgType != "" {
+		msg.SetType(cd.msgType)
+	}
+	if start > 0 {
+		var rec *syslogRecord
+		if rec, err = parseSyslog(line[:start], cd.syslogTimeParser); err != nil {
+			return nil, err
+		}
+		setSyslogFields(msg, rec, cd.hostnameKeep)
+	}
+	for i, name := range headerFields {
+		message.NewStringField(msg, name, header[i])
+	}
+	for i, key := range keys {
+		var value interface{} = values[i]
+		if cefIntegerKeys[key] {
+			if n, e := strconv.ParseInt(values[i], 10, 64); e == nil {
+				value = n
+			}
+		}
+		if cefTimeKeys[key] {
+			if t, e := cd.timeParser.Parse(values[i]); e == nil {
+				msg.SetTimestamp(t.UnixNano())
+			}
+		}
+		if key == "sev" && severity == -1 {
+			severity = cefSeverity(values[i])
+		}
+		if f := msg.FindFirstField(key); f != nil {
+			if err = f.AddValue(value); err != nil {
+				return nil, fmt.Errorf("repeated key '%s': %s", key, err)
+			}
+			continue
+		}
+		var f *message.Field
+		if f, err = message.NewField(key, value, ""); err != nil {
+			return nil, fmt.Errorf("field creation error: %s", err)
+		}
+		msg.AddField(f)
+	}
+	if severity != -1 {
+		msg.SetSeverity(severity)
+	}
+	msg.SetPayload(line[start:])
+	return []*PipelinePack{pack}, nil
+}
+
+func init() {
+	RegisterPlugin("CefDecoder", func() interface{} {
+		return new(CefDecoder)
+	})
+}
@@ -0,0 +1,161 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package syslog
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CefDecoderSpec(c gs.Context) {
+	c.Specify("A CefDecoder", func() {
+		decoder := new(CefDecoder)
+		conf := decoder.ConfigStruct().(*CefDecoderConfig)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		decode := func(payload string) (*message.Message, error) {
+			pack.Message = new(message.Message)
+			pack.Message.SetHostname("input.example.com")
+			pack.Message.SetSeverity(7)
+			pack.Message.SetPayload(payload)
+			packs, err := decoder.Decode(pack)
+			if packs == nil {
+				return nil, err
+			}
+			return packs[0].Message, err
+		}
+
+		c.Specify("decodes CEF w/ a syslog prefix", func() {
+			conf.MessageType = "cef"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			cef := `CEF:0|Security|threat\|manager|1.0|100|worm successfully stopped|10|` +
+				`src=10.0.0.1 dst=2.1.2.2 spt=1232 msg=Detected a threat. No action needed\= ok ` +
+				`filePath=C:\\Program Files\\x cs1=a|b cs1Label=note rt=1442417870123 `
+			msg, err := decode("<134>Sep 16 15:37:50 fw01 " + cef + "\n")
+			c.Assume(err, gs.IsNil)
+
+			c.Expect(msg.GetType(), gs.Equals, "cef")
+			c.Expect(msg.GetHostname(), gs.Equals, "fw01")
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(2))
+			c.Expect(msg.GetTimestamp(), gs.Equals, int64(1442417870123000000))
+			c.Expect(msg.GetPayload(), gs.Equals, cef)
+			value, _ := msg.GetFieldValue("syslogfacility")
+			c.Expect(value, gs.Equals, int64(16))
+			value, _ = msg.GetFieldValue("cef_version")
+			c.Expect(value, gs.Equals, "0")
+			value, _ = msg.GetFieldValue("device_product")
+			c.Expect(value, gs.Equals, "threat|manager")
+			value, _ = msg.GetFieldValue("signature_id")
+			c.Expect(value, gs.Equals, "100")
+			value, _ = msg.GetFieldValue("name")
+			c.Expect(value, gs.Equals, "worm successfully stopped")
+			value, _ = msg.GetFieldValue("severity")
+			c.Expect(value, gs.Equals, "10")
+			value, _ = msg.GetFieldValue("src")
+			c.Expect(value, gs.Equals, "10.0.0.1")
+			value, _ = msg.GetFieldValue("spt")
+			c.Expect(value, gs.Equals, int64(1232))
+			value, _ = msg.GetFieldValue("msg")
+			c.Expect(value, gs.Equals, "Detected a threat. No action needed= ok")
+			value, _ = msg.GetFieldValue("filePath")
+			c.Expect(value, gs.Equals, `C:\Program Files\x`)
+			value, _ = msg.GetFieldValue("cs1")
+			c.Expect(value, gs.Equals, "a|b")
+			value, _ = msg.GetFieldValue("rt")
+			c.Expect(value, gs.Equals, "1442417870123")
+		})
+
+		c.Specify("decodes CEF w/o a syslog prefix", func() {
+			conf.HostnameKeep = true
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode("CEF:1|Acme|WAF|2.0|sqli|SQL injection|Medium|" +
+				"request=/login?user=x rt=Sep 16 2015 15:37:50 UTC")
+			c.Assume(err, gs.IsNil)
+
+			c.Expect(msg.GetHostname(), gs.Equals, "input.example.com")
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(4))
+			c.Expect(msg.GetTimestamp(), gs.Equals,
+				time.Date(2015, 9, 16, 15, 37, 50, 0, time.UTC).UnixNano())
+			c.Expect(msg.FindFirstField("syslogfacility"), gs.IsNil)
+			value, _ := msg.GetFieldValue("request")
+			c.Expect(value, gs.Equals, "/login?user=x")
+
+			msg, err = decode("CEF:0|Acme|IDS|1|1|ping|Unknown|")
+			c.Assume(err, gs.IsNil)
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(7))
+			c.Expect(len(msg.Fields), gs.Equals, 7)
+		})
+
+		c.Specify("decodes LEEF", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			msg, err := decode("<13>Sep 16 15:37:50 ids01 LEEF:1.0|Lancope|StealthWatch|1.0|41|" +
+				"src=10.0.1.8\tdst=10.0.0.5\tsev=8\tsrcPort=81\tmsg=a=b c")
+			c.Assume(err, gs.IsNil)
+
+			c.Expect(msg.GetHostname(), gs.Equals, "ids01")
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(3))
+			value, _ := msg.GetFieldValue("leef_version")
+			c.Expect(value, gs.Equals, "1.0")
+			value, _ = msg.GetFieldValue("event_id")
+			c.Expect(value, gs.Equals, "41")
+			value, _ = msg.GetFieldValue("srcPort")
+			c.Expect(value, gs.Equals, int64(81))
+			value, _ = msg.GetFieldValue("sev")
+			c.Expect(value, gs.Equals, int64(8))
+			value, _ = msg.GetFieldValue("msg")
+			c.Expect(value, gs.Equals, "a=b c")
+
+			msg, err = decode("LEEF:2.0|Lancope|StealthWatch|1.0|41|^|src=10.0.1.8^dst=10.0.0.5")
+			c.Assume(err, gs.IsNil)
+			value, _ = msg.GetFieldValue("dst")
+			c.Expect(value, gs.Equals, "10.0.0.5")
+
+			msg, err = decode("LEEF:2.0|Lancope|StealthWatch|1.0|41|0x7c|src=10.0.1.8|dst=10.0.0.5")
+			c.Assume(err, gs.IsNil)
+			value, _ = msg.GetFieldValue("dst")
+			c.Expect(value, gs.Equals, "10.0.0.5")
+
+			msg, err = decode("LEEF:2.0|Lancope|StealthWatch|1.0|41|src=10.0.1.8\tdst=a|b")
+			c.Assume(err, gs.IsNil)
+			value, _ = msg.GetFieldValue("dst")
+			c.Expect(value, gs.Equals, "a|b")
+		})
+
+		c.Specify("fails on invalid messages", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			for _, payload := range []string{
+				"not a security event",
+				"xCEF:0|a|b|c|d|e|1|",
+				"CEF:0|a|b|c|d|e",
+				"LEEF:1.0|a|b|c",
+				"LEEF:2.0|a|b|c|d|xzz|src=1",
+				"<999>Sep 16 15:37:50 host CEF:0|a|b|c|d|e|1|",
+				"CEF:0|a|b|c|d|e|1|spt=1 spt=x",
+			} {
+				msg, err := decode(payload)
+				c.Expect(msg, gs.IsNil)
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+	})
+}
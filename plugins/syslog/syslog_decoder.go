@@ -0,0 +1,392 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+// Package syslog provides decoders for syslog messages in both the BSD (RFC
+// 3164) and the RFC 5424 formats, and for the CEF and LEEF security event
+// formats usually carried over syslog.
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Structured data parameter, RFC 5424 section 6.3.3.
+type sdParam struct {
+	name  string
+	value string
+}
+
+// Structured data element, RFC 5424 section 6.3.1.
+type sdElement struct {
+	id     string
+	params []sdParam
+}
+
+// Parsed syslog message, absent values are left empty.
+type syslogRecord struct {
+	pri       int // -1 if the message has no PRI
+	timestamp time.Time
+	hostname  string
+	appName   string
+	procId    string
+	msgId     string
+	sd        []sdElement
+	msg       string
+}
+
+// Parser state, the position advances as the message is consumed.
+type syslogParser struct {
+	buf string
+	pos int
+	tp  *message.TimeParser
+}
+
+func (p *syslogParser) rest() string {
+	return p.buf[p.pos:]
+}
+
+func (p *syslogParser) eof() bool {
+	return p.pos >= len(p.buf)
+}
+
+// Returns the characters up to the next space, which is skipped.
+func (p *syslogParser) token() string {
+	start := p.pos
+	for p.pos < len(p.buf) && p.buf[p.pos] != ' ' {
+		p.pos++
+	}
+	tok := p.buf[start:p.pos]
+	if p.pos < len(p.buf) {
+		p.pos++
+	}
+	return tok
+}
+
+// Returns the token, or an empty string for the NILVALUE.
+func (p *syslogParser) optionalToken() string {
+	if tok := p.token(); tok != "-" {
+		return tok
+	}
+	return ""
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Parses the `<PRI>` part, returns -1 if there is none.
+func (p *syslogParser) parsePri() (int, error) {
+	if p.eof() || p.buf[p.pos] != '<' {
+		return -1, nil
+	}
+	end := strings.IndexByte(p.rest(), '>')
+	if end < 2 || end > 4 {
+		return 0, errors.New("invalid PRI")
+	}
+	pri, err := strconv.Atoi(p.buf[p.pos+1 : p.pos+end])
+	if err != nil || pri > 191 {
+		return 0, fmt.Errorf("invalid PRI: %s", p.buf[p.pos+1:p.pos+end])
+	}
+	p.pos += end + 1
+	return pri, nil
+}
+
+// Returns true if the remaining input starts with an RFC 5424 VERSION.
+func (p *syslogParser) isRFC5424() bool {
+	i := p.pos
+	for i < len(p.buf) && i-p.pos < 3 && isDigit(p.buf[i]) {
+		i++
+	}
+	return i > p.pos && i < len(p.buf) && p.buf[i] == ' ' && p.buf[p.pos] != '0'
+}
+
+func (p *syslogParser) parseRFC5424(rec *syslogRecord) (err error) {
+	p.token() // VERSION
+	if ts := p.optionalToken(); ts != "" {
+		if rec.timestamp, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+			return fmt.Errorf("invalid TIMESTAMP: %s", ts)
+		}
+	}
+	rec.hostname = p.optionalToken()
+	rec.appName = p.optionalToken()
+	rec.procId = p.optionalToken()
+	rec.msgId = p.optionalToken()
+	if p.eof() {
+		return errors.New("missing STRUCTURED-DATA")
+	}
+	if p.buf[p.pos] == '-' {
+		p.pos++
+	} else if rec.sd, err = p.parseStructuredData(); err != nil {
+		return
+	}
+	if !p.eof() {
+		if p.buf[p.pos] != ' ' {
+			return errors.New("invalid STRUCTURED-DATA")
+		}
+		rec.msg = strings.TrimPrefix(p.buf[p.pos+1:], "\xef\xbb\xbf")
+	}
+	return
+}
+
+func (p *syslogParser) parseStructuredData() (sd []sdElement, err error) {
+	for !p.eof() && p.buf[p.pos] == '[' {
+		p.pos++
+		var elem sdElement
+		start := p.pos
+		for !p.eof() && p.buf[p.pos] != ' ' && p.buf[p.pos] != ']' {
+			p.pos++
+		}
+		if elem.id = p.buf[start:p.pos]; elem.id == "" {
+			return nil, errors.New("missing SD-ID")
+		}
+		for !p.eof() && p.buf[p.pos] == ' ' {
+			p.pos++
+			var param sdParam
+			if param, err = p.parseParam(); err != nil {
+				return
+			}
+			elem.params = append(elem.params, param)
+		}
+		if p.eof() || p.buf[p.pos] != ']' {
+			return nil, fmt.Errorf("unterminated SD-ELEMENT '%s'", elem.id)
+		}
+		p.pos++
+		sd = append(sd, elem)
+	}
+	if sd == nil {
+		err = errors.New("invalid STRUCTURED-DATA")
+	}
+	return
+}
+
+func (p *syslogParser) parseParam() (param sdParam, err error) {
+	eq := strings.Index(p.rest(), "=\"")
+	if eq < 1 {
+		return param, errors.New("invalid SD-PARAM")
+	}
+	param.name = p.buf[p.pos : p.pos+eq]
+	p.pos += eq + 2
+	value := make([]byte, 0, 32)
+	for ; !p.eof(); p.pos++ {
+		c := p.buf[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			param.value = string(value)
+			return
+		case c == '\\' && p.pos+1 < len(p.buf) && strings.IndexByte(`"\]`,
+			p.buf[p.pos+1]) != -1:
+			p.pos++
+			c = p.buf[p.pos]
+		}
+		value = append(value, c)
+	}
+	return param, fmt.Errorf("unterminated SD-PARAM '%s'", param.name)
+}
+
+var months = "JanFebMarAprMayJunJulAugSepOctNovDec"
+
+// Returns the length of an RFC 3164 (e.g. "Oct 11 22:14:15", optionally
+// followed by fractional seconds) or RFC 3339 timestamp at the start of the
+// string, 0 if there is none.
+func timestampLen(s string) int {
+	if len(s) >= 15 && strings.Index(months, s[:3]) != -1 && s[3] == ' ' {
+		n := 15
+		if n < len(s) && s[n] == '.' {
+			for n++; n < len(s) && isDigit(s[n]); n++ {
+			}
+		}
+		return n
+	}
+	if len(s) > 0 && isDigit(s[0]) {
+		if n := strings.IndexByte(s, ' '); n != -1 {
+			return n
+		}
+		return len(s)
+	}
+	return 0
+}
+
+func (p *syslogParser) parseRFC3164(rec *syslogRecord) {
+	if n := timestampLen(p.rest()); n > 0 {
+		if ts, err := p.tp.Parse(p.buf[p.pos : p.pos+n]); err == nil {
+			rec.timestamp = ts
+			p.pos += n
+			for !p.eof() && p.buf[p.pos] == ' ' {
+				p.pos++
+			}
+		}
+	}
+	// The HOSTNAME is frequently omitted, in which case the TAG comes first.
+	if end := strings.IndexByte(p.rest(), ' '); end > 0 {
+		if tok := p.buf[p.pos : p.pos+end]; !isTag(tok) {
+			rec.hostname = tok
+			p.pos += end + 1
+		}
+	}
+	if !p.eof() {
+		end := strings.IndexByte(p.rest(), ' ')
+		if end == -1 {
+			end = len(p.rest())
+		}
+		if tok := p.buf[p.pos : p.pos+end]; isTag(tok) {
+			tok = tok[:len(tok)-1]
+			if i := strings.IndexByte(tok, '['); i != -1 {
+				rec.procId = tok[i+1 : len(tok)-1]
+				tok = tok[:i]
+			}
+			rec.appName = tok
+			p.pos += end
+			if !p.eof() {
+				p.pos++
+			}
+		}
+	}
+	rec.msg = p.rest()
+}
+
+// Returns true if the token is a TAG, i.e. a program name optionally followed
+// by a bracketed PID and terminated by a colon.
+func isTag(tok string) bool {
+	if len(tok) < 2 || tok[len(tok)-1] != ':' {
+		return false
+	}
+	tok = tok[:len(tok)-1]
+	if i := strings.IndexByte(tok, '['); i != -1 {
+		return i > 0 && tok[len(tok)-1] == ']' &&
+			strings.IndexAny(tok[i+1:len(tok)-1], "[]") == -1
+	}
+	return strings.IndexByte(tok, ':') == -1
+}
+
+// Parses an RFC 5424 or RFC 3164 syslog message, the latter is assumed if no
+// VERSION follows the PRI.
+func parseSyslog(line string, tp *message.TimeParser) (rec *syslogRecord, err error) {
+	p := &syslogParser{buf: strings.TrimRight(line, "\r\n\x00"), tp: tp}
+	rec = new(syslogRecord)
+	if rec.pri, err = p.parsePri(); err != nil {
+		return nil, err
+	}
+	if rec.pri != -1 && p.isRFC5424() {
+		err = p.parseRFC5424(rec)
+	} else {
+		p.parseRFC3164(rec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Populates the message headers and fields from the parsed syslog record,
+// leaving the Payload untouched.
+func setSyslogFields(msg *message.Message, rec *syslogRecord, hostnameKeep bool) {
+	if rec.pri != -1 {
+		msg.SetSeverity(int32(rec.pri & 7))
+		message.NewInt64Field(msg, "syslogfacility", int64(rec.pri>>3), "")
+	}
+	if !rec.timestamp.IsZero() {
+		msg.SetTimestamp(rec.timestamp.UnixNano())
+	}
+	if rec.hostname != "" && !hostnameKeep {
+		msg.SetHostname(rec.hostname)
+	}
+	if rec.appName != "" {
+		message.NewStringField(msg, "programname", rec.appName)
+	}
+	if rec.procId != "" {
+		if pid, err := strconv.ParseInt(rec.procId, 10, 32); err == nil {
+			msg.SetPid(int32(pid))
+		} else {
+			message.NewStringField(msg, "procid", rec.procId)
+		}
+	}
+	if rec.msgId != "" {
+		message.NewStringField(msg, "msgid", rec.msgId)
+	}
+	for _, elem := range rec.sd {
+		for _, param := range elem.params {
+			name := elem.id + "." + param.name
+			if f := msg.FindFirstField(name); f != nil {
+				f.AddValue(param.value)
+			} else {
+				message.NewStringField(msg, name, param.value)
+			}
+		}
+	}
+}
+
+type SyslogDecoderConfig struct {
+	// Message Type, left untouched if empty.
+	MessageType string `toml:"message_type"`
+
+	// Time zone in which RFC 3164 timestamps, which carry no zone
+	// information, are presumed to be. Defaults to "UTC".
+	TimestampLocation string `toml:"timestamp_location"`
+
+	// Keep the Hostname set by the input rather than using the one from the
+	// syslog message.
+	HostnameKeep bool `toml:"hostname_keep"`
+}
+
+type SyslogDecoder struct {
+	msgType      string
+	hostnameKeep bool
+	timeParser   *message.TimeParser
+}
+
+func (sd *SyslogDecoder) ConfigStruct() interface{} {
+	return new(SyslogDecoderConfig)
+}
+
+func (sd *SyslogDecoder) Init(config interface{}) (err error) {
+	conf := config.(*SyslogDecoderConfig)
+	sd.msgType = conf.MessageType
+	sd.hostnameKeep = conf.HostnameKeep
+	loc, err := time.LoadLocation(conf.TimestampLocation)
+	if err != nil {
+		return fmt.Errorf("SyslogDecoder unknown timestamp_location '%s': %s",
+			conf.TimestampLocation, err)
+	}
+	sd.timeParser, err = message.NewTimeParser([]string{time.Stamp,
+		time.RFC3339Nano}, loc)
+	return
+}
+
+func (sd *SyslogDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	rec, err := parseSyslog(pack.Message.GetPayload(), sd.timeParser)
+	if err != nil {
+		return nil, err
+	}
+	msg := pack.Message
+	if sd.msgType != "" {
+		msg.SetType(sd.msgType)
+	}
+	setSyslogFields(msg, rec, sd.hostnameKeep)
+	msg.SetPayload(rec.msg)
+	return []*PipelinePack{pack}, nil
+}
+
+func init() {
+	RegisterPlugin("SyslogDecoder", func() interface{} {
+		return new(SyslogDecoder)
+	})
+}
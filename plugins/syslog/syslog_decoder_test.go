@@ -0,0 +1,151 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package syslog
+
+import (
+	"time"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SyslogDecoderSpec(c gs.Context) {
+	c.Specify("A SyslogDecoder", func() {
+		decoder := new(SyslogDecoder)
+		conf := decoder.ConfigStruct().(*SyslogDecoderConfig)
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+		pack.Message.SetHostname("input.example.com")
+
+		c.Specify("decodes RFC 5424 messages", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high \"x\" \]"] ` +
+				"\xef\xbb\xbfAn application event log entry\n")
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(5))
+			value, _ := msg.GetFieldValue("syslogfacility")
+			c.Expect(value, gs.Equals, int64(20))
+			c.Expect(msg.GetTimestamp(), gs.Equals,
+				time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC).UnixNano())
+			c.Expect(msg.GetHostname(), gs.Equals, "mymachine.example.com")
+			value, _ = msg.GetFieldValue("programname")
+			c.Expect(value, gs.Equals, "evntslog")
+			c.Expect(msg.FindFirstField("procid"), gs.IsNil)
+			value, _ = msg.GetFieldValue("msgid")
+			c.Expect(value, gs.Equals, "ID47")
+			value, _ = msg.GetFieldValue("exampleSDID@32473.eventID")
+			c.Expect(value, gs.Equals, "1011")
+			value, _ = msg.GetFieldValue("examplePriority@32473.class")
+			c.Expect(value, gs.Equals, `high "x" ]`)
+			c.Expect(msg.GetPayload(), gs.Equals, "An application event log entry")
+		})
+
+		c.Specify("decodes RFC 5424 messages w/ nil values", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload("<34>1 - - su 1234 - - 'su root' failed")
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(2))
+			c.Expect(msg.GetHostname(), gs.Equals, "input.example.com")
+			c.Expect(msg.GetPid(), gs.Equals, int32(1234))
+			c.Expect(msg.FindFirstField("msgid"), gs.IsNil)
+			c.Expect(msg.GetPayload(), gs.Equals, "'su root' failed")
+		})
+
+		c.Specify("decodes RFC 3164 messages", func() {
+			conf.TimestampLocation = "America/New_York"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload("<34>Oct  1 22:14:15 mymachine su[230]: 'su root' failed")
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(2))
+			value, _ := msg.GetFieldValue("syslogfacility")
+			c.Expect(value, gs.Equals, int64(4))
+			ts := time.Unix(0, msg.GetTimestamp()).UTC()
+			c.Expect(ts.Month(), gs.Equals, time.October)
+			c.Expect(ts.Day(), gs.Equals, 2)
+			c.Expect(ts.Hour(), gs.Equals, 2)
+			c.Expect(msg.GetHostname(), gs.Equals, "mymachine")
+			value, _ = msg.GetFieldValue("programname")
+			c.Expect(value, gs.Equals, "su")
+			c.Expect(msg.GetPid(), gs.Equals, int32(230))
+			c.Expect(msg.GetPayload(), gs.Equals, "'su root' failed")
+		})
+
+		c.Specify("decodes RFC 3164 messages w/o a hostname", func() {
+			conf.HostnameKeep = true
+			conf.MessageType = "syslog"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload("<13>Oct 11 22:14:15 sshd: session opened")
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			c.Expect(msg.GetType(), gs.Equals, "syslog")
+			c.Expect(msg.GetHostname(), gs.Equals, "input.example.com")
+			value, _ := msg.GetFieldValue("programname")
+			c.Expect(value, gs.Equals, "sshd")
+			c.Expect(msg.GetPayload(), gs.Equals, "session opened")
+		})
+
+		c.Specify("decodes RFC 3164 messages w/o a PRI or TAG", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetSeverity(7)
+			pack.Message.SetPayload("Oct 11 22:14:15 mymachine kernel panic")
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			msg := pack.Message
+
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(7))
+			c.Expect(msg.FindFirstField("syslogfacility"), gs.IsNil)
+			c.Expect(msg.GetHostname(), gs.Equals, "mymachine")
+			c.Expect(msg.FindFirstField("programname"), gs.IsNil)
+			c.Expect(msg.GetPayload(), gs.Equals, "kernel panic")
+		})
+
+		c.Specify("fails on invalid messages", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			for _, payload := range []string{
+				"<192>Oct 11 22:14:15 mymachine su: x",
+				"<13>1 not-a-time host app - - - x",
+				`<13>1 - host app - - [id key="value] x`,
+				`<13>1 - host app - - [id key=value] x`,
+			} {
+				pack.Message.SetPayload(payload)
+				_, err = decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+
+		c.Specify("fails on an unknown timestamp location", func() {
+			conf.TimestampLocation = "Nowhere/Special"
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -89,6 +89,11 @@ func (t *TcpOutput) ConfigStruct() interface{} {
 	}
 }
 
+// Messages are only ever looked at through the encoder.
+func (t *TcpOutput) EncoderOnly() bool {
+	return true
+}
+
 func (t *TcpOutput) SetName(name string) {
 	re := regexp.MustCompile("\\W")
 	t.name = re.ReplaceAllString(name, "_")
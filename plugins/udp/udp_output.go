@@ -54,6 +54,11 @@ func (o *UdpOutput) ConfigStruct() interface{} {
 	}
 }
 
+// Messages are only ever looked at through the encoder.
+func (o *UdpOutput) EncoderOnly() bool {
+	return true
+}
+
 // Initialize UDP connection
 func (o *UdpOutput) Init(config interface{}) (err error) {
 	o.UdpOutputConfig = config.(*UdpOutputConfig) // assert we have the right config type
@@ -0,0 +1,62 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package useragent
+
+import (
+	"container/list"
+)
+
+type uaCacheEntry struct {
+	key   string
+	value *userAgent
+}
+
+// Least recently used cache of the parsed user-agents. Not safe for
+// concurrent use, each decoder has its own.
+type uaCache struct {
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+}
+
+func newUaCache(size int) *uaCache {
+	return &uaCache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+func (c *uaCache) get(key string) *userAgent {
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*uaCacheEntry).value
+	}
+	return nil
+}
+
+func (c *uaCache) add(key string, value *userAgent) {
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*uaCacheEntry).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		delete(c.entries, oldest.Value.(*uaCacheEntry).key)
+		c.order.Remove(oldest)
+	}
+	c.entries[key] = c.order.PushFront(&uaCacheEntry{key, value})
+}
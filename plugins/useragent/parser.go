@@ -0,0 +1,206 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+/*
+Package useragent provides a decoder parsing user-agent strings into the
+browser, operating system and device type, w/ regexes read from a file.
+*/
+package useragent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Family used when no parser matches.
+const uaOther = "Other"
+
+// A parser as found in the regexes file, the layout follows the ua-parser
+// project's regexes w/ the addition of the device type.
+type uaParserConfig struct {
+	Regex     string `json:"regex"`
+	RegexFlag string `json:"regex_flag"`
+
+	FamilyReplacement string `json:"family_replacement"`
+	V1Replacement     string `json:"v1_replacement"`
+	V2Replacement     string `json:"v2_replacement"`
+	V3Replacement     string `json:"v3_replacement"`
+
+	OsReplacement   string `json:"os_replacement"`
+	OsV1Replacement string `json:"os_v1_replacement"`
+	OsV2Replacement string `json:"os_v2_replacement"`
+	OsV3Replacement string `json:"os_v3_replacement"`
+
+	DeviceType string `json:"device_type"`
+}
+
+type uaRegexesConfig struct {
+	UserAgentParsers []uaParserConfig `json:"user_agent_parsers"`
+	OsParsers        []uaParserConfig `json:"os_parsers"`
+	DeviceParsers    []uaParserConfig `json:"device_parsers"`
+}
+
+// Compiled parser, the replacements are the family followed by the major,
+// minor and patch versions. Empty replacements use the matching group.
+type uaMatcher struct {
+	regex        *regexp.Regexp
+	replacements [4]string
+}
+
+// Returns the family and the version of the first matching parser.
+func matchFamily(matchers []*uaMatcher, ua string) (family, version string) {
+	for _, m := range matchers {
+		groups := m.regex.FindStringSubmatch(ua)
+		if groups == nil {
+			continue
+		}
+		var values [4]string
+		for i, repl := range m.replacements {
+			if repl != "" {
+				values[i] = strings.TrimSpace(expandGroups(repl, groups))
+			} else if i+1 < len(groups) {
+				values[i] = groups[i+1]
+			}
+		}
+		if values[0] == "" {
+			values[0] = uaOther
+		}
+		// The version ends at the first missing part.
+		parts := make([]string, 0, 3)
+		for _, v := range values[1:] {
+			if v == "" {
+				break
+			}
+			parts = append(parts, v)
+		}
+		return values[0], strings.Join(parts, ".")
+	}
+	return uaOther, ""
+}
+
+// Replaces the $1 to $9 references w/ the matching groups.
+func expandGroups(repl string, groups []string) string {
+	if !strings.Contains(repl, "$") {
+		return repl
+	}
+	for i := 9; i > 0; i-- {
+		var group string
+		if i < len(groups) {
+			group = groups[i]
+		}
+		repl = strings.Replace(repl, "$"+strconv.Itoa(i), group, -1)
+	}
+	return repl
+}
+
+type uaDeviceMatcher struct {
+	regex      *regexp.Regexp
+	deviceType string
+}
+
+// Parsed user-agent.
+type userAgent struct {
+	browser        string
+	browserVersion string
+	os             string
+	osVersion      string
+	deviceType     string
+}
+
+// Device type of the crawlers, setting the bot flag.
+const uaBotDeviceType = "bot"
+
+func (ua *userAgent) isBot() bool {
+	return ua.deviceType == uaBotDeviceType
+}
+
+type uaParser struct {
+	browsers []*uaMatcher
+	oses     []*uaMatcher
+	devices  []*uaDeviceMatcher
+}
+
+func compileRegex(section string, i int, conf uaParserConfig) (*regexp.Regexp, error) {
+	expr := conf.Regex
+	switch conf.RegexFlag {
+	case "":
+	case "i":
+		expr = "(?i)" + expr
+	default:
+		return nil, fmt.Errorf("%s[%d]: unsupported regex_flag '%s'", section, i,
+			conf.RegexFlag)
+	}
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%s[%d]: %s", section, i, err)
+	}
+	return regex, nil
+}
+
+// Loads the parsers from a regexes file.
+func loadUaParser(path string) (*uaParser, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var conf uaRegexesConfig
+	if err = json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("invalid regexes file '%s': %s", path, err)
+	}
+	p := new(uaParser)
+	for i, c := range conf.UserAgentParsers {
+		regex, err := compileRegex("user_agent_parsers", i, c)
+		if err != nil {
+			return nil, err
+		}
+		p.browsers = append(p.browsers, &uaMatcher{regex, [4]string{
+			c.FamilyReplacement, c.V1Replacement, c.V2Replacement, c.V3Replacement}})
+	}
+	for i, c := range conf.OsParsers {
+		regex, err := compileRegex("os_parsers", i, c)
+		if err != nil {
+			return nil, err
+		}
+		p.oses = append(p.oses, &uaMatcher{regex, [4]string{
+			c.OsReplacement, c.OsV1Replacement, c.OsV2Replacement, c.OsV3Replacement}})
+	}
+	for i, c := range conf.DeviceParsers {
+		regex, err := compileRegex("device_parsers", i, c)
+		if err != nil {
+			return nil, err
+		}
+		if c.DeviceType == "" {
+			return nil, fmt.Errorf("device_parsers[%d]: missing device_type", i)
+		}
+		p.devices = append(p.devices, &uaDeviceMatcher{regex, c.DeviceType})
+	}
+	return p, nil
+}
+
+func (p *uaParser) parse(s string) *userAgent {
+	ua := &userAgent{deviceType: "other"}
+	ua.browser, ua.browserVersion = matchFamily(p.browsers, s)
+	ua.os, ua.osVersion = matchFamily(p.oses, s)
+	for _, d := range p.devices {
+		if d.regex.MatchString(s) {
+			ua.deviceType = d.deviceType
+			break
+		}
+	}
+	return ua
+}
@@ -0,0 +1,137 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package useragent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type UserAgentDecoderConfig struct {
+	// Field holding the user-agent string.
+	SourceField string `toml:"source_field"`
+
+	// Regexes file, relative paths are relative to the share_dir.
+	RegexesFile string `toml:"regexes_file"`
+
+	// Prefix of the names of the fields receiving the parsed values.
+	FieldPrefix string `toml:"field_prefix"`
+
+	// Number of parsed user-agents kept in the cache, 0 disables it.
+	CacheSize uint `toml:"cache_size"`
+}
+
+type UserAgentDecoder struct {
+	pConfig     *PipelineConfig
+	sourceField string
+	prefix      string
+	parser      *uaParser
+	cache       *uaCache
+}
+
+func (ud *UserAgentDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
+	ud.pConfig = pConfig
+}
+
+func (ud *UserAgentDecoder) ConfigStruct() interface{} {
+	return &UserAgentDecoderConfig{
+		RegexesFile: "useragent/regexes.json",
+		FieldPrefix: "user_agent_",
+		CacheSize:   1000,
+	}
+}
+
+func (ud *UserAgentDecoder) Init(config interface{}) (err error) {
+	conf := config.(*UserAgentDecoderConfig)
+	if conf.SourceField == "" {
+		return errors.New("`source_field` must be specified")
+	}
+	ud.sourceField = conf.SourceField
+	ud.prefix = conf.FieldPrefix
+	path := ud.pConfig.Globals.PrependShareDir(conf.RegexesFile)
+	if ud.parser, err = loadUaParser(path); err != nil {
+		return fmt.Errorf("UserAgentDecoder can't load regexes: %s", err)
+	}
+	if conf.CacheSize > 0 {
+		ud.cache = newUaCache(int(conf.CacheSize))
+	}
+	return
+}
+
+func (ud *UserAgentDecoder) parse(s string) (ua *userAgent) {
+	if ud.cache == nil {
+		return ud.parser.parse(s)
+	}
+	if ua = ud.cache.get(s); ua == nil {
+		ua = ud.parser.parse(s)
+		ud.cache.add(s, ua)
+	}
+	return
+}
+
+func (ud *UserAgentDecoder) addField(msg *message.Message, name string,
+	value interface{}) error {
+
+	f, err := message.NewField(ud.prefix+name, value, "")
+	if err != nil {
+		return fmt.Errorf("field creation error: %s", err)
+	}
+	msg.AddField(f)
+	return nil
+}
+
+func (ud *UserAgentDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	value, _ := pack.Message.GetFieldValue(ud.sourceField)
+	s, ok := value.(string)
+	if !ok || s == "" {
+		// No user-agent, e.g. the header was missing. Pass the message through.
+		return []*PipelinePack{pack}, nil
+	}
+
+	ua := ud.parse(s)
+	msg := pack.Message
+	if err = ud.addField(msg, "browser", ua.browser); err != nil {
+		return
+	}
+	if ua.browserVersion != "" {
+		if err = ud.addField(msg, "browser_version", ua.browserVersion); err != nil {
+			return
+		}
+	}
+	if err = ud.addField(msg, "os", ua.os); err != nil {
+		return
+	}
+	if ua.osVersion != "" {
+		if err = ud.addField(msg, "os_version", ua.osVersion); err != nil {
+			return
+		}
+	}
+	if err = ud.addField(msg, "device_type", ua.deviceType); err != nil {
+		return
+	}
+	if err = ud.addField(msg, "bot", ua.isBot()); err != nil {
+		return
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+func init() {
+	RegisterPlugin("UserAgentDecoder", func() interface{} {
+		return new(UserAgentDecoder)
+	})
+}
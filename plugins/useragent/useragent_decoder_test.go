@@ -0,0 +1,156 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package useragent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func UserAgentDecoderSpec(c gs.Context) {
+	c.Specify("A UserAgentDecoder", func() {
+		decoder := new(UserAgentDecoder)
+		decoder.SetPipelineConfig(NewPipelineConfig(nil))
+		conf := decoder.ConfigStruct().(*UserAgentDecoderConfig)
+		conf.SourceField = "user_agent"
+		regexesFile, err := filepath.Abs("regexes.json")
+		c.Assume(err, gs.IsNil)
+		conf.RegexesFile = regexesFile
+		supply := make(chan *PipelinePack, 1)
+		pack := NewPipelinePack(supply)
+
+		decode := func(ua string) *message.Message {
+			pack.Message = new(message.Message)
+			f, _ := message.NewField("user_agent", ua, "")
+			pack.Message.AddField(f)
+			packs, err := decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+			return packs[0].Message
+		}
+		getString := func(msg *message.Message, name string) string {
+			value, _ := msg.GetFieldValue(name)
+			s, _ := value.(string)
+			return s
+		}
+
+		c.Specify("parses user-agents w/ the bundled regexes", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			tests := []struct {
+				ua                      string
+				browser, browserVersion string
+				os, osVersion           string
+				deviceType              string
+			}{
+				{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 " +
+					"(KHTML, like Gecko) Chrome/120.0.6099.109 Safari/537.36",
+					"Chrome", "120.0.6099", "Windows", "10", "desktop"},
+				{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) " +
+					"AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 " +
+					"Mobile/15E148 Safari/604.1",
+					"Mobile Safari", "17.1", "iOS", "17.1.2", "mobile"},
+				{"Mozilla/5.0 (compatible; Googlebot/2.1; " +
+					"+http://www.google.com/bot.html)",
+					"Googlebot", "2.1", "Other", "", "bot"},
+				{"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 " +
+					"(KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
+					"Chrome", "119.0.0", "Android", "13", "tablet"},
+				{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 " +
+					"(KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+					"Chrome Mobile", "120.0.6099", "Android", "14", "mobile"},
+				{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) " +
+					"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 " +
+					"Safari/537.36 Edg/120.0.2210.61",
+					"Edge", "120.0.2210", "Mac OS X", "10.15.7", "desktop"},
+				{"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:120.0) " +
+					"Gecko/20100101 Firefox/120.0",
+					"Firefox", "120.0", "Ubuntu", "", "desktop"},
+				{"curl/8.4.0", "curl", "8.4.0", "Other", "", "other"},
+			}
+			for _, test := range tests {
+				msg := decode(test.ua)
+				c.Expect(getString(msg, "user_agent_browser"), gs.Equals, test.browser)
+				c.Expect(getString(msg, "user_agent_browser_version"), gs.Equals,
+					test.browserVersion)
+				c.Expect(getString(msg, "user_agent_os"), gs.Equals, test.os)
+				c.Expect(getString(msg, "user_agent_os_version"), gs.Equals,
+					test.osVersion)
+				c.Expect(getString(msg, "user_agent_device_type"), gs.Equals,
+					test.deviceType)
+				bot, _ := msg.GetFieldValue("user_agent_bot")
+				c.Expect(bot, gs.Equals, test.deviceType == "bot")
+			}
+		})
+
+		c.Specify("caches the parsed user-agents", func() {
+			conf.CacheSize = 2
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decode("curl/8.4.0")
+			ua := decoder.cache.get("curl/8.4.0")
+			c.Assume(ua, gs.Not(gs.IsNil))
+			ua.browser = "cached"
+			c.Expect(getString(decode("curl/8.4.0"), "user_agent_browser"),
+				gs.Equals, "cached")
+
+			// The least recently used entry is evicted.
+			decode("Wget/1.21.4")
+			decoder.cache.get("curl/8.4.0")
+			decode("okhttp/4.12.0")
+			c.Expect(decoder.cache.get("Wget/1.21.4"), gs.IsNil)
+			c.Expect(decoder.cache.get("curl/8.4.0"), gs.Not(gs.IsNil))
+			c.Expect(len(decoder.cache.entries), gs.Equals, 2)
+		})
+
+		c.Specify("passes messages w/o user-agent through", func() {
+			conf.FieldPrefix = "ua."
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message = new(message.Message)
+			packs, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+			c.Expect(len(pack.Message.Fields), gs.Equals, 0)
+			c.Expect(getString(decode("Wget/1.21.4"), "ua.browser"), gs.Equals, "Wget")
+		})
+
+		c.Specify("fails on invalid settings", func() {
+			dir, err := ioutil.TempDir("", "useragent")
+			c.Assume(err, gs.IsNil)
+			defer os.RemoveAll(dir)
+			for _, regexes := range []string{
+				`{"user_agent_parsers": [{"regex": "(a"}]}`,
+				`{"os_parsers": [{"regex": "(a)", "regex_flag": "x"}]}`,
+				`{"device_parsers": [{"regex": "a"}]}`,
+				`[`,
+			} {
+				conf.RegexesFile = filepath.Join(dir, "regexes.json")
+				ioutil.WriteFile(conf.RegexesFile, []byte(regexes), 0644)
+				c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			}
+			conf.RegexesFile = filepath.Join(dir, "missing.json")
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+			conf.RegexesFile = regexesFile
+			conf.SourceField = ""
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -462,6 +462,11 @@ func TestReadMessage(t *testing.T) {
 		t.Errorf("%s", err)
 	}
 	pack.MsgBytes = []byte("rawdata")
+	f, _ := message.NewField("http", map[string]interface{}{
+		"method": "GET",
+		"status": 200,
+	}, "")
+	pack.Message.AddField(f)
 	r := sb.ProcessMessage(pack)
 	if r != 0 {
 		t.Errorf("ProcessMessage should return 0, received %d", r)
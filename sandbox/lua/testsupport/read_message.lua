@@ -32,6 +32,8 @@ function process_message ()
     if read_message("Type") ~= "TEST" then return 19 end
     if read_message("raw") ~= "rawdata" then return 20 end
     if read_message("Fields[empty_bytes]") ~= nil then return 21 end
+    if read_message("Fields[http.method]") ~= "GET" then return 22 end
+    if read_message("Fields[http]") ~= '{"method":"GET","status":200}' then return 23 end
 
     return 0
 end
@@ -0,0 +1,256 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package zstd
+
+// forwardBitReader reads the little-endian bit fields of FSE table
+// descriptions.
+type forwardBitReader struct {
+	data []byte
+	pos  uint // in bits
+}
+
+func (br *forwardBitReader) peek(n uint) uint32 {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		p := br.pos + i
+		if int(p>>3) < len(br.data) {
+			v |= uint32(br.data[p>>3]>>(p&7)&1) << i
+		}
+	}
+	return v
+}
+
+func (br *forwardBitReader) read(n uint) uint32 {
+	v := br.peek(n)
+	br.pos += n
+	return v
+}
+
+// reverseBitReader reads the backward bit streams of Huffman coded literals
+// and FSE coded symbols, which start at the highest bit below the end marker
+// in the last byte and are read towards the first byte. Reading past the
+// start of the stream yields zero bits and sets overflow.
+type reverseBitReader struct {
+	data     []byte
+	off      int    // the next byte to load is data[off-1]
+	bits     uint64 // the low cnt bits haven't been consumed yet
+	cnt      uint
+	overflow bool
+}
+
+func (br *reverseBitReader) init(data []byte) error {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return errCorrupt
+	}
+	last := data[len(data)-1]
+	br.data = data
+	br.off = len(data) - 1
+	br.cnt = highBit(uint32(last))
+	br.bits = uint64(last) & (1<<br.cnt - 1)
+	br.overflow = false
+	return nil
+}
+
+func (br *reverseBitReader) fill() {
+	for br.cnt <= 56 && br.off > 0 {
+		br.off--
+		br.bits = br.bits<<8 | uint64(br.data[br.off])
+		br.cnt += 8
+	}
+}
+
+// peek returns the next n bits, n must not exceed 56.
+func (br *reverseBitReader) peek(n uint) uint64 {
+	if br.cnt < n {
+		br.fill()
+		if br.cnt < n {
+			return br.bits << (n - br.cnt) & (1<<n - 1)
+		}
+	}
+	return br.bits >> (br.cnt - n) & (1<<n - 1)
+}
+
+func (br *reverseBitReader) skip(n uint) {
+	if n > br.cnt {
+		br.overflow = true
+		br.cnt = 0
+		return
+	}
+	br.cnt -= n
+}
+
+func (br *reverseBitReader) read(n uint) uint64 {
+	v := br.peek(n)
+	br.skip(n)
+	return v
+}
+
+// finished returns whether exactly all of the stream's bits were consumed.
+func (br *reverseBitReader) finished() bool {
+	return !br.overflow && br.cnt == 0 && br.off == 0
+}
+
+// highBit returns the position of the highest set bit of v, which must not
+// be zero.
+func highBit(v uint32) uint {
+	var n uint
+	for v > 1 {
+		v >>= 1
+		n++
+	}
+	return n
+}
+
+type fseEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	newState uint16
+}
+
+type fseTable struct {
+	log     uint
+	entries []fseEntry
+}
+
+// readFSETable parses an FSE table description, returning the table and the
+// number of bytes used.
+func readFSETable(data []byte, maxLog uint, maxSymbol int) (*fseTable, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errCorrupt
+	}
+	br := &forwardBitReader{data: data}
+	log := uint(br.read(4)) + 5
+	if log > maxLog {
+		return nil, 0, errCorrupt
+	}
+
+	var (
+		norm      = make([]int16, maxSymbol+1)
+		remaining = int32(1)<<log + 1
+		threshold = int32(1) << log
+		nbBits    = log + 1
+		symbol    int
+		prevZero  bool
+	)
+	for remaining > 1 && symbol <= maxSymbol {
+		if prevZero {
+			// A zero probability is followed by the number of additional
+			// zero probability symbols, in 2 bit increments.
+			for {
+				repeat := int(br.read(2))
+				symbol += repeat
+				if repeat != 3 {
+					break
+				}
+			}
+			if symbol > maxSymbol {
+				return nil, 0, errCorrupt
+			}
+		}
+		max := 2*threshold - 1 - remaining
+		var count int32
+		if low := int32(br.peek(nbBits - 1)); low < max {
+			count = low
+			br.pos += nbBits - 1
+		} else {
+			count = int32(br.read(nbBits))
+			if count >= threshold {
+				count -= max
+			}
+		}
+		count-- // -1 stands for a "less than 1" probability
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		norm[symbol] = int16(count)
+		symbol++
+		prevZero = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	n := int(br.pos+7) >> 3
+	if remaining != 1 || n > len(data) {
+		return nil, 0, errCorrupt
+	}
+	table, err := buildFSETable(norm[:symbol], log)
+	return table, n, err
+}
+
+func buildFSETable(norm []int16, log uint) (*fseTable, error) {
+	size := 1 << log
+	table := &fseTable{log: log, entries: make([]fseEntry, size)}
+	next := make([]uint32, len(norm))
+	high := size - 1
+	for s, count := range norm {
+		if count == -1 {
+			table.entries[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = uint32(count)
+		}
+	}
+	pos := 0
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	for s, count := range norm {
+		for i := 0; i < int(count); i++ {
+			table.entries[pos].symbol = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, errCorrupt
+	}
+	for i := range table.entries {
+		e := &table.entries[i]
+		state := next[e.symbol]
+		next[e.symbol]++
+		e.nbBits = uint8(log - highBit(state))
+		e.newState = uint16(state<<e.nbBits - uint32(size))
+	}
+	return table, nil
+}
+
+// rleFSETable returns a table always decoding symbol without using any bits.
+func rleFSETable(symbol uint8) *fseTable {
+	return &fseTable{entries: []fseEntry{{symbol: symbol}}}
+}
+
+type fseState struct {
+	table *fseTable
+	state uint32
+}
+
+func (s *fseState) init(table *fseTable, br *reverseBitReader) {
+	s.table = table
+	s.state = uint32(br.read(table.log))
+}
+
+func (s *fseState) symbol() uint8 {
+	return s.table.entries[s.state].symbol
+}
+
+func (s *fseState) update(br *reverseBitReader) {
+	e := s.table.entries[s.state]
+	s.state = uint32(e.newState) + uint32(br.read(uint(e.nbBits)))
+}
@@ -0,0 +1,162 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package zstd
+
+const (
+	maxHuffmanBits      = 11
+	maxHuffmanWeightLog = 6
+)
+
+type huffmanEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+// huffmanTable is indexed by the next maxBits bits of a stream.
+type huffmanTable struct {
+	maxBits uint
+	entries []huffmanEntry
+}
+
+// readHuffmanTable parses a Huffman tree description, returning the table
+// and the number of bytes used.
+func readHuffmanTable(data []byte) (*huffmanTable, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errCorrupt
+	}
+	var (
+		weights [256]uint8
+		count   int
+		n       int
+	)
+	header := int(data[0])
+	if header >= 128 {
+		// Weights stored directly, 4 bits each.
+		count = header - 127
+		n = 1 + (count+1)/2
+		if n > len(data) {
+			return nil, 0, errCorrupt
+		}
+		for i := 0; i < count; i++ {
+			b := data[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 0x0F
+			}
+		}
+	} else {
+		// FSE compressed weights, decoded by two interleaved states.
+		n = 1 + header
+		if n > len(data) {
+			return nil, 0, errCorrupt
+		}
+		table, used, err := readFSETable(data[1:n], maxHuffmanWeightLog, 255)
+		if err != nil {
+			return nil, 0, err
+		}
+		var br reverseBitReader
+		if err = br.init(data[1+used : n]); err != nil {
+			return nil, 0, err
+		}
+		var state1, state2 fseState
+		state1.init(table, &br)
+		state2.init(table, &br)
+		for {
+			if count > 253 {
+				return nil, 0, errCorrupt
+			}
+			weights[count] = state1.symbol()
+			count++
+			state1.update(&br)
+			if br.overflow {
+				weights[count] = state2.symbol()
+				count++
+				break
+			}
+			weights[count] = state2.symbol()
+			count++
+			state2.update(&br)
+			if br.overflow {
+				weights[count] = state1.symbol()
+				count++
+				break
+			}
+		}
+	}
+
+	// The last symbol's weight is implied by the others.
+	var total uint32
+	for _, w := range weights[:count] {
+		if w > maxHuffmanBits {
+			return nil, 0, errCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, 0, errCorrupt
+	}
+	maxBits := highBit(total) + 1
+	if maxBits > maxHuffmanBits {
+		return nil, 0, errCorrupt
+	}
+	rest := uint32(1)<<maxBits - total
+	if rest&(rest-1) != 0 {
+		return nil, 0, errCorrupt
+	}
+	weights[count] = uint8(highBit(rest) + 1)
+	count++
+
+	// Codes are assigned by increasing weight, then increasing symbol, each
+	// symbol taking 2^(weight-1) consecutive table entries.
+	table := &huffmanTable{
+		maxBits: maxBits,
+		entries: make([]huffmanEntry, 1<<maxBits),
+	}
+	pos := 0
+	for w := uint8(1); w <= uint8(maxBits); w++ {
+		length := 1 << (w - 1)
+		nbBits := uint8(maxBits + 1 - uint(w))
+		for s, sw := range weights[:count] {
+			if sw != w {
+				continue
+			}
+			for i := 0; i < length; i++ {
+				table.entries[pos+i] = huffmanEntry{symbol: uint8(s), nbBits: nbBits}
+			}
+			pos += length
+		}
+	}
+	return table, n, nil
+}
+
+// decode fills out with the symbols of a single Huffman coded stream.
+func (t *huffmanTable) decode(data []byte, out []byte) error {
+	var br reverseBitReader
+	if err := br.init(data); err != nil {
+		return err
+	}
+	for i := range out {
+		e := t.entries[br.peek(t.maxBits)]
+		out[i] = e.symbol
+		br.skip(uint(e.nbBits))
+	}
+	if !br.finished() {
+		return errCorrupt
+	}
+	return nil
+}
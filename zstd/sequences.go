@@ -0,0 +1,385 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package zstd
+
+const (
+	maxLiteralsLengthLog = 9
+	maxMatchLengthLog    = 9
+	maxOffsetLog         = 8
+
+	maxLiteralsLengthSymbol = 35
+	maxMatchLengthSymbol    = 52
+	maxOffsetSymbol         = 31
+)
+
+var (
+	literalsLengthBase = [maxLiteralsLengthSymbol + 1]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	literalsLengthBits = [maxLiteralsLengthSymbol + 1]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	matchLengthBase = [maxMatchLengthSymbol + 1]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	matchLengthBits = [maxMatchLengthSymbol + 1]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+
+	predefinedLiteralsLength = mustBuildFSETable([]int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}, 6)
+	predefinedMatchLength = mustBuildFSETable([]int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}, 6)
+	predefinedOffset = mustBuildFSETable([]int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}, 5)
+)
+
+func mustBuildFSETable(norm []int16, log uint) *fseTable {
+	table, err := buildFSETable(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return table
+}
+
+// sequenceDecoder decodes compressed blocks, keeping the state that's shared
+// between the blocks of a frame.
+type sequenceDecoder struct {
+	huffman        *huffmanTable
+	literalsLength *fseTable
+	offset         *fseTable
+	matchLength    *fseTable
+	repeat         [3]int
+	literals       []byte
+}
+
+func (d *sequenceDecoder) reset() {
+	d.huffman = nil
+	d.literalsLength = nil
+	d.offset = nil
+	d.matchLength = nil
+	d.repeat = [3]int{1, 4, 8}
+}
+
+// decodeBlock appends the contents of the compressed block to out, which
+// holds the frame's history.
+func (d *sequenceDecoder) decodeBlock(block []byte, out []byte) ([]byte, error) {
+	literals, n, err := d.decodeLiterals(block)
+	if err != nil {
+		return out, err
+	}
+	block = block[n:]
+
+	if len(block) == 0 {
+		return out, errCorrupt
+	}
+	var count int
+	switch b0 := int(block[0]); {
+	case b0 < 128:
+		count, n = b0, 1
+	case b0 < 255:
+		if len(block) < 2 {
+			return out, errCorrupt
+		}
+		count, n = (b0-128)<<8+int(block[1]), 2
+	default:
+		if len(block) < 3 {
+			return out, errCorrupt
+		}
+		count, n = int(block[1])+int(block[2])<<8+0x7F00, 3
+	}
+	block = block[n:]
+	if count == 0 {
+		return append(out, literals...), nil
+	}
+
+	if len(block) == 0 {
+		return out, errCorrupt
+	}
+	modes := block[0]
+	if modes&0x03 != 0 {
+		return out, errCorrupt
+	}
+	block = block[1:]
+	if d.literalsLength, n, err = readSequenceTable(block, modes>>6,
+		d.literalsLength, predefinedLiteralsLength, maxLiteralsLengthLog,
+		maxLiteralsLengthSymbol); err != nil {
+		return out, err
+	}
+	block = block[n:]
+	if d.offset, n, err = readSequenceTable(block, modes>>4&0x03, d.offset,
+		predefinedOffset, maxOffsetLog, maxOffsetSymbol); err != nil {
+		return out, err
+	}
+	block = block[n:]
+	if d.matchLength, n, err = readSequenceTable(block, modes>>2&0x03,
+		d.matchLength, predefinedMatchLength, maxMatchLengthLog,
+		maxMatchLengthSymbol); err != nil {
+		return out, err
+	}
+	block = block[n:]
+
+	var br reverseBitReader
+	if err = br.init(block); err != nil {
+		return out, err
+	}
+	var llState, ofState, mlState fseState
+	llState.init(d.literalsLength, &br)
+	ofState.init(d.offset, &br)
+	mlState.init(d.matchLength, &br)
+
+	for i := 0; i < count; i++ {
+		llCode := llState.symbol()
+		ofCode := ofState.symbol()
+		mlCode := mlState.symbol()
+		if llCode > maxLiteralsLengthSymbol || mlCode > maxMatchLengthSymbol ||
+			ofCode > maxOffsetSymbol {
+			return out, errCorrupt
+		}
+
+		offsetValue := 1<<ofCode + int(br.read(uint(ofCode)))
+		matchLength := int(matchLengthBase[mlCode]) +
+			int(br.read(uint(matchLengthBits[mlCode])))
+		literalsLength := int(literalsLengthBase[llCode]) +
+			int(br.read(uint(literalsLengthBits[llCode])))
+
+		var offset int
+		if offsetValue > 3 {
+			offset = offsetValue - 3
+			d.repeat[2], d.repeat[1], d.repeat[0] = d.repeat[1], d.repeat[0], offset
+		} else {
+			// Repeat offsets are shifted by one when there are no literals.
+			index := offsetValue - 1
+			if literalsLength == 0 {
+				index++
+			}
+			switch index {
+			case 0:
+				offset = d.repeat[0]
+			case 1:
+				offset = d.repeat[1]
+				d.repeat[1], d.repeat[0] = d.repeat[0], offset
+			case 2:
+				offset = d.repeat[2]
+				d.repeat[2], d.repeat[1], d.repeat[0] = d.repeat[1], d.repeat[0], offset
+			default:
+				offset = d.repeat[0] - 1
+				d.repeat[2], d.repeat[1], d.repeat[0] = d.repeat[1], d.repeat[0], offset
+			}
+		}
+
+		if i < count-1 {
+			llState.update(&br)
+			mlState.update(&br)
+			ofState.update(&br)
+		}
+
+		if literalsLength > len(literals) {
+			return out, errCorrupt
+		}
+		out = append(out, literals[:literalsLength]...)
+		literals = literals[literalsLength:]
+		if offset <= 0 || offset > len(out) || matchLength > maxBlockSize {
+			return out, errCorrupt
+		}
+		start := len(out) - offset
+		if matchLength <= offset {
+			out = append(out, out[start:start+matchLength]...)
+		} else {
+			// The match overlaps the data it produces.
+			for j := 0; j < matchLength; j++ {
+				out = append(out, out[start+j])
+			}
+		}
+	}
+	if !br.finished() {
+		return out, errCorrupt
+	}
+	return append(out, literals...), nil
+}
+
+// readSequenceTable returns the table to use for a symbol type according to
+// its compression mode, along with the number of bytes used.
+func readSequenceTable(data []byte, mode uint8, previous, predefined *fseTable,
+	maxLog uint, maxSymbol int) (*fseTable, int, error) {
+
+	switch mode {
+	case 0: // Predefined
+		return predefined, 0, nil
+	case 1: // RLE
+		if len(data) == 0 || int(data[0]) > maxSymbol {
+			return nil, 0, errCorrupt
+		}
+		return rleFSETable(data[0]), 1, nil
+	case 2: // FSE compressed
+		return readFSETable(data, maxLog, maxSymbol)
+	}
+	// Repeat
+	if previous == nil {
+		return nil, 0, errCorrupt
+	}
+	return previous, 0, nil
+}
+
+// decodeLiterals returns the block's literals and the size of the literals
+// section.
+func (d *sequenceDecoder) decodeLiterals(block []byte) ([]byte, int, error) {
+	if len(block) == 0 {
+		return nil, 0, errCorrupt
+	}
+	literalsType := block[0] & 0x03
+	sizeFormat := block[0] >> 2 & 0x03
+
+	if literalsType < 2 {
+		// Raw or RLE literals.
+		var size, n int
+		switch sizeFormat {
+		case 0, 2:
+			size, n = int(block[0]>>3), 1
+		case 1:
+			if len(block) < 2 {
+				return nil, 0, errCorrupt
+			}
+			size, n = int(block[0]>>4)+int(block[1])<<4, 2
+		default:
+			if len(block) < 3 {
+				return nil, 0, errCorrupt
+			}
+			size, n = int(block[0]>>4)+int(block[1])<<4+int(block[2])<<12, 3
+		}
+		if size > maxBlockSize {
+			return nil, 0, errCorrupt
+		}
+		if literalsType == 0 {
+			if n+size > len(block) {
+				return nil, 0, errCorrupt
+			}
+			return block[n : n+size], n + size, nil
+		}
+		if n >= len(block) {
+			return nil, 0, errCorrupt
+		}
+		d.literals = d.literals[:0]
+		for i := 0; i < size; i++ {
+			d.literals = append(d.literals, block[n])
+		}
+		return d.literals, n + 1, nil
+	}
+
+	// Huffman coded literals, either with a new tree or reusing the last one.
+	var (
+		size, compressedSize, n int
+		streams                 = 4
+	)
+	switch sizeFormat {
+	case 0, 1:
+		if len(block) < 3 {
+			return nil, 0, errCorrupt
+		}
+		if sizeFormat == 0 {
+			streams = 1
+		}
+		v := int(block[0]) | int(block[1])<<8 | int(block[2])<<16
+		size, compressedSize, n = v>>4&0x3FF, v>>14&0x3FF, 3
+	case 2:
+		if len(block) < 4 {
+			return nil, 0, errCorrupt
+		}
+		v := int(block[0]) | int(block[1])<<8 | int(block[2])<<16 | int(block[3])<<24
+		size, compressedSize, n = v>>4&0x3FFF, v>>18&0x3FFF, 4
+	default:
+		if len(block) < 5 {
+			return nil, 0, errCorrupt
+		}
+		v := int(block[0]) | int(block[1])<<8 | int(block[2])<<16 |
+			int(block[3])<<24 | int(block[4])<<32
+		size, compressedSize, n = v>>4&0x3FFFF, v>>22&0x3FFFF, 5
+	}
+	if size > maxBlockSize || n+compressedSize > len(block) {
+		return nil, 0, errCorrupt
+	}
+	data := block[n : n+compressedSize]
+	if literalsType == 2 {
+		table, used, err := readHuffmanTable(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		d.huffman = table
+		data = data[used:]
+	} else if d.huffman == nil {
+		return nil, 0, errCorrupt
+	}
+
+	if cap(d.literals) < size {
+		d.literals = make([]byte, size)
+	}
+	d.literals = d.literals[:size]
+	if streams == 1 {
+		if err := d.huffman.decode(data, d.literals); err != nil {
+			return nil, 0, err
+		}
+		return d.literals, n + compressedSize, nil
+	}
+
+	// Four streams, preceded by a jump table holding the sizes of the first
+	// three.
+	if len(data) < 6 {
+		return nil, 0, errCorrupt
+	}
+	var sizes [4]int
+	total := 6
+	for i := 0; i < 3; i++ {
+		sizes[i] = int(data[2*i]) | int(data[2*i+1])<<8
+		total += sizes[i]
+	}
+	if total > len(data) {
+		return nil, 0, errCorrupt
+	}
+	sizes[3] = len(data) - total
+	data = data[6:]
+	segment := (size + 3) / 4
+	out := d.literals
+	for i := 0; i < 4; i++ {
+		regenerated := segment
+		if i == 3 || regenerated > len(out) {
+			regenerated = len(out)
+		}
+		if err := d.huffman.decode(data[:sizes[i]], out[:regenerated]); err != nil {
+			return nil, 0, err
+		}
+		data = data[sizes[i]:]
+		out = out[regenerated:]
+	}
+	return d.literals, n + compressedSize, nil
+}
@@ -0,0 +1,115 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package zstd
+
+import "encoding/binary"
+
+const (
+	prime64_1 uint64 = 0x9E3779B185EBCA87
+	prime64_2 uint64 = 0xC2B2AE3D27D4EB4F
+	prime64_3 uint64 = 0x165667B19E3779F9
+	prime64_4 uint64 = 0x85EBCA77C2B2AE63
+	prime64_5 uint64 = 0x27D4EB2F165667C5
+)
+
+// xxh64 computes the XXH64 hash with a seed of 0 used for frame checksums.
+type xxh64 struct {
+	v     [4]uint64
+	total uint64
+	mem   [32]byte
+	n     int
+}
+
+func (h *xxh64) reset() {
+	h.v = [4]uint64{prime64_1, prime64_2, 0, 0}
+	// Wrap around at run time, constant arithmetic can't overflow.
+	h.v[0] += prime64_2
+	h.v[3] -= prime64_1
+	h.total = 0
+	h.n = 0
+}
+
+func rotl64(x uint64, r uint) uint64 {
+	return x<<r | x>>(64-r)
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	return rotl64(acc+input*prime64_2, 31) * prime64_1
+}
+
+func xxhMergeRound(acc, v uint64) uint64 {
+	return (acc^xxhRound(0, v))*prime64_1 + prime64_4
+}
+
+func (h *xxh64) stripes(p []byte) []byte {
+	for ; len(p) >= 32; p = p[32:] {
+		for i := range h.v {
+			h.v[i] = xxhRound(h.v[i], binary.LittleEndian.Uint64(p[8*i:]))
+		}
+	}
+	return p
+}
+
+func (h *xxh64) write(p []byte) {
+	h.total += uint64(len(p))
+	if h.n > 0 {
+		c := copy(h.mem[h.n:], p)
+		h.n += c
+		p = p[c:]
+		if h.n < 32 {
+			return
+		}
+		h.stripes(h.mem[:])
+		h.n = 0
+	}
+	p = h.stripes(p)
+	h.n = copy(h.mem[:], p)
+}
+
+func (h *xxh64) sum64() uint64 {
+	var sum uint64
+	if h.total >= 32 {
+		sum = rotl64(h.v[0], 1) + rotl64(h.v[1], 7) + rotl64(h.v[2], 12) +
+			rotl64(h.v[3], 18)
+		for _, v := range h.v {
+			sum = xxhMergeRound(sum, v)
+		}
+	} else {
+		sum = prime64_5
+	}
+	sum += h.total
+
+	p := h.mem[:h.n]
+	for ; len(p) >= 8; p = p[8:] {
+		sum ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		sum = rotl64(sum, 27)*prime64_1 + prime64_4
+	}
+	if len(p) >= 4 {
+		sum ^= uint64(binary.LittleEndian.Uint32(p)) * prime64_1
+		sum = rotl64(sum, 23)*prime64_2 + prime64_3
+		p = p[4:]
+	}
+	for _, b := range p {
+		sum ^= uint64(b) * prime64_5
+		sum = rotl64(sum, 11) * prime64_1
+	}
+
+	sum ^= sum >> 33
+	sum *= prime64_2
+	sum ^= sum >> 29
+	sum *= prime64_3
+	sum ^= sum >> 32
+	return sum
+}
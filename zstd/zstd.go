@@ -0,0 +1,277 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+// Package zstd implements a decompressor for the Zstandard format as
+// described in RFC 8878. Dictionaries aren't supported.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	frameMagic         = 0xFD2FB528
+	skippableMagicMask = 0xFFFFFFF0
+	skippableMagic     = 0x184D2A50
+
+	maxBlockSize = 128 * 1024
+	// The largest window accepted, the reference decoder's default limit.
+	maxWindowSize = 1 << 27
+)
+
+var (
+	errCorrupt     = errors.New("zstd: corrupt input")
+	errChecksum    = errors.New("zstd: checksum mismatch")
+	errContentSize = errors.New("zstd: frame content size mismatch")
+)
+
+// Reader decompresses a stream of zstd frames.
+type Reader struct {
+	r       io.Reader
+	err     error
+	inFrame bool
+	last    bool // the current frame's last block has been decoded
+	window  int
+	size    uint64 // declared frame content size
+	hasSize bool
+	decoded uint64
+	check   bool
+	hash    xxh64
+	hdr     [14]byte
+	block   []byte
+	out     []byte // window history followed by data not yet read
+	pos     int    // start of the unread data in out
+	seq     sequenceDecoder
+}
+
+// NewReader returns a Reader decompressing the frames read from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Read reads decompressed data. Errors from the underlying reader, including
+// an unexpected EOF in the middle of a frame, are fatal.
+func (z *Reader) Read(p []byte) (int, error) {
+	for {
+		if z.pos < len(z.out) {
+			n := copy(p, z.out[z.pos:])
+			z.pos += n
+			return n, nil
+		}
+		if z.err != nil {
+			return 0, z.err
+		}
+		if !z.inFrame {
+			z.err = z.readFrameHeader()
+		} else if z.last {
+			z.err = z.finishFrame()
+		} else {
+			z.err = z.readBlock()
+		}
+	}
+}
+
+func (z *Reader) readFull(p []byte) error {
+	_, err := io.ReadFull(z.r, p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (z *Reader) readFrameHeader() error {
+	if _, err := io.ReadFull(z.r, z.hdr[:4]); err != nil {
+		// A clean EOF between frames ends the stream.
+		return err
+	}
+	magic := binary.LittleEndian.Uint32(z.hdr[:4])
+	if magic&skippableMagicMask == skippableMagic {
+		if err := z.readFull(z.hdr[:4]); err != nil {
+			return err
+		}
+		size := int64(binary.LittleEndian.Uint32(z.hdr[:4]))
+		if n, err := io.CopyN(ioutil.Discard, z.r, size); n < size {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return nil
+	}
+	if magic != frameMagic {
+		return fmt.Errorf("zstd: invalid magic number %#x", magic)
+	}
+
+	if err := z.readFull(z.hdr[:1]); err != nil {
+		return err
+	}
+	desc := z.hdr[0]
+	fcsFlag := desc >> 6
+	singleSegment := desc&0x20 != 0
+	if desc&0x08 != 0 {
+		return errCorrupt
+	}
+	z.check = desc&0x04 != 0
+	dictIdSize := [4]int{0, 1, 2, 4}[desc&0x03]
+	fcsSize := [4]int{0, 2, 4, 8}[fcsFlag]
+	if fcsFlag == 0 && singleSegment {
+		fcsSize = 1
+	}
+	windowDescSize := 1
+	if singleSegment {
+		windowDescSize = 0
+	}
+	hdr := z.hdr[:windowDescSize+dictIdSize+fcsSize]
+	if err := z.readFull(hdr); err != nil {
+		return err
+	}
+
+	var window uint64
+	if !singleSegment {
+		exponent := uint(hdr[0] >> 3)
+		mantissa := uint64(hdr[0] & 0x07)
+		base := uint64(1) << (10 + exponent)
+		window = base + base/8*mantissa
+		hdr = hdr[1:]
+	}
+	var dictId uint32
+	for i := dictIdSize - 1; i >= 0; i-- {
+		dictId = dictId<<8 | uint32(hdr[i])
+	}
+	if dictId != 0 {
+		return errors.New("zstd: dictionaries are not supported")
+	}
+	hdr = hdr[dictIdSize:]
+	z.hasSize = fcsSize > 0
+	z.size = 0
+	for i := fcsSize - 1; i >= 0; i-- {
+		z.size = z.size<<8 | uint64(hdr[i])
+	}
+	if fcsSize == 2 {
+		z.size += 256
+	}
+	if singleSegment {
+		window = z.size
+	}
+	if window > maxWindowSize {
+		return fmt.Errorf("zstd: window size %d exceeds the maximum of %d",
+			window, maxWindowSize)
+	}
+
+	z.window = int(window)
+	z.inFrame = true
+	z.last = false
+	z.decoded = 0
+	z.hash.reset()
+	z.out = z.out[:0]
+	z.pos = 0
+	z.seq.reset()
+	return nil
+}
+
+func (z *Reader) finishFrame() error {
+	if z.hasSize && z.decoded != z.size {
+		return errContentSize
+	}
+	if z.check {
+		if err := z.readFull(z.hdr[:4]); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(z.hdr[:4]) != uint32(z.hash.sum64()) {
+			return errChecksum
+		}
+	}
+	z.inFrame = false
+	return nil
+}
+
+func (z *Reader) readBlock() error {
+	if err := z.readFull(z.hdr[:3]); err != nil {
+		return err
+	}
+	header := uint32(z.hdr[0]) | uint32(z.hdr[1])<<8 | uint32(z.hdr[2])<<16
+	z.last = header&1 != 0
+	blockType := (header >> 1) & 0x03
+	size := int(header >> 3)
+
+	blockMax := maxBlockSize
+	if z.window < blockMax {
+		blockMax = z.window
+	}
+	if size > blockMax {
+		return errCorrupt
+	}
+
+	// Drop history that's no longer reachable before appending more data.
+	if extra := len(z.out) - z.window; extra > 0 && extra >= z.window/2 {
+		z.out = z.out[:copy(z.out, z.out[extra:])]
+		z.pos = len(z.out)
+	}
+	start := len(z.out)
+
+	switch blockType {
+	case 0: // Raw
+		if cap(z.out)-len(z.out) < size {
+			z.out = growSlice(z.out, size)
+		}
+		if err := z.readFull(z.out[start : start+size]); err != nil {
+			return err
+		}
+		z.out = z.out[:start+size]
+	case 1: // RLE
+		if err := z.readFull(z.hdr[:1]); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			z.out = append(z.out, z.hdr[0])
+		}
+	case 2: // Compressed
+		if cap(z.block) < size {
+			z.block = make([]byte, size)
+		}
+		z.block = z.block[:size]
+		if err := z.readFull(z.block); err != nil {
+			return err
+		}
+		var err error
+		if z.out, err = z.seq.decodeBlock(z.block, z.out); err != nil {
+			return err
+		}
+		if len(z.out)-start > blockMax {
+			return errCorrupt
+		}
+	default:
+		return errCorrupt
+	}
+
+	z.decoded += uint64(len(z.out) - start)
+	if z.hasSize && z.decoded > z.size {
+		return errContentSize
+	}
+	if z.check {
+		z.hash.write(z.out[start:])
+	}
+	return nil
+}
+
+// growSlice returns b with room for at least n more bytes.
+func growSlice(b []byte, n int) []byte {
+	grown := make([]byte, len(b), 2*cap(b)+n)
+	copy(grown, b)
+	return grown
+}
@@ -0,0 +1,136 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2015
+# the Initial Developer. All Rights Reserved.
+#
+# Contributor(s):
+#   agent (agent@local)
+#
+# ***** END LICENSE BLOCK *****/
+
+package zstd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+)
+
+const (
+	// "test1\ntest12\ntest123\n" w/ and w/o a checksum, stored in raw blocks.
+	smallFrame        = "28b52ffd0458a9000074657374310a7465737431320a746573743132330a8856971f"
+	smallFrameNoCheck = "28b52ffd0058a9000074657374310a7465737431320a746573743132330a"
+	emptyFrame        = "28b52ffd240001000099e9d851"
+	skippableFrame    = "5a2a4d1803000000abcdef"
+	smallData         = "test1\ntest12\ntest123\n"
+)
+
+// testInput returns the data compressed with the zstd command line tool in
+// the testsupport directory, e.g. `zstd -1 --no-check` for words_fast.zst
+// and `cat | zstd -19` for words_stream.zst, which has no content size.
+func testInput() []byte {
+	words := []string{"heka", "message", "payload", "splitter", "decoder", "\n"}
+	var buf bytes.Buffer
+	seed := uint32(1)
+	next := func() uint32 {
+		seed = seed*1103515245 + 12345
+		return seed >> 16
+	}
+	for buf.Len() < 150000 {
+		buf.WriteString(words[next()%uint32(len(words))])
+		buf.WriteByte(' ')
+	}
+	for i := 0; i < 4096; i++ {
+		buf.WriteByte(byte(next()))
+	}
+	buf.Write(bytes.Repeat([]byte{'a'}, 200000))
+	return buf.Bytes()
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func decompress(r io.Reader) ([]byte, error) {
+	return ioutil.ReadAll(NewReader(r))
+}
+
+func TestDecompressFiles(t *testing.T) {
+	expected := testInput()
+	for _, name := range []string{"words_fast.zst", "words_stream.zst"} {
+		compressed, err := ioutil.ReadFile(filepath.Join("testsupport", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := decompress(bytes.NewReader(compressed))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if !bytes.Equal(data, expected) {
+			t.Errorf("%s: decompressed data doesn't match", name)
+		}
+
+		data, err = decompress(iotest.OneByteReader(bytes.NewReader(compressed)))
+		if err != nil || !bytes.Equal(data, expected) {
+			t.Errorf("%s: one byte reads failed: %v", name, err)
+		}
+
+		_, err = decompress(bytes.NewReader(compressed[:len(compressed)-10]))
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("%s: expected an unexpected EOF, got %v", name, err)
+		}
+	}
+}
+
+func TestDecompressFrames(t *testing.T) {
+	frames := [][]byte{
+		mustDecodeHex(t, smallFrame),
+		mustDecodeHex(t, skippableFrame),
+		mustDecodeHex(t, emptyFrame),
+		mustDecodeHex(t, smallFrameNoCheck),
+	}
+	data, err := decompress(bytes.NewReader(bytes.Join(frames, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != smallData+smallData {
+		t.Errorf("unexpected data: %q", data)
+	}
+
+	data, err = decompress(bytes.NewReader(nil))
+	if err != nil || len(data) != 0 {
+		t.Errorf("empty input: %q, %v", data, err)
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+	frame := mustDecodeHex(t, smallFrame)
+	frame[len(frame)-1]++
+	if _, err := decompress(bytes.NewReader(frame)); err != errChecksum {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+
+	frame = mustDecodeHex(t, smallFrame)
+	frame[0]++
+	if _, err := decompress(bytes.NewReader(frame)); err == nil {
+		t.Error("expected an invalid magic number error")
+	}
+
+	// A compressed block w/ an empty literals section but no sequences
+	// section.
+	frame = mustDecodeHex(t, "28b52ffd0000"+"0d0000"+"00")
+	if _, err := decompress(bytes.NewReader(frame)); err != errCorrupt {
+		t.Errorf("expected a corrupt input error, got %v", err)
+	}
+}